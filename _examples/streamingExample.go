@@ -61,7 +61,7 @@ func NewApp() (*App, error) {
 
 func (app *App) Stream() {
 	var wg sync.WaitGroup
-	inputStream, reply := app.master.OpenStream(app.client.Addr())
+	inputStream, reply := app.master.OpenStream(app.client.Addr(), 4, "")
 
 	// Test the input stream
 	wg.Add(1)
@@ -6,6 +6,7 @@ import (
 	"crypto"
 	"crypto/rand"
 	"crypto/rsa"
+	"crypto/tls"
 	"crypto/x509"
 	"crypto/x509/pkix"
 	"encoding/asn1"
@@ -26,6 +27,7 @@ import (
 
 	"github.com/gorilla/mux"
 	log "github.com/inconshreveable/log15"
+	"github.com/joonnna/ifrit/netutil"
 )
 
 var (
@@ -58,6 +60,7 @@ type group struct {
 	knownCertsMutex sync.RWMutex
 
 	existingIds map[string]bool
+	keyIds      map[string][]byte
 	idMutex     sync.RWMutex
 
 	groupCert *x509.Certificate
@@ -111,6 +114,7 @@ func LoadCa(path string, numBootNodes, numRings uint32) (*Ca, error) {
 			bootNodes:   numBootNodes,
 			numRings:    numRings,
 			existingIds: make(map[string]bool),
+			keyIds:      make(map[string][]byte),
 		}
 
 		// Read group certificate
@@ -234,6 +238,23 @@ func (c *Ca) Start(host, port string) error {
 	return c.httpHandler(addr)
 }
 
+// Same as Start, but certificate requests are served over https with the given server certificate,
+// keeping the requests confidential and letting clients authenticate the certificate authority.
+// Clients reach it at https://host:port.
+func (c *Ca) StartTLS(host, port string, cert tls.Certificate) error {
+	addr := fmt.Sprintf("%s:%s", host, port)
+	log.Info("Started certificate authority over https", "addr", addr)
+	c.addr = addr
+
+	if err := c.newHttpServer(addr); err != nil {
+		return err
+	}
+
+	c.httpServer.TLSConfig = &tls.Config{Certificates: []tls.Certificate{cert}}
+
+	return c.httpServer.ListenAndServeTLS("", "")
+}
+
 // Returns the address(ip:port) of the certificate authority, this can
 // be directly used as input to the ifrit client entry address.
 func (c *Ca) Addr() string {
@@ -288,6 +309,7 @@ func (c *Ca) NewGroup(ringNum, bootNodes uint32) error {
 		knownCerts:  make([]*x509.Certificate, bootNodes),
 		bootNodes:   bootNodes,
 		existingIds: make(map[string]bool),
+		keyIds:      make(map[string][]byte),
 	}
 
 	c.groups = append(c.groups, g)
@@ -307,6 +329,14 @@ func genKeys() (*rsa.PrivateKey, error) {
 }
 
 func (c *Ca) httpHandler(addr string) error {
+	if err := c.newHttpServer(addr); err != nil {
+		return err
+	}
+
+	return c.httpServer.ListenAndServe()
+}
+
+func (c *Ca) newHttpServer(addr string) error {
 	r := mux.NewRouter()
 	r.HandleFunc("/certificateRequest", c.certificateSigning).Methods("POST")
 
@@ -322,7 +352,7 @@ func (c *Ca) httpHandler(addr string) error {
 		WriteTimeout: time.Second * 10,
 	}
 
-	return c.httpServer.ListenAndServe()
+	return nil
 }
 
 func (c *Ca) certificateSigning(w http.ResponseWriter, r *http.Request) {
@@ -372,12 +402,17 @@ func (c *Ca) certificateSigning(w http.ResponseWriter, r *http.Request) {
 			return
 		}
 	*/
-	id := g.genId()
+	// Requests for already known keys are renewals, they keep their id.
+	id, renewal, err := g.keyId(reqCert.PublicKey)
+	if err != nil {
+		log.Error(err.Error())
+		return
+	}
 
 	newCert := &x509.Certificate{
 		SerialNumber:    serialNumber,
 		SubjectKeyId:    id,
-		Subject:         reqCert.Subject,
+		Subject:         netutil.OrderedLocality(reqCert.Subject),
 		NotBefore:       time.Now().AddDate(-10, 0, 0),
 		NotAfter:        time.Now().AddDate(10, 0, 0),
 		ExtraExtensions: []pkix.Extension{ext},
@@ -399,7 +434,11 @@ func (c *Ca) certificateSigning(w http.ResponseWriter, r *http.Request) {
 		log.Error(err.Error())
 		return
 	}
-	trusted := g.addKnownCert(knownCert)
+
+	var trusted bool
+	if !renewal {
+		trusted = g.addKnownCert(knownCert)
+	}
 
 	respStruct := struct {
 		OwnCert    []byte
@@ -460,6 +499,38 @@ func (g *group) getTrustedNodes() [][]byte {
 	return ret
 }
 
+// Returns the id previously assigned to the given public key, or a new one if it is unknown.
+func (g *group) keyId(pub interface{}) ([]byte, bool, error) {
+	b, err := x509.MarshalPKIXPublicKey(pub)
+	if err != nil {
+		return nil, false, err
+	}
+
+	key := string(b)
+
+	g.idMutex.RLock()
+	id, ok := g.keyIds[key]
+	g.idMutex.RUnlock()
+
+	if ok {
+		return id, true, nil
+	}
+
+	newId := g.genId()
+
+	g.idMutex.Lock()
+	defer g.idMutex.Unlock()
+
+	// Concurrent request for the same key got here first.
+	if id, ok := g.keyIds[key]; ok {
+		return id, true, nil
+	}
+
+	g.keyIds[key] = newId
+
+	return newId, false, nil
+}
+
 func (g *group) genId() []byte {
 	g.idMutex.Lock()
 	defer g.idMutex.Unlock()
@@ -1,33 +1,371 @@
 package ifrit
 
 import (
+	"context"
+	"crypto"
+	"crypto/x509"
 	"crypto/x509/pkix"
 	"errors"
 	"fmt"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"net"
+	"strconv"
+	"time"
 
 	log "github.com/inconshreveable/log15"
 
 	"github.com/joonnna/ifrit/comm"
 	"github.com/joonnna/ifrit/core"
+	"github.com/joonnna/ifrit/core/discovery"
 	"github.com/joonnna/ifrit/netutil"
+	pb "github.com/joonnna/ifrit/protobuf"
 	"github.com/spf13/viper"
+	"google.golang.org/grpc"
 )
 
 type Client struct {
 	node *core.Node
+
+	maxMessageSize int
 }
 
+// Diagnostic information about a peer, see ViewSnapshot.
+type PeerInfo = core.PeerInfo
+
+// Snapshot of a single ring, see RingTopology.
+type RingView = discovery.RingView
+
+// Snapshot of the client's runtime statistics, see Stats.
+type Stats = core.Stats
+
+// Gossip message with the view digest, own note and application data sent to a neighbor, see RegisterGossipTap.
+type GossipMessage = pb.State
+
+// Describes a change in the live view, see RegisterMembershipHandler.
+type MembershipEvent = discovery.Event
+
+// Signs on behalf of the client, see ClientConfig.Signer.
+// Implementations backed by an HSM or KMS keep the private key out of the process.
+type Signer = crypto.Signer
+
+// Traces message and gossip rpcs, see ClientConfig.Tracer.
+// Wrap an OpenTelemetry tracer and propagator to include ifrit hops in your traces.
+type Tracer = core.Tracer
+
+// Chooses the neighbors to gossip with each gossip interval, see ClientConfig.PartnerSelector.
+type PartnerSelector = core.PartnerSelector
+
+// Neighbor a PartnerSelector chooses among, with the rings it neighbors this client on and its gossip round trip time.
+type Neighbour = core.Neighbour
+
+// Gossips with fanout neighbors chosen uniformly at random each round.
+func RandomPartners(fanout int) PartnerSelector {
+	return core.RandomPartners(fanout)
+}
+
+// Gossips with the successor and predecessor of one ring each round, rotating through the rings.
+// Same as the default with a gossip fanout of zero.
+func RingPartners() PartnerSelector {
+	return core.RingPartners()
+}
+
+// Gossips with the fanout neighbors with the lowest gossip round trip times,
+// neighbors not measured yet are tried first.
+func LatencyPartners(fanout int) PartnerSelector {
+	return core.LatencyPartners(fanout)
+}
+
+// Source of time for the client's gossip, monitor and view update loops, see ClientConfig.Clock.
+type Clock = discovery.Clock
+
+// Timer created by a Clock.
+type Timer = discovery.Timer
+
+// Kind of membership change.
+type MembershipKind = discovery.EventKind
+
+const (
+	Joined  = discovery.Joined
+	Left    = discovery.Left
+	Accused = discovery.Accused
+)
+
+// Kind of message or datagram the client dropped, see RegisterDropHandler.
+type DropKind = core.DropKind
+
+const (
+	DroppedGossip      = core.DroppedGossip
+	DroppedStreamFrame = core.DroppedStreamFrame
+	UdpReadError       = core.UdpReadError
+	UdpWriteError      = core.UdpWriteError
+)
+
 type ClientConfig struct {
+	// Ports the client binds, zero lets the os pick a free port, see Addr. Hostname is the address announced to other peers
+	// and must be set, CertPath is the directory of a stored identity, see NewClientCertificate.
 	UdpPort, TcpPort   int
 	Hostname, CertPath string
+
+	// Address family of the udp socket used for pings, one of udp, udp4 or udp6.
+	// Defaults to udp, which accepts both IPv4 and IPv6.
+	UdpNetwork string
+
+	// Identity bundle from ExportIdentity, takes precedence over CertPath if set.
+	Identity []byte
+
+	// Optional behavior settings.
+	// Zero values fall back to the ifrit config file, or to the defaults if it is absent.
+	CaAddr string
+
+	// Deadline of each certificate request to the CA, and how many times a request is attempted
+	// before NewClient gives up with ErrCaUnreachable. The wait between attempts starts at
+	// CaRetryBackoff and doubles for each attempt, so clients ride out a restarting CA.
+	CaTimeout         time.Duration
+	CaRequestAttempts uint32
+	CaRetryBackoff    time.Duration
+
+	// How often a running client checks that the CA answers, see RegisterCAStatusHandler. Certificate rotations
+	// that fail while the CA is down are retried once it answers again, the client keeps its current certificate
+	// meanwhile. Defaults to 30 seconds, ignored without a CA.
+	CaPollInterval time.Duration
+
+	// Path to a PEM encoded certificate the server certificate of an https CA is validated against,
+	// the system roots are used if empty. The CA address is given as https://host:port.
+	CaServerCertPath string
+
+	// Allows requesting certificates from a CA over plain http, which the CA address uses
+	// when given without a scheme. Only for local testing, the request travels in cleartext.
+	AllowInsecureCa bool
+
+	GossipInterval     time.Duration
+	MonitorInterval    time.Duration
+	ViewUpdateInterval time.Duration
+
+	// How long an accused peer has to rebut before it is evicted from the live view.
+	// High latency deployments should raise it to avoid evicting peers that are merely slow.
+	RemovalTimeout time.Duration
+
+	PingLimit             uint32
+	PingsPerInterval      uint32
+	MaxConcurrentMessages uint32
+	MessageTimeout        time.Duration
+	GossipFanout          uint32
+	GossipMode            string
+
+	// Deadline of each gossip round, after which rpcs to stalled peers are cancelled and the next round
+	// is scheduled as usual. Defaults to the gossip interval.
+	GossipRoundTimeout time.Duration
+
+	// Chooses the neighbors to gossip with each gossip interval, replacing the choice made by GossipFanout.
+	// See RandomPartners, RingPartners and LatencyPartners, or implement your own strategy.
+	PartnerSelector PartnerSelector
+
+	// Decides whether received content of a versioned gossip entry is newer than the content held for its id,
+	// for instance by comparing version vectors encoded in the content. Return true to replace the current content,
+	// and false for equal content. Versions are compared if nil, otherwise they are only carried along.
+	// Called while the entries are locked, so it must not call back into the client.
+	GossipComparator func(current, received []byte) bool
+
+	// How long each ping waits for a pong, and how many times it is resent
+	// before counting as failed. Lower timeouts with retransmits detect loss faster on lossy links.
+	PingTimeout     time.Duration
+	PingRetransmits uint32
+
+	// Checks peers over tcp, through a tls handshake with their rpc address, once ping_limit udp pings
+	// in a row have failed, and only accuses them if that fails too. Also enabled by tcp_ping_fallback.
+	// For networks filtering udp, where peers would otherwise be accused although gossip reaches them.
+	TcpPingFallback bool
+
+	// One of none, gzip or snappy.
+	Compression string
+
+	// Number of recently received gossip entries remembered to avoid handing the same
+	// content from the same peer to the gossip handler more than once. Defaults to 1024.
+	GossipCacheSize uint32
+
+	// Hands all received gossip to the gossip handler, repeated content included, regardless of GossipCacheSize.
+	DisableGossipCache bool
+
+	// Gossip rpcs accepted per second from a single peer, in bursts of up to GossipRateBurst.
+	// Peers gossiping faster are rejected until they slow down. Default to 10 and 20.
+	GossipRateLimit uint32
+	GossipRateBurst uint32
+
+	// Maximum number of concurrent rpcs served per connection, zero means no limit.
+	MaxConcurrentStreams uint32
+
+	// Maximum size in bytes of messages sent and received, gossip included.
+	// Larger payloads are rejected with ErrMessageSize. Defaults to 4MB.
+	MaxMessageSize uint32
+
+	// Maximum size in bytes of the gossip messages sent each round, defaults to MaxMessageSize.
+	// Membership state is always sent, application gossip that does not fit is spread over the following rounds.
+	MaxGossipSize uint32
+
+	// How long before the certificate expires the cert expiry handler is invoked.
+	// Zero uses cert_expiry_threshold from the config file, a negative value disables the check.
+	CertExpiryThreshold time.Duration
+
+	// How far off the clock of a pinged peer may be before a warning is logged and Stats.ClockSkews counts it.
+	// Defaults to a second.
+	ClockSkewThreshold time.Duration
+
+	// Maximum number of peers in the full view, zero means no limit. Once exceeded, the peers outside
+	// the live view that were heard from least recently are evicted. Live peers are never evicted.
+	MaxViewSize uint32
+
+	// Rings the client participates on, the lowest bit being the first ring, also set by ring_mask.
+	// Zero participates on all rings. Lets clients on weaker hardware carry a smaller monitoring load:
+	// on the rings left out the client is neither monitored nor monitors anyone. At most half
+	// the rings minus one can be left out, NewClient fails with ErrConfig otherwise.
+	RingMask uint32
+
+	// Runs the client as an observer, for monitoring appliances, also enabled by observer_mode.
+	// Observers see the full view and the liveness of other clients, but other clients keep them out of
+	// their live view, so observers are never chosen as monitors or gossip partners and are not among their Members.
+	// Observers do not monitor anyone and never spread application gossip, gossip content set on them is ignored.
+	ObserverMode bool
+
+	// Zone label of the client, such as its region or data center, also set by zone. It is carried in the
+	// certificate of the client as its organizational unit, peers learn it from there, see MembersInZone.
+	// The zone is part of the identity: clients loading a stored identity through CertPath or Identity
+	// keep the zone of its certificate, renewals included, and peers keep the zone they first learned.
+	Zone string
+
+	// Addresses (ip:port) of existing clients to gossip with once started, in addition to
+	// the peers learned from the CA. Lets clients join through members the CA does not know of.
+	// Unreachable seeds are retried each gossip interval until SeedRetryTimeout has passed.
+	SeedNodes        []string
+	SeedRetryTimeout time.Duration
+
+	// Healthy reports the client as unhealthy once no gossip round has completed within HealthMissedRounds
+	// gossip intervals, plus the round timeout, defaulting to 3. It does as well once the client has had
+	// no live peers for longer than HealthIsolationTimeout, defaulting to five minutes, while knowing of others.
+	HealthMissedRounds     uint32
+	HealthIsolationTimeout time.Duration
+
+	// Paths to PEM encoded certificates of CAs trusted in addition to our own.
+	// Peers with certificates signed by any of them are accepted into the network.
+	TrustedCaPaths []string
+
+	// Pins the public key of peers, mapping the string of a peer id to the KeyPin of its certificate.
+	// Certificates for a pinned id with any other key are rejected, even if a trusted CA signed them.
+	// Certificate rotations keep the key and are accepted. Ids without a pin are only checked against the CAs.
+	KeyPins map[string][]byte
+
+	// Digest algorithm of notes, accusations, pings and Sign calls, one of sha256, sha384 or sha512.
+	// Defaults to sha256. All clients of a network must use the same algorithm,
+	// signatures from clients using another one are rejected and logged.
+	SignatureHash string
+
+	// Signs notes, accusations and Sign calls, and authenticates the client in tls handshakes.
+	// Its public key must be an ecdsa key. If nil a key is generated and kept in memory,
+	// with a signer the private key can not be saved through SavePrivateKey.
+	Signer Signer
+
+	// ONLY FOR TESTING, never set in production since anyone knowing the seed can recreate the key.
+	// Derives the client's key from the seed and, when no CA is used, its id,
+	// so that tests get the same ids and ring placements on every run. Can not be combined with Signer.
+	InsecureTestSeed []byte
+
+	// Traces SendTo, Notify and gossip rpcs and their handling on the receiving side if set,
+	// nothing is traced otherwise. The trace context of messages is passed along in the grpc metadata,
+	// so the receiver's message handler runs within a span of the sender's trace.
+	Tracer Tracer
+
+	// Extra options for the grpc server and for dialing peers, e.g. interceptors, keepalive parameters or window sizes.
+	// They are applied after the grpc options ifrit sets itself, and so take precedence over them.
+	// The tls credentials can not be overridden, and the gossip service is always the one registered.
+	ServerOptions []grpc.ServerOption
+	DialOptions   []grpc.DialOption
+
+	// Drives the gossip, monitor and view update loops, accusation timeouts and the other timeouts kept by the client.
+	// The real clock is used if nil, tests can set a fake clock and advance it to trigger gossip rounds and evictions.
+	// Socket deadlines and message timeouts always use the real clock.
+	Clock Clock
+
+	// Starts the http server serving the view dump and the visualizer endpoints, and reports to the
+	// visualizer at VizAddr. The server is off unless enabled here or by use_viz, it has no authentication.
+	UseViz  bool
+	VizAddr string
+
+	// Address (ip:port) the http server binds to, for serving it on a dedicated management interface.
+	// Defaults to the first free port from 12300 on the address the hostname resolves to.
+	// NewClient fails if the address can not be bound.
+	HttpAddr string
+
+	// Receives all ifrit log output if set, otherwise the log15 root handler is left as is.
+	// The logger is global, it receives the output of all clients in the process.
+	// NewClient fails if another client already installed a different logger.
+	Logger Logger
 }
 
 var (
+	// Returned by SetGossipContent when the given content is already being gossiped.
+	ErrGossipUnchanged = errors.New("Gossip content is unchanged")
+
+	// Returned by SendTo and its variants when too many messages already wait to be sent.
+	ErrSendQueueFull = core.ErrSendQueueFull
+
+	// Returned by SetGossipContentVersioned when the id already has an equal or higher version,
+	// or was published by another client.
+	ErrGossipVersion = errors.New("Gossip entry version is not newer than the current one")
+
+	// Returned when data exceeds the maximum message size, see ClientConfig.MaxMessageSize.
+	ErrMessageSize = comm.ErrMessageSize
+
+	// Returned by NewClient when the CA could not be reached within ClientConfig.CaRequestAttempts.
+	ErrCaUnreachable = comm.ErrCaUnreachable
+
+	// Stages of NewClient, errors returned by NewClient match one of them with errors.Is, see SetupError.
+	// Configuration errors are permanent, as are bind errors on a fixed port, CA errors are often transient.
+	ErrConfig = errors.New("Invalid client configuration")
+	ErrBind   = errors.New("Could not bind client sockets")
+	ErrCA     = errors.New("Could not obtain a certificate from the CA")
+	ErrComm   = errors.New("Could not set up client communication")
+
+	// Returned by Request when no response arrived before the deadline of the context or the message timeout.
+	ErrTimeout = errors.New("Request timed out")
+
+	// Returned by Request when the destination could not be reached or failed to respond.
+	ErrUnreachable = errors.New("Destination unreachable")
+
+	// Returned by SendToHost when the host presents a certificate we would not accept from a peer.
+	ErrPeerCert = errors.New("Host certificate failed validation")
+
 	errNoData      = errors.New("Supplied data is of length 0")
 	errNoCaAddress = errors.New("Config does not contain address of CA")
 	errNoClientArg = errors.New("Client argument zero")
+	errSeedSigner  = errors.New("InsecureTestSeed can not be combined with Signer")
+	errNoHostname  = errors.New("Config does not contain a hostname")
 )
 
+// Returned by NewClient, tells which stage of creating the client failed.
+// Matches both its stage and the error that caused it with errors.Is and errors.As.
+type SetupError struct {
+	// One of ErrConfig, ErrBind, ErrCA or ErrComm.
+	Stage error
+	Err   error
+}
+
+func (e *SetupError) Error() string {
+	return fmt.Sprintf("%s: %s", e.Stage.Error(), e.Err.Error())
+}
+
+func (e *SetupError) Unwrap() error {
+	return e.Err
+}
+
+func (e *SetupError) Is(target error) bool {
+	return target == e.Stage
+}
+
+func setupError(stage, err error) error {
+	return &SetupError{Stage: stage, Err: err}
+}
+
 /* Creates and returns a new ifrit client instance.
  *
  * Change: Added argument struct containing specifiable context for ifrit-client. - marius
@@ -36,61 +374,128 @@ func NewClient(cliCfg *ClientConfig) (*Client, error) {
 	var cu *comm.CryptoUnit
 
 	if cliCfg == nil {
-		return nil, errNoClientArg
+		return nil, setupError(ErrConfig, errNoClientArg)
 	}
 
-	err := readConfig()
+	if err := cliCfg.validate(); err != nil {
+		return nil, setupError(ErrConfig, err)
+	}
+
+	if cliCfg.Logger != nil {
+		if err := setLogger(cliCfg.Logger); err != nil {
+			return nil, setupError(ErrConfig, err)
+		}
+	}
+
+	signer, err := cliCfg.signer()
 	if err != nil {
-		return nil, err
+		return nil, setupError(ErrConfig, err)
 	}
 
-	udpConn, udpAddr, err := netutil.ListenUdp(cliCfg.Hostname, cliCfg.UdpPort)
+	err = readConfig()
 	if err != nil {
-		return nil, err
+		return nil, setupError(ErrConfig, err)
+	}
+
+	udpConn, udpAddr, err := netutil.ListenUdpNetwork(cliCfg.udpNetwork(), cliCfg.Hostname, cliCfg.UdpPort)
+	if err != nil {
+		return nil, setupError(ErrBind, err)
 	}
 
 	l, err := netutil.GetListener(cliCfg.Hostname, cliCfg.TcpPort)
 	if err != nil {
-		return nil, err
+		return nil, setupError(ErrBind, err)
 	}
 
 	log.Debug("addrs", "rpc", l.Addr().String(), "udp", udpAddr)
 
+	// Advertise the bound port, the os picks one if the configured port is zero.
+	tcpPort := l.Addr().(*net.TCPAddr).Port
+
 	pk := pkix.Name{
-		Locality: []string{fmt.Sprintf("%s:%d", cliCfg.Hostname, cliCfg.TcpPort), udpAddr},
+		Locality: []string{net.JoinHostPort(cliCfg.Hostname, strconv.Itoa(tcpPort)), udpAddr},
 	}
+	cliCfg.setZone(&pk)
 
-	caAddr := viper.GetString("ca_addr")
+	caAddr := cliCfg.caAddr()
 
-	if cliCfg.CertPath == "" {
-		cu, err = comm.NewCu(pk, caAddr, cliCfg.Hostname)
+	caPolicy, err := cliCfg.caRequestPolicy()
+	if err != nil {
+		return nil, setupError(ErrConfig, err)
+	}
+
+	if cliCfg.Identity != nil {
+		cu, err = comm.LoadCuBundle(cliCfg.Identity, pk, caAddr)
 		if err != nil {
-			return nil, err
+			return nil, setupError(ErrConfig, err)
+		}
+		cu.SetCaRequestPolicy(caPolicy)
+	} else if cliCfg.CertPath == "" {
+		cu, err = comm.NewCu(pk, caAddr, cliCfg.Hostname, signer, caPolicy)
+		if err != nil {
+			// Without a CA the certificate is self-signed, failing that is a problem with the identity.
+			if caAddr == "" {
+				return nil, setupError(ErrConfig, err)
+			}
+			return nil, setupError(ErrCA, err)
 		}
 	} else {
-		cu, err = comm.LoadCu(cliCfg.CertPath, pk, caAddr)
+		cu, err = comm.LoadCu(cliCfg.CertPath, pk, caAddr, signer)
 		if err != nil {
-			return nil, err
+			return nil, setupError(ErrConfig, err)
 		}
+		cu.SetCaRequestPolicy(caPolicy)
+	}
+
+	if err := cu.SetSignatureHash(stringSetting(cliCfg.SignatureHash, "signature_hash")); err != nil {
+		return nil, setupError(ErrConfig, err)
 	}
 
-	c, err := comm.NewComm(cu.Certificate(), cu.CaCertificate(), cu.Priv(), l)
+	trustedCAs, err := cliCfg.trustedCAs()
 	if err != nil {
-		return nil, err
+		return nil, setupError(ErrConfig, err)
 	}
 
-	udpServer, err := comm.NewUdpServer(cu, udpConn)
+	caCerts := append([]*x509.Certificate{cu.CaCertificate()}, trustedCAs...)
+
+	maxMessageSize := int(uintSetting(cliCfg.MaxMessageSize, "max_message_size"))
+	maxStreams := uintSetting(cliCfg.MaxConcurrentStreams, "max_concurrent_streams")
+
+	c, err := comm.NewComm(cu.Certificate(), caCerts, cu.Signer(), l, cliCfg.compression(), maxStreams, maxMessageSize, cliCfg.ServerOptions, cliCfg.DialOptions)
 	if err != nil {
-		return nil, err
+		return nil, setupError(ErrComm, err)
 	}
 
-	n, err := core.NewNode(c, udpServer, cu, cu)
+	udpServer, err := comm.NewUdpServer(cu, udpConn, cliCfg.Hostname, 0)
 	if err != nil {
-		return nil, err
+		return nil, setupError(ErrComm, err)
+	}
+
+	udpServer.SetPingTimeout(intervalSetting(cliCfg.PingTimeout, "ping_timeout"))
+	udpServer.SetPingRetransmits(int(uintSetting(cliCfg.PingRetransmits, "ping_retransmits")))
+
+	conf := cliCfg.nodeConfig()
+	conf.TrustedCAs = trustedCAs
+
+	if caAddr == "" {
+		conf.CaPollInterval = 0
+	}
+
+	if conf.MaxGossipSize == 0 || int(conf.MaxGossipSize) > maxMessageSize {
+		conf.MaxGossipSize = uint32(maxMessageSize)
+	}
+
+	n, err := core.NewNode(c, udpServer, cu, cu, conf)
+	if err != nil {
+		if errors.Is(err, core.ErrHttpListen) {
+			return nil, setupError(ErrBind, err)
+		}
+		return nil, setupError(ErrConfig, err)
 	}
 
 	return &Client{
-		node: n,
+		node:           n,
+		maxMessageSize: maxMessageSize,
 	}, nil
 }
 
@@ -99,8 +504,8 @@ func NewClientCertificate(cliCfg *ClientConfig, path string) error {
 
 	pk := pkix.Name{
 		Locality: []string{
-			fmt.Sprintf("%s:%d", cliCfg.Hostname, cliCfg.TcpPort),
-			fmt.Sprintf("%s:%d", cliCfg.Hostname, cliCfg.UdpPort),
+			net.JoinHostPort(cliCfg.Hostname, strconv.Itoa(cliCfg.TcpPort)),
+			net.JoinHostPort(cliCfg.Hostname, strconv.Itoa(cliCfg.UdpPort)),
 		},
 	}
 
@@ -108,24 +513,45 @@ func NewClientCertificate(cliCfg *ClientConfig, path string) error {
 		return err
 	}
 
-	caAddr := viper.GetString("ca_addr")
+	cliCfg.setZone(&pk)
+
+	caAddr := cliCfg.caAddr()
 
-	cu, err := comm.NewStaticCu(pk, caAddr, cliCfg.Hostname)
+	caPolicy, err := cliCfg.caRequestPolicy()
 	if err != nil {
 		return err
 	}
 
-	if err := cu.SavePrivateKey(path); err != nil {
+	cu, err := comm.NewStaticCu(pk, caAddr, cliCfg.Hostname, cliCfg.Signer, caPolicy)
+	if err != nil {
 		return err
 	}
+
+	// Keys held by an external signer stay there, load with the same signer in ClientConfig.
+	if cliCfg.Signer == nil {
+		if err := cu.SavePrivateKey(path); err != nil {
+			return err
+		}
+	}
 	err = cu.SaveCertificate(path)
 
 	return err
 }
 
 // Client starts operating.
-func (c *Client) Start() {
-	c.node.Start()
+// Blocks until the client is stopped, or until its rpc or http server fails.
+// Returns the server error, nil if the client was stopped.
+func (c *Client) Start() error {
+	return c.node.Start()
+}
+
+// Same as Start, but returns immediately.
+// The first returned channel is closed once the client has contacted the network and is participating in gossip,
+// or once it failed. The second receives what Start would have returned once the client stops,
+// the error of a failing server or nil.
+// Returns an error if the client was already started.
+func (c *Client) StartAsync() (<-chan struct{}, <-chan error, error) {
+	return c.node.StartAsync()
 }
 
 // Stops client operations.
@@ -134,17 +560,182 @@ func (c *Client) Stop() {
 	c.node.Stop()
 }
 
+// Announces to its neighbours that the client is leaving the network, then stops it like Stop.
+// Neighbours, the peers monitoring the client, remove it right away, giving faster convergence than Stop,
+// where peers only notice the departure once their accusations time out.
+// The cost is a final round of messages to all ring neighbours, which Leave waits for
+// at most for the gossip round timeout.
+// Returns an error if the client is not running.
+func (c *Client) Leave() error {
+	return c.node.Leave()
+}
+
+// Pauses the client, it stops gossiping, monitoring its neighbours and removing accused peers,
+// but keeps responding to incoming messages and stays in the view of other peers.
+// Staying paused for longer than the removal timeout risks eviction by other peers,
+// as the client no longer spreads its own note through gossip.
+func (c *Client) Pause() {
+	c.node.Pause()
+}
+
+// Resumes gossiping and monitoring after Pause.
+func (c *Client) Resume() {
+	c.node.Resume()
+}
+
+// Changes how often the client gossips while running, e.g. to throttle gossip under load.
+// The new interval takes effect after the current one has passed, returns an error if it is not positive.
+func (c *Client) SetGossipInterval(d time.Duration) error {
+	return c.node.SetGossipInterval(d)
+}
+
+// Changes how often the client monitors its neighbours while running, see SetGossipInterval.
+func (c *Client) SetMonitorInterval(d time.Duration) error {
+	return c.node.SetMonitorInterval(d)
+}
+
+// Changes how often the client checks for expired accusations while running, see SetGossipInterval.
+func (c *Client) SetViewUpdateInterval(d time.Duration) error {
+	return c.node.SetViewUpdateInterval(d)
+}
+
+// Same as Stop, but in-flight messages, streams and incoming requests are given until the context
+// is done to complete. If the context is done first, the client is forcefully stopped and an error
+// describing the remaining work is returned.
+// The client cannot be used after calling StopWithContext.
+func (c *Client) StopWithContext(ctx context.Context) error {
+	return c.node.StopWithContext(ctx)
+}
+
 // Returns the address (ip:port, rpc endpoint) of all other ifrit clients in the network which is currently believed to be alive.
 func (c *Client) Members() []string {
 	return c.node.LiveMembers()
 }
 
+// Same as Members, but only the clients whose certificate carries the given zone label, see ClientConfig.Zone.
+// An empty zone gives the clients without a zone.
+func (c *Client) MembersInZone(zone string) []string {
+	return c.node.LiveMembersInZone(zone)
+}
+
+// Returns the zone label of the certificate of this client, empty if it has none.
+func (c *Client) Zone() string {
+	return c.node.Zone()
+}
+
+// Returns true if the ifrit client with the given id is currently believed to be alive, as reported by Members.
+// Accused clients are live until their removal timeout expires. A constant time lookup, unlike searching the result of Members.
+func (c *Client) IsLive(id []byte) bool {
+	return c.node.IsLive(id)
+}
+
+// Same as IsLive, but for the ifrit client with the given address (ip:port, rpc endpoint).
+func (c *Client) IsLiveAddr(addr string) bool {
+	return c.node.IsLiveAddr(addr)
+}
+
+// Blocks until at least n other ifrit clients are believed to be alive, as reported by Members.
+// Woken by membership changes rather than polling, useful for gating readiness on discovery.
+// Returns the context error if fewer peers are alive once the context is done.
+func (c *Client) WaitForMembers(ctx context.Context, n int) error {
+	return c.node.WaitForMembers(ctx, n)
+}
+
+// Returns a snapshot of gossip, membership and messaging statistics collected since the client was created.
+func (c *Client) Stats() Stats {
+	return c.node.Stats()
+}
+
+// Returns false, along with the reason, if the client is not running, has not completed a gossip round
+// within ClientConfig.HealthMissedRounds gossip intervals, has a certificate or CA certificate outside its
+// validity period, or has had no live peers for longer than ClientConfig.HealthIsolationTimeout while
+// knowing of other peers. The http server serves the same check at /healthz, for liveness and readiness probes.
+func (c *Client) Healthy() (bool, string) {
+	return c.node.Healthy()
+}
+
+// Returns when the last gossip round ended, zero before the first one.
+// Each round ends within its deadline, see ClientConfig.GossipRoundTimeout, so a time further back
+// than the gossip interval plus the round timeout means gossip has stopped progressing.
+func (c *Client) LastGossipRound() time.Time {
+	return c.node.LastGossipRound()
+}
+
+// Returns the number of accused peers waiting for their removal timeout to expire.
+// Each one is removed from the live view when its timeout expires, unless it rebuts the accusation first.
+// An abnormal number of them is logged along with the accused peers, as it points at an accusation storm.
+func (c *Client) PendingTimeouts() int {
+	return c.node.PendingTimeouts()
+}
+
+// Returns the number of messages sent with SendTo and its variants that are still waiting for a response.
+// At most ClientConfig.MaxConcurrentMessages messages are in flight, further sends wait for one of them to finish.
+func (c *Client) InFlight() int {
+	return c.node.InFlight()
+}
+
+// Returns the recent round trip time of gossip exchanges with the peer with the given id.
+// The latency is weighted towards the most recent exchanges.
+// Returns false if the client has not gossiped with the peer since it joined the live view.
+func (c *Client) PeerLatency(id []byte) (time.Duration, bool) {
+	return c.node.PeerLatency(id)
+}
+
+// Returns how far ahead of the local clock the clock of the given peer is, negative if it is behind.
+// Estimated from the timestamps of pongs the peer answered the pings of this client with, so only
+// ring successors have an estimate, and it is off by at most half the ping round trip time.
+// Returns zero if the client has no estimate for the peer.
+// Large skews, see ClientConfig.ClockSkewThreshold, are logged and counted in Stats.ClockSkews.
+func (c *Client) EstimatedClockSkew(id []byte) time.Duration {
+	skew, _ := c.node.EstimatedClockSkew(id)
+	return skew
+}
+
+// Same as PeerLatency, but for every live peer the client has gossiped with, keyed by string(id).
+func (c *Client) AllPeerLatencies() map[string]time.Duration {
+	return c.node.AllPeerLatencies()
+}
+
+// Returns information about every peer this client knows of, including peers that are accused or not believed to be alive.
+// Useful for diagnosing why peers never enter the live view.
+func (c *Client) ViewSnapshot() []PeerInfo {
+	return c.node.ViewSnapshot()
+}
+
+// Returns the peers responsible for monitoring this client, its predecessor on each ring.
+// These are the peers that accuse this client if it stops answering pings.
+func (c *Client) Monitors() []PeerInfo {
+	return c.node.Monitors()
+}
+
+// Returns the peers this client monitors, its successor on each ring.
+// These are the peers this client accuses if they stop answering pings.
+func (c *Client) Monitoring() []PeerInfo {
+	return c.node.Monitoring()
+}
+
+// Returns, per ring, the ordered ids of the live peers on it and this client's immediate successor and predecessor.
+// The client monitors its successors and gossips with its neighbours, which makes this useful for
+// understanding why a given peer is, or is not, being pinged.
+func (c *Client) RingTopology() []RingView {
+	return c.node.RingTopology()
+}
+
+// Evicts the peer with the given id from the live view right away, for peers known to be permanently gone.
+// Other clients are informed through accusations spread with regular gossip, which they act on once
+// the removal timeout expires without a rebuttal. A peer that is in fact alive rebuts and rejoins.
+// Returns an error if the id is unknown or is the client's own.
+func (c *Client) EvictPeer(id []byte) error {
+	return c.node.EvictPeer(id)
+}
+
 // Returns ifrit's internal ID generated by the trusted CA
 func (c *Client) Id() string {
 	return c.node.Id()
 }
 
-// Returns the address(ip:port) of the ifrit client.
+// Returns the address(ip:port) of the ifrit client, as advertised to other peers.
+// Reports the port picked by the os if TcpPort is zero.
 // Can be directly used as entry addresses in the config.
 func (c *Client) Addr() string {
 	return c.node.Addr()
@@ -163,48 +754,333 @@ func (c *Client) VerifySignature(r, s, content []byte, id string) bool {
 	return c.node.Verify(r, s, content, id)
 }
 
+// Same as VerifySignature, but checks the signature against the certificate the client with the given id
+// had at the given time, for verifying signatures on older messages after the certificate is renewed.
+// Returns false if no certificate known for the client was valid at that time,
+// only the last few renewed certificates of each client are remembered.
+func (c *Client) VerifySignatureAt(r, s, content, id []byte, at time.Time) bool {
+	return c.node.VerifyAt(r, s, content, id, at)
+}
+
+// Returns the certificate of the ifrit client with the given id, signed by the trusted CA.
+// Returns an error if no observed peer has the given id.
+// Its public key can be cached to verify signatures made with Sign, see VerifySignature.
+func (c *Client) CertificateForId(id []byte) (*x509.Certificate, error) {
+	return c.node.IdToCertificate(id)
+}
+
+// Returns the pin of the public key in the given certificate, the sha256 digest of its subject public key info.
+// Pair it with the id of the certificate in ClientConfig.KeyPins.
+func KeyPin(cert *x509.Certificate) []byte {
+	return core.KeyPin(cert)
+}
+
+// Requests a fresh certificate for the current private key from the CA and starts using it
+// for new connections, without restarting the client. The id of the client stays the same.
+// Peers keep accepting the old certificate until they have seen the new one.
+// Returns an error if the client does not use a CA. If the CA is unreachable the client keeps its
+// current certificate and retries the rotation once the CA answers again, see RegisterCAStatusHandler.
+func (c *Client) RotateCertificate() error {
+	return c.node.RotateCertificate()
+}
+
+// Returns the epoch of the note the client announces itself with.
+func (c *Client) LocalEpoch() uint64 {
+	return c.node.LocalEpoch()
+}
+
+// Signs the note of the client again with the next epoch, peers replace the note they hold
+// with the new one as it is included in the next gossip round. Useful to have peers re-accept
+// the client quickly, for instance after it detected a change of its network.
+// Returns an error if the note is already at the highest possible epoch.
+func (c *Client) RefreshNote() error {
+	return c.node.RefreshNote()
+}
+
 // Sends the given data to the given destination.
 // The caller must ensure that the given data is not modified after calling this function.
 // The returned channel will be populated with the response.
-// If the destination could not be reached or timeout occurs, nil will be sent through the channel.
+// If the destination could not be reached, timeout occurs or too many messages already wait to be sent,
+// nil will be sent through the channel, an empty response is sent as an empty slice. Use Request to tell an unreachable destination from a timeout.
+// The timeout is the message timeout of the client config, there is none by default.
+// Exactly one value is sent through the channel, it is buffered so abandoning it does not block the request,
+// which holds resources until it is answered, times out, its context is cancelled or the client stops.
 // The response data can be safely modified after receiving it.
 func (c *Client) SendTo(dest string, data []byte) chan []byte {
+	ch, err := c.SendToContext(context.Background(), dest, data)
+	if err != nil {
+		log.Error(err.Error(), "addr", dest)
+		ch = make(chan []byte, 1)
+		ch <- nil
+	}
+
+	return ch
+}
+
+// Same as SendTo, but the given context is used for the request.
+// Cancelling the context or exceeding its deadline aborts the request and closes the returned channel.
+// A deadline on the context replaces the default message timeout for this request.
+// Returns an error if the context is already done, and ErrSendQueueFull if too many messages wait to be sent.
+func (c *Client) SendToContext(ctx context.Context, dest string, data []byte) (chan []byte, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	if err := c.checkSize(data); err != nil {
+		return nil, err
+	}
+
 	ch := make(chan []byte, 1)
 
-	go c.node.SendMessage(dest, ch, data)
+	if err := c.node.QueueMessage(ctx, dest, ch, data); err != nil {
+		return nil, err
+	}
 
-	return ch
+	return ch, nil
+}
+
+// Same as SendToContext, but waits for the response and returns it.
+// Returns ErrTimeout if the deadline of the context, or the message timeout if the context has none, passes first,
+// ErrUnreachable if the destination could not be reached, and the context error if the context is cancelled.
+// An empty response is returned as an empty, non-nil slice.
+func (c *Client) Request(ctx context.Context, dest string, data []byte) ([]byte, error) {
+	if _, ok := ctx.Deadline(); !ok && c.node.MessageTimeout() > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, c.node.MessageTimeout())
+		defer cancel()
+	}
+
+	ch, err := c.SendToContext(ctx, dest, data)
+	if err == nil {
+		if reply := <-ch; reply != nil {
+			return reply, nil
+		}
+		err = ctx.Err()
+	}
+
+	switch {
+	case err == nil:
+		return nil, ErrUnreachable
+	case errors.Is(err, context.DeadlineExceeded):
+		return nil, ErrTimeout
+	default:
+		return nil, err
+	}
+}
+
+// Same as Request, but sends to a host that does not have to be in the view, such as an entry point
+// of another cluster sharing our CA. The certificate of the host is validated the same way as
+// the certificates of peers during the handshake of the connection carrying the message, the message
+// is not sent and ErrPeerCert is returned if it fails.
+// The host is not added to the view, and the connection is closed once the response arrives.
+func (c *Client) SendToHost(ctx context.Context, host string, port int, data []byte) ([]byte, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	if err := c.checkSize(data); err != nil {
+		return nil, err
+	}
+
+	if _, ok := ctx.Deadline(); !ok && c.node.MessageTimeout() > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, c.node.MessageTimeout())
+		defer cancel()
+	}
+
+	reply, err := c.node.SendToHost(ctx, net.JoinHostPort(host, strconv.Itoa(port)), data)
+	switch {
+	case err == nil:
+		return reply, nil
+	case errors.Is(err, core.ErrPeerCert), errors.Is(err, comm.ErrPeerCert):
+		return nil, fmt.Errorf("%w: %s", ErrPeerCert, err.Error())
+	case ctx.Err() == context.DeadlineExceeded:
+		return nil, ErrTimeout
+	case ctx.Err() != nil:
+		return nil, ctx.Err()
+	default:
+		return nil, ErrUnreachable
+	}
+}
+
+// Controls retries of SendToIdWithRetry.
+type RetryPolicy = core.RetryPolicy
+
+// Same as SendToId, but failed attempts are retried with exponential backoff as given by the policy.
+// The id is resolved to an address again before each retry, so peers whose address changed are still reached.
+// The channel receives the first successful response, or nil once all attempts have failed.
+// Returns an error if no observed peer has the specified destination id, and ErrSendQueueFull as for SendToContext.
+func (c *Client) SendToIdWithRetry(destId []byte, data []byte, policy RetryPolicy) (chan []byte, error) {
+	if _, err := c.node.IdToAddr(destId); err != nil {
+		return nil, err
+	}
+
+	if err := c.checkSize(data); err != nil {
+		return nil, err
+	}
+
+	ch := make(chan []byte, 1)
+
+	if err := c.node.QueueMessageWithRetry(destId, ch, data, policy); err != nil {
+		return nil, err
+	}
+
+	return ch, nil
+}
+
+// Fire-and-forget version of SendTo for one-way notifications.
+// Returns once the message is queued for sending, without waiting for the response or allocating a reply channel.
+// The response from the receiver's message handler is discarded.
+// Notifications count towards MaxConcurrentMessages, Notify blocks while the limit is reached.
+// Returns an error if the client is not running.
+func (c *Client) Notify(dest string, data []byte) error {
+	if err := c.checkSize(data); err != nil {
+		return err
+	}
+
+	return c.node.Notify(dest, data)
 }
 
 // Same as SendTo, but destination is now the Ifrit id of the receiver.
-// Returns an error if no observed peer has the specified  destination id.
+// Returns an error if no observed peer has the specified  destination id, and ErrSendQueueFull as for SendToContext.
 func (c *Client) SendToId(destId []byte, data []byte) (chan []byte, error) {
 	addr, err := c.node.IdToAddr(destId)
 	if err != nil {
 		return nil, err
 	}
 
+	if err := c.checkSize(data); err != nil {
+		return nil, err
+	}
+
 	ch := make(chan []byte, 1)
 
-	go c.node.SendMessage(addr, ch, data)
+	if err := c.node.QueueMessage(context.Background(), addr, ch, data); err != nil {
+		return nil, err
+	}
+
+	return ch, nil
+}
+
+// Same as SendToId, but if no observed peer has the destination id yet, waits for it to join
+// the view through gossip before sending. Useful when the id was learned out-of-band before ifrit learned it.
+// The context bounds both the wait and the request, see SendToContext.
+// Returns the context error if the peer does not join before the context is done.
+func (c *Client) SendToIdWait(ctx context.Context, destId []byte, data []byte) (chan []byte, error) {
+	if err := c.checkSize(data); err != nil {
+		return nil, err
+	}
+
+	addr, err := c.node.WaitForPeer(ctx, destId)
+	if err != nil {
+		return nil, err
+	}
+
+	return c.SendToContext(ctx, addr, data)
+}
+
+// Sends the given data to all ifrit clients currently believed to be alive, except this client.
+// The live view is read once at call time.
+// Returns a map from each destination address to its reply channel, see SendTo for details on the channels.
+func (c *Client) Broadcast(data []byte) map[string]chan []byte {
+	return c.broadcast(c.peers(), data)
+}
+
+// Same as Broadcast, but only sends to a random subset of n live peers.
+// If n exceeds the number of live peers, the data is sent to all of them.
+func (c *Client) BroadcastN(data []byte, n int) map[string]chan []byte {
+	peers := c.peers()
+
+	if n < 0 {
+		n = 0
+	}
+
+	if n < len(peers) {
+		rand.Shuffle(len(peers), func(i, j int) {
+			peers[i], peers[j] = peers[j], peers[i]
+		})
+		peers = peers[:n]
+	}
 
-	return ch, err
+	return c.broadcast(peers, data)
+}
+
+// Same as Broadcast, but only sends to the live peers in the given zone, see MembersInZone.
+// Latency sensitive applications can use it to keep requests within their own region.
+func (c *Client) BroadcastZone(zone string, data []byte) map[string]chan []byte {
+	self := c.Addr()
+
+	members := c.MembersInZone(zone)
+	peers := make([]string, 0, len(members))
+
+	for _, addr := range members {
+		if addr != self {
+			peers = append(peers, addr)
+		}
+	}
+
+	return c.broadcast(peers, data)
+}
+
+// Returns a snapshot of the live members, excluding this client.
+func (c *Client) peers() []string {
+	self := c.Addr()
+
+	members := c.Members()
+	ret := make([]string, 0, len(members))
+
+	for _, addr := range members {
+		if addr != self {
+			ret = append(ret, addr)
+		}
+	}
+
+	return ret
+}
+
+func (c *Client) broadcast(dest []string, data []byte) map[string]chan []byte {
+	ret := make(map[string]chan []byte, len(dest))
+
+	for _, addr := range dest {
+		ret[addr] = c.SendTo(addr, data)
+	}
+
+	return ret
 }
 
 // Returns a pair of channels used for bi-directional streams, given the destination. The first channel
 // is the input stream to the server and the second stream is the reply stream from the server.
 // To close the stream, close the input channel. The reply stream is open as long as the server sends messages
 // back to the client. The caller must ensure that the reply stream does not block by draining the buffer so that the stream session can complete.
-// Note: it is adviced to implement an aknowledgement mechanism to avoid an untimely closing of a channel and loss of messages.
-func (c *Client) OpenStream(dest string) (chan []byte, chan []byte) {
+// The window bounds how many messages may be sent before the remote stream handler has consumed them,
+// once the window is full writes to the input stream block until the handler catches up.
+// Replies are buffered until they are read, so the window keeps moving while the reply stream is not drained.
+// A window of zero disables flow control.
+// Messages in both directions are compressed with the given algorithm, one of none, gzip or snappy,
+// empty uses ClientConfig.Compression. The receiver learns the algorithm when the stream is opened,
+// so stream handlers always see the original data. An unknown algorithm closes the reply stream right away.
+func (c *Client) OpenStream(dest string, window uint32, compression string) (chan []byte, chan []byte) {
 	inputStream := make(chan []byte)
 	replyStream := make(chan []byte)
 
-	go c.node.OpenStream(dest, inputStream, replyStream)
+	go c.node.OpenStream(dest, inputStream, replyStream, window, compression)
 
 	return inputStream, replyStream
 }
 
+// Same as OpenStream, but destination is now the Ifrit id of the receiver.
+// Returns an error if no observed peer has the specified destination id.
+func (c *Client) OpenStreamToId(destId []byte, window uint32, compression string) (chan []byte, chan []byte, error) {
+	input := make(chan []byte)
+	reply := make(chan []byte)
+
+	if err := c.node.OpenStreamToId(destId, input, reply, window, compression); err != nil {
+		return nil, nil, err
+	}
+
+	return input, reply, nil
+}
+
 // Registers the given function as the stream handler.
 // Invoked when the client opens a stream. The callback accepts two channels -
 // an unbuffered input channel and an unbuffered channel used for replying to the client.
@@ -214,6 +1090,16 @@ func (c *Client) RegisterStreamHandler(streamHandler func(chan []byte, chan []by
 	c.node.SetStreamHandler(streamHandler)
 }
 
+// Same as RegisterStreamHandler, but lets the application authorize each stream before any data flows.
+// The accept callback is invoked with the Ifrit id of the peer opening the stream, taken from the certificate
+// it presented during the mutual TLS handshake. Returning a non-nil error rejects the stream, the error is
+// returned to the opening side and its reply stream is closed. Otherwise the returned stream handler
+// is run with the stream's channels, as described in RegisterStreamHandler.
+// Replaces any handler registered through RegisterStreamHandler, and vice versa.
+func (c *Client) RegisterStreamHandlerWithSender(accept func(senderId []byte) (func(chan []byte, chan []byte), error)) {
+	c.node.SetStreamHandlerWithSender(accept)
+}
+
 // Registers the given function as the message handler.
 // Invoked each time the ifrit client receives an application message (another client sent it through SendTo), this callback will be invoked.
 // The returned byte slice will be sent back as the response.
@@ -224,14 +1110,30 @@ func (c *Client) RegisterMsgHandler(msgHandler func([]byte) ([]byte, error)) {
 	c.node.SetMsgHandler(msgHandler)
 }
 
+// Same as RegisterMsgHandler, but the callback also receives the Ifrit id of the sender.
+// The id is taken from the certificate the sender presented during the mutual TLS handshake,
+// which is signed by the trusted CA, so it cannot be spoofed.
+// Replaces any handler registered through RegisterMsgHandler, and vice versa.
+func (c *Client) RegisterMsgHandlerWithSender(msgHandler func(senderId []byte, data []byte) ([]byte, error)) {
+	c.node.SetMsgHandlerWithSender(msgHandler)
+}
+
 // Registers the given function as the gossip handler.
-// Invoked each time ifrit receives application gossip.
+// Invoked each time ifrit receives application gossip it has not seen recently,
+// content a peer keeps gossiping is only delivered once, see GossipCacheSize.
 // The returned byte slice will be sent back as the response.
 // If the callback returns a non-nil error, it will be sent back as the response instead.
 func (c *Client) RegisterGossipHandler(gossipHandler func([]byte) ([]byte, error)) {
 	c.node.SetGossipHandler(gossipHandler)
 }
 
+// Registers the given function as the batch gossip handler.
+// Invoked with the id and data of each payload a neighbor enqueued through EnqueueGossip,
+// in the order they were enqueued.
+func (c *Client) RegisterBatchGossipHandler(batchHandler func(id, data []byte)) {
+	c.node.SetBatchGossipHandler(batchHandler)
+}
+
 // Registers the given function as the gossip response handler.
 // Invoked when ifrit receives a response after gossiping application data.
 // All responses originates from a gossip handler invocation.
@@ -240,20 +1142,182 @@ func (c *Client) RegisterResponseHandler(responseHandler func([]byte)) {
 	c.node.SetResponseHandler(responseHandler)
 }
 
+// Same as RegisterResponseHandler, but the callback also receives the Ifrit id of the responding peer
+// and the id of the gossip content the response was made for, the sha256 digest of the content
+// set through SetGossipContent. Responses to replaced content can thus be told apart from current ones.
+// Replaces any handler registered through RegisterResponseHandler, and vice versa.
+func (c *Client) RegisterResponseHandlerWithSender(responseHandler func(peerId, contentId, response []byte)) {
+	c.node.SetResponseHandlerWithSender(responseHandler)
+}
+
+// Registers the given function as the gossip tap, for debugging what the client gossips.
+// Invoked with the neighbor address and the fully assembled message right before each outgoing gossip,
+// on the goroutine sending it. The same message may be sent to several neighbors, so the tap
+// must not modify it, and gossip is held up until the tap returns. Pass nil to remove the tap.
+func (c *Client) RegisterGossipTap(tap func(peerAddr string, msg *GossipMessage)) {
+	c.node.SetGossipTap(tap)
+}
+
+// Registers the given function as the membership handler.
+// Invoked each time a peer joins or leaves the live view, or gets accused of having crashed.
+// Events are delivered in the order they occurred on a dedicated goroutine,
+// a slow handler delays later events but never blocks the protocol.
+func (c *Client) RegisterMembershipHandler(membershipHandler func(MembershipEvent)) {
+	c.node.SetMembershipHandler(membershipHandler)
+}
+
+// Returns a channel receiving the same events as the membership handler, for select based loops.
+// Each call returns a new channel buffering 256 events. A consumer that falls further behind
+// loses the oldest buffered events, so neither the protocol nor other consumers wait for it.
+// The channel is closed when the client stops.
+func (c *Client) MembershipEvents() <-chan MembershipEvent {
+	return c.node.MembershipEvents()
+}
+
+// Registers the given function as the accusation handler.
+// Invoked each time the client processes a valid accusation against itself, after it has issued a rebuttal.
+// The callback receives the Ifrit id of the accuser and the ring the accusation was made on.
+// Repeated accusations can indicate local network or clock problems before the client is evicted.
+func (c *Client) RegisterAccusationHandler(accusationHandler func(accuserId []byte, ringNum uint32)) {
+	c.node.SetAccusationHandler(accusationHandler)
+}
+
+// Registers the given function as the drop handler.
+// Invoked each time inbound gossip is rejected, a stream message is lost
+// or the ping socket fails to read or write. peerId is the peer involved, nil if it is not known,
+// which is always the case for udp errors. Each drop is also counted in Stats.
+// Drops are handed to the handler one at a time, in order, from a single goroutine.
+// While the handler falls 256 drops behind, further drops are only counted.
+func (c *Client) RegisterDropHandler(dropHandler func(kind DropKind, peerId []byte)) {
+	c.node.SetDropHandler(dropHandler)
+}
+
+// Registers the given function as the cert expiry handler.
+// Invoked with the remaining validity once the client certificate is about to expire,
+// as configured by the cert expiry threshold, giving time to rotate it before tls handshakes fail.
+// The check runs each monitor interval, but the handler is only invoked once per crossing of the threshold.
+func (c *Client) RegisterCertExpiryHandler(certExpiryHandler func(expiresIn time.Duration)) {
+	c.node.SetCertExpiryHandler(certExpiryHandler)
+}
+
+// Registers the given function as the CA status handler.
+// Invoked with false once the CA stops answering, found by the periodic check of ClientConfig.CaPollInterval
+// or by a failed RotateCertificate, and with true once it answers again. Only changes are reported, the CA
+// is assumed up when the client starts. The client keeps working on its current certificate while the CA is
+// down, and a rotation that failed meanwhile is retried when the handler is invoked with true.
+func (c *Client) RegisterCAStatusHandler(caStatusHandler func(up bool)) {
+	c.node.SetCaStatusHandler(caStatusHandler)
+}
+
 // Replaces the gossip set with the given data.
 // This data will be exchanged with neighbors in each gossip interaction.
 // Recipients will receive it through the message handler callback.
 // The response generated by the message handler callback will be sent back and invoke the response handler callback.
+// Returns ErrGossipUnchanged if the given data equals the content already being gossiped.
 func (c *Client) SetGossipContent(data []byte) error {
 	if len(data) <= 0 {
 		return errNoData
 	}
 
-	c.node.SetExternalGossipContent(data)
+	if err := c.checkSize(data); err != nil {
+		return err
+	}
+
+	if changed := c.node.SetExternalGossipContent(data); !changed {
+		return ErrGossipUnchanged
+	}
+
+	return nil
+}
+
+// Same as SetGossipContent, but the content stops being gossiped once the given ttl has passed.
+// A ttl of zero or less never expires.
+func (c *Client) SetGossipContentWithTTL(data []byte, ttl time.Duration) error {
+	if len(data) <= 0 {
+		return errNoData
+	}
+
+	if err := c.checkSize(data); err != nil {
+		return err
+	}
+
+	c.node.SetExternalGossipContentWithTTL(data, ttl)
+
+	return nil
+}
+
+// Gossips the given data under the given id, next to the content set through SetGossipContent.
+// Unlike that content, entries are relayed beyond the neighbors: each client keeps the highest version
+// it has received for an id and gossips it on, so publishing a higher version replaces the content everywhere.
+// Returns ErrGossipVersion if an equal or higher version is already known for the id.
+// With ClientConfig.GossipComparator set, it decides instead of the versions.
+// Entries are signed, only the client that published an id can publish new versions of it,
+// ErrGossipVersion is also returned for an id published by another client.
+func (c *Client) SetGossipContentVersioned(id, data []byte, version uint64) error {
+	if len(data) <= 0 {
+		return errNoData
+	}
+
+	if err := c.checkSize(data); err != nil {
+		return err
+	}
+
+	if accepted := c.node.SetGossipContentVersioned(id, data, version); !accepted {
+		return ErrGossipVersion
+	}
+
+	return nil
+}
+
+// Returns the content and version of the highest version received for the given id,
+// the content is nil if nothing has been received for it.
+func (c *Client) GossipContentVersioned(id []byte) ([]byte, uint64) {
+	return c.node.GossipContentVersioned(id)
+}
+
+// Returns the content of every versioned entry this client currently gossips, keyed by id,
+// see SetGossipContentVersioned. The map and its content are copies and can be modified freely.
+func (c *Client) GossipContent() map[string][]byte {
+	return c.node.GossipContent()
+}
+
+// Enqueues the given data under the given id to be gossiped in the next gossip round only.
+// Everything enqueued between two rounds is sent together, so many small updates share a single round
+// instead of each replacing the content set through SetGossipContent. Receiving neighbors hand each payload
+// to the handler registered through RegisterBatchGossipHandler, payloads are not relayed any further.
+// Payloads are only sent by push gossip, an error is returned if the gossip mode is pull.
+func (c *Client) EnqueueGossip(id, data []byte) error {
+	if len(data) <= 0 {
+		return errNoData
+	}
+
+	if err := c.checkSize(data); err != nil {
+		return err
+	}
+
+	return c.node.EnqueueGossip(id, data)
+}
+
+// Rejects data that can not fit in a single message.
+func (c *Client) checkSize(data []byte) error {
+	if len(data) > c.maxMessageSize {
+		return ErrMessageSize
+	}
 
 	return nil
 }
 
+// Removes the gossip content, only membership information is exchanged with neighbors until new content is set.
+func (c *Client) ClearGossipContent() {
+	c.node.ClearExternalGossipContent()
+}
+
+// Same as SetGossipContent, but the gossip content is read from the given reader until EOF.
+// Returns an error if the reader yields no data.
+func (c *Client) SetGossipContentFromReader(data io.Reader) error {
+	return c.node.ReadExternalGossipContent(data)
+}
+
 func (c *Client) SavePrivateKey(path string) error {
 	return c.node.SavePrivateKey(path)
 }
@@ -262,6 +1326,26 @@ func (c *Client) SaveCertificate(path string) error {
 	return c.node.SaveCertificate(path)
 }
 
+// Returns the client's current certificate, DER encoded, for registering the client with other systems
+// without saving it to disk. The certificate changes when it is rotated, see RotateCertificate.
+// The returned slice is a copy and can be safely modified.
+func (c *Client) Certificate() []byte {
+	return c.node.Certificate()
+}
+
+// Returns the client's public key, DER encoded as a PKIX subject public key info, see x509.ParsePKIXPublicKey.
+// The returned slice is a copy and can be safely modified.
+func (c *Client) PublicKey() []byte {
+	return c.node.PublicKey()
+}
+
+// Returns the certificate chain, known certificates and private key of the client as a single PEM bundle.
+// Pass it as ClientConfig.Identity to create a client with the same identity, for example when
+// provisioning nodes through a secrets manager. Fails if the key is held by an external signer.
+func (c *Client) ExportIdentity() ([]byte, error) {
+	return c.node.ExportIdentity()
+}
+
 func readConfig() error {
 	viper.SetConfigName("ifrit_config")
 	viper.AddConfigPath("/var/tmp")
@@ -269,9 +1353,11 @@ func readConfig() error {
 
 	viper.SetConfigType("yaml")
 
-	err := viper.ReadInConfig()
-	if err != nil {
-		return err
+	// The config file is optional, all settings can be given through ClientConfig.
+	if err := viper.ReadInConfig(); err != nil {
+		if _, ok := err.(viper.ConfigFileNotFoundError); !ok {
+			return err
+		}
 	}
 
 	// Behavior variables
@@ -280,14 +1366,262 @@ func readConfig() error {
 	viper.SetDefault("view_update_interval", 10)
 	viper.SetDefault("ping_limit", 3)
 	viper.SetDefault("pings_per_interval", 3)
+	viper.SetDefault("ping_timeout", 5)
+	viper.SetDefault("ping_retransmits", 0)
 	viper.SetDefault("removal_timeout", 60)
 	viper.SetDefault("max_concurrent_messages", 5)
+	viper.SetDefault("max_concurrent_streams", 0)
+	viper.SetDefault("max_message_size", comm.DefaultMaxMessageSize)
+	viper.SetDefault("max_gossip_size", 0)
+	viper.SetDefault("max_view_size", 0)
+	viper.SetDefault("ring_mask", 0)
+	viper.SetDefault("message_timeout", 0)
+	viper.SetDefault("gossip_round_timeout", 0)
+	viper.SetDefault("gossip_fanout", 0)
+	viper.SetDefault("gossip_mode", "push")
+	viper.SetDefault("gossip_cache_size", 1024)
+	viper.SetDefault("gossip_rate_limit", 10)
+	viper.SetDefault("gossip_rate_burst", 20)
 	viper.SetDefault("use_compression", true)
+	viper.SetDefault("cert_expiry_threshold", 86400)
+	viper.SetDefault("clock_skew_threshold", 1)
+	viper.SetDefault("seed_retry_timeout", 300)
+	viper.SetDefault("health_missed_rounds", 3)
+	viper.SetDefault("health_isolation_timeout", 300)
+	viper.SetDefault("ca_timeout", 10)
+	viper.SetDefault("ca_request_attempts", 5)
+	viper.SetDefault("ca_poll_interval", 30)
+	viper.SetDefault("ca_retry_backoff", 1)
+	viper.SetDefault("signature_hash", comm.SignatureSHA256)
 
 	// Visualizer specific
 	viper.SetDefault("viz_update_interval", 10)
 
-	viper.SafeWriteConfig()
+	return nil
+}
+
+// Highest valid port number, port zero picks a free port.
+const maxPort = 65535
+
+// Catches invalid settings before anything is bound or requested from the CA.
+func (cfg *ClientConfig) validate() error {
+	if cfg.UdpPort < 0 || cfg.UdpPort > maxPort {
+		return fmt.Errorf("UdpPort %d is outside of the valid range 0-%d", cfg.UdpPort, maxPort)
+	}
+
+	if cfg.TcpPort < 0 || cfg.TcpPort > maxPort {
+		return fmt.Errorf("TcpPort %d is outside of the valid range 0-%d", cfg.TcpPort, maxPort)
+	}
+
+	if cfg.Hostname == "" {
+		return errNoHostname
+	}
+
+	// The identity bundle takes precedence, the cert path is not used then.
+	if cfg.Identity == nil && cfg.CertPath != "" {
+		if _, err := ioutil.ReadDir(cfg.CertPath); err != nil {
+			return fmt.Errorf("CertPath is not a readable directory: %w", err)
+		}
+	}
 
 	return nil
 }
+
+func (cfg *ClientConfig) caAddr() string {
+	if cfg.CaAddr != "" {
+		return cfg.CaAddr
+	}
+
+	return viper.GetString("ca_addr")
+}
+
+// Carries the zone label, if any, as the organizational unit of the certificate requested for the identity.
+func (cfg *ClientConfig) setZone(pk *pkix.Name) {
+	if zone := stringSetting(cfg.Zone, "zone"); zone != "" {
+		pk.OrganizationalUnit = []string{zone}
+	}
+}
+
+// Upper bound of the wait between certificate requests to the CA.
+const maxCaBackoff = time.Second * 30
+
+func (cfg *ClientConfig) caRequestPolicy() (comm.CaRequestPolicy, error) {
+	policy := comm.CaRequestPolicy{
+		Timeout:     intervalSetting(cfg.CaTimeout, "ca_timeout"),
+		MaxAttempts: int(uintSetting(cfg.CaRequestAttempts, "ca_request_attempts")),
+		BaseBackoff: intervalSetting(cfg.CaRetryBackoff, "ca_retry_backoff"),
+		MaxBackoff:  maxCaBackoff,
+		AllowHttp:   cfg.AllowInsecureCa || viper.GetBool("allow_insecure_ca"),
+	}
+
+	if path := stringSetting(cfg.CaServerCertPath, "ca_server_cert_path"); path != "" {
+		c, err := comm.LoadCertificate(path)
+		if err != nil {
+			return policy, err
+		}
+
+		policy.RootCAs = x509.NewCertPool()
+		policy.RootCAs.AddCert(c)
+	}
+
+	return policy, nil
+}
+
+// Signer given in the config, or one derived from the test seed.
+func (cfg *ClientConfig) signer() (Signer, error) {
+	if cfg.InsecureTestSeed == nil {
+		return cfg.Signer, nil
+	}
+
+	if cfg.Signer != nil {
+		return nil, errSeedSigner
+	}
+
+	log.Warn("Deriving key from InsecureTestSeed, never use it in production")
+
+	return comm.NewSeededSigner(cfg.InsecureTestSeed), nil
+}
+
+func (cfg *ClientConfig) udpNetwork() string {
+	if cfg.UdpNetwork != "" {
+		return cfg.UdpNetwork
+	}
+
+	return "udp"
+}
+
+// The compression setting takes precedence over the older use_compression setting.
+func (cfg *ClientConfig) compression() string {
+	if cfg.Compression != "" {
+		return cfg.Compression
+	}
+
+	if viper.IsSet("compression") {
+		return viper.GetString("compression")
+	}
+
+	if viper.GetBool("use_compression") {
+		return comm.GzipCompression
+	}
+
+	return comm.NoCompression
+}
+
+// Loads the additionally trusted CA certificates, the paths of the client config take precedence over trusted_ca_paths.
+func (cfg *ClientConfig) trustedCAs() ([]*x509.Certificate, error) {
+	var certs []*x509.Certificate
+
+	paths := cfg.TrustedCaPaths
+	if len(paths) == 0 {
+		paths = viper.GetStringSlice("trusted_ca_paths")
+	}
+
+	for _, p := range paths {
+		c, err := comm.LoadCertificate(p)
+		if err != nil {
+			return nil, err
+		}
+		certs = append(certs, c)
+	}
+
+	return certs, nil
+}
+
+// Merges the behavior settings of the client config with the ifrit config file,
+// settings given in the client config takes precedence.
+func (cfg *ClientConfig) nodeConfig() *core.Config {
+	return &core.Config{
+		GossipInterval:        intervalSetting(cfg.GossipInterval, "gossip_interval"),
+		MonitorInterval:       intervalSetting(cfg.MonitorInterval, "monitor_interval"),
+		ViewUpdateInterval:    intervalSetting(cfg.ViewUpdateInterval, "view_update_interval"),
+		RemovalTimeout:        intervalSetting(cfg.RemovalTimeout, "removal_timeout"),
+		PingLimit:             uintSetting(cfg.PingLimit, "ping_limit"),
+		PingsPerInterval:      uintSetting(cfg.PingsPerInterval, "pings_per_interval"),
+		MaxConcurrentMessages: uintSetting(cfg.MaxConcurrentMessages, "max_concurrent_messages"),
+		MessageTimeout:        intervalSetting(cfg.MessageTimeout, "message_timeout"),
+		GossipFanout:          uintSetting(cfg.GossipFanout, "gossip_fanout"),
+		GossipMode:            stringSetting(cfg.GossipMode, "gossip_mode"),
+		GossipCacheSize:       cfg.gossipCacheSize(),
+		GossipRateLimit:       uintSetting(cfg.GossipRateLimit, "gossip_rate_limit"),
+		GossipRateBurst:       uintSetting(cfg.GossipRateBurst, "gossip_rate_burst"),
+		MaxGossipSize:         uintSetting(cfg.MaxGossipSize, "max_gossip_size"),
+		MaxViewSize:           uintSetting(cfg.MaxViewSize, "max_view_size"),
+		RingMask:              uintSetting(cfg.RingMask, "ring_mask"),
+		EntryAddrs:            viper.GetStringSlice("entry_addrs"),
+		CertExpiryThreshold:   cfg.certExpiryThreshold(),
+		ClockSkewThreshold:    intervalSetting(cfg.ClockSkewThreshold, "clock_skew_threshold"),
+		CaPollInterval:        intervalSetting(cfg.CaPollInterval, "ca_poll_interval"),
+		SeedNodes:             stringSliceSetting(cfg.SeedNodes, "seed_nodes"),
+		SeedRetryTimeout:      intervalSetting(cfg.SeedRetryTimeout, "seed_retry_timeout"),
+		HealthMissedRounds:    uintSetting(cfg.HealthMissedRounds, "health_missed_rounds"),
+		Tracer:                cfg.Tracer,
+		PartnerSelector:       cfg.PartnerSelector,
+		Clock:                 cfg.Clock,
+		KeyPins:               cfg.KeyPins,
+		GossipComparator:      cfg.GossipComparator,
+
+		GossipRoundTimeout: intervalSetting(cfg.GossipRoundTimeout, "gossip_round_timeout"),
+
+		ObserverMode: cfg.ObserverMode || viper.GetBool("observer_mode"),
+
+		TcpPingFallback: cfg.TcpPingFallback || viper.GetBool("tcp_ping_fallback"),
+
+		HealthIsolationTimeout: intervalSetting(cfg.HealthIsolationTimeout, "health_isolation_timeout"),
+
+		UseViz:            cfg.UseViz || viper.GetBool("use_viz"),
+		VizAddr:           stringSetting(cfg.VizAddr, "viz_addr"),
+		VizUpdateInterval: intervalSetting(0, "viz_update_interval"),
+		HttpAddr:          stringSetting(cfg.HttpAddr, "http_addr"),
+	}
+}
+
+// The node does not deduplicate gossip with a cache size of zero.
+func (cfg *ClientConfig) gossipCacheSize() uint32 {
+	if cfg.DisableGossipCache {
+		return 0
+	}
+
+	return uintSetting(cfg.GossipCacheSize, "gossip_cache_size")
+}
+
+// A negative threshold disables the check, which the node does for any threshold that is not positive.
+func (cfg *ClientConfig) certExpiryThreshold() time.Duration {
+	if cfg.CertExpiryThreshold < 0 {
+		return 0
+	}
+
+	return intervalSetting(cfg.CertExpiryThreshold, "cert_expiry_threshold")
+}
+
+// Config file intervals are given in seconds.
+func intervalSetting(value time.Duration, key string) time.Duration {
+	if value > 0 {
+		return value
+	}
+
+	return time.Second * time.Duration(viper.GetInt32(key))
+}
+
+func stringSetting(value string, key string) string {
+	if value != "" {
+		return value
+	}
+
+	return viper.GetString(key)
+}
+
+func stringSliceSetting(value []string, key string) []string {
+	if len(value) > 0 {
+		return value
+	}
+
+	return viper.GetStringSlice(key)
+}
+
+func uintSetting(value uint32, key string) uint32 {
+	if value > 0 {
+		return value
+	}
+
+	return uint32(viper.GetInt32(key))
+}
@@ -0,0 +1,206 @@
+package ifrit
+
+import (
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	log "github.com/inconshreveable/log15"
+	"github.com/stretchr/testify/require"
+	"github.com/stretchr/testify/suite"
+)
+
+type ClientTestSuite struct {
+	suite.Suite
+}
+
+func TestClientTestSuite(t *testing.T) {
+	r := log.Root()
+
+	r.SetHandler(log.DiscardHandler())
+
+	suite.Run(t, new(ClientTestSuite))
+}
+
+func (suite *ClientTestSuite) TestNewClientConfigError() {
+	tests := []struct {
+		name string
+		cfg  *ClientConfig
+	}{
+		{"nil config", nil},
+		{"no hostname", &ClientConfig{}},
+		{"invalid port", &ClientConfig{Hostname: "127.0.0.1", TcpPort: -1}},
+		{"unreadable cert path", &ClientConfig{Hostname: "127.0.0.1", CertPath: "/nonexistent/ifrit"}},
+	}
+
+	for _, t := range tests {
+		_, err := NewClient(t.cfg)
+		require.True(suite.T(), errors.Is(err, ErrConfig), "%s: should fail with ErrConfig, got %v.", t.name, err)
+		require.False(suite.T(), errors.Is(err, ErrBind), "%s: matched another stage.", t.name)
+	}
+}
+
+func (suite *ClientTestSuite) TestNewClientBindError() {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(suite.T(), err, "Failed to listen.")
+	defer l.Close()
+
+	_, err = NewClient(&ClientConfig{
+		Hostname: "127.0.0.1",
+		TcpPort:  l.Addr().(*net.TCPAddr).Port,
+	})
+	require.True(suite.T(), errors.Is(err, ErrBind), "Should fail with ErrBind, got %v.", err)
+}
+
+func (suite *ClientTestSuite) TestNewClientCaError() {
+	_, err := NewClient(&ClientConfig{
+		Hostname:          "127.0.0.1",
+		CaAddr:            freeAddr(suite.T()),
+		CaTimeout:         time.Millisecond * 100,
+		CaRequestAttempts: 1,
+		AllowInsecureCa:   true,
+	})
+	require.True(suite.T(), errors.Is(err, ErrCA), "Should fail with ErrCA, got %v.", err)
+	require.True(suite.T(), errors.Is(err, ErrCaUnreachable), "Cause not kept, got %v.", err)
+
+	var setupErr *SetupError
+	require.True(suite.T(), errors.As(err, &setupErr), "Not a SetupError, got %T.", err)
+	require.Equal(suite.T(), ErrCA, setupErr.Stage, "Wrong stage.")
+}
+
+func (suite *ClientTestSuite) TestNewClientCommError() {
+	_, err := NewClient(&ClientConfig{
+		Hostname:    "127.0.0.1",
+		Compression: "unknown",
+	})
+	require.True(suite.T(), errors.Is(err, ErrComm), "Should fail with ErrComm, got %v.", err)
+}
+
+func (suite *ClientTestSuite) TestIpv6Addr() {
+	c, err := NewClient(&ClientConfig{
+		Hostname: "::1",
+	})
+	require.NoError(suite.T(), err, "Failed to create client.")
+	defer c.Stop()
+
+	host, port, err := net.SplitHostPort(c.Addr())
+	require.NoError(suite.T(), err, "Invalid client address.")
+	require.Equal(suite.T(), "::1", host, "Wrong host.")
+	require.NotEqual(suite.T(), "0", port, "Advertised the configured port instead of the bound one.")
+}
+
+func (suite *ClientTestSuite) TestCertExpiryThreshold() {
+	cfg := &ClientConfig{CertExpiryThreshold: time.Hour}
+	require.Equal(suite.T(), time.Hour, cfg.nodeConfig().CertExpiryThreshold, "Threshold not passed on.")
+
+	cfg.CertExpiryThreshold = -1
+	require.Zero(suite.T(), cfg.nodeConfig().CertExpiryThreshold, "Negative threshold did not disable the check.")
+}
+
+func (suite *ClientTestSuite) TestDisableGossipCache() {
+	cfg := &ClientConfig{GossipCacheSize: 10}
+	require.Equal(suite.T(), uint32(10), cfg.nodeConfig().GossipCacheSize, "Cache size not passed on.")
+
+	cfg.DisableGossipCache = true
+	require.Zero(suite.T(), cfg.nodeConfig().GossipCacheSize, "Deduplication not disabled.")
+}
+
+func (suite *ClientTestSuite) TestOpenStreamToUnknownId() {
+	c, err := NewClient(&ClientConfig{
+		Hostname: "127.0.0.1",
+	})
+	require.NoError(suite.T(), err, "Failed to create client.")
+	defer c.Stop()
+
+	input, reply, err := c.OpenStreamToId([]byte("unknown"), 0, "")
+	require.Error(suite.T(), err, "Opened a stream to an unknown id.")
+	require.Nil(suite.T(), input, "Input stream returned with an error.")
+	require.Nil(suite.T(), reply, "Reply stream returned with an error.")
+}
+
+func (suite *ClientTestSuite) TestDiscoveryOnOsAssignedPorts() {
+	numClients := 10
+
+	clients := make([]*Client, 0, numClients)
+	defer func() {
+		for _, c := range clients {
+			c.Stop()
+		}
+	}()
+
+	var seeds []string
+
+	for i := 0; i < numClients; i++ {
+		c, err := NewClient(&ClientConfig{
+			Hostname:           "127.0.0.1",
+			GossipInterval:     time.Millisecond * 100,
+			MonitorInterval:    time.Millisecond * 100,
+			ViewUpdateInterval: time.Millisecond * 100,
+			SeedNodes:          seeds,
+		})
+		require.NoError(suite.T(), err, "Failed to create client.")
+
+		clients = append(clients, c)
+
+		_, port, err := net.SplitHostPort(c.Addr())
+		require.NoError(suite.T(), err, "Invalid client address.")
+		require.NotEqual(suite.T(), "0", port, "Advertised the configured port instead of the bound one.")
+
+		ready, _, err := c.StartAsync()
+		require.NoError(suite.T(), err, "Failed to start client.")
+		<-ready
+
+		if seeds == nil {
+			seeds = []string{c.Addr()}
+		}
+	}
+
+	require.Eventually(suite.T(), func() bool {
+		for _, c := range clients {
+			if len(c.Members()) != numClients-1 {
+				return false
+			}
+		}
+		return true
+	}, time.Second*30, time.Millisecond*100, "Clients did not discover each other.")
+}
+
+func (suite *ClientTestSuite) TestLoggerIsGlobal() {
+	defer func() {
+		installedLogger = nil
+		log.Root().SetHandler(log.DiscardHandler())
+	}()
+
+	first, second := &discardLogger{name: "first"}, &discardLogger{name: "second"}
+
+	require.NoError(suite.T(), setLogger(first), "Failed to install logger.")
+	require.NoError(suite.T(), setLogger(first), "Installing the same logger again failed.")
+	require.Equal(suite.T(), errLoggerSet, setLogger(second), "Replaced the installed logger.")
+
+	_, err := NewClient(&ClientConfig{
+		Hostname: "127.0.0.1",
+		Logger:   second,
+	})
+	require.True(suite.T(), errors.Is(err, ErrConfig), "Should fail with ErrConfig, got %v.", err)
+	require.True(suite.T(), errors.Is(err, errLoggerSet), "Cause not kept, got %v.", err)
+}
+
+type discardLogger struct {
+	name string
+}
+
+func (l *discardLogger) Debug(msg string, keyvals ...interface{}) {}
+func (l *discardLogger) Info(msg string, keyvals ...interface{})  {}
+func (l *discardLogger) Error(msg string, keyvals ...interface{}) {}
+
+// Address nothing listens on.
+func freeAddr(t *testing.T) string {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err, "Failed to listen.")
+
+	addr := l.Addr().String()
+	l.Close()
+
+	return addr
+}
@@ -2,23 +2,33 @@ package comm
 
 import (
 	"crypto/tls"
+	"crypto/x509"
 	"errors"
+	"fmt"
+	"net"
 	"sync"
 	"time"
 	"io"
 
 	pb "github.com/joonnna/ifrit/protobuf"
 	log "github.com/inconshreveable/log15"
-	"github.com/spf13/viper"
 	"golang.org/x/net/context"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/encoding"
 	"google.golang.org/grpc/encoding/gzip"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"github.com/golang/protobuf/proto"
 )
 
 var (
+	// Returned by PeerCertificate when the peer presents no certificate or one that fails verification.
+	ErrPeerCert = errors.New("Peer certificate could not be verified")
+
 	errReachable = errors.New("Remote entity not reachable")
-	errNilConfig = errors.New("Provided tls config was nil")
+	errNilConfig   = errors.New("Provided tls config was nil")
+	errCompression = errors.New("Unknown compression, must be none, gzip or snappy")
 )
 
 type gRPCClient struct {
@@ -26,6 +36,11 @@ type gRPCClient struct {
 	connectionMutex sync.RWMutex
 
 	dialOptions []grpc.DialOption
+
+	// Same configuration as the transport credentials, for handshakes outside of grpc.
+	tlsConfig *tls.Config
+
+	maxMsgSize int
 }
 
 type conn struct {
@@ -33,82 +48,166 @@ type conn struct {
 	cc *grpc.ClientConn
 }
 
-func newClient(config *tls.Config) (*gRPCClient, error) {
+// Messages larger than maxMsgSize bytes are neither sent nor accepted as replies.
+// The given options are applied after our defaults and before the transport credentials.
+func newClient(config *tls.Config, compression string, maxMsgSize int, opts []grpc.DialOption) (*gRPCClient, error) {
 	var dialOptions []grpc.DialOption
 
 	if config == nil {
 		return nil, errNilConfig
 	}
 
+	switch compression {
+	case "", NoCompression, gzip.Name, snappyName:
+	default:
+		return nil, errCompression
+	}
+
 	creds := credentials.NewTLS(config)
 
-	dialOptions = append(dialOptions, grpc.WithTransportCredentials(creds))
 	dialOptions = append(dialOptions, grpc.WithBackoffMaxDelay(time.Minute*1))
+	dialOptions = append(dialOptions,
+		grpc.WithDefaultCallOptions(grpc.MaxCallRecvMsgSize(maxMsgSize), grpc.MaxCallSendMsgSize(maxMsgSize)))
 
-	if compress := viper.GetBool("use_compression"); compress {
+	if compression != "" && compression != NoCompression {
 		dialOptions = append(dialOptions,
-			grpc.WithDefaultCallOptions(grpc.UseCompressor(gzip.Name)))
+			grpc.WithDefaultCallOptions(grpc.UseCompressor(compression)))
 	}
 
+	dialOptions = append(dialOptions, opts...)
+	dialOptions = append(dialOptions, grpc.WithTransportCredentials(creds))
+
 	return &gRPCClient{
 		allConnections: make(map[string]*conn),
 		dialOptions:    dialOptions,
+		tlsConfig:      config,
+		maxMsgSize:     maxMsgSize,
 	}, nil
 }
 
-func (c *gRPCClient) Gossip(addr string, args *pb.State) (*pb.StateResponse, error) {
+// Cancelling the given context aborts the rpc.
+func (c *gRPCClient) Gossip(ctx context.Context, addr string, args *pb.State) (*pb.StateResponse, error) {
 	conn, err := c.connection(addr)
 	if err != nil {
 		return nil, err
 	}
 
-	r, err := conn.Spread(context.Background(), args)
-	if err != nil {
+	if err := c.checkSize(args); err != nil {
 		return nil, err
 	}
 
+	r, err := conn.Spread(ctx, args)
+	if err != nil {
+		return nil, sizeError(err)
+	}
+
 	return r, nil
 }
 
-func (c *gRPCClient) Send(addr string, args *pb.Msg) (*pb.MsgResponse, error) {
+// Cancelling the given context aborts the rpc.
+func (c *gRPCClient) Pull(ctx context.Context, addr string, args *pb.State) (*pb.StateResponse, error) {
 	conn, err := c.connection(addr)
 	if err != nil {
 		return nil, err
 	}
 
-	r, err := conn.Messenger(context.Background(), args)
+	if err := c.checkSize(args); err != nil {
+		return nil, err
+	}
+
+	r, err := conn.Pull(ctx, args)
+	if err != nil {
+		return nil, sizeError(err)
+	}
+
+	return r, nil
+}
+
+// Cancelling the given context aborts the rpc.
+func (c *gRPCClient) Send(ctx context.Context, addr string, args *pb.Msg) (*pb.MsgResponse, error) {
+	conn, err := c.connection(addr)
 	if err != nil {
 		return nil, err
 	}
 
+	if err := c.checkSize(args); err != nil {
+		return nil, err
+	}
+
+	r, err := conn.Messenger(ctx, args)
+	if err != nil {
+		return nil, sizeError(err)
+	}
+
 	return r, nil
 }
 
-func (c *gRPCClient) StreamMessenger(addr string, input, reply chan []byte) error {
+// A window larger than zero enables flow control, at most window messages
+// are sent without being acknowledged by the remote stream handler.
+// Sending blocks until the next acknowledgement arrives. Replies not yet received by the caller
+// are buffered, so acknowledgements reopen the window even while the caller is not draining replies.
+// Zero disables flow control.
+// Messages in both directions are compressed with the given algorithm, empty uses the client's compression.
+// The receiver learns the algorithm from the stream headers and decompresses transparently.
+// Dropped, if not nil, is called for each message that is too large or could not be sent before the stream broke.
+func (c *gRPCClient) StreamMessenger(addr string, input, reply chan []byte, window uint32, compression string, dropped func()) error {
+	defer close(reply)
+
+	opts, err := compressionOptions(compression)
+	if err != nil {
+		return err
+	}
+
 	conn, err := c.connection(addr)
 	if err != nil {
 		return err
 	}
 	
-	srv, err := conn.Stream(context.Background()) 
+	srv, err := conn.Stream(context.Background(), opts...) 
 	if err != nil {
 		return err
 	}
 
 	ctx := srv.Context()
-	done := make(chan bool)
-	defer close(reply)
+	errs := make(chan error, 1)
+
+	// Holds one slot per unacknowledged message
+	var outstanding chan struct{}
+	if window > 0 {
+		outstanding = make(chan struct{}, window)
+	}
 
 	// Sending messages from input stream to the server. 
 	// Runs until the producer closes the channel
 	go func() {
+		var seq uint64
+
 		for content := range input {
 			msg := &pb.Msg{
 				Content: content,
 			}
 
+			if err := c.checkSize(msg); err != nil {
+				log.Error(err.Error())
+				streamDropped(dropped)
+				continue
+			}
+
+			if outstanding != nil {
+				select {
+				case outstanding <- struct{}{}:
+				case <-ctx.Done():
+					streamDropped(dropped)
+					continue
+				}
+
+				seq++
+				msg.Seq = seq
+			}
+
 			if err := srv.Send(msg); err != nil {
 				log.Error(err.Error())
+				streamDropped(dropped)
 			}
 		}
 
@@ -118,20 +217,25 @@ func (c *gRPCClient) StreamMessenger(addr string, input, reply chan []byte) erro
 	}()
 
 	// Receiving replies from the server
+	incoming := make(chan *pb.MsgResponse)
+
 	go func() {
+		defer close(incoming)
+
 		for {
 			req, err := srv.Recv()
 			if err == io.EOF {
-				close(done)
+				errs <- nil
 				return
 			}
 
+			// The stream is finished, e.g. rejected by the remote handler
 			if err != nil {
-				log.Error(err.Error())
-				continue
+				errs <- err
+				return
 			}
 
-			reply <-req.GetContent()
+			incoming <- req
 		}
 	}()
 
@@ -143,11 +247,79 @@ func (c *gRPCClient) StreamMessenger(addr string, input, reply chan []byte) erro
 		//close(done) // might need to call close here?
 	}()
 
-	<-done
+	// Acknowledgements are handled while replies wait for the caller, a caller that is
+	// slow to drain replies must not keep the send window from reopening.
+	var pending [][]byte
+
+	for incoming != nil || len(pending) > 0 {
+		var out chan []byte
+		var next []byte
+
+		if len(pending) > 0 {
+			out = reply
+			next = pending[0]
+		}
+
+		select {
+		case out <- next:
+			pending = pending[1:]
+		case req, ok := <-incoming:
+			if !ok {
+				incoming = nil
+				continue
+			}
+
+			if req.GetAck() != 0 {
+				if outstanding != nil {
+					<-outstanding
+				}
+				continue
+			}
+
+			pending = append(pending, req.GetContent())
+		}
+	}
+
+	return <-errs
+}
+
+func streamDropped(dropped func()) {
+	if dropped != nil {
+		dropped()
+	}
+}
+
+// Call options overriding the client's compression, none if empty.
+func compressionOptions(compression string) ([]grpc.CallOption, error) {
+	switch compression {
+	case "":
+		return nil, nil
+	case NoCompression:
+		return []grpc.CallOption{grpc.UseCompressor(encoding.Identity)}, nil
+	case gzip.Name, snappyName:
+		return []grpc.CallOption{grpc.UseCompressor(compression)}, nil
+	default:
+		return nil, errCompression
+	}
+}
+
+func (c *gRPCClient) checkSize(msg proto.Message) error {
+	if proto.Size(msg) > c.maxMsgSize {
+		return ErrMessageSize
+	}
 
 	return nil
 }
 
+// The remote peer rejects messages exceeding its own limit with resource exhausted.
+func sizeError(err error) error {
+	if status.Code(err) == codes.ResourceExhausted {
+		return ErrMessageSize
+	}
+
+	return err
+}
+
 func (c *gRPCClient) CloseConn(addr string) {
 	c.connectionMutex.Lock()
 	defer c.connectionMutex.Unlock()
@@ -158,6 +330,133 @@ func (c *gRPCClient) CloseConn(addr string) {
 	}
 }
 
+// Sends the message over a connection of its own that is closed once the reply arrives, so hosts
+// outside of the view leave no connection behind. The certificate the host presents during the
+// handshake is verified against our CA certificates, if there are any, and then given to verify,
+// the message is only sent if both accept it.
+// Returns an error wrapping ErrPeerCert if the CA verification fails, and the error of verify if it rejects the certificate.
+func (c *gRPCClient) SendToHost(ctx context.Context, addr string, args *pb.Msg, verify func(*x509.Certificate) error) (*pb.MsgResponse, error) {
+	if err := c.checkSize(args); err != nil {
+		return nil, err
+	}
+
+	config := c.tlsConfig.Clone()
+	config.VerifyPeerCertificate = func(rawCerts [][]byte, _ [][]*x509.Certificate) error {
+		if len(rawCerts) == 0 {
+			return ErrPeerCert
+		}
+
+		cert, err := x509.ParseCertificate(rawCerts[0])
+		if err != nil {
+			return fmt.Errorf("%w: %s", ErrPeerCert, err.Error())
+		}
+
+		return verify(cert)
+	}
+
+	creds := &handshakeCreds{TransportCredentials: credentials.NewTLS(config)}
+
+	cc, err := grpc.DialContext(ctx, addr, append(c.dialOptions, grpc.WithTransportCredentials(creds))...)
+	if err != nil {
+		return nil, err
+	}
+	defer cc.Close()
+
+	r, err := pb.NewGossipClient(cc).Messenger(ctx, args)
+	if err != nil {
+		// The rpc only reports the handshake failure as unavailable.
+		if hsErr := creds.err(); hsErr != nil {
+			if verificationError(hsErr) {
+				return nil, fmt.Errorf("%w: %s", ErrPeerCert, hsErr.Error())
+			}
+			return nil, hsErr
+		}
+		return nil, sizeError(err)
+	}
+
+	return r, nil
+}
+
+// Keeps the error of the last failed client handshake.
+type handshakeCreds struct {
+	credentials.TransportCredentials
+
+	lastErr error
+	mutex   sync.Mutex
+}
+
+func (hc *handshakeCreds) ClientHandshake(ctx context.Context, authority string, raw net.Conn) (net.Conn, credentials.AuthInfo, error) {
+	conn, info, err := hc.TransportCredentials.ClientHandshake(ctx, authority, raw)
+	if err != nil {
+		hc.mutex.Lock()
+		hc.lastErr = err
+		hc.mutex.Unlock()
+	}
+
+	return conn, info, err
+}
+
+// Clones share the recorded error, they belong to the same send.
+func (hc *handshakeCreds) Clone() credentials.TransportCredentials {
+	return hc
+}
+
+func (hc *handshakeCreds) err() error {
+	hc.mutex.Lock()
+	defer hc.mutex.Unlock()
+
+	return hc.lastErr
+}
+
+// Performs a tls handshake with the given address, like the one preceding rpcs, and returns the
+// certificate the peer presented. The certificate is verified against our CA certificates if there are any.
+// Returns an error wrapping ErrPeerCert if the certificate is missing or fails verification.
+func (c *gRPCClient) PeerCertificate(ctx context.Context, addr string) (*x509.Certificate, error) {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, err
+	}
+
+	raw, err := (&net.Dialer{}).DialContext(ctx, "tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	defer raw.Close()
+
+	if deadline, ok := ctx.Deadline(); ok {
+		raw.SetDeadline(deadline)
+	}
+
+	config := c.tlsConfig.Clone()
+	if config.ServerName == "" {
+		config.ServerName = host
+	}
+
+	tlsConn := tls.Client(raw, config)
+
+	if err := tlsConn.Handshake(); err != nil {
+		if verificationError(err) {
+			return nil, fmt.Errorf("%w: %s", ErrPeerCert, err.Error())
+		}
+		return nil, err
+	}
+
+	certs := tlsConn.ConnectionState().PeerCertificates
+	if len(certs) == 0 {
+		return nil, ErrPeerCert
+	}
+
+	return certs[0], nil
+}
+
+func verificationError(err error) bool {
+	var authority x509.UnknownAuthorityError
+	var hostname x509.HostnameError
+	var invalid x509.CertificateInvalidError
+
+	return errors.As(err, &authority) || errors.As(err, &hostname) || errors.As(err, &invalid)
+}
+
 func (c *gRPCClient) dial(addr string) (*conn, error) {
 	c.connectionMutex.Lock()
 	defer c.connectionMutex.Unlock()
@@ -2,6 +2,7 @@ package comm
 
 import (
 	"crypto/tls"
+	"crypto/x509"
 	"crypto/x509/pkix"
 	"os"
 	"testing"
@@ -28,7 +29,7 @@ func (suite *ClientTestSuite) SetupTest() {
 	conf, err := validClientConfig()
 	require.NoError(suite.T(), err, "Failed to generate config")
 
-	c, err := newClient(conf)
+	c, err := newClient(conf, GzipCompression, DefaultMaxMessageSize, nil)
 	require.NoError(suite.T(), err, "Failed to create client")
 
 	suite.c = c
@@ -39,8 +40,9 @@ func (suite *ClientTestSuite) TestNewClient() {
 	require.NoError(suite.T(), err, "Failed to generate config")
 
 	tests := []struct {
-		config *tls.Config
-		out    error
+		config      *tls.Config
+		compression string
+		out         error
 	}{
 		{
 			config: nil,
@@ -51,10 +53,34 @@ func (suite *ClientTestSuite) TestNewClient() {
 			config: validConf,
 			out:    nil,
 		},
+
+		{
+			config:      validConf,
+			compression: NoCompression,
+			out:         nil,
+		},
+
+		{
+			config:      validConf,
+			compression: GzipCompression,
+			out:         nil,
+		},
+
+		{
+			config:      validConf,
+			compression: SnappyCompression,
+			out:         nil,
+		},
+
+		{
+			config:      validConf,
+			compression: "lz4",
+			out:         errCompression,
+		},
 	}
 
 	for i, t := range tests {
-		c, err := newClient(t.config)
+		c, err := newClient(t.config, t.compression, DefaultMaxMessageSize, nil)
 		require.Equalf(suite.T(), t.out, err, "Invalid error output for test %d", i)
 
 		if t.out == nil {
@@ -81,5 +107,5 @@ func validClientConfig() (*tls.Config, error) {
 		return nil, err
 	}
 
-	return clientConfig(certs.ownCert, certs.caCert, priv), nil
+	return clientConfig(newTlsCertificate(certs.ownCert, priv), []*x509.Certificate{certs.caCert}), nil
 }
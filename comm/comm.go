@@ -1,26 +1,58 @@
 package comm
 
 import (
-	"crypto/ecdsa"
+	"crypto"
 	"crypto/tls"
 	"crypto/x509"
 	"errors"
 	"net"
+	"sync"
 
 	pb "github.com/joonnna/ifrit/protobuf"
+	"google.golang.org/grpc"
 )
 
 var (
+	// Returned when a message exceeds the maximum message size, either our own or the receiver's.
+	ErrMessageSize = errors.New("Message exceeds the maximum message size")
+
 	errNilCert = errors.New("Given certificate was nil")
 	errNilPriv = errors.New("Given private key was nil")
 )
 
+// Maximum size in bytes of a single message when none is given, the gRPC default.
+const DefaultMaxMessageSize = 4 * 1024 * 1024
+
 type Comm struct {
 	s *gRPCServer
 	*gRPCClient
+
+	cert *tlsCertificate
+}
+
+// Certificate presented in tls handshakes, replaceable while serving.
+type tlsCertificate struct {
+	mutex sync.RWMutex
+	cert  *tls.Certificate
 }
 
-func NewComm(cert, caCert *x509.Certificate, priv *ecdsa.PrivateKey, l net.Listener) (*Comm, error) {
+// Compression algorithms for outgoing messages, see NewComm.
+const (
+	NoCompression     = "none"
+	GzipCompression   = "gzip"
+	SnappyCompression = snappyName
+)
+
+// NewComm creates the gRPC server and client used for all tcp communication.
+// Outgoing messages are compressed with the given algorithm, empty or NoCompression disables compression.
+// Incoming messages are accepted with any of the algorithms.
+// Peers must present certificates signed by one of the given ca certificates,
+// if none are given any certificate is accepted.
+// At most maxStreams concurrent rpcs are served per connection, zero means no limit.
+// Messages larger than maxMsgSize bytes are rejected, zero means DefaultMaxMessageSize.
+// The given server and dial options, e.g. interceptors, keepalive parameters or window sizes,
+// override the defaults above. The tls credentials are always our own, and are applied last.
+func NewComm(cert *x509.Certificate, caCerts []*x509.Certificate, priv crypto.Signer, l net.Listener, compression string, maxStreams uint32, maxMsgSize int, serverOpts []grpc.ServerOption, dialOpts []grpc.DialOption) (*Comm, error) {
 	if cert == nil {
 		return nil, errNilCert
 	}
@@ -29,16 +61,22 @@ func NewComm(cert, caCert *x509.Certificate, priv *ecdsa.PrivateKey, l net.Liste
 		return nil, errNilPriv
 	}
 
-	serverConf := serverConfig(cert, caCert, priv)
+	if maxMsgSize <= 0 {
+		maxMsgSize = DefaultMaxMessageSize
+	}
+
+	tlsCert := newTlsCertificate(cert, priv)
+
+	serverConf := serverConfig(tlsCert, caCerts)
 
-	server, err := newServer(serverConf, l)
+	server, err := newServer(serverConf, l, maxStreams, maxMsgSize, serverOpts)
 	if err != nil {
 		return nil, err
 	}
 
-	clientConf := clientConfig(cert, caCert, priv)
+	clientConf := clientConfig(tlsCert, caCerts)
 
-	client, err := newClient(clientConf)
+	client, err := newClient(clientConf, compression, maxMsgSize, dialOpts)
 	if err != nil {
 		return nil, err
 	}
@@ -46,6 +84,7 @@ func NewComm(cert, caCert *x509.Certificate, priv *ecdsa.PrivateKey, l net.Liste
 	return &Comm{
 		s:          server,
 		gRPCClient: client,
+		cert:       tlsCert,
 	}, nil
 }
 
@@ -53,33 +92,66 @@ func (c *Comm) Register(p pb.GossipServer) {
 	pb.RegisterGossipServer(c.s.rpcServer, p)
 }
 
-func (c *Comm) Start() {
-	c.s.start()
+// Blocks until the server is stopped, returns an error if serving fails.
+func (c *Comm) Start() error {
+	return c.s.start()
 }
 
 func (c *Comm) Stop() {
 	c.s.stop()
 }
 
+// Stops accepting new rpcs and blocks until all pending rpcs have completed.
+func (c *Comm) GracefulStop() {
+	c.s.gracefulStop()
+}
+
 func (c *Comm) Addr() string {
 	return c.s.addr()
 }
 
-func serverConfig(c, caCert *x509.Certificate, key *ecdsa.PrivateKey) *tls.Config {
-	tlsCert := tls.Certificate{
+// Replaces the certificate presented to peers, the private key stays the same.
+// Only new connections are affected, existing ones keep using the previous certificate.
+func (c *Comm) SetCertificate(cert *x509.Certificate) {
+	c.cert.set(cert)
+}
+
+func newTlsCertificate(c *x509.Certificate, key crypto.Signer) *tlsCertificate {
+	return &tlsCertificate{
+		cert: &tls.Certificate{
+			Certificate: [][]byte{c.Raw},
+			PrivateKey:  key,
+		},
+	}
+}
+
+func (tc *tlsCertificate) get() *tls.Certificate {
+	tc.mutex.RLock()
+	defer tc.mutex.RUnlock()
+
+	return tc.cert
+}
+
+func (tc *tlsCertificate) set(c *x509.Certificate) {
+	tc.mutex.Lock()
+	defer tc.mutex.Unlock()
+
+	tc.cert = &tls.Certificate{
 		Certificate: [][]byte{c.Raw},
-		PrivateKey:  key,
+		PrivateKey:  tc.cert.PrivateKey,
 	}
+}
 
+func serverConfig(c *tlsCertificate, caCerts []*x509.Certificate) *tls.Config {
 	conf := &tls.Config{
-		Certificates: []tls.Certificate{tlsCert},
+		GetCertificate: func(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+			return c.get(), nil
+		},
 	}
 
-	if caCert == nil {
+	if pool := certPool(caCerts); pool == nil {
 		conf.ClientAuth = tls.RequestClientCert
 	} else {
-		pool := x509.NewCertPool()
-		pool.AddCert(caCert)
 		conf.ClientCAs = pool
 		conf.ClientAuth = tls.RequireAndVerifyClientCert
 	}
@@ -87,19 +159,14 @@ func serverConfig(c, caCert *x509.Certificate, key *ecdsa.PrivateKey) *tls.Confi
 	return conf
 }
 
-func clientConfig(c, caCert *x509.Certificate, key *ecdsa.PrivateKey) *tls.Config {
-	tlsCert := tls.Certificate{
-		Certificate: [][]byte{c.Raw},
-		PrivateKey:  key,
-	}
-
+func clientConfig(c *tlsCertificate, caCerts []*x509.Certificate) *tls.Config {
 	conf := &tls.Config{
-		Certificates: []tls.Certificate{tlsCert},
+		GetClientCertificate: func(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+			return c.get(), nil
+		},
 	}
 
-	if caCert != nil {
-		pool := x509.NewCertPool()
-		pool.AddCert(caCert)
+	if pool := certPool(caCerts); pool != nil {
 		conf.RootCAs = pool
 	} else {
 		conf.InsecureSkipVerify = true
@@ -107,3 +174,21 @@ func clientConfig(c, caCert *x509.Certificate, key *ecdsa.PrivateKey) *tls.Confi
 
 	return conf
 }
+
+// Returns nil if there are no (non-nil) certificates.
+func certPool(certs []*x509.Certificate) *x509.CertPool {
+	var pool *x509.CertPool
+
+	for _, c := range certs {
+		if c == nil {
+			continue
+		}
+
+		if pool == nil {
+			pool = x509.NewCertPool()
+		}
+		pool.AddCert(c)
+	}
+
+	return pool
+}
@@ -1 +1,1028 @@
 package comm
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"errors"
+	"io"
+	"math/big"
+	"net"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	log "github.com/inconshreveable/log15"
+	"github.com/joonnna/ifrit/cauth"
+	pb "github.com/joonnna/ifrit/protobuf"
+	"github.com/stretchr/testify/require"
+	"github.com/stretchr/testify/suite"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/connectivity"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/peer"
+)
+
+type CommTestSuite struct {
+	suite.Suite
+}
+
+type ca struct {
+	cert *x509.Certificate
+	priv *ecdsa.PrivateKey
+}
+
+// Replies to all gossip with an empty response.
+type gossipServerStub struct {
+	mutex  sync.Mutex
+	serial *big.Int
+}
+
+func (gs *gossipServerStub) Spread(ctx context.Context, args *pb.State) (*pb.StateResponse, error) {
+	if p, ok := peer.FromContext(ctx); ok {
+		if info, ok := p.AuthInfo.(credentials.TLSInfo); ok && len(info.State.PeerCertificates) > 0 {
+			gs.mutex.Lock()
+			gs.serial = info.State.PeerCertificates[0].SerialNumber
+			gs.mutex.Unlock()
+		}
+	}
+
+	return &pb.StateResponse{}, nil
+}
+
+// Serial number of the certificate presented by the last peer.
+func (gs *gossipServerStub) peerSerial() *big.Int {
+	gs.mutex.Lock()
+	defer gs.mutex.Unlock()
+
+	return gs.serial
+}
+
+func (gs *gossipServerStub) Pull(ctx context.Context, args *pb.State) (*pb.StateResponse, error) {
+	return &pb.StateResponse{}, nil
+}
+
+func (gs *gossipServerStub) Messenger(ctx context.Context, args *pb.Msg) (*pb.MsgResponse, error) {
+	return &pb.MsgResponse{}, nil
+}
+
+func (gs *gossipServerStub) Stream(stream pb.Gossip_StreamServer) error {
+	return nil
+}
+
+// Records the sequence numbers of streamed messages and acknowledges them on demand.
+type streamServerStub struct {
+	gossipServerStub
+	received chan uint64
+	acks     chan uint64
+	reject   error
+}
+
+// Sends every received message back.
+type echoStreamServerStub struct {
+	gossipServerStub
+}
+
+func (es *echoStreamServerStub) Stream(stream pb.Gossip_StreamServer) error {
+	for {
+		msg, err := stream.Recv()
+		if err == io.EOF {
+			return nil
+		}
+
+		if err != nil {
+			return err
+		}
+
+		if err := stream.Send(&pb.MsgResponse{Content: msg.GetContent()}); err != nil {
+			return err
+		}
+	}
+}
+
+// Echoes each message and acknowledges it once the echo is sent.
+type ackingEchoStreamServerStub struct {
+	gossipServerStub
+}
+
+func (es *ackingEchoStreamServerStub) Stream(stream pb.Gossip_StreamServer) error {
+	for {
+		msg, err := stream.Recv()
+		if err == io.EOF {
+			return nil
+		}
+
+		if err != nil {
+			return err
+		}
+
+		if err := stream.Send(&pb.MsgResponse{Content: msg.GetContent()}); err != nil {
+			return err
+		}
+
+		if err := stream.Send(&pb.MsgResponse{Ack: msg.GetSeq()}); err != nil {
+			return err
+		}
+	}
+}
+
+func (ss *streamServerStub) Stream(stream pb.Gossip_StreamServer) error {
+	if ss.reject != nil {
+		return ss.reject
+	}
+
+	go func() {
+		for seq := range ss.acks {
+			stream.Send(&pb.MsgResponse{Ack: seq})
+		}
+	}()
+
+	for {
+		msg, err := stream.Recv()
+		if err == io.EOF {
+			return nil
+		}
+
+		if err != nil {
+			return err
+		}
+
+		ss.received <- msg.GetSeq()
+	}
+}
+
+func TestCommTestSuite(t *testing.T) {
+	r := log.Root()
+
+	r.SetHandler(log.DiscardHandler())
+
+	suite.Run(t, new(CommTestSuite))
+}
+
+func (suite *CommTestSuite) TestTrustedCAs() {
+	ca1 := suite.newCa()
+	ca2 := suite.newCa()
+	untrusted := suite.newCa()
+
+	trusted := []*x509.Certificate{ca1.cert, ca2.cert}
+
+	server := suite.newComm(ca1, trusted)
+	server.Register(&gossipServerStub{})
+	go server.Start()
+	defer server.Stop()
+
+	client := suite.newComm(ca2, trusted)
+	defer client.Stop()
+
+	_, err := client.Gossip(context.Background(), server.Addr(), &pb.State{})
+	require.NoError(suite.T(), err, "Gossip failed between peers of different trusted CAs.")
+
+	outsider := suite.newComm(untrusted, []*x509.Certificate{untrusted.cert})
+	defer outsider.Stop()
+
+	_, err = outsider.Gossip(context.Background(), server.Addr(), &pb.State{})
+	require.Error(suite.T(), err, "Gossip succeeded between peers without a common trusted CA.")
+}
+
+func (suite *CommTestSuite) TestPeerCertificate() {
+	issuer := suite.newCa()
+	untrusted := suite.newCa()
+
+	server := suite.newComm(issuer, []*x509.Certificate{issuer.cert})
+	go server.Start()
+	defer server.Stop()
+
+	client := suite.newComm(issuer, []*x509.Certificate{issuer.cert})
+	defer client.Stop()
+
+	cert, err := client.PeerCertificate(context.Background(), server.Addr())
+	require.NoError(suite.T(), err, "Failed to get certificate of peer with the same CA.")
+	require.Equal(suite.T(), server.cert.get().Certificate[0], cert.Raw, "Got wrong certificate.")
+
+	outsider := suite.newComm(untrusted, []*x509.Certificate{untrusted.cert})
+	defer outsider.Stop()
+
+	_, err = outsider.PeerCertificate(context.Background(), server.Addr())
+	require.True(suite.T(), errors.Is(err, ErrPeerCert), "Accepted certificate from untrusted CA.")
+
+	_, err = client.PeerCertificate(context.Background(), freeAddr(suite.T()))
+	require.Error(suite.T(), err, "Got certificate from address nothing listens on.")
+	require.False(suite.T(), errors.Is(err, ErrPeerCert), "Unreachable address reported as a certificate error.")
+}
+
+func (suite *CommTestSuite) TestSendToHost() {
+	issuer := suite.newCa()
+	untrusted := suite.newCa()
+
+	server := suite.newComm(issuer, []*x509.Certificate{issuer.cert})
+	server.Register(&gossipServerStub{})
+	go server.Start()
+	defer server.Stop()
+
+	client := suite.newComm(issuer, []*x509.Certificate{issuer.cert})
+	defer client.Stop()
+
+	var presented *x509.Certificate
+	accept := func(c *x509.Certificate) error {
+		presented = c
+		return nil
+	}
+
+	_, err := client.SendToHost(context.Background(), server.Addr(), &pb.Msg{}, accept)
+	require.NoError(suite.T(), err, "Failed to send to host with the same CA.")
+	require.NotNil(suite.T(), presented, "Certificate not verified.")
+	require.Equal(suite.T(), server.cert.get().Certificate[0], presented.Raw, "Verified wrong certificate.")
+	require.Empty(suite.T(), client.allConnections, "Connection to the host kept.")
+
+	rejected := errors.New("rejected")
+
+	_, err = client.SendToHost(context.Background(), server.Addr(), &pb.Msg{}, func(*x509.Certificate) error {
+		return rejected
+	})
+	require.True(suite.T(), errors.Is(err, rejected), "Message sent despite rejected certificate, got %v.", err)
+
+	outsider := suite.newComm(untrusted, []*x509.Certificate{untrusted.cert})
+	defer outsider.Stop()
+
+	presented = nil
+
+	_, err = outsider.SendToHost(context.Background(), server.Addr(), &pb.Msg{}, accept)
+	require.True(suite.T(), errors.Is(err, ErrPeerCert), "Accepted certificate from untrusted CA, got %v.", err)
+	require.Nil(suite.T(), presented, "Certificate from untrusted CA given to verify.")
+
+	_, err = client.SendToHost(context.Background(), freeAddr(suite.T()), &pb.Msg{}, accept)
+	require.Error(suite.T(), err, "Sent to address nothing listens on.")
+	require.False(suite.T(), errors.Is(err, ErrPeerCert), "Unreachable address reported as a certificate error.")
+}
+
+func (suite *CommTestSuite) TestSetCertificate() {
+	issuer := suite.newCa()
+	caCerts := []*x509.Certificate{issuer.cert}
+
+	stub := &gossipServerStub{}
+
+	server := suite.newComm(issuer, caCerts)
+	server.Register(stub)
+	go server.Start()
+	defer server.Stop()
+
+	priv, err := genKeys()
+	require.NoError(suite.T(), err, "Failed to generate keys.")
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(suite.T(), err, "Failed to listen on loopback.")
+
+	old := issuedCert(suite.T(), priv, issuer)
+
+	client, err := NewComm(old, caCerts, priv, l, NoCompression, 0, 0, nil, nil)
+	require.NoError(suite.T(), err, "Failed to create comm.")
+	defer client.Stop()
+
+	_, err = client.Gossip(context.Background(), server.Addr(), &pb.State{})
+	require.NoError(suite.T(), err, "Gossip failed.")
+	require.Equal(suite.T(), old.SerialNumber, stub.peerSerial(), "Server saw wrong certificate.")
+
+	renewed := issuedCert(suite.T(), priv, issuer)
+	client.SetCertificate(renewed)
+
+	_, err = client.Gossip(context.Background(), server.Addr(), &pb.State{})
+	require.NoError(suite.T(), err, "Gossip failed on existing connection.")
+	require.Equal(suite.T(), old.SerialNumber, stub.peerSerial(), "Existing connection should keep the previous certificate.")
+
+	client.CloseConn(server.Addr())
+
+	_, err = client.Gossip(context.Background(), server.Addr(), &pb.State{})
+	require.NoError(suite.T(), err, "Gossip failed with renewed certificate.")
+	require.Equal(suite.T(), renewed.SerialNumber, stub.peerSerial(), "New connection did not use the renewed certificate.")
+}
+
+func (suite *CommTestSuite) TestGrpcOptions() {
+	issuer := suite.newCa()
+	caCerts := []*x509.Certificate{issuer.cert}
+
+	var served, sent int32
+
+	serverInterceptor := func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		atomic.AddInt32(&served, 1)
+		return handler(ctx, req)
+	}
+
+	clientInterceptor := func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+		atomic.AddInt32(&sent, 1)
+		return invoker(ctx, method, req, reply, cc, opts...)
+	}
+
+	// Credentials given as options must not replace our own.
+	otherCreds := credentials.NewTLS(&tls.Config{InsecureSkipVerify: true})
+
+	priv, err := genKeys()
+	require.NoError(suite.T(), err, "Failed to generate keys.")
+
+	stub := &gossipServerStub{}
+
+	server, err := NewComm(issuedCert(suite.T(), priv, issuer), caCerts, priv, localListener(suite.T()), NoCompression, 0, 0,
+		[]grpc.ServerOption{grpc.UnaryInterceptor(serverInterceptor), grpc.Creds(otherCreds)}, nil)
+	require.NoError(suite.T(), err, "Failed to create comm.")
+	server.Register(stub)
+	go server.Start()
+	defer server.Stop()
+
+	clientCert := issuedCert(suite.T(), priv, issuer)
+
+	client, err := NewComm(clientCert, caCerts, priv, localListener(suite.T()), NoCompression, 0, 0,
+		nil, []grpc.DialOption{grpc.WithUnaryInterceptor(clientInterceptor), grpc.WithTransportCredentials(otherCreds)})
+	require.NoError(suite.T(), err, "Failed to create comm.")
+	defer client.Stop()
+
+	_, err = client.Gossip(context.Background(), server.Addr(), &pb.State{})
+	require.NoError(suite.T(), err, "Gossip failed.")
+	require.Equal(suite.T(), int32(1), atomic.LoadInt32(&served), "Server interceptor not invoked.")
+	require.Equal(suite.T(), int32(1), atomic.LoadInt32(&sent), "Client interceptor not invoked.")
+	require.Equal(suite.T(), clientCert.SerialNumber, stub.peerSerial(), "Server did not see the client certificate, tls was overridden.")
+}
+
+func (suite *CommTestSuite) TestCaRequestRetry() {
+	identity := pkix.Name{Locality: []string{"127.0.0.1:8000", "127.0.0.1:8001"}}
+
+	addr := freeAddr(suite.T())
+
+	_, err := NewCu(identity, addr, "localhost", nil, CaRequestPolicy{MaxAttempts: 2, BaseBackoff: time.Millisecond * 10, AllowHttp: true})
+	require.True(suite.T(), errors.Is(err, ErrCaUnreachable), "Should fail with ErrCaUnreachable, got %v.", err)
+
+	// The CA comes up while the request is being retried, as during a restart.
+	authority := newCa(suite.T())
+	host, port := splitAddr(suite.T(), addr)
+
+	go func() {
+		time.Sleep(time.Millisecond * 200)
+		authority.Start(host, port)
+	}()
+	defer authority.Shutdown()
+
+	policy := CaRequestPolicy{
+		Timeout:     time.Second,
+		MaxAttempts: 50,
+		BaseBackoff: time.Millisecond * 20,
+		MaxBackoff:  time.Millisecond * 100,
+		AllowHttp:   true,
+	}
+
+	cu, err := NewCu(identity, addr, "localhost", nil, policy)
+	require.NoError(suite.T(), err, "Request not retried until the CA came up.")
+	require.NotNil(suite.T(), cu.CaCertificate(), "No certificate from the CA.")
+
+	// Accepts connections but never answers.
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(suite.T(), err, "Failed to listen.")
+	defer l.Close()
+
+	start := time.Now()
+
+	_, err = NewCu(identity, l.Addr().String(), "localhost", nil, CaRequestPolicy{Timeout: time.Millisecond * 100, MaxAttempts: 1, AllowHttp: true})
+	require.True(suite.T(), errors.Is(err, ErrCaUnreachable), "Should fail with ErrCaUnreachable, got %v.", err)
+	require.Less(suite.T(), int64(time.Since(start)), int64(time.Second*5), "Request not timed out.")
+}
+
+func (suite *CommTestSuite) TestPingCa() {
+	identity := pkix.Name{Locality: []string{"127.0.0.1:8000", "127.0.0.1:8001"}}
+
+	selfSigned, err := NewCu(identity, "", "localhost", nil, CaRequestPolicy{})
+	require.NoError(suite.T(), err, "Failed to create crypto unit.")
+	require.EqualError(suite.T(), selfSigned.PingCa(), errNoCa.Error(), "Pinged a CA without an address.")
+
+	addr := freeAddr(suite.T())
+	authority := newCa(suite.T())
+	host, port := splitAddr(suite.T(), addr)
+
+	go authority.Start(host, port)
+
+	policy := CaRequestPolicy{
+		Timeout:     time.Second,
+		MaxAttempts: 50,
+		BaseBackoff: time.Millisecond * 20,
+		MaxBackoff:  time.Millisecond * 100,
+		AllowHttp:   true,
+	}
+
+	cu, err := NewCu(identity, addr, "localhost", nil, policy)
+	require.NoError(suite.T(), err, "Failed to get a certificate from the CA.")
+	require.NoError(suite.T(), cu.PingCa(), "Running CA not reachable.")
+
+	authority.Shutdown()
+
+	require.True(suite.T(), errors.Is(cu.PingCa(), ErrCaUnreachable), "Stopped CA reachable.")
+}
+
+func (suite *CommTestSuite) TestHttpsCa() {
+	identity := pkix.Name{Locality: []string{"127.0.0.1:8000", "127.0.0.1:8001"}}
+
+	issuer := suite.newCa()
+
+	priv, err := genKeys()
+	require.NoError(suite.T(), err, "Failed to generate keys.")
+
+	serverCert := tls.Certificate{
+		Certificate: [][]byte{issuedCert(suite.T(), priv, issuer).Raw},
+		PrivateKey:  priv,
+	}
+
+	authority, caAddr := startCaWith(suite.T(), func(authority *cauth.Ca, host, port string) error {
+		return authority.StartTLS(host, port, serverCert)
+	})
+	defer authority.Shutdown()
+
+	roots := x509.NewCertPool()
+	roots.AddCert(issuer.cert)
+
+	cu, err := NewCu(identity, "https://"+caAddr, "localhost", nil, CaRequestPolicy{RootCAs: roots})
+	require.NoError(suite.T(), err, "Failed to request certificate over https.")
+	require.NotNil(suite.T(), cu.CaCertificate(), "No certificate from the CA.")
+
+	_, err = cu.RenewCertificate()
+	require.NoError(suite.T(), err, "Failed to renew certificate over https.")
+
+	_, err = NewCu(identity, "https://"+caAddr, "localhost", nil, CaRequestPolicy{})
+	require.True(suite.T(), errors.Is(err, ErrCaUnreachable), "Accepted CA server certificate not signed by a trusted root, got %v.", err)
+
+	_, err = NewCu(identity, caAddr, "localhost", nil, CaRequestPolicy{})
+	require.EqualError(suite.T(), err, errInsecureCa.Error(), "Requested over plain http without it being allowed.")
+
+	_, err = NewCu(identity, "http://"+caAddr, "localhost", nil, CaRequestPolicy{})
+	require.EqualError(suite.T(), err, errInsecureCa.Error(), "Requested over plain http without it being allowed.")
+
+	_, err = NewCu(identity, "ftp://"+caAddr, "localhost", nil, CaRequestPolicy{AllowHttp: true})
+	require.EqualError(suite.T(), err, errCaScheme.Error(), "Accepted unsupported scheme.")
+}
+
+func (suite *CommTestSuite) TestRenewCertificate() {
+	authority, caAddr := startCa(suite.T())
+	defer authority.Shutdown()
+
+	identity := pkix.Name{Locality: []string{"127.0.0.1:8000", "127.0.0.1:8001"}}
+
+	cu, err := NewCu(identity, caAddr, "localhost", nil, CaRequestPolicy{AllowHttp: true})
+	require.NoError(suite.T(), err, "Failed to request certificate.")
+
+	old := cu.Certificate()
+
+	renewed, err := cu.RenewCertificate()
+	require.NoError(suite.T(), err, "Failed to renew certificate.")
+	require.Equal(suite.T(), old.SubjectKeyId, renewed.SubjectKeyId, "Renewed certificate has a new id.")
+	require.NotEqual(suite.T(), old.SerialNumber, renewed.SerialNumber, "Certificate was not renewed.")
+	require.Equal(suite.T(), renewed, cu.Certificate(), "Renewed certificate not installed.")
+
+	other, err := NewCu(identity, caAddr, "localhost", nil, CaRequestPolicy{AllowHttp: true})
+	require.NoError(suite.T(), err, "Failed to request certificate.")
+	require.NotEqual(suite.T(), old.SubjectKeyId, other.Certificate().SubjectKeyId, "Different keys were given the same id.")
+
+	_, err = (&CryptoUnit{}).RenewCertificate()
+	require.EqualError(suite.T(), err, errNoCa.Error(), "Should not renew without a ca.")
+}
+
+func (suite *CommTestSuite) TestStreamWindow() {
+	issuer := suite.newCa()
+	caCerts := []*x509.Certificate{issuer.cert}
+
+	stub := &streamServerStub{
+		received: make(chan uint64, 10),
+		acks:     make(chan uint64),
+	}
+	defer close(stub.acks)
+
+	server := suite.newComm(issuer, caCerts)
+	server.Register(stub)
+	go server.Start()
+	defer server.Stop()
+
+	client := suite.newComm(issuer, caCerts)
+	defer client.Stop()
+
+	input := make(chan []byte)
+	reply := make(chan []byte)
+
+	go client.StreamMessenger(server.Addr(), input, reply, 2, "", nil)
+
+	go func() {
+		for i := 0; i < 4; i++ {
+			input <- []byte("data")
+		}
+		close(input)
+	}()
+
+	require.Equal(suite.T(), uint64(1), suite.nextSeq(stub.received), "Wrong sequence number.")
+	require.Equal(suite.T(), uint64(2), suite.nextSeq(stub.received), "Wrong sequence number.")
+
+	select {
+	case <-stub.received:
+		suite.T().Fatal("Sent more messages than the window allows.")
+	case <-time.After(time.Millisecond * 100):
+	}
+
+	stub.acks <- 1
+	require.Equal(suite.T(), uint64(3), suite.nextSeq(stub.received), "Ack did not open the window.")
+
+	stub.acks <- 2
+	stub.acks <- 3
+	require.Equal(suite.T(), uint64(4), suite.nextSeq(stub.received), "Ack did not open the window.")
+
+	select {
+	case _, ok := <-reply:
+		require.False(suite.T(), ok, "Acks should not be delivered as replies.")
+	case <-time.After(time.Second):
+		suite.T().Fatal("Reply stream never closed.")
+	}
+}
+
+func (suite *CommTestSuite) TestStreamCompression() {
+	issuer := suite.newCa()
+	caCerts := []*x509.Certificate{issuer.cert}
+
+	data := bytes.Repeat([]byte("compressible "), 1<<15)
+
+	for _, compression := range []string{NoCompression, GzipCompression, SnappyCompression} {
+		counter := &byteCountingListener{Listener: localListener(suite.T())}
+
+		server := newTestComm(suite.T(), issuer, caCerts, counter, 0)
+		server.Register(&echoStreamServerStub{})
+		go server.Start()
+
+		client := suite.newComm(issuer, caCerts)
+
+		input := make(chan []byte)
+		reply := make(chan []byte)
+
+		go client.StreamMessenger(server.Addr(), input, reply, 0, compression, nil)
+
+		input <- data
+		close(input)
+
+		select {
+		case echoed := <-reply:
+			require.Equal(suite.T(), data, echoed, "Stream handler did not receive the original data with %s.", compression)
+		case <-time.After(time.Second * 5):
+			suite.T().Fatalf("No reply with %s.", compression)
+		}
+
+		if compression == NoCompression {
+			require.Greater(suite.T(), counter.numBytes(), int64(len(data)), "Data compressed with %s.", compression)
+		} else {
+			require.Less(suite.T(), counter.numBytes(), int64(len(data)/10), "Data not compressed with %s.", compression)
+		}
+
+		client.Stop()
+		server.Stop()
+	}
+
+	client := suite.newComm(issuer, caCerts)
+	defer client.Stop()
+
+	reply := make(chan []byte)
+
+	err := client.StreamMessenger("127.0.0.1:1", make(chan []byte), reply, 0, "lz4", nil)
+	require.EqualError(suite.T(), err, errCompression.Error(), "Accepted unknown compression.")
+
+	_, ok := <-reply
+	require.False(suite.T(), ok, "Reply stream not closed.")
+}
+
+func (suite *CommTestSuite) TestStreamWindowSlowReader() {
+	issuer := suite.newCa()
+	caCerts := []*x509.Certificate{issuer.cert}
+
+	server := suite.newComm(issuer, caCerts)
+	server.Register(&ackingEchoStreamServerStub{})
+	go server.Start()
+	defer server.Stop()
+
+	client := suite.newComm(issuer, caCerts)
+	defer client.Stop()
+
+	numMsgs := 10
+
+	input := make(chan []byte)
+	reply := make(chan []byte)
+
+	go client.StreamMessenger(server.Addr(), input, reply, 2, "", nil)
+
+	// Nothing drains the replies until all messages are sent, acknowledgements still have to reopen the window.
+	for i := 0; i < numMsgs; i++ {
+		select {
+		case input <- []byte{byte(i)}:
+		case <-time.After(time.Second * 5):
+			suite.T().Fatalf("Window never reopened, message %d not sent.", i)
+		}
+	}
+	close(input)
+
+	for i := 0; i < numMsgs; i++ {
+		time.Sleep(time.Millisecond * 10)
+
+		select {
+		case echoed := <-reply:
+			require.Equal(suite.T(), []byte{byte(i)}, echoed, "Replies out of order.")
+		case <-time.After(time.Second * 5):
+			suite.T().Fatalf("Reply %d never arrived.", i)
+		}
+	}
+
+	select {
+	case _, ok := <-reply:
+		require.False(suite.T(), ok, "Acks should not be delivered as replies.")
+	case <-time.After(time.Second * 5):
+		suite.T().Fatal("Reply stream never closed.")
+	}
+}
+
+func (suite *CommTestSuite) TestStreamDropHandler() {
+	issuer := suite.newCa()
+	caCerts := []*x509.Certificate{issuer.cert}
+
+	server := suite.newComm(issuer, caCerts)
+	server.Register(&echoStreamServerStub{})
+	go server.Start()
+	defer server.Stop()
+
+	client := suite.newCommWithSize(issuer, caCerts, 1024)
+	defer client.Stop()
+
+	dropped := make(chan struct{}, 1)
+
+	input := make(chan []byte)
+	reply := make(chan []byte)
+
+	go client.StreamMessenger(server.Addr(), input, reply, 0, "", func() {
+		dropped <- struct{}{}
+	})
+
+	input <- make([]byte, 2048)
+
+	select {
+	case <-dropped:
+	case <-time.After(time.Second * 5):
+		suite.T().Fatal("Oversized stream message not reported as dropped.")
+	}
+
+	input <- []byte("data")
+	close(input)
+
+	select {
+	case echoed := <-reply:
+		require.Equal(suite.T(), []byte("data"), echoed, "Stream broken by the dropped message.")
+	case <-time.After(time.Second * 5):
+		suite.T().Fatal("No reply after the dropped message.")
+	}
+
+	require.Empty(suite.T(), dropped, "Sent message reported as dropped.")
+}
+
+func (suite *CommTestSuite) TestStreamNoWindow() {
+	issuer := suite.newCa()
+	caCerts := []*x509.Certificate{issuer.cert}
+
+	stub := &streamServerStub{
+		received: make(chan uint64, 10),
+		acks:     make(chan uint64),
+	}
+	defer close(stub.acks)
+
+	server := suite.newComm(issuer, caCerts)
+	server.Register(stub)
+	go server.Start()
+	defer server.Stop()
+
+	client := suite.newComm(issuer, caCerts)
+	defer client.Stop()
+
+	input := make(chan []byte)
+	reply := make(chan []byte)
+
+	go client.StreamMessenger(server.Addr(), input, reply, 0, "", nil)
+
+	for i := 0; i < 4; i++ {
+		input <- []byte("data")
+	}
+	close(input)
+
+	for i := 0; i < 4; i++ {
+		require.Equal(suite.T(), uint64(0), suite.nextSeq(stub.received), "Messages should not be sequenced without a window.")
+	}
+}
+
+func (suite *CommTestSuite) TestStreamRejected() {
+	issuer := suite.newCa()
+	caCerts := []*x509.Certificate{issuer.cert}
+
+	stub := &streamServerStub{reject: errors.New("rejected")}
+
+	server := suite.newComm(issuer, caCerts)
+	server.Register(stub)
+	go server.Start()
+	defer server.Stop()
+
+	client := suite.newComm(issuer, caCerts)
+	defer client.Stop()
+
+	input := make(chan []byte)
+	defer close(input)
+
+	reply := make(chan []byte)
+
+	err := client.StreamMessenger(server.Addr(), input, reply, 2, "", nil)
+	require.Error(suite.T(), err, "Rejected stream did not fail.")
+	require.Contains(suite.T(), err.Error(), "rejected", "Rejection error not returned.")
+
+	_, ok := <-reply
+	require.False(suite.T(), ok, "Reply stream not closed after rejection.")
+}
+
+func (suite *CommTestSuite) TestMessageSize() {
+	issuer := suite.newCa()
+	caCerts := []*x509.Certificate{issuer.cert}
+
+	server := suite.newCommWithSize(issuer, caCerts, 1024)
+	server.Register(&gossipServerStub{})
+	go server.Start()
+	defer server.Stop()
+
+	client := suite.newComm(issuer, caCerts)
+	defer client.Stop()
+
+	_, err := client.Send(context.Background(), server.Addr(), &pb.Msg{Content: make([]byte, 512)})
+	require.NoError(suite.T(), err, "Failed to send message within the limit.")
+
+	_, err = client.Send(context.Background(), server.Addr(), &pb.Msg{Content: make([]byte, 2048)})
+	require.EqualError(suite.T(), err, ErrMessageSize.Error(), "Receiver accepted message above its limit.")
+
+	_, err = client.Gossip(context.Background(), server.Addr(), &pb.State{ExternalGossip: make([]byte, DefaultMaxMessageSize)})
+	require.EqualError(suite.T(), err, ErrMessageSize.Error(), "Sent message above our own limit.")
+}
+
+func (suite *CommTestSuite) TestConnectionReuse() {
+	issuer := suite.newCa()
+	caCerts := []*x509.Certificate{issuer.cert}
+
+	counter := &countingListener{Listener: localListener(suite.T())}
+
+	server := newTestComm(suite.T(), issuer, caCerts, counter, 0)
+	server.Register(&gossipServerStub{})
+	go server.Start()
+	defer server.Stop()
+
+	client := suite.newComm(issuer, caCerts)
+	defer client.Stop()
+
+	for i := 0; i < 5; i++ {
+		_, err := client.Gossip(context.Background(), server.Addr(), &pb.State{})
+		require.NoError(suite.T(), err, "Failed to gossip.")
+	}
+
+	require.Equal(suite.T(), 1, counter.numAccepted(), "Connection not reused across gossip rounds.")
+
+	cached := client.getConnection(server.Addr())
+	require.NotNil(suite.T(), cached, "Connection not cached.")
+
+	client.CloseConn(server.Addr())
+	require.Nil(suite.T(), client.getConnection(server.Addr()), "Closed connection still cached.")
+	require.Equal(suite.T(), connectivity.Shutdown, cached.cc.GetState(), "Evicted connection not closed.")
+
+	_, err := client.Gossip(context.Background(), server.Addr(), &pb.State{})
+	require.NoError(suite.T(), err, "Failed to gossip after closing the connection.")
+	require.Equal(suite.T(), 2, counter.numAccepted(), "No new connection after closing the old one.")
+}
+
+func (suite *CommTestSuite) nextSeq(received chan uint64) uint64 {
+	select {
+	case seq := <-received:
+		return seq
+	case <-time.After(time.Second):
+		suite.T().Fatal("Timed out waiting for streamed message.")
+	}
+
+	return 0
+}
+
+// Starts a certificate authority on a free port, returns once it serves requests.
+func startCa(t *testing.T) (*cauth.Ca, string) {
+	return startCaWith(t, func(authority *cauth.Ca, host, port string) error {
+		return authority.Start(host, port)
+	})
+}
+
+// Starts the certificate authority with the given start function, waiting until it accepts connections.
+func startCaWith(t *testing.T, start func(*cauth.Ca, string, string) error) (*cauth.Ca, string) {
+	addr := freeAddr(t)
+
+	authority := newCa(t)
+
+	host, port := splitAddr(t, addr)
+
+	go start(authority, host, port)
+
+	for i := 0; i < 50; i++ {
+		if conn, err := net.Dial("tcp", addr); err == nil {
+			conn.Close()
+			return authority, addr
+		}
+		time.Sleep(time.Millisecond * 20)
+	}
+
+	t.Fatal("Certificate authority never started.")
+
+	return nil, ""
+}
+
+func newCa(t *testing.T) *cauth.Ca {
+	authority, err := cauth.NewCa("")
+	require.NoError(t, err, "Failed to create ca.")
+	require.NoError(t, authority.NewGroup(32, 1), "Failed to create group.")
+
+	return authority
+}
+
+// Returns a local address nothing listens on.
+func freeAddr(t *testing.T) string {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err, "Failed to find free port.")
+	addr := l.Addr().String()
+	l.Close()
+
+	return addr
+}
+
+func splitAddr(t *testing.T, addr string) (string, string) {
+	host, port, err := net.SplitHostPort(addr)
+	require.NoError(t, err, "Failed to parse address.")
+
+	return host, port
+}
+
+func (suite *CommTestSuite) newCa() *ca {
+	return newTestCa(suite.T())
+}
+
+func newTestCa(t testing.TB) *ca {
+	priv, err := genKeys()
+	require.NoError(t, err, "Failed to generate keys.")
+
+	certs, err := selfSignedCert(priv, pkix.Name{Locality: []string{"127.0.0.1:0"}})
+	require.NoError(t, err, "Failed to create ca certificate.")
+
+	return &ca{cert: certs.ownCert, priv: priv}
+}
+
+func (suite *CommTestSuite) newComm(issuer *ca, caCerts []*x509.Certificate) *Comm {
+	return suite.newCommWithSize(issuer, caCerts, 0)
+}
+
+func (suite *CommTestSuite) newCommWithSize(issuer *ca, caCerts []*x509.Certificate, maxMsgSize int) *Comm {
+	return newTestComm(suite.T(), issuer, caCerts, localListener(suite.T()), maxMsgSize)
+}
+
+func newTestComm(t testing.TB, issuer *ca, caCerts []*x509.Certificate, l net.Listener, maxMsgSize int) *Comm {
+	priv, err := genKeys()
+	require.NoError(t, err, "Failed to generate keys.")
+
+	c, err := NewComm(issuedCert(t, priv, issuer), caCerts, priv, l, NoCompression, 0, maxMsgSize, nil, nil)
+	require.NoError(t, err, "Failed to create comm.")
+
+	return c
+}
+
+// Counts accepted connections, each one costs a tls handshake.
+type countingListener struct {
+	net.Listener
+
+	mutex    sync.Mutex
+	accepted int
+}
+
+func (cl *countingListener) Accept() (net.Conn, error) {
+	c, err := cl.Listener.Accept()
+	if err == nil {
+		cl.mutex.Lock()
+		cl.accepted++
+		cl.mutex.Unlock()
+	}
+
+	return c, err
+}
+
+func (cl *countingListener) numAccepted() int {
+	cl.mutex.Lock()
+	defer cl.mutex.Unlock()
+
+	return cl.accepted
+}
+
+// Counts the bytes read from accepted connections.
+type byteCountingListener struct {
+	net.Listener
+
+	read int64
+}
+
+type byteCountingConn struct {
+	net.Conn
+	l *byteCountingListener
+}
+
+func (bl *byteCountingListener) Accept() (net.Conn, error) {
+	c, err := bl.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+
+	return &byteCountingConn{Conn: c, l: bl}, nil
+}
+
+func (bl *byteCountingListener) numBytes() int64 {
+	return atomic.LoadInt64(&bl.read)
+}
+
+func (bc *byteCountingConn) Read(b []byte) (int, error) {
+	n, err := bc.Conn.Read(b)
+	atomic.AddInt64(&bc.l.read, int64(n))
+
+	return n, err
+}
+
+func BenchmarkGossip(b *testing.B) {
+	b.Run("Reused", func(b *testing.B) {
+		benchmarkGossip(b, false)
+	})
+
+	b.Run("Fresh", func(b *testing.B) {
+		benchmarkGossip(b, true)
+	})
+}
+
+// Gossips with a single peer, closing the connection after each round if fresh is set.
+func benchmarkGossip(b *testing.B, fresh bool) {
+	r := log.Root()
+	r.SetHandler(log.DiscardHandler())
+
+	issuer := newTestCa(b)
+	caCerts := []*x509.Certificate{issuer.cert}
+
+	counter := &countingListener{Listener: localListener(b)}
+
+	server := newTestComm(b, issuer, caCerts, counter, 0)
+	server.Register(&gossipServerStub{})
+	go server.Start()
+	defer server.Stop()
+
+	client := newTestComm(b, issuer, caCerts, localListener(b), 0)
+	defer client.Stop()
+
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		_, err := client.Gossip(context.Background(), server.Addr(), &pb.State{})
+		require.NoError(b, err, "Failed to gossip.")
+
+		if fresh {
+			client.CloseConn(server.Addr())
+		}
+	}
+
+	b.StopTimer()
+
+	b.ReportMetric(float64(counter.numAccepted())/float64(b.N), "handshakes/op")
+}
+
+func localListener(t testing.TB) net.Listener {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err, "Failed to listen on loopback.")
+
+	return l
+}
+
+func issuedCert(t testing.TB, priv *ecdsa.PrivateKey, issuer *ca) *x509.Certificate {
+	serial, err := genSerialNumber()
+	require.NoError(t, err, "Failed to generate serial number.")
+
+	template := &x509.Certificate{
+		SerialNumber: serial,
+		SubjectKeyId: genId(),
+		Subject:      pkix.Name{Locality: []string{"127.0.0.1:0"}},
+		NotBefore:    time.Now().AddDate(-1, 0, 0),
+		NotAfter:     time.Now().AddDate(1, 0, 0),
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth,
+			x509.ExtKeyUsageServerAuth},
+		KeyUsage: x509.KeyUsageDigitalSignature | x509.KeyUsageKeyEncipherment,
+	}
+
+	raw, err := x509.CreateCertificate(rand.Reader, template, issuer.cert, priv.Public(), issuer.priv)
+	require.NoError(t, err, "Failed to sign certificate.")
+
+	cert, err := x509.ParseCertificate(raw)
+	require.NoError(t, err, "Failed to parse certificate.")
+
+	return cert
+}
@@ -2,10 +2,13 @@ package comm
 
 import (
 	"bytes"
+	"crypto"
 	"crypto/ecdsa"
 	"crypto/elliptic"
 	"crypto/rand"
 	"crypto/sha256"
+	"crypto/sha512"
+	"crypto/tls"
 	"crypto/x509"
 	"crypto/x509/pkix"
 	"encoding/asn1"
@@ -14,17 +17,21 @@ import (
 	"encoding/pem"
 	"errors"
 	"fmt"
+	"io"
 	"io/fs"
 	"io/ioutil"
 	"math/big"
 	"net"
 	"net/http"
+	"net/url"
 	"os"
 	"path/filepath"
 	"strings"
+	"sync"
 	"time"
 
 	log "github.com/inconshreveable/log15"
+	"github.com/joonnna/ifrit/netutil"
 )
 
 var (
@@ -35,14 +42,66 @@ var (
 	errPemDecode   = errors.New("Unable to decode content in given file")
 	errInvlKeyPath = errors.New("Storage path-argument is invalid")
 	errNoCa        = errors.New("No address for Certificate Authority")
+	errRenewedId   = errors.New("Renewed certificate has a different id")
+	errSignerKey   = errors.New("Signer public key is not an ecdsa key")
+	errNoPrivKey   = errors.New("Private key is held by an external signer")
+	errBundle      = errors.New("Identity bundle lacks own certificate or private key")
+	errInsecureCa  = errors.New("Certificate Authority address is not https and plain http is not allowed")
+	errCaScheme    = errors.New("Certificate Authority address has an unsupported scheme")
+	errSigHash     = errors.New("Unsupported signature hash algorithm")
+
+	// Returned when no certificate request reached the CA, wrapped with the last failure.
+	ErrCaUnreachable = errors.New("Certificate Authority is unreachable")
 )
 
+// Digest algorithms of signatures, see SetSignatureHash.
+const (
+	SignatureSHA256 = "sha256"
+	SignatureSHA384 = "sha384"
+	SignatureSHA512 = "sha512"
+)
+
+var signatureHashes = map[string]crypto.Hash{
+	SignatureSHA256: crypto.SHA256,
+	SignatureSHA384: crypto.SHA384,
+	SignatureSHA512: crypto.SHA512,
+}
+
+// Deadline of PingCa when the request policy has no timeout.
+const defaultCaPingTimeout = time.Second * 10
+
+// Controls how certificate requests are sent to the CA, see NewCu.
+type CaRequestPolicy struct {
+	// Deadline of each attempt, zero means no deadline.
+	Timeout time.Duration
+
+	// Total number of attempts, values below one gives a single attempt.
+	MaxAttempts int
+
+	// Wait before the second attempt, doubled for each following attempt.
+	BaseBackoff time.Duration
+
+	// Upper bound of the wait between attempts, zero means unbounded.
+	MaxBackoff time.Duration
+
+	// Certificates the server certificate of an https CA is validated against,
+	// the system roots are used if nil.
+	RootCAs *x509.CertPool
+
+	// Allows requests to a CA over plain http, the certificate request then travels in cleartext.
+	// Only meant for local testing.
+	AllowHttp bool
+}
+
 type CryptoUnit struct {
-	priv   *ecdsa.PrivateKey
-	pk     pkix.Name
-	caAddr string
+	signer   crypto.Signer
+	hash     crypto.Hash
+	pk       pkix.Name
+	caAddr   string
+	caPolicy CaRequestPolicy
 
 	self       *x509.Certificate
+	selfMutex  sync.RWMutex
 	ca         *x509.Certificate
 	numRings   uint32
 	knownCerts []*x509.Certificate
@@ -63,7 +122,10 @@ type certSet struct {
 	trusted    bool
 }
 
-func NewCu(identity pkix.Name, caAddr string, dnsLabel string) (*CryptoUnit, error) {
+// NewCu creates a crypto unit with a certificate from the CA at caAddr, or a self-signed one if caAddr is empty.
+// All signing is done by the given signer, if nil an ecdsa key is generated and kept in memory.
+// Requests to the CA, including later renewals, are timed out and retried according to the given policy.
+func NewCu(identity pkix.Name, caAddr string, dnsLabel string, signer crypto.Signer, policy CaRequestPolicy) (*CryptoUnit, error) {
 	var certs *certSet
 	var extValue []byte
 
@@ -71,12 +133,12 @@ func NewCu(identity pkix.Name, caAddr string, dnsLabel string) (*CryptoUnit, err
 		return nil, errNoAddrs
 	}
 
-	serviceAddr := strings.Split(identity.Locality[0], ":")
-	if len(serviceAddr) <= 0 {
+	serviceHost, _, err := net.SplitHostPort(identity.Locality[0])
+	if err != nil {
 		return nil, errNoHostIp
 	}
 
-	serviceIP, err := net.LookupIP(serviceAddr[0])
+	serviceIP, err := net.LookupIP(serviceHost)
 	if err != nil {
 		return nil, err
 	}
@@ -86,21 +148,20 @@ func NewCu(identity pkix.Name, caAddr string, dnsLabel string) (*CryptoUnit, err
 		return nil, errNoHostIp
 	}
 
-	priv, err := genKeys()
+	signer, err = defaultSigner(signer)
 	if err != nil {
 		return nil, err
 	}
 
 	if caAddr != "" {
-		addr := fmt.Sprintf("http://%s/certificateRequest", caAddr)
-		certs, err = sendCertRequest(priv, addr, identity, dnsLabel)
+		certs, err = sendCertRequest(signer, caAddr, identity, dnsLabel, policy)
 		if err != nil {
 			return nil, err
 		}
 
 	} else {
 		// TODO only have numrings in notes and not certificate?
-		certs, err = selfSignedCert(priv, identity)
+		certs, err = selfSignedCert(signer, identity)
 		if err != nil {
 			return nil, err
 		}
@@ -123,16 +184,17 @@ func NewCu(identity pkix.Name, caAddr string, dnsLabel string) (*CryptoUnit, err
 		self:       certs.ownCert,
 		numRings:   numRings,
 		caAddr:     caAddr,
+		caPolicy:   policy,
 		pk:         identity,
-		priv:       priv,
+		signer:     signer,
 		knownCerts: certs.knownCerts,
 		trusted:    certs.trusted,
 	}, nil
 }
 
-func LoadCu(certPath string, identity pkix.Name, caAddr string) (*CryptoUnit, error) {
-	var extValue []byte
-
+// LoadCu creates a crypto unit from the certificates stored at certPath.
+// The private key is read from certPath unless a signer is given.
+func LoadCu(certPath string, identity pkix.Name, caAddr string, signer crypto.Signer) (*CryptoUnit, error) {
 	if certPath == "" {
 		return nil, errInvlPath
 	}
@@ -142,11 +204,30 @@ func LoadCu(certPath string, identity pkix.Name, caAddr string) (*CryptoUnit, er
 		return nil, err
 	}
 
-	priv, err := loadPrivKey(certPath)
+	if signer == nil {
+		if signer, err = loadPrivKey(certPath); err != nil {
+			return nil, err
+		}
+	} else if _, ok := signer.Public().(*ecdsa.PublicKey); !ok {
+		return nil, errSignerKey
+	}
+
+	return loadedCu(certs, signer, identity, caAddr)
+}
+
+// LoadCuBundle creates a crypto unit from an identity bundle produced by ExportIdentity.
+func LoadCuBundle(bundle []byte, identity pkix.Name, caAddr string) (*CryptoUnit, error) {
+	certs, priv, err := parseBundle(bundle)
 	if err != nil {
 		return nil, err
 	}
 
+	return loadedCu(certs, priv, identity, caAddr)
+}
+
+func loadedCu(certs *certSet, signer crypto.Signer, identity pkix.Name, caAddr string) (*CryptoUnit, error) {
+	var extValue []byte
+
 	for _, e := range certs.ownCert.Extensions {
 		if e.Id.Equal(asn1.ObjectIdentifier{2, 5, 13, 37}) {
 			extValue = e.Value
@@ -159,20 +240,23 @@ func LoadCu(certPath string, identity pkix.Name, caAddr string) (*CryptoUnit, er
 
 	numRings := binary.LittleEndian.Uint32(extValue[0:])
 
+	// Renewals keep the zone label of the stored certificate.
+	identity.OrganizationalUnit = certs.ownCert.Subject.OrganizationalUnit
+
 	return &CryptoUnit{
 		ca:         certs.caCert,
 		self:       certs.ownCert,
 		numRings:   numRings,
 		caAddr:     caAddr,
 		pk:         identity,
-		priv:       priv,
+		signer:     signer,
 		knownCerts: certs.knownCerts,
 		trusted:    certs.trusted,
 	}, nil
 }
 
 /* Like NewCu() but without validation of identity ip/hostname-existence. */
-func NewStaticCu(identity pkix.Name, caAddr string, dnsLabel string) (*CryptoUnit, error) {
+func NewStaticCu(identity pkix.Name, caAddr string, dnsLabel string, signer crypto.Signer, policy CaRequestPolicy) (*CryptoUnit, error) {
 	var certs *certSet
 	var extValue []byte
 
@@ -180,12 +264,11 @@ func NewStaticCu(identity pkix.Name, caAddr string, dnsLabel string) (*CryptoUni
 		return nil, errNoAddrs
 	}
 
-	serviceAddr := strings.Split(identity.Locality[0], ":")
-	if len(serviceAddr) <= 0 {
+	if _, _, err := net.SplitHostPort(identity.Locality[0]); err != nil {
 		return nil, errNoHostIp
 	}
 
-	priv, err := genKeys()
+	signer, err := defaultSigner(signer)
 	if err != nil {
 		return nil, err
 	}
@@ -194,9 +277,7 @@ func NewStaticCu(identity pkix.Name, caAddr string, dnsLabel string) (*CryptoUni
 		return nil, errNoCa
 	}
 
-	addr := fmt.Sprintf("http://%s/certificateRequest", caAddr)
-
-	certs, err = sendCertRequest(priv, addr, identity, dnsLabel)
+	certs, err = sendCertRequest(signer, caAddr, identity, dnsLabel, policy)
 	if err != nil {
 		return nil, err
 	}
@@ -218,8 +299,9 @@ func NewStaticCu(identity pkix.Name, caAddr string, dnsLabel string) (*CryptoUni
 		self:       certs.ownCert,
 		numRings:   numRings,
 		caAddr:     caAddr,
+		caPolicy:   policy,
 		pk:         identity,
-		priv:       priv,
+		signer:     signer,
 		knownCerts: certs.knownCerts,
 		trusted:    certs.trusted,
 	}, nil
@@ -230,9 +312,81 @@ func (cu *CryptoUnit) Trusted() bool {
 }
 
 func (cu *CryptoUnit) Certificate() *x509.Certificate {
+	cu.selfMutex.RLock()
+	defer cu.selfMutex.RUnlock()
+
 	return cu.self
 }
 
+// Sets how renewal requests are sent to the CA, for crypto units loaded from disk or a bundle.
+// Must be called before the crypto unit is used.
+func (cu *CryptoUnit) SetCaRequestPolicy(policy CaRequestPolicy) {
+	cu.caPolicy = policy
+}
+
+// Requests a new certificate for our current private key from the CA.
+// The CA keeps the id of known keys, so the renewed certificate replaces the current one.
+func (cu *CryptoUnit) RenewCertificate() (*x509.Certificate, error) {
+	if cu.caAddr == "" {
+		return nil, errNoCa
+	}
+
+	current := cu.Certificate()
+
+	var dnsLabel string
+	if len(current.DNSNames) > 0 {
+		dnsLabel = current.DNSNames[0]
+	}
+
+	certs, err := sendCertRequest(cu.signer, cu.caAddr, cu.pk, dnsLabel, cu.caPolicy)
+	if err != nil {
+		return nil, err
+	}
+
+	if !bytes.Equal(certs.ownCert.SubjectKeyId, current.SubjectKeyId) {
+		return nil, errRenewedId
+	}
+
+	cu.selfMutex.Lock()
+	cu.self = certs.ownCert
+	cu.selfMutex.Unlock()
+
+	return certs.ownCert, nil
+}
+
+// Checks whether the CA answers requests, with a single attempt within the timeout of the request policy,
+// or defaultCaPingTimeout if it has none. Any answer but a server error counts, so no certificate is requested.
+// Returns an error wrapping ErrCaUnreachable if the CA does not answer, errNoCa if there is no CA.
+func (cu *CryptoUnit) PingCa() error {
+	if cu.caAddr == "" {
+		return errNoCa
+	}
+
+	reqUrl, err := certRequestUrl(cu.caAddr, cu.caPolicy.AllowHttp)
+	if err != nil {
+		return err
+	}
+
+	timeout := cu.caPolicy.Timeout
+	if timeout <= 0 {
+		timeout = defaultCaPingTimeout
+	}
+
+	resp, err := caClient(cu.caPolicy, timeout).Get(reqUrl)
+	if err != nil {
+		return fmt.Errorf("%w: %s", ErrCaUnreachable, err.Error())
+	}
+	defer resp.Body.Close()
+
+	io.Copy(ioutil.Discard, resp.Body)
+
+	if resp.StatusCode >= http.StatusInternalServerError {
+		return fmt.Errorf("%w: status %s", ErrCaUnreachable, resp.Status)
+	}
+
+	return nil
+}
+
 func (cu *CryptoUnit) CaCertificate() *x509.Certificate {
 	return cu.ca
 }
@@ -241,8 +395,18 @@ func (cu *CryptoUnit) NumRings() uint32 {
 	return cu.numRings
 }
 
+// Returns nil if the private key is held by an external signer.
 func (cu *CryptoUnit) Priv() *ecdsa.PrivateKey {
-	return cu.priv
+	if seeded, ok := cu.signer.(*seededSigner); ok {
+		return seeded.PrivateKey
+	}
+
+	priv, _ := cu.signer.(*ecdsa.PrivateKey)
+	return priv
+}
+
+func (cu *CryptoUnit) Signer() crypto.Signer {
+	return cu.signer
 }
 
 func (cu *CryptoUnit) ContactList() []*x509.Certificate {
@@ -255,39 +419,101 @@ func (cu *CryptoUnit) ContactList() []*x509.Certificate {
 	return ret
 }
 
+// Sets the digest algorithm of signatures made and verified by the crypto unit,
+// one of SignatureSHA256, SignatureSHA384 or SignatureSHA512. Empty means SignatureSHA256.
+// All peers of a network must use the same algorithm. Must be called before the crypto unit is used.
+func (cu *CryptoUnit) SetSignatureHash(name string) error {
+	if name == "" {
+		name = SignatureSHA256
+	}
+
+	h, ok := signatureHashes[name]
+	if !ok {
+		return errSigHash
+	}
+
+	cu.hash = h
+
+	return nil
+}
+
+func (cu *CryptoUnit) signatureHash() crypto.Hash {
+	if cu.hash == 0 {
+		return crypto.SHA256
+	}
+
+	return cu.hash
+}
+
 func (cu *CryptoUnit) Verify(data, r, s []byte, pub *ecdsa.PublicKey) bool {
 	if pub == nil {
 		log.Error("Peer had no publicKey")
 		return false
 	}
 
-	var rInt, sInt big.Int
+	if verifyDigest(cu.signatureHash(), data, r, s, pub) {
+		return true
+	}
+
+	// Signatures do not carry their digest algorithm, check if the signer uses another one
+	// so that a misconfigured peer is reported instead of just failing verification.
+	if h, ok := otherSignatureHash(cu.signatureHash(), data, r, s, pub); ok {
+		log.Error("Signature made with a different hash algorithm, all peers must use the same signature hash",
+			"expected", cu.signatureHash().String(), "got", h.String())
+	}
 
-	b := hashContent(data)
+	return false
+}
+
+// Returns the supported algorithm other than the given one the signature verifies with, if any.
+func otherSignatureHash(expected crypto.Hash, data, r, s []byte, pub *ecdsa.PublicKey) (crypto.Hash, bool) {
+	for _, h := range signatureHashes {
+		if h != expected && verifyDigest(h, data, r, s, pub) {
+			return h, true
+		}
+	}
+
+	return 0, false
+}
+
+func verifyDigest(h crypto.Hash, data, r, s []byte, pub *ecdsa.PublicKey) bool {
+	var rInt, sInt big.Int
 
 	rInt.SetBytes(r)
 	sInt.SetBytes(s)
 
-	return ecdsa.Verify(pub, b, &rInt, &sInt)
+	return ecdsa.Verify(pub, hashContent(h, data), &rInt, &sInt)
 }
 
 func (cu *CryptoUnit) Sign(data []byte) ([]byte, []byte, error) {
-	hash := hashContent(data)
+	h := cu.signatureHash()
+
+	hash := hashContent(h, data)
 
-	r, s, err := ecdsa.Sign(rand.Reader, cu.priv, hash)
+	der, err := cu.signer.Sign(rand.Reader, hash, h)
 	if err != nil {
 		return nil, nil, err
 	}
 
-	return r.Bytes(), s.Bytes(), nil
+	var sig ecdsaSignature
+
+	if _, err := asn1.Unmarshal(der, &sig); err != nil {
+		return nil, nil, err
+	}
+
+	return sig.R.Bytes(), sig.S.Bytes(), nil
 }
 
 /* Save private key for node crypto-unit to new file in argument directory-path.
  * - marius
  */
 func (cu *CryptoUnit) SavePrivateKey(path string) error {
+	priv := cu.Priv()
+	if priv == nil {
+		return errNoPrivKey
+	}
 
-	path = filepath.Join(path, fmt.Sprintf("certificate-%s", cu.self.SerialNumber))
+	path = filepath.Join(path, fmt.Sprintf("certificate-%s", cu.Certificate().SerialNumber))
 
 	err := os.MkdirAll(path, fs.ModePerm)
 	if err != nil {
@@ -301,7 +527,7 @@ func (cu *CryptoUnit) SavePrivateKey(path string) error {
 		return err
 	}
 
-	keyBytes, err := x509.MarshalECPrivateKey(cu.priv)
+	keyBytes, err := x509.MarshalECPrivateKey(priv)
 	if err != nil {
 		return err
 	}
@@ -325,8 +551,9 @@ func (cu *CryptoUnit) SavePrivateKey(path string) error {
  * - marius
  */
 func (cu *CryptoUnit) SaveCertificate(path string) error {
+	self := cu.Certificate()
 
-	path = filepath.Join(path, fmt.Sprintf("certificate-%s", cu.self.SerialNumber))
+	path = filepath.Join(path, fmt.Sprintf("certificate-%s", self.SerialNumber))
 
 	err := os.MkdirAll(path, fs.ModePerm)
 	if err != nil {
@@ -362,9 +589,9 @@ func (cu *CryptoUnit) SaveCertificate(path string) error {
 	/*
 	 * Self.
 	 */
-	fname = filepath.Join(path, fmt.Sprintf("self-%s.pem", cu.self.SerialNumber))
+	fname = filepath.Join(path, fmt.Sprintf("self-%s.pem", self.SerialNumber))
 
-	err = saveCert(cu.self, fname)
+	err = saveCert(self, fname)
 	if err != nil {
 		log.Error(err.Error())
 	}
@@ -374,6 +601,91 @@ func (cu *CryptoUnit) SaveCertificate(path string) error {
 	return nil
 }
 
+// Pem block header marking the role of each certificate in an identity bundle.
+const bundleRole = "Ifrit-Role"
+
+// ExportIdentity encodes our certificate, the CA certificate, the known certificates
+// and the private key as a single PEM bundle, which can be loaded with LoadCuBundle.
+func (cu *CryptoUnit) ExportIdentity() ([]byte, error) {
+	var buf bytes.Buffer
+
+	priv := cu.Priv()
+	if priv == nil {
+		return nil, errNoPrivKey
+	}
+
+	keyBytes, err := x509.MarshalECPrivateKey(priv)
+	if err != nil {
+		return nil, err
+	}
+
+	blocks := []*pem.Block{
+		{Type: "CERTIFICATE", Headers: map[string]string{bundleRole: "self"}, Bytes: cu.Certificate().Raw},
+	}
+
+	if cu.ca != nil {
+		blocks = append(blocks, &pem.Block{Type: "CERTIFICATE", Headers: map[string]string{bundleRole: "ca"}, Bytes: cu.ca.Raw})
+	}
+
+	for _, c := range cu.knownCerts {
+		blocks = append(blocks, &pem.Block{Type: "CERTIFICATE", Headers: map[string]string{bundleRole: "known"}, Bytes: c.Raw})
+	}
+
+	blocks = append(blocks, &pem.Block{Type: "EC PRIVATE KEY", Bytes: keyBytes})
+
+	for _, b := range blocks {
+		if err := pem.Encode(&buf, b); err != nil {
+			return nil, err
+		}
+	}
+
+	return buf.Bytes(), nil
+}
+
+func parseBundle(bundle []byte) (*certSet, *ecdsa.PrivateKey, error) {
+	var priv *ecdsa.PrivateKey
+
+	certs := &certSet{trusted: true}
+
+	for {
+		var block *pem.Block
+
+		block, bundle = pem.Decode(bundle)
+		if block == nil {
+			break
+		}
+
+		if block.Type == "EC PRIVATE KEY" {
+			key, err := x509.ParseECPrivateKey(block.Bytes)
+			if err != nil {
+				return nil, nil, err
+			}
+			priv = key
+			continue
+		}
+
+		c, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		switch block.Headers[bundleRole] {
+		case "self":
+			certs.ownCert = c
+		case "ca":
+			certs.caCert = c
+		default:
+			certs.knownCerts = append(certs.knownCerts, c)
+		}
+	}
+
+	if certs.ownCert == nil || priv == nil {
+		return nil, nil, errBundle
+	}
+
+	return certs, priv, nil
+}
+
 func saveCert(cert *x509.Certificate, filename string) error {
 	f, err := os.Create(filename)
 	if err != nil {
@@ -469,6 +781,11 @@ func loadCertSet(certPath string) (*certSet, error) {
 	}, nil
 }
 
+// LoadCertificate reads a single PEM encoded certificate from the given path.
+func LoadCertificate(path string) (*x509.Certificate, error) {
+	return loadCert(path)
+}
+
 func loadCert(path string) (*x509.Certificate, error) {
 	certPem, err := ioutil.ReadFile(path)
 	if err != nil {
@@ -515,28 +832,32 @@ func loadPrivKey(certPath string) (*ecdsa.PrivateKey, error) {
 	return privKey, nil
 }
 
-func sendCertRequest(privKey *ecdsa.PrivateKey, caAddr string, pk pkix.Name, dnsLabel string) (*certSet, error) {
+func sendCertRequest(signer crypto.Signer, caAddr string, pk pkix.Name, dnsLabel string, policy CaRequestPolicy) (*certSet, error) {
 	var certs certResponse
 	set := &certSet{}
 
+	reqUrl, err := certRequestUrl(caAddr, policy.AllowHttp)
+	if err != nil {
+		return nil, err
+	}
+
 	template := x509.CertificateRequest{
 		SignatureAlgorithm: x509.ECDSAWithSHA256,
-		Subject:            pk,
+		Subject:            netutil.OrderedLocality(pk),
 		DNSNames:           []string{dnsLabel},
 	}
 
-	certReqBytes, err := x509.CreateCertificateRequest(rand.Reader, &template, privKey)
+	certReqBytes, err := x509.CreateCertificateRequest(rand.Reader, &template, signer)
 	if err != nil {
 		return nil, err
 	}
 
-	resp, err := http.Post(caAddr, "text", bytes.NewBuffer(certReqBytes))
+	body, err := postCertRequest(reqUrl, certReqBytes, policy)
 	if err != nil {
 		return nil, err
 	}
-	defer resp.Body.Close()
 
-	err = json.NewDecoder(resp.Body).Decode(&certs)
+	err = json.Unmarshal(body, &certs)
 	if err != nil {
 		return nil, err
 	}
@@ -564,7 +885,94 @@ func sendCertRequest(privKey *ecdsa.PrivateKey, caAddr string, pk pkix.Name, dns
 	return set, nil
 }
 
-func selfSignedCert(priv *ecdsa.PrivateKey, pk pkix.Name) (*certSet, error) {
+// Returns the url certificate requests are posted to,
+// the CA address is either an https or http url, or an ip:port which is served over plain http.
+func certRequestUrl(caAddr string, allowHttp bool) (string, error) {
+	if !strings.Contains(caAddr, "://") {
+		caAddr = "http://" + caAddr
+	}
+
+	u, err := url.Parse(caAddr)
+	if err != nil {
+		return "", err
+	}
+
+	switch u.Scheme {
+	case "https":
+	case "http":
+		if !allowHttp {
+			return "", errInsecureCa
+		}
+	default:
+		return "", errCaScheme
+	}
+
+	u.Path = "/certificateRequest"
+
+	return u.String(), nil
+}
+
+// Posts the certificate request until the CA answers, or the attempts of the policy are exhausted.
+// Connection failures, timeouts and server errors are retried, letting nodes wait out a CA restart.
+func postCertRequest(reqUrl string, certReq []byte, policy CaRequestPolicy) ([]byte, error) {
+	var err error
+
+	client := caClient(policy, policy.Timeout)
+	backoff := policy.BaseBackoff
+
+	for attempt := 1; ; attempt++ {
+		var body []byte
+
+		body, err = attemptCertRequest(client, reqUrl, certReq)
+		if err == nil {
+			return body, nil
+		}
+
+		log.Debug(err.Error(), "url", reqUrl, "attempt", attempt)
+
+		if attempt >= policy.MaxAttempts {
+			return nil, fmt.Errorf("%w after %d attempt(s): %s", ErrCaUnreachable, attempt, err.Error())
+		}
+
+		time.Sleep(backoff)
+
+		backoff *= 2
+		if policy.MaxBackoff > 0 && backoff > policy.MaxBackoff {
+			backoff = policy.MaxBackoff
+		}
+	}
+}
+
+func caClient(policy CaRequestPolicy, timeout time.Duration) *http.Client {
+	return &http.Client{
+		Timeout: timeout,
+		Transport: &http.Transport{
+			Proxy:           http.ProxyFromEnvironment,
+			TLSClientConfig: &tls.Config{RootCAs: policy.RootCAs},
+		},
+	}
+}
+
+func attemptCertRequest(client *http.Client, reqUrl string, certReq []byte) ([]byte, error) {
+	resp, err := client.Post(reqUrl, "text", bytes.NewBuffer(certReq))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.StatusCode >= http.StatusInternalServerError {
+		return nil, fmt.Errorf("Certificate request failed with status %s", resp.Status)
+	}
+
+	return body, nil
+}
+
+func selfSignedCert(signer crypto.Signer, pk pkix.Name) (*certSet, error) {
 	ringBytes := make([]byte, 4)
 	binary.LittleEndian.PutUint32(ringBytes[0:], uint32(32))
 
@@ -579,26 +987,31 @@ func selfSignedCert(priv *ecdsa.PrivateKey, pk pkix.Name) (*certSet, error) {
 		return nil, err
 	}
 
-	serviceAddr := strings.Split(pk.Locality[0], ":")
-	if len(serviceAddr) <= 0 {
+	serviceHost, _, err := net.SplitHostPort(pk.Locality[0])
+	if err != nil {
 		return nil, errNoHostIp
 	}
 
-	ip := net.ParseIP(serviceAddr[0])
+	ip := net.ParseIP(serviceHost)
 	if ip == nil {
 		return nil, errNoHostIp
 	}
 
+	id := genId()
+	if seeded, ok := signer.(*seededSigner); ok {
+		id = seeded.id
+	}
+
 	// TODO generate ids and serial numbers differently
 	newCert := &x509.Certificate{
 		SerialNumber:          serial,
-		SubjectKeyId:          genId(),
-		Subject:               pk,
+		SubjectKeyId:          id,
+		Subject:               netutil.OrderedLocality(pk),
 		BasicConstraintsValid: true,
 		NotBefore:             time.Now().AddDate(-10, 0, 0),
 		NotAfter:              time.Now().AddDate(10, 0, 0),
 		ExtraExtensions:       []pkix.Extension{ext},
-		PublicKey:             priv.PublicKey,
+		PublicKey:             signer.Public(),
 		IPAddresses:           []net.IP{ip},
 		IsCA:                  true,
 		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth,
@@ -608,7 +1021,7 @@ func selfSignedCert(priv *ecdsa.PrivateKey, pk pkix.Name) (*certSet, error) {
 	}
 
 	signedCert, err := x509.CreateCertificate(rand.Reader, newCert,
-		newCert, priv.Public(), priv)
+		newCert, signer.Public(), signer)
 	if err != nil {
 		return nil, err
 	}
@@ -646,8 +1059,55 @@ func genKeys() (*ecdsa.PrivateKey, error) {
 	return privKey, nil
 }
 
-func hashContent(data []byte) []byte {
-	h := sha256.New()
+// Asn1 structure of ecdsa signatures produced by crypto.Signer.
+type ecdsaSignature struct {
+	R, S *big.Int
+}
+
+// Generates an in-memory key if no signer is given, only ecdsa keys are supported.
+func defaultSigner(signer crypto.Signer) (crypto.Signer, error) {
+	if signer == nil {
+		return genKeys()
+	}
+
+	if _, ok := signer.Public().(*ecdsa.PublicKey); !ok {
+		return nil, errSignerKey
+	}
+
+	return signer, nil
+}
+
+// ONLY FOR TESTING
+// Key derived from a seed, self-signed certificates created with it get an id
+// derived from the same seed, giving reproducible ids and ring placements.
+type seededSigner struct {
+	*ecdsa.PrivateKey
+	id []byte
+}
+
+// ONLY FOR TESTING
+// Returns a signer whose key, and id when no CA is used, is derived from the given seed.
+// Anyone knowing the seed can recreate the key, it must never be used in production.
+func NewSeededSigner(seed []byte) crypto.Signer {
+	curve := elliptic.P521()
+	h := sha512.Sum512(seed)
+
+	// Scalar in [1, N-1]
+	d := new(big.Int).SetBytes(h[:])
+	d.Mod(d, new(big.Int).Sub(curve.Params().N, big.NewInt(1)))
+	d.Add(d, big.NewInt(1))
+
+	priv := &ecdsa.PrivateKey{D: d}
+	priv.PublicKey.Curve = curve
+	priv.PublicKey.X, priv.PublicKey.Y = curve.ScalarBaseMult(d.Bytes())
+
+	id := sha256.Sum256(elliptic.Marshal(curve, priv.PublicKey.X, priv.PublicKey.Y))
+
+	return &seededSigner{PrivateKey: priv, id: id[:]}
+}
+
+func hashContent(hash crypto.Hash, data []byte) []byte {
+	h := hash.New()
 	h.Write(data)
 	return h.Sum(nil)
 }
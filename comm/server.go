@@ -30,7 +30,9 @@ type gRPCServer struct {
 	listenAddr string
 }
 
-func newServer(config *tls.Config, l net.Listener) (*gRPCServer, error) {
+// A maxStreams of zero leaves the number of concurrent streams per connection unlimited.
+// The given options are applied after our defaults and before the transport credentials.
+func newServer(config *tls.Config, l net.Listener, maxStreams uint32, maxMsgSize int, opts []grpc.ServerOption) (*gRPCServer, error) {
 	var serverOpts []grpc.ServerOption
 
 	if config == nil {
@@ -44,8 +46,16 @@ func newServer(config *tls.Config, l net.Listener) (*gRPCServer, error) {
 
 	creds := credentials.NewTLS(config)
 
-	serverOpts = append(serverOpts, grpc.Creds(creds))
 	serverOpts = append(serverOpts, grpc.KeepaliveParams(keepAlive))
+	serverOpts = append(serverOpts, grpc.MaxRecvMsgSize(maxMsgSize))
+	serverOpts = append(serverOpts, grpc.MaxSendMsgSize(maxMsgSize))
+
+	if maxStreams > 0 {
+		serverOpts = append(serverOpts, grpc.MaxConcurrentStreams(maxStreams))
+	}
+
+	serverOpts = append(serverOpts, opts...)
+	serverOpts = append(serverOpts, grpc.Creds(creds))
 
 	return &gRPCServer{
 		listener:   l,
@@ -67,6 +77,10 @@ func (s *gRPCServer) stop() {
 	s.rpcServer.Stop()
 }
 
+func (s *gRPCServer) gracefulStop() {
+	s.rpcServer.GracefulStop()
+}
+
 func (s *gRPCServer) addr() string {
 	return s.listenAddr
 }
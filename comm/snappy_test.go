@@ -0,0 +1,134 @@
+package comm
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/x509/pkix"
+	"io/ioutil"
+	"testing"
+
+	"github.com/golang/protobuf/proto"
+	log "github.com/inconshreveable/log15"
+	pb "github.com/joonnna/ifrit/protobuf"
+	"github.com/stretchr/testify/require"
+	"github.com/stretchr/testify/suite"
+	"google.golang.org/grpc/encoding"
+	_ "google.golang.org/grpc/encoding/gzip"
+)
+
+type CompressionTestSuite struct {
+	suite.Suite
+}
+
+func TestCompressionTestSuite(t *testing.T) {
+	r := log.Root()
+
+	r.SetHandler(log.DiscardHandler())
+
+	suite.Run(t, new(CompressionTestSuite))
+}
+
+func (suite *CompressionTestSuite) TestRoundTrip() {
+	data, err := proto.Marshal(testStateResponse(50))
+	require.NoError(suite.T(), err, "Failed to marshal state.")
+
+	for _, name := range []string{GzipCompression, SnappyCompression} {
+		c := encoding.GetCompressor(name)
+		require.NotNilf(suite.T(), c, "Compressor %s not registered.", name)
+
+		// Twice to reuse pooled writers.
+		for i := 0; i < 2; i++ {
+			compressed, err := compress(c, data)
+			require.NoErrorf(suite.T(), err, "Failed to compress with %s.", name)
+
+			r, err := c.Decompress(bytes.NewReader(compressed))
+			require.NoErrorf(suite.T(), err, "Failed to decompress with %s.", name)
+
+			out, err := ioutil.ReadAll(r)
+			require.NoErrorf(suite.T(), err, "Failed to decompress with %s.", name)
+			require.Equalf(suite.T(), data, out, "Round trip altered data with %s.", name)
+		}
+	}
+}
+
+// Compares cpu time and compressed size of a gossip response under each codec.
+// Run with: go test -run none -bench Compression ./comm
+func BenchmarkCompression(b *testing.B) {
+	data, err := proto.Marshal(testStateResponse(50))
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	for _, name := range []string{GzipCompression, SnappyCompression} {
+		c := encoding.GetCompressor(name)
+
+		b.Run(name, func(b *testing.B) {
+			var size int
+
+			b.SetBytes(int64(len(data)))
+
+			for i := 0; i < b.N; i++ {
+				compressed, err := compress(c, data)
+				if err != nil {
+					b.Fatal(err)
+				}
+				size = len(compressed)
+			}
+
+			b.ReportMetric(float64(size), "compressed-bytes")
+			b.ReportMetric(float64(size)/float64(len(data)), "ratio")
+		})
+	}
+}
+
+func compress(c encoding.Compressor, data []byte) ([]byte, error) {
+	var buf bytes.Buffer
+
+	w, err := c.Compress(&buf)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := w.Write(data); err != nil {
+		return nil, err
+	}
+
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+// Builds a response resembling gossip to a new peer, certificates with notes.
+func testStateResponse(numPeers int) *pb.StateResponse {
+	ret := &pb.StateResponse{}
+
+	priv, err := genKeys()
+	if err != nil {
+		panic(err)
+	}
+
+	for i := 0; i < numPeers; i++ {
+		cert, err := selfSignedCert(priv, pkix.Name{Locality: []string{"127.0.0.1:8000", "pingAddr"}})
+		if err != nil {
+			panic(err)
+		}
+
+		id := make([]byte, 32)
+		rand.Read(id)
+
+		ret.Certificates = append(ret.Certificates, &pb.Certificate{Raw: cert.ownCert.Raw})
+		ret.Notes = append(ret.Notes, &pb.Note{
+			Epoch: uint64(i),
+			Id:    id,
+			Mask:  0xffffffff,
+			Signature: &pb.Signature{
+				R: id,
+				S: id,
+			},
+		})
+	}
+
+	return ret
+}
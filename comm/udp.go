@@ -2,6 +2,7 @@ package comm
 
 import (
 	"net"
+	"strconv"
 	"time"
 
 	"github.com/golang/protobuf/proto"
@@ -9,10 +10,27 @@ import (
 	pb "github.com/joonnna/ifrit/protobuf"
 )
 
+const (
+	// Largest payload a single UDP datagram can carry over IPv4.
+	defaultMaxDatagramSize = 65507
+
+	defaultPingTimeout = time.Second * 5
+)
+
 type UDPServer struct {
 	conn *net.UDPConn
 	addr string
 
+	maxDatagramSize int
+
+	pingTimeout     time.Duration
+	pingRetransmits int
+
+	verifier func(*pb.Ping) bool
+	pongNote func() *pb.Note
+
+	errorHandler func(write bool)
+
 	exitChan  chan bool
 	pauseChan chan time.Duration
 
@@ -23,15 +41,63 @@ type pongSigner interface {
 	Sign([]byte) ([]byte, []byte, error)
 }
 
-func NewUdpServer(ps pongSigner, conn *net.UDPConn) (*UDPServer, error) {
+// NewUdpServer creates a ping server serving the given connection.
+// The server is advertised at the given hostname and the port the connection is bound to.
+// maxDatagramSize bounds the size of received datagrams, zero or a negative
+// value selects the maximum UDP payload size (65507 bytes).
+func NewUdpServer(ps pongSigner, conn *net.UDPConn, hostname string, maxDatagramSize int) (*UDPServer, error) {
+	if maxDatagramSize <= 0 {
+		maxDatagramSize = defaultMaxDatagramSize
+	}
+
+	port := conn.LocalAddr().(*net.UDPAddr).Port
+
 	return &UDPServer{
-		conn:       conn,
-		exitChan:   make(chan bool, 1),
-		pauseChan:  make(chan time.Duration, 1),
-		pongSigner: ps,
+		conn:            conn,
+		addr:            net.JoinHostPort(hostname, strconv.Itoa(port)),
+		maxDatagramSize: maxDatagramSize,
+		pingTimeout:     defaultPingTimeout,
+		exitChan:        make(chan bool, 1),
+		pauseChan:       make(chan time.Duration, 1),
+		pongSigner:      ps,
 	}, nil
 }
 
+// Sets how long each ping waits for a pong before it is retransmitted or fails.
+// Must not be called concurrently with Ping.
+func (us *UDPServer) SetPingTimeout(d time.Duration) {
+	if d > 0 {
+		us.pingTimeout = d
+	}
+}
+
+// Sets how many times a ping is resent when no pong arrives within the ping timeout.
+// Must not be called concurrently with Ping.
+func (us *UDPServer) SetPingRetransmits(n int) {
+	us.pingRetransmits = n
+}
+
+// Sets the function deciding whether an incoming ping comes from a trusted peer,
+// pings it rejects, or that can not be parsed, are dropped without a pong.
+// If never set all pings are answered. Must be called before Start.
+func (us *UDPServer) SetPingVerifier(verifier func(*pb.Ping) bool) {
+	us.verifier = verifier
+}
+
+// Sets the function providing the note attached to each pong, so that pingers
+// learn our latest note without waiting for gossip. Must be called before Start.
+func (us *UDPServer) SetPongNote(note func() *pb.Note) {
+	us.pongNote = note
+}
+
+// Sets the function told about each failed read, and each failed pong write, of the serving socket.
+// Must be called before Start.
+func (us *UDPServer) SetErrorHandler(handler func(write bool)) {
+	us.errorHandler = handler
+}
+
+// Sends the ping and waits for the pong, the ping is resent up to the configured
+// number of retransmits if no pong arrives within the ping timeout.
 func (us *UDPServer) Ping(addr string, p *pb.Ping) (*pb.Pong, error) {
 	udpAddr, err := net.ResolveUDPAddr("udp", addr)
 	if err != nil {
@@ -43,23 +109,38 @@ func (us *UDPServer) Ping(addr string, p *pb.Ping) (*pb.Pong, error) {
 		return nil, err
 	}
 	defer c.Close()
-	c.SetDeadline(time.Now().Add(time.Second * 5))
 
 	data, err := proto.Marshal(p)
 	if err != nil {
 		return nil, err
 	}
 
-	_, err = c.Write(data)
-	if err != nil {
-		return nil, err
-	}
+	bytes := make([]byte, us.maxDatagramSize)
 
-	bytes := make([]byte, 256)
+	var n int
 
-	n, err := c.Read(bytes)
-	if err != nil {
-		return nil, err
+	for attempt := 0; ; attempt++ {
+		c.SetDeadline(time.Now().Add(us.pingTimeout))
+
+		_, err = c.Write(data)
+		if err != nil {
+			return nil, err
+		}
+
+		n, err = c.Read(bytes)
+		if err == nil {
+			break
+		}
+
+		if netErr, ok := err.(net.Error); !ok || !netErr.Timeout() || attempt >= us.pingRetransmits {
+			return nil, err
+		}
+
+		log.Debug("Ping timed out, retransmitting", "addr", addr, "attempt", attempt+1)
+	}
+
+	if n == len(bytes) {
+		log.Warn("Pong filled the whole receive buffer, likely truncated", "addr", addr, "size", n)
 	}
 
 	pong := &pb.Pong{}
@@ -73,7 +154,7 @@ func (us *UDPServer) Ping(addr string, p *pb.Ping) (*pb.Pong, error) {
 }
 
 func (us *UDPServer) Start() {
-	bytes := make([]byte, 256)
+	bytes := make([]byte, us.maxDatagramSize)
 	for {
 		select {
 		case d := <-us.pauseChan:
@@ -82,12 +163,38 @@ func (us *UDPServer) Start() {
 			return
 		default:
 			n, addr, err := us.conn.ReadFrom(bytes)
+			if err != nil {
+				// Reads fail once the connection is closed by Stop.
+				select {
+				case <-us.exitChan:
+					return
+				default:
+				}
+
+				log.Error(err.Error())
+				us.reportError(false)
+				continue
+			}
+
+			if n == len(bytes) {
+				log.Warn("Ping filled the whole receive buffer, likely truncated", "addr", addr, "size", n)
+			}
+
+			if us.verifier != nil && !us.authenticated(bytes[:n]) {
+				log.Debug("Dropping unauthenticated ping", "addr", addr)
+				continue
+			}
+
+			stamp := time.Now().UnixNano()
+
+			// The time is signed with the ping, so the clock skew estimate can not be tampered with.
+			data, err := pb.PongSignedData(bytes[:n], stamp)
 			if err != nil {
 				log.Error(err.Error())
 				continue
 			}
 
-			r, s, err := us.Sign(bytes[:n])
+			r, s, err := us.Sign(data)
 			if err != nil {
 				log.Error(err.Error())
 				continue
@@ -98,6 +205,11 @@ func (us *UDPServer) Start() {
 					R: r,
 					S: s,
 				},
+				Time: stamp,
+			}
+
+			if us.pongNote != nil {
+				pong.Note = us.pongNote()
 			}
 
 			resp, err := proto.Marshal(pong)
@@ -110,12 +222,29 @@ func (us *UDPServer) Start() {
 			_, err = us.conn.WriteTo(resp, addr)
 			if err != nil {
 				log.Error(err.Error())
+				us.reportError(true)
 				continue
 			}
 		}
 	}
 }
 
+func (us *UDPServer) reportError(write bool) {
+	if us.errorHandler != nil {
+		us.errorHandler(write)
+	}
+}
+
+func (us *UDPServer) authenticated(data []byte) bool {
+	ping := &pb.Ping{}
+
+	if err := proto.Unmarshal(data, ping); err != nil {
+		return false
+	}
+
+	return us.verifier(ping)
+}
+
 func (us *UDPServer) Addr() string {
 	return us.addr
 }
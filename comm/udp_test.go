@@ -1 +1,243 @@
 package comm
+
+import (
+	"bytes"
+	"crypto/rand"
+	"fmt"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+	log "github.com/inconshreveable/log15"
+	"github.com/joonnna/ifrit/netutil"
+	pb "github.com/joonnna/ifrit/protobuf"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/stretchr/testify/suite"
+)
+
+type UdpTestSuite struct {
+	suite.Suite
+
+	s      *UDPServer
+	signer *recordingSigner
+}
+
+// Records the last payload it was asked to sign.
+type recordingSigner struct {
+	mutex sync.Mutex
+	data  []byte
+}
+
+func (rs *recordingSigner) Sign(data []byte) ([]byte, []byte, error) {
+	rs.mutex.Lock()
+	defer rs.mutex.Unlock()
+
+	rs.data = append([]byte(nil), data...)
+
+	return []byte("r"), []byte("s"), nil
+}
+
+func (rs *recordingSigner) signed() []byte {
+	rs.mutex.Lock()
+	defer rs.mutex.Unlock()
+
+	return rs.data
+}
+
+func TestUdpTestSuite(t *testing.T) {
+	r := log.Root()
+
+	r.SetHandler(log.DiscardHandler())
+
+	suite.Run(t, new(UdpTestSuite))
+}
+
+func (suite *UdpTestSuite) SetupTest() {
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	require.NoError(suite.T(), err, "Failed to listen on loopback.")
+
+	suite.signer = &recordingSigner{}
+
+	s, err := NewUdpServer(suite.signer, conn, "127.0.0.1", 0)
+	require.NoError(suite.T(), err, "Failed to create udp server.")
+
+	go s.Start()
+
+	suite.s = s
+}
+
+func (suite *UdpTestSuite) TearDownTest() {
+	suite.s.Stop()
+}
+
+func (suite *UdpTestSuite) TestLargePing() {
+	nonce := make([]byte, 2048)
+	_, err := rand.Read(nonce)
+	require.NoError(suite.T(), err, "Failed to generate nonce.")
+
+	ping := &pb.Ping{
+		Nonce: nonce,
+	}
+
+	expected, err := proto.Marshal(ping)
+	require.NoError(suite.T(), err, "Failed to marshal ping.")
+
+	pong, err := suite.s.Ping(suite.s.conn.LocalAddr().String(), ping)
+	require.NoError(suite.T(), err, "Ping failed.")
+	require.NotNil(suite.T(), pong.GetSignature(), "Pong was not signed.")
+
+	assert.InDelta(suite.T(), time.Now().UnixNano(), pong.GetTime(), float64(time.Second), "Pong not stamped with the time it was answered.")
+
+	// The signature covers the full ping and the time.
+	signed, err := pb.PongSignedData(expected, pong.GetTime())
+	require.NoError(suite.T(), err, "Failed to build signed pong data.")
+	assert.True(suite.T(), bytes.Equal(signed, suite.signer.signed()), "Server did not sign the full ping along with the time.")
+}
+
+func (suite *UdpTestSuite) TestMaxDatagramSize() {
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	require.NoError(suite.T(), err, "Failed to listen on loopback.")
+	defer conn.Close()
+
+	s, err := NewUdpServer(suite.signer, conn, "127.0.0.1", 0)
+	require.NoError(suite.T(), err, "Failed to create udp server.")
+	assert.Equal(suite.T(), defaultMaxDatagramSize, s.maxDatagramSize, "Zero size should select the default size.")
+
+	s, err = NewUdpServer(suite.signer, conn, "127.0.0.1", 512)
+	require.NoError(suite.T(), err, "Failed to create udp server.")
+	assert.Equal(suite.T(), 512, s.maxDatagramSize, "Did not use the supplied size.")
+}
+
+func (suite *UdpTestSuite) TestIPv6() {
+	conn, addr, err := netutil.ListenUdpNetwork("udp6", "::1", 0)
+	if err != nil {
+		suite.T().Skip("IPv6 unavailable: " + err.Error())
+	}
+
+	port := conn.LocalAddr().(*net.UDPAddr).Port
+	require.Equal(suite.T(), fmt.Sprintf("[::1]:%d", port), addr, "IPv6 address not bracketed.")
+
+	s, err := NewUdpServer(suite.signer, conn, "::1", 0)
+	require.NoError(suite.T(), err, "Failed to create udp server.")
+	require.Equal(suite.T(), addr, s.Addr(), "Server advertises another address than netutil.")
+
+	go s.Start()
+	defer s.Stop()
+
+	pong, err := suite.s.Ping(addr, &pb.Ping{Nonce: []byte("nonce")})
+	require.NoError(suite.T(), err, "Ping over IPv6 failed.")
+	require.NotNil(suite.T(), pong.GetSignature(), "Pong was not signed.")
+}
+
+func (suite *UdpTestSuite) TestAddr() {
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	require.NoError(suite.T(), err, "Failed to listen on loopback.")
+
+	s, err := NewUdpServer(suite.signer, conn, "127.0.0.1", 0)
+	require.NoError(suite.T(), err, "Failed to create udp server.")
+
+	go s.Start()
+	defer s.Stop()
+
+	port := conn.LocalAddr().(*net.UDPAddr).Port
+	require.Equal(suite.T(), fmt.Sprintf("127.0.0.1:%d", port), s.Addr(), "Addr does not reflect the given hostname and bound port.")
+
+	_, err = suite.s.Ping(s.Addr(), &pb.Ping{Nonce: []byte("nonce")})
+	require.NoError(suite.T(), err, "Advertised address is not reachable.")
+}
+
+func (suite *UdpTestSuite) TestPingRetransmit() {
+	lossy, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	require.NoError(suite.T(), err, "Failed to listen on loopback.")
+	defer lossy.Close()
+
+	// Drops the first datagram from each sender and answers the rest.
+	go func() {
+		bytes := make([]byte, defaultMaxDatagramSize)
+		pong, _ := proto.Marshal(&pb.Pong{})
+		seen := make(map[string]bool)
+
+		for {
+			_, addr, err := lossy.ReadFrom(bytes)
+			if err != nil {
+				return
+			}
+
+			if !seen[addr.String()] {
+				seen[addr.String()] = true
+				continue
+			}
+
+			lossy.WriteTo(pong, addr)
+		}
+	}()
+
+	ping := &pb.Ping{Nonce: []byte("nonce")}
+
+	suite.s.SetPingTimeout(time.Millisecond * 50)
+
+	_, err = suite.s.Ping(lossy.LocalAddr().String(), ping)
+	require.Error(suite.T(), err, "Ping succeeded without retransmitting the dropped datagram.")
+
+	suite.s.SetPingRetransmits(1)
+
+	_, err = suite.s.Ping(lossy.LocalAddr().String(), ping)
+	require.NoError(suite.T(), err, "Ping failed despite retransmitting the dropped datagram.")
+}
+
+func (suite *UdpTestSuite) TestPingVerifier() {
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	require.NoError(suite.T(), err, "Failed to listen on loopback.")
+
+	s, err := NewUdpServer(suite.signer, conn, "127.0.0.1", 0)
+	require.NoError(suite.T(), err, "Failed to create udp server.")
+
+	s.SetPingVerifier(func(p *pb.Ping) bool {
+		return string(p.GetId()) == "trusted"
+	})
+
+	go s.Start()
+	defer s.Stop()
+
+	suite.s.SetPingTimeout(time.Millisecond * 50)
+
+	_, err = suite.s.Ping(s.Addr(), &pb.Ping{Id: []byte("trusted")})
+	require.NoError(suite.T(), err, "Verified ping not answered.")
+
+	_, err = suite.s.Ping(s.Addr(), &pb.Ping{Id: []byte("spoofed")})
+	require.Error(suite.T(), err, "Rejected ping answered.")
+
+	_, err = conn.WriteTo([]byte("garbage"), conn.LocalAddr())
+	require.NoError(suite.T(), err, "Failed to send garbage.")
+
+	_, err = suite.s.Ping(s.Addr(), &pb.Ping{Id: []byte("trusted")})
+	require.NoError(suite.T(), err, "Unparseable datagram stopped the server.")
+}
+
+func (suite *UdpTestSuite) TestPongNote() {
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	require.NoError(suite.T(), err, "Failed to listen on loopback.")
+
+	s, err := NewUdpServer(suite.signer, conn, "127.0.0.1", 0)
+	require.NoError(suite.T(), err, "Failed to create udp server.")
+
+	note := &pb.Note{Epoch: 3, Id: []byte("id")}
+
+	s.SetPongNote(func() *pb.Note {
+		return note
+	})
+
+	go s.Start()
+	defer s.Stop()
+
+	pong, err := suite.s.Ping(s.Addr(), &pb.Ping{Id: []byte("id")})
+	require.NoError(suite.T(), err, "Ping failed.")
+	require.True(suite.T(), proto.Equal(note, pong.GetNote()), "Pong does not carry the note.")
+
+	pong, err = suite.s.Ping(suite.s.Addr(), &pb.Ping{Id: []byte("id")})
+	require.NoError(suite.T(), err, "Ping failed.")
+	assert.Nil(suite.T(), pong.GetNote(), "Pong carries a note without a note source.")
+}
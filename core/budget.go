@@ -0,0 +1,62 @@
+package core
+
+import (
+	"github.com/golang/protobuf/proto"
+)
+
+// Room left for application data in a gossip message, membership state is always
+// sent in full and application data gets whatever it leaves of the size limit.
+type gossipBudget struct {
+	unlimited bool
+
+	// Size limit of the whole message, and what the membership state and application data leave of it.
+	max  int
+	left int
+}
+
+// Budget for a message with the given membership state, unlimited if no size limit is set.
+func (n *Node) newGossipBudget(msg proto.Message) *gossipBudget {
+	if n.maxGossipSize == 0 {
+		return &gossipBudget{unlimited: true}
+	}
+
+	return &gossipBudget{
+		max:  n.maxGossipSize,
+		left: n.maxGossipSize - proto.Size(msg),
+	}
+}
+
+// Reserves room for the given data as a field of the message, returns false if it does not fit.
+func (b *gossipBudget) takeBytes(data []byte) bool {
+	return b.take(len(data))
+}
+
+// Same as takeBytes but for an embedded message.
+func (b *gossipBudget) takeMsg(m proto.Message) bool {
+	return b.take(proto.Size(m))
+}
+
+// Returns true if the message would not fit in any gossip message, not even one without other content.
+func (b *gossipBudget) oversizedMsg(m proto.Message) bool {
+	return !b.unlimited && fieldSize(proto.Size(m)) > b.max
+}
+
+func (b *gossipBudget) take(size int) bool {
+	if b.unlimited {
+		return true
+	}
+
+	s := fieldSize(size)
+	if s > b.left {
+		return false
+	}
+
+	b.left -= s
+
+	return true
+}
+
+// Encoded size of a length delimited field, all gossip fields have single byte tags.
+func fieldSize(size int) int {
+	return 1 + proto.SizeVarint(uint64(size)) + size
+}
@@ -0,0 +1,81 @@
+package core
+
+import (
+	log "github.com/inconshreveable/log15"
+)
+
+// Checks the CA each poll interval, so that the CA status handler learns when it goes down and comes back.
+func (n *Node) caPollLoop() {
+	defer n.wg.Done()
+
+	for {
+		select {
+		case <-n.exitChan:
+			log.Info("Stopping CA polling")
+			return
+		case <-n.clock.After(n.caPollInterval):
+			err := n.cm.PingCa()
+			if err != nil {
+				log.Debug(err.Error())
+			}
+
+			n.setCaStatus(err == nil)
+		}
+	}
+}
+
+// Invokes the CA status handler if the status changed, and retries a certificate
+// rotation that failed while the CA was down once it is back.
+func (n *Node) setCaStatus(up bool) {
+	n.caMutex.Lock()
+	changed := n.caDown == up
+	n.caDown = !up
+
+	retry := up && n.rotationPending
+	if retry {
+		n.rotationPending = false
+	}
+	n.caMutex.Unlock()
+
+	if changed {
+		if up {
+			log.Info("Certificate Authority is reachable again")
+		} else {
+			log.Warn("Certificate Authority is unreachable, continuing with the current certificate")
+		}
+
+		if handler := n.getCaStatusHandler(); handler != nil {
+			go handler(up)
+		}
+	}
+
+	if retry {
+		if err := n.RotateCertificate(); err != nil {
+			log.Error("Retried certificate rotation failed", "err", err)
+		} else {
+			log.Info("Rotated certificate after the Certificate Authority came back")
+		}
+	}
+}
+
+// Called after a failed rotation, the rotation is retried by the poll loop if the CA was unreachable.
+func (n *Node) caRequestFailed() {
+	if n.caPollInterval <= 0 || n.cm.PingCa() == nil {
+		return
+	}
+
+	n.caMutex.Lock()
+	n.rotationPending = true
+	n.caMutex.Unlock()
+
+	n.setCaStatus(false)
+}
+
+// Returns false once the CA poll loop, or a failed certificate rotation, found the CA unreachable,
+// until the CA answers again. Always true if the CA is not polled.
+func (n *Node) CaReachable() bool {
+	n.caMutex.Lock()
+	defer n.caMutex.Unlock()
+
+	return !n.caDown
+}
@@ -0,0 +1,59 @@
+package core
+
+import (
+	"time"
+
+	log "github.com/inconshreveable/log15"
+	"github.com/joonnna/ifrit/core/discovery"
+)
+
+// Clock offsets beyond this are reported when no threshold is configured.
+const defaultClockSkewThreshold = time.Second
+
+func (n *Node) getClockSkewThreshold() time.Duration {
+	if n.clockSkewThreshold > 0 {
+		return n.clockSkewThreshold
+	}
+
+	return defaultClockSkewThreshold
+}
+
+func (n *Node) exceedsClockSkew(skew time.Duration) bool {
+	if skew < 0 {
+		skew = -skew
+	}
+
+	return skew > n.getClockSkewThreshold()
+}
+
+// Records the clock offset estimated from a pong of the peer, warning once
+// each time its estimate moves beyond the threshold.
+func (n *Node) recordClockSkew(p *discovery.Peer, sample time.Duration) {
+	prev, known := n.stats.peerClockSkew(p.Id)
+
+	skew := n.stats.recordClockSkew(p.Id, sample, n.exceedsClockSkew(sample))
+
+	if n.exceedsClockSkew(skew) && !(known && n.exceedsClockSkew(prev)) {
+		log.Warn("Clock skew to peer beyond threshold, check its time synchronisation",
+			"addr", p.Addr, "skew", skew, "threshold", n.getClockSkewThreshold())
+	}
+}
+
+// Peers with skewed clocks may see certificates as expired or not yet valid, failing
+// their pings and gossip, so accusations involving them are worth a closer look.
+func (n *Node) checkAccusationSkew(accused, accuser *discovery.Peer) {
+	for _, p := range []*discovery.Peer{accused, accuser} {
+		if skew, ok := n.stats.peerClockSkew(p.Id); ok && n.exceedsClockSkew(skew) {
+			log.Warn("Accusation involves peer with clock skew beyond threshold",
+				"accused", accused.Addr, "accuser", accuser.Addr, "peer", p.Addr, "skew", skew)
+			return
+		}
+	}
+}
+
+// Returns how far ahead of our clock the clock of the given peer is, negative if it is behind,
+// false if the peer has not answered a ping of ours with a timestamp since it joined the live view.
+// The estimate is off by at most half the round trip time of the pings.
+func (n *Node) EstimatedClockSkew(id []byte) (time.Duration, bool) {
+	return n.stats.peerClockSkew(string(id))
+}
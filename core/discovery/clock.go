@@ -0,0 +1,58 @@
+package discovery
+
+import (
+	"time"
+)
+
+// Source of time for the view and the node, lets tests control time instead of waiting for it.
+type Clock interface {
+	Now() time.Time
+
+	// Same as time.After.
+	After(d time.Duration) <-chan time.Time
+
+	// Same as time.NewTimer.
+	NewTimer(d time.Duration) Timer
+}
+
+// Timer created by a Clock, with the semantics of time.Timer.
+type Timer interface {
+	C() <-chan time.Time
+	Stop() bool
+	Reset(d time.Duration) bool
+}
+
+// Clock backed by the time package, used unless another clock is set.
+func RealClock() Clock {
+	return realClock{}
+}
+
+type realClock struct{}
+
+func (realClock) Now() time.Time {
+	return time.Now()
+}
+
+func (realClock) After(d time.Duration) <-chan time.Time {
+	return time.After(d)
+}
+
+func (realClock) NewTimer(d time.Duration) Timer {
+	return realTimer{t: time.NewTimer(d)}
+}
+
+type realTimer struct {
+	t *time.Timer
+}
+
+func (rt realTimer) C() <-chan time.Time {
+	return rt.t.C
+}
+
+func (rt realTimer) Stop() bool {
+	return rt.t.Stop()
+}
+
+func (rt realTimer) Reset(d time.Duration) bool {
+	return rt.t.Reset(d)
+}
@@ -0,0 +1,35 @@
+package discovery
+
+// Kind of change in the live view.
+type EventKind uint8
+
+const (
+	// Peer was added to the live view.
+	Joined EventKind = iota
+
+	// Peer was removed from the live view.
+	Left
+
+	// Peer got accused and has until the removal timeout to rebut.
+	Accused
+)
+
+func (k EventKind) String() string {
+	switch k {
+	case Joined:
+		return "Joined"
+	case Left:
+		return "Left"
+	case Accused:
+		return "Accused"
+	default:
+		return "Unknown"
+	}
+}
+
+// Event describes a change in the live view.
+type Event struct {
+	Id   string
+	Addr string
+	Kind EventKind
+}
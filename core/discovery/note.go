@@ -12,6 +12,14 @@ type Note struct {
 	epoch uint64
 	mask  uint32
 	id    string
+
+	// Announces that the peer is leaving the network.
+	leaving bool
+
+	// Announces that the peer only observes the network, it is kept
+	// out of the rings and never monitors or is monitored by others.
+	observer bool
+
 	*signature
 }
 
@@ -35,6 +43,18 @@ func (n *Note) IsMoreRecent(other uint64) bool {
 	return n.epoch < other
 }
 
+func (n *Note) Epoch() uint64 {
+	return n.epoch
+}
+
+func (n *Note) IsLeaving() bool {
+	return n.leaving
+}
+
+func (n *Note) IsObserver() bool {
+	return n.observer
+}
+
 func (n *Note) ToPbMsg() *pb.Note {
 	return &pb.Note{
 		Epoch: n.epoch,
@@ -44,6 +64,8 @@ func (n *Note) ToPbMsg() *pb.Note {
 			R: n.r,
 			S: n.s,
 		},
+		Leaving:  n.leaving,
+		Observer: n.observer,
 	}
 }
 
@@ -69,6 +91,40 @@ func NewNote(id string, epoch uint64, mask uint32, priv *ecdsa.PrivateKey) *pb.N
 	return n.ToPbMsg()
 }
 
+// ONLY FOR TESTING
+func NewLeavingNote(id string, epoch uint64, mask uint32, priv *ecdsa.PrivateKey) *pb.Note {
+	n := &Note{
+		id:      id,
+		epoch:   epoch,
+		mask:    mask,
+		leaving: true,
+	}
+
+	err := signNote(n, priv)
+	if err != nil {
+		panic(err)
+	}
+
+	return n.ToPbMsg()
+}
+
+// ONLY FOR TESTING
+func NewObserverNote(id string, epoch uint64, mask uint32, priv *ecdsa.PrivateKey) *pb.Note {
+	n := &Note{
+		id:       id,
+		epoch:    epoch,
+		mask:     mask,
+		observer: true,
+	}
+
+	err := signNote(n, priv)
+	if err != nil {
+		panic(err)
+	}
+
+	return n.ToPbMsg()
+}
+
 // ONLY FOR TESTING
 func NewUnsignedNote(id string, epoch uint64, mask uint32) *pb.Note {
 	n := &Note{
@@ -88,9 +144,11 @@ func signNote(n *Note, privKey *ecdsa.PrivateKey) error {
 	}
 
 	noteMsg := &pb.Note{
-		Epoch: n.epoch,
-		Id:    []byte(n.id),
-		Mask:  n.mask,
+		Epoch:    n.epoch,
+		Id:       []byte(n.id),
+		Mask:     n.mask,
+		Leaving:  n.leaving,
+		Observer: n.observer,
 	}
 
 	b, err := proto.Marshal(noteMsg)
@@ -29,9 +29,14 @@ var (
 	errTooManyDeactivatedRings = errors.New("Mask contains too many deactivated rings")
 	errNonExistingRing         = errors.New("Accusation specifies non exisiting ring")
 	errDeactivatedRing         = errors.New("Accusation on deactivated ring")
+	errCertId                  = errors.New("Certificate id does not match peer id")
+	errCertPubKey              = errors.New("Certificate public key does not match peer public key")
 	ErrAccAlreadyExists        = errors.New("Accusation already exists")
 )
 
+// Number of replaced certificates kept per peer.
+const maxCertHistory = 8
+
 type Peer struct {
 	Addr     string
 	PingAddr string
@@ -39,6 +44,9 @@ type Peer struct {
 	// Debuging and experiments only.
 	HttpAddr string
 
+	// Zone label of the peer, the first organizational unit of its certificate, empty if it has none.
+	Zone string
+
 	noteMutex sync.RWMutex
 	note      *Note
 
@@ -47,10 +55,18 @@ type Peer struct {
 
 	Id        string
 	cert      *x509.Certificate
+	certMutex sync.RWMutex
 	publicKey *ecdsa.PublicKey
 
+	// Certificates replaced by renewals, oldest first, see CertificateAt.
+	prevCerts []*x509.Certificate
+
 	nPing      uint32
 	nPingMutex sync.RWMutex
+
+	// Last time the peer was heard from, directly or through a new note, see View.MarkSeen.
+	lastSeen  time.Time
+	seenMutex sync.RWMutex
 }
 
 // Ecdsa signature values
@@ -69,7 +85,7 @@ type timeout struct {
 func newPeer(cert *x509.Certificate, numRings uint32) (*Peer, error) {
 	var ok bool
 	var i uint32
-	var http string
+	var http, zone string
 	var pb *ecdsa.PublicKey
 
 	if numRings == 0 {
@@ -90,6 +106,10 @@ func newPeer(cert *x509.Certificate, numRings uint32) (*Peer, error) {
 		return nil, errPeerId
 	}
 
+	if len(cert.Subject.OrganizationalUnit) > 0 {
+		zone = cert.Subject.OrganizationalUnit[0]
+	}
+
 	if pb, ok = cert.PublicKey.(*ecdsa.PublicKey); !ok {
 		return nil, errPubKey
 	}
@@ -104,6 +124,7 @@ func newPeer(cert *x509.Certificate, numRings uint32) (*Peer, error) {
 		Addr:        cert.Subject.Locality[0],
 		PingAddr:    cert.Subject.Locality[1],
 		HttpAddr:    http,
+		Zone:        zone,
 		cert:        cert,
 		Id:          string(cert.SubjectKeyId),
 		publicKey:   pb,
@@ -113,6 +134,9 @@ func newPeer(cert *x509.Certificate, numRings uint32) (*Peer, error) {
 }
 
 func (p *Peer) Certificate() []byte {
+	p.certMutex.RLock()
+	defer p.certMutex.RUnlock()
+
 	if p.cert == nil {
 		log.Error("Peer had no certificate")
 		return nil
@@ -121,6 +145,86 @@ func (p *Peer) Certificate() []byte {
 	return p.cert.Raw
 }
 
+// Replaces the certificate of the peer with a renewed one, the id and public key has to stay the same.
+// Certificates that do not expire after the current one are ignored.
+func (p *Peer) UpdateCertificate(cert *x509.Certificate) error {
+	if cert == nil {
+		return errNoCert
+	}
+
+	if string(cert.SubjectKeyId) != p.Id {
+		return errCertId
+	}
+
+	pub, ok := cert.PublicKey.(*ecdsa.PublicKey)
+	if !ok {
+		return errPubKey
+	}
+
+	if !p.sameKey(pub) {
+		return errCertPubKey
+	}
+
+	p.certMutex.Lock()
+	defer p.certMutex.Unlock()
+
+	if p.cert != nil && !cert.NotAfter.After(p.cert.NotAfter) {
+		return nil
+	}
+
+	if p.cert != nil {
+		p.prevCerts = append(p.prevCerts, p.cert)
+		if len(p.prevCerts) > maxCertHistory {
+			p.prevCerts = p.prevCerts[1:]
+		}
+	}
+
+	p.cert = cert
+
+	return nil
+}
+
+// Returns true if the certificate is for the key and addresses of the peer.
+// Renewed certificates keep both, a certificate with the id of the peer that does not is from another host.
+func (p *Peer) SameHost(cert *x509.Certificate) bool {
+	pub, ok := cert.PublicKey.(*ecdsa.PublicKey)
+
+	return ok && p.sameKey(pub) && p.sameAddrs(cert)
+}
+
+func (p *Peer) sameKey(pub *ecdsa.PublicKey) bool {
+	return pub.Curve == p.publicKey.Curve && pub.X.Cmp(p.publicKey.X) == 0 && pub.Y.Cmp(p.publicKey.Y) == 0
+}
+
+func (p *Peer) sameAddrs(cert *x509.Certificate) bool {
+	l := cert.Subject.Locality
+
+	return len(l) >= 2 && l[0] == p.Addr && l[1] == p.PingAddr
+}
+
+// Returns the most recent certificate of the peer that was valid at the given time,
+// nil if none of the current and the last replaced certificates were.
+func (p *Peer) CertificateAt(at time.Time) *x509.Certificate {
+	p.certMutex.RLock()
+	defer p.certMutex.RUnlock()
+
+	if validAt(p.cert, at) {
+		return p.cert
+	}
+
+	for i := len(p.prevCerts) - 1; i >= 0; i-- {
+		if c := p.prevCerts[i]; validAt(c, at) {
+			return c
+		}
+	}
+
+	return nil
+}
+
+func validAt(cert *x509.Certificate, at time.Time) bool {
+	return cert != nil && !at.Before(cert.NotBefore) && !at.After(cert.NotAfter)
+}
+
 func (p *Peer) PublicKey() *ecdsa.PublicKey {
 	return p.publicKey
 }
@@ -304,14 +408,38 @@ func (p *Peer) IsAccused() bool {
 }
 
 func (p *Peer) AddNote(mask uint32, epoch uint64, r, s []byte) {
+	p.addNote(mask, epoch, false, false, r, s)
+}
+
+// Same as AddNote, but the note announces that the peer is leaving the network.
+func (p *Peer) AddLeavingNote(mask uint32, epoch uint64, r, s []byte) {
+	p.addNote(mask, epoch, true, false, r, s)
+}
+
+// Same as AddNote, but the note announces that the peer only observes the network.
+func (p *Peer) AddObserverNote(mask uint32, epoch uint64, r, s []byte) {
+	p.addNote(mask, epoch, false, true, r, s)
+}
+
+// Returns true if the most recent note of the peer announces that it only observes the network.
+func (p *Peer) IsObserver() bool {
+	p.noteMutex.RLock()
+	defer p.noteMutex.RUnlock()
+
+	return p.note != nil && p.note.observer
+}
+
+func (p *Peer) addNote(mask uint32, epoch uint64, leaving, observer bool, r, s []byte) {
 	p.noteMutex.Lock()
 	defer p.noteMutex.Unlock()
 
 	if p.note == nil || p.note.IsMoreRecent(epoch) {
 		p.note = &Note{
-			id:    p.Id,
-			mask:  mask,
-			epoch: epoch,
+			id:       p.Id,
+			mask:     mask,
+			epoch:    epoch,
+			leaving:  leaving,
+			observer: observer,
 			signature: &signature{
 				r: r,
 				s: s,
@@ -331,6 +459,7 @@ func (p *Peer) Info() (*pb.Certificate, *pb.Note, []*pb.Accusation) {
 	var c *pb.Certificate
 	var n *pb.Note
 
+	p.certMutex.RLock()
 	if p.cert != nil {
 		c = &pb.Certificate{
 			Raw: p.cert.Raw,
@@ -338,6 +467,7 @@ func (p *Peer) Info() (*pb.Certificate, *pb.Note, []*pb.Accusation) {
 	} else {
 		log.Error("Had no certificate for peer", "addr", p.Addr)
 	}
+	p.certMutex.RUnlock()
 
 	if note := p.Note(); note != nil {
 		n = note.ToPbMsg()
@@ -362,6 +492,24 @@ func (p *Peer) IncrementPing() {
 	p.nPing++
 }
 
+// Records that the peer was heard from at the given time, earlier times than the current one are ignored.
+func (p *Peer) Seen(t time.Time) {
+	p.seenMutex.Lock()
+	defer p.seenMutex.Unlock()
+
+	if t.After(p.lastSeen) {
+		p.lastSeen = t
+	}
+}
+
+// Returns the last time the peer was heard from.
+func (p *Peer) LastSeen() time.Time {
+	p.seenMutex.RLock()
+	defer p.seenMutex.RUnlock()
+
+	return p.lastSeen
+}
+
 func (p *Peer) ResetPing() {
 	p.nPingMutex.Lock()
 	defer p.nPingMutex.Unlock()
@@ -1,15 +1,18 @@
 package discovery
 
 import (
+	"crypto"
 	"crypto/ecdsa"
 	"crypto/elliptic"
 	"crypto/rand"
 	"crypto/x509"
 	"crypto/x509/pkix"
+	"fmt"
 	"math"
 	"math/big"
 	"os"
 	"testing"
+	"time"
 
 	log "github.com/inconshreveable/log15"
 	"github.com/stretchr/testify/assert"
@@ -81,7 +84,16 @@ func (suite *PeerTestSuite) TestNewPeer() {
 	validCert := &x509.Certificate{
 		SubjectKeyId: []byte("testId"),
 		Subject: pkix.Name{
-			Locality: []string{"rpcAddr", "pingAddr", "httpAddr"},
+			Locality:           []string{"rpcAddr", "pingAddr", "httpAddr"},
+			OrganizationalUnit: []string{"eu-west"},
+		},
+		PublicKey: suite.priv.Public(),
+	}
+
+	noZoneCert := &x509.Certificate{
+		SubjectKeyId: []byte("testId"),
+		Subject: pkix.Name{
+			Locality: []string{"rpcAddr", "pingAddr"},
 		},
 		PublicKey: suite.priv.Public(),
 	}
@@ -113,6 +125,7 @@ func (suite *PeerTestSuite) TestNewPeer() {
 		addr      string
 		pingAddr  string
 		httpAddr  string
+		zone      string
 		accMapLen int
 		id        string
 		pub       ecdsa.PublicKey
@@ -124,10 +137,21 @@ func (suite *PeerTestSuite) TestNewPeer() {
 			addr:      validCert.Subject.Locality[0],
 			pingAddr:  validCert.Subject.Locality[1],
 			httpAddr:  validCert.Subject.Locality[2],
+			zone:      validCert.Subject.OrganizationalUnit[0],
 			accMapLen: int(suite.numRings),
 			id:        string(validCert.SubjectKeyId),
 		},
 
+		{
+			in:        noZoneCert,
+			numRings:  suite.numRings,
+			err:       nil,
+			addr:      noZoneCert.Subject.Locality[0],
+			pingAddr:  noZoneCert.Subject.Locality[1],
+			accMapLen: int(suite.numRings),
+			id:        string(noZoneCert.SubjectKeyId),
+		},
+
 		{
 			in:  validCert,
 			err: errNoRings,
@@ -171,6 +195,7 @@ func (suite *PeerTestSuite) TestNewPeer() {
 			require.Equalf(suite.T(), t.pingAddr, p.PingAddr,
 				"Invalid pingAddr for test %d", i)
 			require.Equalf(suite.T(), t.httpAddr, p.HttpAddr, "Invalid error for test %d", i)
+			require.Equalf(suite.T(), t.zone, p.Zone, "Invalid zone for test %d", i)
 			require.Equalf(suite.T(), t.accMapLen, len(p.accusations),
 				"Invalid len of accusations for test %d", i)
 			require.Equalf(suite.T(), t.id, p.Id, "Invalid id for test %d", i)
@@ -208,6 +233,61 @@ func (suite *PeerTestSuite) TestCertificate() {
 	}
 }
 
+func (suite *PeerTestSuite) TestCertificateAt() {
+	p := suite.p
+
+	start := time.Now().Add(-time.Hour * 24 * 10)
+	cert := func(days int) *x509.Certificate {
+		return &x509.Certificate{
+			Raw:          []byte(fmt.Sprintf("cert-%d", days)),
+			SubjectKeyId: []byte(p.Id),
+			PublicKey:    suite.priv.Public(),
+			NotBefore:    start.Add(time.Hour * 24 * time.Duration(days)),
+			NotAfter:     start.Add(time.Hour * 24 * time.Duration(days+1)),
+		}
+	}
+
+	p.cert = cert(0)
+
+	for days := 1; days <= maxCertHistory+1; days++ {
+		require.NoError(suite.T(), p.UpdateCertificate(cert(days)), "Failed to update certificate.")
+	}
+
+	at := func(days int, hours time.Duration) time.Time {
+		return start.Add(time.Hour*24*time.Duration(days) + hours)
+	}
+
+	assert.Equal(suite.T(), cert(maxCertHistory+1).Raw, p.CertificateAt(at(maxCertHistory+1, time.Hour)).Raw, "Current certificate not used.")
+	assert.Equal(suite.T(), cert(3).Raw, p.CertificateAt(at(3, time.Hour)).Raw, "Replaced certificate not kept.")
+	assert.Equal(suite.T(), cert(1).Raw, p.CertificateAt(at(1, time.Hour)).Raw, "Oldest kept certificate not used.")
+	assert.Nil(suite.T(), p.CertificateAt(at(0, time.Hour)), "History not bounded.")
+	assert.Nil(suite.T(), p.CertificateAt(at(maxCertHistory+3, 0)), "Returned certificate valid before the given time.")
+	assert.Len(suite.T(), p.prevCerts, maxCertHistory, "Invalid history length.")
+}
+
+func (suite *PeerTestSuite) TestSameHost() {
+	p := suite.p
+	p.Addr = "rpcAddr"
+	p.PingAddr = "pingAddr"
+
+	otherPriv, err := ecdsa.GenerateKey(elliptic.P224(), rand.Reader)
+	require.NoError(suite.T(), err, "Failed to generate private key.")
+
+	cert := func(pub crypto.PublicKey, addrs ...string) *x509.Certificate {
+		return &x509.Certificate{
+			SubjectKeyId: []byte(p.Id),
+			Subject:      pkix.Name{Locality: addrs},
+			PublicKey:    pub,
+		}
+	}
+
+	assert.True(suite.T(), p.SameHost(cert(suite.priv.Public(), "rpcAddr", "pingAddr", "otherHttpAddr")), "Renewed certificate from another host.")
+	assert.False(suite.T(), p.SameHost(cert(otherPriv.Public(), "rpcAddr", "pingAddr", "httpAddr")), "Certificate with another key from the same host.")
+	assert.False(suite.T(), p.SameHost(cert(suite.priv.Public(), "otherAddr", "pingAddr", "httpAddr")), "Certificate with another address from the same host.")
+	assert.False(suite.T(), p.SameHost(cert(suite.priv.Public(), "rpcAddr", "otherPingAddr")), "Certificate with another ping address from the same host.")
+	assert.False(suite.T(), p.SameHost(cert(suite.priv.Public(), "rpcAddr")), "Certificate without ping address from the same host.")
+}
+
 func (suite *PeerTestSuite) TestPublicKey() {
 	pb, ok := suite.priv.Public().(*ecdsa.PublicKey)
 	require.True(suite.T(), ok, "Failed to cast cert public key to ecdsa pub key")
@@ -40,6 +40,19 @@ type ring struct {
 	selfId  *ringId
 }
 
+// Snapshot of a single ring, see View.RingTopology.
+type RingView struct {
+	Num uint32
+
+	// Ids of all peers on the ring, including the local node, in ring order.
+	Members []string
+
+	// Ids of the local node's immediate neighbours on the ring,
+	// empty if the local node is alone on the ring.
+	Successor   string
+	Predecessor string
+}
+
 type ringId struct {
 	p    *Peer
 	hash []byte
@@ -204,6 +217,46 @@ func (rs *rings) myRingPredecessor(ringNum uint32) *Peer {
 
 }
 
+// Returns our predecessor on each ring, the peers monitoring us, in ring order without duplicates.
+func (rs *rings) myPredecessors() []*Peer {
+	return rs.uniqueNeighbours(rs.myRingPredecessor)
+}
+
+// Returns our successor on each ring, the peers we monitor, in ring order without duplicates.
+func (rs *rings) mySuccessors() []*Peer {
+	return rs.uniqueNeighbours(rs.myRingSuccessor)
+}
+
+func (rs *rings) uniqueNeighbours(neighbour func(uint32) *Peer) []*Peer {
+	var i uint32
+
+	ret := make([]*Peer, 0, rs.numRings)
+	exists := make(map[string]bool)
+
+	for i = 1; i <= rs.numRings; i++ {
+		if p := neighbour(i); p != nil && !exists[p.Id] {
+			exists[p.Id] = true
+			ret = append(ret, p)
+		}
+	}
+
+	return ret
+}
+
+func (rs *rings) topology() []RingView {
+	var i uint32
+
+	ret := make([]RingView, 0, rs.numRings)
+
+	for i = 1; i <= rs.numRings; i++ {
+		if r, ok := rs.ringMap[i]; ok {
+			ret = append(ret, r.view())
+		}
+	}
+
+	return ret
+}
+
 func (rs *rings) shouldBeMyNeighbour(id string) bool {
 	for _, r := range rs.ringMap {
 		if isNeighbour := r.betweenNeighbours(id); isNeighbour {
@@ -455,6 +508,29 @@ func isBetween(start, end, new *ringId) bool {
 	}
 }
 
+func (r *ring) view() RingView {
+	members := make([]string, 0, r.length)
+
+	for _, id := range r.succList[:r.length] {
+		members = append(members, id.p.Id)
+	}
+
+	rv := RingView{
+		Num:     r.ringNum,
+		Members: members,
+	}
+
+	if succ := r.successor().p; succ.Id != r.selfId.p.Id {
+		rv.Successor = succ.Id
+	}
+
+	if prev := r.predecessor().p; prev.Id != r.selfId.p.Id {
+		rv.Predecessor = prev.Id
+	}
+
+	return rv
+}
+
 func (r *ring) successor() *ringId {
 	return succ(r.succList, int(r.selfIdx), r.length)
 }
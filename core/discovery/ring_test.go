@@ -326,6 +326,41 @@ func (suite *RingsTestSuite) TestMyRingPredecessor() {
 	assert.Nil(suite.T(), suite.rings.myRingPredecessor(suite.rings.numRings+1), "Should return nil with invalid ring number.")
 }
 
+func (suite *RingsTestSuite) TestTopology() {
+	topology := suite.rings.topology()
+	require.Equal(suite.T(), int(suite.rings.numRings), len(topology), "Wrong number of rings.")
+
+	for _, rv := range topology {
+		assert.Equal(suite.T(), []string{suite.rings.self.Id}, rv.Members, "Empty ring should only contain self.")
+		assert.Empty(suite.T(), rv.Successor, "Alone on ring should have no successor.")
+		assert.Empty(suite.T(), rv.Predecessor, "Alone on ring should have no predecessor.")
+	}
+
+	for i := 0; i < 10; i++ {
+		peer := &Peer{
+			Id: fmt.Sprintf("testId%d", i),
+		}
+
+		suite.rings.add(peer)
+	}
+
+	topology = suite.rings.topology()
+
+	for i, rv := range topology {
+		r := suite.rings.ringMap[rv.Num]
+
+		assert.Equal(suite.T(), uint32(i+1), rv.Num, "Rings not ordered by ring number.")
+		require.Equal(suite.T(), r.length, len(rv.Members), "Wrong number of ring members.")
+
+		for idx, id := range r.succList {
+			assert.Equal(suite.T(), id.p.Id, rv.Members[idx], "Members not in ring order.")
+		}
+
+		assert.Equal(suite.T(), r.successor().p.Id, rv.Successor, "Wrong successor.")
+		assert.Equal(suite.T(), r.predecessor().p.Id, rv.Predecessor, "Wrong predecessor.")
+	}
+}
+
 func (suite *RingsTestSuite) TestShouldBeMyNeighbour() {
 	p := &Peer{
 		Id: "testId",
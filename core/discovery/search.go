@@ -86,6 +86,10 @@ func findSuccAndPrev(slice []*ringId, searchId *ringId, length int) (*ringId, *r
 	var currIdx int
 	var successor, predecessor *ringId
 
+	if length == 0 {
+		return nil, nil
+	}
+
 	maxIdx := length - 1
 
 	for {
@@ -130,6 +130,48 @@ func (suite *SearchTestSuite) TestSearch() {
 	}
 }
 
+func (suite *SearchTestSuite) TestSingleElement() {
+	id := &ringId{
+		hash: hashId(1, []byte("testId")),
+	}
+
+	other := &ringId{
+		hash: hashId(1, []byte("anotherTestId")),
+	}
+
+	idx, err := search([]*ringId{}, id, 0)
+	assert.EqualError(suite.T(), err, errZeroLength.Error(), "Should return error with an empty slice.")
+	assert.Zero(suite.T(), idx, "Should return zero index when returning an error.")
+
+	single := []*ringId{id}
+
+	idx, err = search(single, id, len(single))
+	require.NoError(suite.T(), err, "Returned error when searching for the only element.")
+	assert.Zero(suite.T(), idx, "Returned wrong index.")
+
+	idx, err = search(single, other, len(single))
+	assert.EqualError(suite.T(), err, errIdNotFound.Error(), "Should return error when searching for a non-existing id.")
+	assert.Zero(suite.T(), idx, "Should return zero index when returning an error.")
+
+	slice, idx := insert([]*ringId{}, id, 0)
+	require.Equal(suite.T(), 1, len(slice), "Returned slice should have 1 element.")
+	assert.Zero(suite.T(), idx, "New element should have index 0.")
+
+	slice, idx = insert(slice, other, len(slice))
+	require.Equal(suite.T(), 2, len(slice), "Returned slice should have 2 elements.")
+
+	if other.compare(id) == 1 {
+		assert.Equal(suite.T(), 1, idx, "Higher id should be inserted after the existing element.")
+	} else {
+		assert.Zero(suite.T(), idx, "Lower id should be inserted before the existing element.")
+	}
+	assert.Equal(suite.T(), other, slice[idx], "Did not add the supplied id at the returned index.")
+
+	succ, prev := findSuccAndPrev([]*ringId{}, id, 0)
+	assert.Nil(suite.T(), succ, "Should not find a successor in an empty slice.")
+	assert.Nil(suite.T(), prev, "Should not find a predecessor in an empty slice.")
+}
+
 func (suite *SearchTestSuite) TestFindSuccAndPrev() {
 	var succ, prev *ringId
 	var insertIdx int
@@ -0,0 +1,69 @@
+package discovery
+
+// Consistent copy of the view, see View.Snapshot.
+type Snapshot struct {
+	Peers []PeerState
+	Rings []RingView
+}
+
+// State of a single peer in the full view.
+type PeerState struct {
+	Id   string
+	Addr string
+
+	// If the peer is in the live view.
+	Live bool
+
+	// Epoch of the most recent note, zero if no note has been received.
+	Epoch uint64
+
+	Accusations []AccusationState
+}
+
+// Outstanding accusation against a peer.
+type AccusationState struct {
+	Accuser string
+	RingNum uint32
+	Epoch   uint64
+}
+
+// Returns the full view, liveness and ring placements as of a single point in time,
+// no peer joins or leaves the view while the snapshot is taken.
+func (v *View) Snapshot() Snapshot {
+	v.viewMutex.RLock()
+	defer v.viewMutex.RUnlock()
+
+	v.liveMutex.RLock()
+	defer v.liveMutex.RUnlock()
+
+	ret := Snapshot{
+		Peers: make([]PeerState, 0, len(v.viewMap)),
+		Rings: v.rings.topology(),
+	}
+
+	for id, p := range v.viewMap {
+		_, live := v.liveMap[id]
+
+		state := PeerState{
+			Id:   p.Id,
+			Addr: p.Addr,
+			Live: live,
+		}
+
+		if note := p.Note(); note != nil {
+			state.Epoch = note.epoch
+		}
+
+		for _, a := range p.AllAccusations() {
+			state.Accusations = append(state.Accusations, AccusationState{
+				Accuser: a.accuser,
+				RingNum: a.ringNum,
+				Epoch:   a.epoch,
+			})
+		}
+
+		ret.Peers = append(ret.Peers, state)
+	}
+
+	return ret
+}
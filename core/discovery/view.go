@@ -4,7 +4,9 @@ import (
 	"crypto/sha256"
 	"crypto/x509"
 	"errors"
+	"math"
 	"math/bits"
+	"sort"
 	"sync"
 	"time"
 	"strings"
@@ -13,30 +15,47 @@ import (
 	log "github.com/inconshreveable/log15"
 	"github.com/joonnna/ifrit/protobuf"
 	pb "github.com/joonnna/ifrit/protobuf"
-	"github.com/spf13/viper"
+)
+
+const (
+	defaultRemovalTimeout = time.Second * 60
+	defaultUpdateTimeout  = time.Second * 10
+
+	// Pending timeouts point at an accusation storm once there are at least minTimeoutStorm
+	// of them and they cover more than one in timeoutStormShare peers of the full view.
+	minTimeoutStorm   = 5
+	timeoutStormShare = 4
 )
 
 var (
 	errInvalidNeighbours  = errors.New("Neighbours are nil ?!")
 	errPeerAlreadyExists  = errors.New("Peer id already exists in the full view")
 	errAlreadyDeactivated = errors.New("Ring was already deactivated")
+	errConfiguredRings    = errors.New("All deactivated rings are left out by the ring mask")
 	errZeroDeactivate     = errors.New("No ring can be deactivated, maxbyz is 0")
 	errNoNote             = errors.New("Note was nil.")
 	errAccusedIsNil       = errors.New("Accused was nil.")
 	errObsIsNil           = errors.New("Observer was nil")
 	errWrongNote          = errors.New("Note does not belong to accused.")
+	errEpochOverflow      = errors.New("Local note is at the highest possible epoch")
 )
 
 type View struct {
 	viewMap   map[string]*Peer
 	viewMutex sync.RWMutex
 
+	// Maximum number of peers in the full view, zero means no limit.
+	maxFull int
+
 	liveMap   map[string]*Peer
 	liveMutex sync.RWMutex
 
 	timeoutMap   map[string]*timeout
 	timeoutMutex sync.RWMutex
 
+	// Set while the pending timeouts point at an accusation storm, only touched by the update loop.
+	timeoutStorm bool
+
 	rings *rings
 
 	currGossipRing  uint32
@@ -45,14 +64,26 @@ type View struct {
 	maxByz           uint32
 	deactivatedRings uint32
 
+	// Rings we participate on, see SetRingMask.
+	ringMask uint32
+
 	removalTimeout float64
-	updateTimeout  time.Duration
+
+	updateTimeout      time.Duration
+	updateTimeoutMutex sync.RWMutex
+
+	clock Clock
 
 	self *Peer
 
 	cm connectionManager
 	s  signer
 
+	eventHandler func(Event)
+
+	paused     bool
+	pauseMutex sync.RWMutex
+
 	exitChan chan bool
 }
 
@@ -95,15 +126,18 @@ func NewView(numRings uint32, cert *x509.Certificate, cm connectionManager, s si
 		exitChan:        make(chan bool, 1),
 		s:               s,
 
-		removalTimeout: viper.GetFloat64("dead_timeout"),
-		updateTimeout: time.Second * time.Duration(viper.
-			GetInt32("view_update_interval")),
+		removalTimeout: defaultRemovalTimeout.Seconds(),
+		updateTimeout:  defaultUpdateTimeout,
+
+		clock: RealClock(),
 	}
 
 	for i = 0; i < numRings; i++ {
 		mask = setBit(mask, i)
 	}
 
+	v.ringMask = mask
+
 	localNote := &Note{
 		epoch: 1,
 		mask:  mask,
@@ -124,8 +158,10 @@ func (v *View) Start() {
 		case <-v.exitChan:
 			log.Info("Stopping view update")
 			return
-		case <-time.After(v.updateTimeout):
-			v.checkTimeouts()
+		case <-v.clock.After(v.getUpdateTimeout()):
+			if !v.isPaused() {
+				v.checkTimeouts()
+			}
 		}
 	}
 }
@@ -134,6 +170,70 @@ func (v *View) Stop() {
 	close(v.exitChan)
 }
 
+// Stops removing peers with expired accusation timeouts until Resume is called.
+func (v *View) Pause() {
+	v.pauseMutex.Lock()
+	defer v.pauseMutex.Unlock()
+
+	v.paused = true
+}
+
+func (v *View) Resume() {
+	v.pauseMutex.Lock()
+	defer v.pauseMutex.Unlock()
+
+	v.paused = false
+}
+
+func (v *View) isPaused() bool {
+	v.pauseMutex.RLock()
+	defer v.pauseMutex.RUnlock()
+
+	return v.paused
+}
+
+// Sets how long accused peers have to rebut before being removed from the live view.
+// Must be called before Start.
+func (v *View) SetRemovalTimeout(d time.Duration) {
+	v.removalTimeout = d.Seconds()
+}
+
+// Sets how often the view checks for expired accusation timeouts.
+// Safe to call while running, the new interval is used after the current wait.
+func (v *View) SetUpdateTimeout(d time.Duration) {
+	v.updateTimeoutMutex.Lock()
+	defer v.updateTimeoutMutex.Unlock()
+
+	v.updateTimeout = d
+}
+
+func (v *View) getUpdateTimeout() time.Duration {
+	v.updateTimeoutMutex.RLock()
+	defer v.updateTimeoutMutex.RUnlock()
+
+	return v.updateTimeout
+}
+
+// Sets the maximum number of peers in the full view, zero means no limit.
+// Once exceeded, the peers outside the live view that were heard from least recently are evicted.
+// Must be called before Start.
+func (v *View) SetMaxFull(max int) {
+	v.maxFull = max
+}
+
+// Sets the clock used for accusation timeouts and the update interval.
+// Must be called before Start.
+func (v *View) SetClock(c Clock) {
+	v.clock = c
+}
+
+// Sets the function invoked on each change in the live view.
+// The handler is invoked while the view is locked, and must not block or call into the view.
+// Must be called before Start.
+func (v *View) SetEventHandler(handler func(Event)) {
+	v.eventHandler = handler
+}
+
 func (v *View) NumRings() uint32 {
 	return v.rings.numRings
 }
@@ -191,24 +291,77 @@ func (v *View) Live() []*Peer {
 
 func (v *View) AddFull(id string, cert *x509.Certificate) error {
 	v.viewMutex.Lock()
-	defer v.viewMutex.Unlock()
 
 	if _, ok := v.viewMap[id]; ok {
+		v.viewMutex.Unlock()
 		log.Error("Tried to add peer twice to viewMap")
 		return errPeerAlreadyExists
 	}
 
 	p, err := newPeer(cert, v.rings.numRings)
 	if err != nil {
+		v.viewMutex.Unlock()
 		log.Error(err.Error())
 		return err
 	}
 
+	p.Seen(v.clock.Now())
+
 	v.viewMap[p.Id] = p
 
+	v.viewMutex.Unlock()
+
+	v.evictStale(p.Id)
+
 	return nil
 }
 
+// Records that the peer with the given id was heard from, the stalest peers are evicted first
+// when the full view is full. Unknown ids are ignored.
+func (v *View) MarkSeen(id string) {
+	if p := v.Peer(id); p != nil {
+		p.Seen(v.clock.Now())
+	}
+}
+
+// Evicts the peers outside the live view that were heard from least recently until the full view
+// is within its maximum size. Live peers, and with them all ring neighbours, are kept even if
+// that leaves the view above its maximum, as is the peer with the given id, which was just added.
+func (v *View) evictStale(keep string) {
+	// Same lock order as the live view event handler, which may look up peers.
+	v.liveMutex.RLock()
+	defer v.liveMutex.RUnlock()
+
+	v.viewMutex.Lock()
+	defer v.viewMutex.Unlock()
+
+	if v.maxFull <= 0 || len(v.viewMap) <= v.maxFull {
+		return
+	}
+
+	var candidates []*Peer
+
+	for id, p := range v.viewMap {
+		if _, live := v.liveMap[id]; !live && id != keep {
+			candidates = append(candidates, p)
+		}
+	}
+
+	sort.Slice(candidates, func(i, j int) bool {
+		return candidates[i].LastSeen().Before(candidates[j].LastSeen())
+	})
+
+	excess := len(v.viewMap) - v.maxFull
+	if excess > len(candidates) {
+		excess = len(candidates)
+	}
+
+	for _, p := range candidates[:excess] {
+		delete(v.viewMap, p.Id)
+		log.Debug("Evicted stale peer from full view", "addr", p.Addr, "lastSeen", p.LastSeen())
+	}
+}
+
 func (v *View) MyNeighbours() []*Peer {
 	v.liveMutex.RLock()
 	defer v.liveMutex.RUnlock()
@@ -237,7 +390,20 @@ func (v *View) MonitorTarget() (*Peer, uint32) {
 	// only take read lock for live view.
 	defer v.incrementMonitorRing()
 
-	return v.rings.myRingSuccessor(v.currMonitorRing), ringNum
+	// We do not monitor on rings left out by our ring mask, and accusations
+	// on rings the successor left out would be void.
+	if !hasBit(v.ringMask, ringNum-1) {
+		return nil, ringNum
+	}
+
+	succ := v.rings.myRingSuccessor(ringNum)
+	if succ != nil {
+		if note := succ.Note(); note != nil && note.IsRingDisabled(ringNum, v.rings.numRings) {
+			return nil, ringNum
+		}
+	}
+
+	return succ, ringNum
 }
 
 func (v *View) AddLive(p *Peer) {
@@ -255,6 +421,8 @@ func (v *View) AddLive(p *Peer) {
 	for _, addr := range old {
 		v.cm.CloseConn(addr)
 	}
+
+	v.notify(p, Joined)
 }
 
 func (v *View) MyRingNeighbours(ringNum uint32) (*Peer, *Peer) {
@@ -264,6 +432,23 @@ func (v *View) MyRingNeighbours(ringNum uint32) (*Peer, *Peer) {
 	return v.rings.myRingSuccessor(ringNum), v.rings.myRingPredecessor(ringNum)
 }
 
+// Returns a snapshot of every ring and the local node's neighbours on it, ordered by ring number.
+func (v *View) RingTopology() []RingView {
+	v.liveMutex.RLock()
+	defer v.liveMutex.RUnlock()
+
+	return v.rings.topology()
+}
+
+// Returns the peers monitoring the local node, its predecessors, and the peers
+// it monitors, its successors, taken from the same state of the rings.
+func (v *View) MonitorAssignment() ([]*Peer, []*Peer) {
+	v.liveMutex.RLock()
+	defer v.liveMutex.RUnlock()
+
+	return v.rings.myPredecessors(), v.rings.mySuccessors()
+}
+
 func (v *View) LivePeer(id string) *Peer {
 	v.liveMutex.RLock()
 	defer v.liveMutex.RUnlock()
@@ -282,6 +467,8 @@ func (v *View) RemoveLive(id string) {
 
 		v.cm.CloseConn(peer.Addr)
 
+		v.notify(peer, Left)
+
 		log.Debug("Removed livePeer", "addr", peer.Addr)
 	} else {
 		log.Debug("Tried to remove non-existing peer from live view.")
@@ -324,10 +511,12 @@ func (v *View) StartTimer(accused *Peer, n *Note, observer *Peer) error {
 	} else {
 		newTimeout = &timeout{
 			observer:  observer,
-			timeStamp: time.Now(),
+			timeStamp: v.clock.Now(),
 			lastNote:  n,
 			accused:   accused,
 		}
+
+		v.notify(accused, Accused)
 	}
 
 	v.timeoutMap[accused.Id] = newTimeout
@@ -353,6 +542,26 @@ func (v *View) DeleteTimeout(id string) {
 	delete(v.timeoutMap, id)
 }
 
+// Returns the number of accused peers waiting for their removal timeout to expire.
+func (v *View) NumTimeouts() int {
+	v.timeoutMutex.RLock()
+	defer v.timeoutMutex.RUnlock()
+
+	return len(v.timeoutMap)
+}
+
+func (v *View) notify(p *Peer, kind EventKind) {
+	if v.eventHandler == nil {
+		return
+	}
+
+	v.eventHandler(Event{
+		Id:   p.Id,
+		Addr: p.Addr,
+		Kind: kind,
+	})
+}
+
 func (v *View) allTimeouts() []*timeout {
 	v.timeoutMutex.RLock()
 	defer v.timeoutMutex.RUnlock()
@@ -367,13 +576,20 @@ func (v *View) allTimeouts() []*timeout {
 }
 
 func (v *View) checkTimeouts() {
+	v.expireTimeouts(v.clock.Now())
+}
+
+// Removes peers whose accusation timeout started more than the removal timeout before now.
+func (v *View) expireTimeouts(now time.Time) {
 	timeouts := v.allTimeouts()
 	if numTimeouts := len(timeouts); numTimeouts > 0 {
 		log.Debug("Have timeouts", "amount", numTimeouts)
 	}
 
+	v.checkTimeoutStorm(timeouts)
+
 	for _, t := range timeouts {
-		if time.Since(t.timeStamp).Seconds() > v.removalTimeout {
+		if now.Sub(t.timeStamp).Seconds() > v.removalTimeout {
 			log.Debug("Timeout expired, removing from live", "addr", t.accused.Addr)
 			v.RemoveLive(t.accused.Id)
 			v.DeleteTimeout(t.accused.Id)
@@ -381,11 +597,39 @@ func (v *View) checkTimeouts() {
 	}
 }
 
+// Logs the accused peers once the pending timeouts point at an accusation storm,
+// and again for the next storm once the number has gone back to normal.
+func (v *View) checkTimeoutStorm(timeouts []*timeout) {
+	v.viewMutex.RLock()
+	numFull := len(v.viewMap)
+	v.viewMutex.RUnlock()
+
+	storm := len(timeouts) >= minTimeoutStorm && len(timeouts)*timeoutStormShare > numFull
+
+	if storm && !v.timeoutStorm {
+		accused := make([]string, 0, len(timeouts))
+		for _, t := range timeouts {
+			accused = append(accused, t.accused.Addr)
+		}
+
+		log.Error("Abnormal number of accused peers, possible accusation storm", "amount", len(timeouts), "full", numFull, "accused", accused)
+	}
+
+	v.timeoutStorm = storm
+}
+
 func (v *View) ShouldRebuttal(epoch uint64, ringNum uint32) bool {
 	v.self.noteMutex.Lock()
 	defer v.self.noteMutex.Unlock()
 
 	if eq := v.self.note.Equal(epoch); eq {
+		// A wrapped epoch would be rejected by every peer, the accusation can not be rebutted.
+		nextEpoch, err := v.nextEpoch()
+		if err != nil {
+			log.Error(err.Error())
+			return false
+		}
+
 		newMask := v.self.note.mask
 
 		mask, err := v.deactivateRing(ringNum)
@@ -396,9 +640,11 @@ func (v *View) ShouldRebuttal(epoch uint64, ringNum uint32) bool {
 		}
 
 		newNote := &Note{
-			id:    v.self.Id,
-			epoch: v.self.note.epoch + 1,
-			mask:  newMask,
+			id:       v.self.Id,
+			epoch:    nextEpoch,
+			mask:     newMask,
+			leaving:  v.self.note.leaving,
+			observer: v.self.note.observer,
 		}
 
 		err = v.signLocalNote(newNote)
@@ -414,6 +660,144 @@ func (v *View) ShouldRebuttal(epoch uint64, ringNum uint32) bool {
 	}
 }
 
+// Installs a renewed certificate for ourself and signs a note with a new epoch,
+// peers receiving the more recent note are given the new certificate along with it.
+func (v *View) RotateCertificate(cert *x509.Certificate) error {
+	if err := v.self.UpdateCertificate(cert); err != nil {
+		return err
+	}
+
+	v.self.noteMutex.Lock()
+	defer v.self.noteMutex.Unlock()
+
+	epoch, err := v.nextEpoch()
+	if err != nil {
+		return err
+	}
+
+	newNote := &Note{
+		id:       v.self.Id,
+		epoch:    epoch,
+		mask:     v.self.note.mask,
+		observer: v.self.note.observer,
+	}
+
+	return v.signLocalNote(newNote)
+}
+
+// Replaces the local note with one announcing that we are leaving the network.
+func (v *View) Leave() error {
+	v.self.noteMutex.Lock()
+	defer v.self.noteMutex.Unlock()
+
+	epoch, err := v.nextEpoch()
+	if err != nil {
+		return err
+	}
+
+	newNote := &Note{
+		id:       v.self.Id,
+		epoch:    epoch,
+		mask:     v.self.note.mask,
+		leaving:  true,
+		observer: v.self.note.observer,
+	}
+
+	return v.signLocalNote(newNote)
+}
+
+// Replaces the local note with an otherwise identical one at the next epoch,
+// peers holding the old note accept the new one as soon as it reaches them.
+func (v *View) RefreshNote() error {
+	v.self.noteMutex.Lock()
+	defer v.self.noteMutex.Unlock()
+
+	epoch, err := v.nextEpoch()
+	if err != nil {
+		return err
+	}
+
+	newNote := &Note{
+		id:       v.self.Id,
+		epoch:    epoch,
+		mask:     v.self.note.mask,
+		leaving:  v.self.note.leaving,
+		observer: v.self.note.observer,
+	}
+
+	return v.signLocalNote(newNote)
+}
+
+// Returns the epoch of the local note.
+func (v *View) LocalEpoch() uint64 {
+	v.self.noteMutex.RLock()
+	defer v.self.noteMutex.RUnlock()
+
+	return v.self.note.epoch
+}
+
+// Epoch following the one of the local note, peers would not accept
+// a note that wrapped around to a lower epoch. Note mutex must be held.
+func (v *View) nextEpoch() (uint64, error) {
+	if v.self.note.epoch == math.MaxUint64 {
+		return 0, errEpochOverflow
+	}
+
+	return v.self.note.epoch + 1, nil
+}
+
+// Restricts the rings we participate on to those with their bit set in the mask, the lowest bit
+// being the first ring. Peers neither monitor us nor accept accusations against us on the other rings,
+// and we do not monitor anyone on them. Zero participates on all rings. At most maxByz rings can be
+// left out, peers reject notes deactivating more. Must be called before the note is gossiped.
+func (v *View) SetRingMask(mask uint32) error {
+	var all uint32
+
+	for i := uint32(0); i < v.rings.numRings; i++ {
+		all = setBit(all, i)
+	}
+
+	if mask == 0 {
+		mask = all
+	}
+	mask &= all
+
+	if err := validMask(mask, v.rings.numRings, v.maxByz); err != nil {
+		return err
+	}
+
+	v.self.noteMutex.Lock()
+	defer v.self.noteMutex.Unlock()
+
+	v.ringMask = mask
+	v.deactivatedRings = v.rings.numRings - uint32(bits.OnesCount32(mask))
+
+	newNote := &Note{
+		id:       v.self.Id,
+		epoch:    v.self.note.epoch,
+		mask:     mask,
+		observer: v.self.note.observer,
+	}
+
+	return v.signLocalNote(newNote)
+}
+
+// Replaces the local note with one announcing that we only observe the network,
+// peers then keep us out of their rings. Must be called before the note is gossiped.
+func (v *View) SetObserver() error {
+	v.self.noteMutex.Lock()
+	defer v.self.noteMutex.Unlock()
+
+	newNote := &Note{
+		id:       v.self.Id,
+		epoch:    v.self.note.epoch,
+		mask:     v.self.note.mask,
+		observer: true,
+	}
+
+	return v.signLocalNote(newNote)
+}
+
 func (v *View) ShouldBeNeighbour(id string) bool {
 	v.liveMutex.RLock()
 	defer v.liveMutex.RUnlock()
@@ -444,6 +828,20 @@ func (v *View) IsAlive(id string) bool {
 	return ok
 }
 
+// Returns true if a peer with the given rpc address is in the live view.
+func (v *View) IsAliveAddr(addr string) bool {
+	v.liveMutex.RLock()
+	defer v.liveMutex.RUnlock()
+
+	for _, p := range v.liveMap {
+		if p.Addr == addr {
+			return true
+		}
+	}
+
+	return false
+}
+
 func (v *View) incrementGossipRing() {
 	v.currGossipRing = ((v.currGossipRing + 1) % (v.rings.numRings + 1))
 	if v.currGossipRing == 0 {
@@ -519,11 +917,17 @@ func (v *View) deactivateRing(ringNumber uint32) (uint32, error) {
 	}
 
 	if v.deactivatedRings == v.maxByz {
+		// Rings left out by the ring mask stay deactivated.
 		for idx = 0; idx <= maxIdx; idx++ {
-			if idx != ringIdx && !hasBit(currMask, idx) {
+			if idx != ringIdx && !hasBit(currMask, idx) && hasBit(v.ringMask, idx) {
 				break
 			}
 		}
+
+		if idx > maxIdx {
+			return 0, errConfiguredRings
+		}
+
 		currMask = setBit(currMask, idx)
 	} else {
 		v.deactivatedRings++
@@ -534,9 +938,11 @@ func (v *View) deactivateRing(ringNumber uint32) (uint32, error) {
 
 func (v *View) signLocalNote(n *Note) error {
 	pbNote := &pb.Note{
-		Epoch: n.epoch,
-		Mask:  n.mask,
-		Id:    []byte(n.id),
+		Epoch:    n.epoch,
+		Mask:     n.mask,
+		Id:       []byte(n.id),
+		Leaving:  n.leaving,
+		Observer: n.observer,
 	}
 
 	bytes, err := gpb.Marshal(pbNote)
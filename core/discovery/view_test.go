@@ -8,6 +8,7 @@ import (
 	"crypto/x509"
 	"crypto/x509/pkix"
 	"fmt"
+	"math"
 	"os"
 	"testing"
 	"time"
@@ -171,6 +172,65 @@ func (suite *ViewTestSuite) TestAddFull() {
 	assert.True(suite.T(), suite.v.Exists(certId), "Peer not added to map.")
 }
 
+func (suite *ViewTestSuite) TestMaxFull() {
+	view := suite.v
+
+	clock := &stepClock{Clock: RealClock(), now: time.Now()}
+	view.SetClock(clock)
+	view.SetMaxFull(6)
+
+	add := func(id string) *Peer {
+		privKey, err := ecdsa.GenerateKey(elliptic.P224(), rand.Reader)
+		require.NoError(suite.T(), err, "Failed to generate private key.")
+
+		clock.step()
+		require.NoError(suite.T(), view.AddFull(id, validCert(id, privKey.Public())), "Failed to add peer.")
+
+		return view.Peer(id)
+	}
+
+	// Oldest entries, kept since they are ring members.
+	for i := 0; i < 3; i++ {
+		view.AddLive(add(fmt.Sprintf("live%d", i)))
+	}
+
+	for i := 0; i < 3; i++ {
+		add(fmt.Sprintf("dead%d", i))
+	}
+
+	require.Len(suite.T(), view.Full(), 6, "Peers evicted below the maximum.")
+
+	clock.step()
+	view.MarkSeen("dead0")
+
+	add("new0")
+	add("new1")
+
+	require.Len(suite.T(), view.Full(), 6, "Full view exceeds its maximum.")
+	assert.False(suite.T(), view.Exists("dead1"), "Stalest peer not evicted.")
+	assert.False(suite.T(), view.Exists("dead2"), "Stalest peer not evicted.")
+	assert.True(suite.T(), view.Exists("dead0"), "Recently seen peer evicted.")
+	assert.True(suite.T(), view.Exists("new0"), "Newest peer evicted.")
+	assert.True(suite.T(), view.Exists("new1"), "Newest peer evicted.")
+
+	for i := 0; i < 3; i++ {
+		assert.True(suite.T(), view.Exists(fmt.Sprintf("live%d", i)), "Live peer evicted.")
+	}
+
+	for _, p := range view.Live() {
+		view.RemoveLive(p.Id)
+	}
+	view.SetMaxFull(2)
+
+	view.AddLive(view.Peer("new0"))
+	view.AddLive(view.Peer("new1"))
+
+	add("new2")
+
+	assert.Len(suite.T(), view.Full(), 3, "Live peers or the added peer evicted.")
+	assert.True(suite.T(), view.Exists("new2"), "Added peer evicted.")
+}
+
 func (suite *ViewTestSuite) TestMyNeighbours() {
 	neighbours := suite.v.MyNeighbours()
 	require.NotNil(suite.T(), neighbours, "Returned nil slice.")
@@ -289,6 +349,56 @@ func (suite *ViewTestSuite) TestMonitorTarget() {
 	}
 }
 
+func (suite *ViewTestSuite) TestRingMask() {
+	var all uint32
+
+	view := suite.v
+
+	for i := uint32(0); i < view.rings.numRings; i++ {
+		all = setBit(all, i)
+	}
+
+	require.EqualError(suite.T(), view.SetRingMask(1), errTooManyDeactivatedRings.Error(), "Accepted mask leaving out more than maxByz rings.")
+	require.Equal(suite.T(), all, view.self.note.mask, "Invalid mask changed the note.")
+
+	require.NoError(suite.T(), view.SetRingMask(0), "Rejected mask participating on all rings.")
+	require.Equal(suite.T(), all, view.self.note.mask, "Zero mask should participate on all rings.")
+
+	// Leave out the first two rings.
+	mask := clearBit(clearBit(all, 0), 1)
+
+	require.NoError(suite.T(), view.SetRingMask(mask), "Rejected valid mask.")
+	require.Equal(suite.T(), mask, view.self.note.mask, "Mask not set in the note.")
+	require.Equal(suite.T(), uint32(2), view.deactivatedRings, "Left out rings not counted as deactivated.")
+	require.True(suite.T(), view.ValidMask(view.self.note.mask), "Peers would reject the mask.")
+
+	for i := 0; i < 10; i++ {
+		view.AddLive(&Peer{Id: fmt.Sprintf("testId%d", i)})
+	}
+
+	// The successor on ring 5 leaves it out.
+	succ := view.rings.myRingSuccessor(5)
+	succ.note = &Note{id: succ.Id, epoch: 1, mask: clearBit(all, 4)}
+
+	for j := uint32(1); j <= view.rings.numRings; j++ {
+		target, ringNum := view.MonitorTarget()
+		require.Equal(suite.T(), j, ringNum, "Returned ringNumber does not match current ring.")
+
+		if j <= 2 || j == 5 {
+			require.Nil(suite.T(), target, "Monitoring on a ring left out by the mask.")
+		} else {
+			require.NotNil(suite.T(), target, "Not monitoring on a ring in the mask.")
+		}
+	}
+
+	// Rebuttals never bring back rings left out by the mask.
+	require.NoError(suite.T(), view.SetRingMask(clearBit(clearBit(mask, 2), 3)), "Rejected valid mask.")
+	require.Equal(suite.T(), view.maxByz, view.deactivatedRings, "Left out rings not counted as deactivated.")
+
+	_, err := view.deactivateRing(5)
+	require.EqualError(suite.T(), err, errConfiguredRings.Error(), "Reactivated a ring left out by the mask.")
+}
+
 func (suite *ViewTestSuite) TestAddLive() {
 	view := suite.v
 
@@ -310,6 +420,35 @@ func (suite *ViewTestSuite) TestAddLive() {
 	assert.True(suite.T(), ok, "Adding peer twice does not alter state.")
 }
 
+func (suite *ViewTestSuite) TestEvents() {
+	view := suite.v
+
+	var events []Event
+	view.SetEventHandler(func(e Event) {
+		events = append(events, e)
+	})
+
+	p := &Peer{
+		Id:   "testId",
+		Addr: "testAddr",
+	}
+
+	view.AddLive(p)
+	view.AddLive(p)
+	require.NoError(suite.T(), view.StartTimer(p, &Note{id: p.Id}, view.self), "Failed to start timer.")
+	require.NoError(suite.T(), view.StartTimer(p, &Note{id: p.Id}, view.self), "Failed to start timer.")
+	view.RemoveLive(p.Id)
+	view.RemoveLive(p.Id)
+
+	expected := []Event{
+		Event{Id: p.Id, Addr: p.Addr, Kind: Joined},
+		Event{Id: p.Id, Addr: p.Addr, Kind: Accused},
+		Event{Id: p.Id, Addr: p.Addr, Kind: Left},
+	}
+
+	assert.Equal(suite.T(), expected, events, "Events not emitted once per change in order.")
+}
+
 func (suite *ViewTestSuite) TestRingNeighbours() {
 	var i uint32
 
@@ -549,6 +688,145 @@ func (suite *ViewTestSuite) TestCheckTimeouts() {
 	require.False(suite.T(), ok, "Timeout not removed from map after expiration.")
 }
 
+func (suite *ViewTestSuite) TestRemovalTimeout() {
+	view := suite.v
+
+	removalTimeout := time.Minute * 5
+	view.SetRemovalTimeout(removalTimeout)
+
+	accused := &Peer{
+		Id: "testAccused",
+	}
+
+	view.AddLive(accused)
+
+	t := &timeout{
+		accused:   accused,
+		observer:  &Peer{Id: "testAccuser"},
+		lastNote:  &Note{id: accused.Id},
+		timeStamp: time.Now(),
+	}
+
+	view.timeoutMap[accused.Id] = t
+
+	view.expireTimeouts(t.timeStamp.Add(removalTimeout))
+	require.True(suite.T(), view.HasTimer(accused.Id), "Timeout removed before the removal timeout elapsed.")
+	require.True(suite.T(), view.IsAlive(accused.Id), "Peer evicted before the removal timeout elapsed.")
+
+	view.expireTimeouts(t.timeStamp.Add(removalTimeout + time.Millisecond))
+	require.False(suite.T(), view.HasTimer(accused.Id), "Timeout not removed after the removal timeout elapsed.")
+	require.False(suite.T(), view.IsAlive(accused.Id), "Peer not evicted after the removal timeout elapsed.")
+}
+
+func (suite *ViewTestSuite) TestPendingTimeouts() {
+	view := suite.v
+	view.SetRemovalTimeout(time.Minute)
+
+	accuser := &Peer{Id: "testAccuser"}
+
+	var accused []*Peer
+
+	for i := 0; i < 10; i++ {
+		p := &Peer{Id: fmt.Sprintf("testAccused%d", i)}
+		accused = append(accused, p)
+
+		require.NoError(suite.T(), view.StartTimer(p, &Note{id: p.Id}, accuser), "Failed to start timer.")
+	}
+
+	require.Equal(suite.T(), len(accused), view.NumTimeouts(), "Invalid number of pending timeouts.")
+
+	view.expireTimeouts(view.clock.Now())
+	assert.True(suite.T(), view.timeoutStorm, "Accusation storm not detected.")
+
+	// Rebuttals
+	for _, p := range accused[:5] {
+		view.DeleteTimeout(p.Id)
+	}
+
+	require.Equal(suite.T(), 5, view.NumTimeouts(), "Rebutted timeouts not removed.")
+
+	view.expireTimeouts(view.clock.Now().Add(time.Minute * 2))
+	require.Zero(suite.T(), view.NumTimeouts(), "Timeouts left after all accusations were resolved.")
+	require.Empty(suite.T(), view.timeoutMap, "Timeouts left after all accusations were resolved.")
+
+	view.expireTimeouts(view.clock.Now())
+	assert.False(suite.T(), view.timeoutStorm, "Accusation storm not over.")
+}
+
+func (suite *ViewTestSuite) TestSnapshot() {
+	view := suite.v
+
+	for _, id := range []string{"live", "dead"} {
+		privKey, err := ecdsa.GenerateKey(elliptic.P224(), rand.Reader)
+		require.NoError(suite.T(), err, "Failed to generate private key.")
+
+		require.NoError(suite.T(), view.AddFull(id, validCert(id, privKey.Public())), "Failed to add peer.")
+	}
+
+	live := view.Peer("live")
+	view.AddLive(live)
+
+	err := live.AddAccusation(live.Id, "accuser", 1, 2, []byte("r"), []byte("s"))
+	require.NoError(suite.T(), err, "Failed to add accusation.")
+
+	snapshot := view.Snapshot()
+	require.Len(suite.T(), snapshot.Peers, 2, "Snapshot should contain the full view.")
+
+	for _, p := range snapshot.Peers {
+		switch p.Id {
+		case "live":
+			assert.True(suite.T(), p.Live, "Live peer not reported as live.")
+			assert.Equal(suite.T(), []AccusationState{{Accuser: "accuser", RingNum: 2, Epoch: 1}}, p.Accusations, "Invalid accusations.")
+		case "dead":
+			assert.False(suite.T(), p.Live, "Dead peer reported as live.")
+			assert.Empty(suite.T(), p.Accusations, "Accusations reported for unaccused peer.")
+		default:
+			suite.T().Fatalf("Unknown peer %s in snapshot.", p.Id)
+		}
+	}
+
+	require.Len(suite.T(), snapshot.Rings, int(view.NumRings()), "Snapshot should contain every ring.")
+
+	for _, r := range snapshot.Rings {
+		assert.Contains(suite.T(), r.Members, "live", "Live peer missing from ring %d.", r.Num)
+		assert.NotContains(suite.T(), r.Members, "dead", "Dead peer placed on ring %d.", r.Num)
+	}
+}
+
+func (suite *ViewTestSuite) TestPause() {
+	view := suite.v
+
+	view.SetRemovalTimeout(0)
+	view.SetUpdateTimeout(time.Millisecond)
+
+	accused := &Peer{
+		Id: "testAccused",
+	}
+
+	view.AddLive(accused)
+
+	view.timeoutMap[accused.Id] = &timeout{
+		accused:   accused,
+		observer:  &Peer{Id: "testAccuser"},
+		lastNote:  &Note{id: accused.Id},
+		timeStamp: time.Now().Add(-time.Second),
+	}
+
+	view.Pause()
+
+	go view.Start()
+	defer view.Stop()
+
+	time.Sleep(time.Millisecond * 20)
+	require.True(suite.T(), view.IsAlive(accused.Id), "Peer evicted while paused.")
+
+	view.Resume()
+
+	require.Eventually(suite.T(), func() bool {
+		return !view.IsAlive(accused.Id)
+	}, time.Second, time.Millisecond, "Peer not evicted after resuming.")
+}
+
 func (suite *ViewTestSuite) TestShouldRebuttal() {
 	view := suite.v
 
@@ -570,6 +848,42 @@ func (suite *ViewTestSuite) TestShouldRebuttal() {
 	assert.True(suite.T(), view.ValidMask(view.self.note.mask), "Mask is not valid after disabling.")
 }
 
+func (suite *ViewTestSuite) TestShouldRebuttalLeaving() {
+	view := suite.v
+
+	require.NoError(suite.T(), view.Leave(), "Failed to leave.")
+
+	assert.True(suite.T(), view.ShouldRebuttal(view.self.note.epoch, 1), "Did not rebut accusation.")
+	assert.True(suite.T(), view.self.note.IsLeaving(), "Rebuttal dropped the leaving announcement.")
+}
+
+func (suite *ViewTestSuite) TestShouldRebuttalEpochOverflow() {
+	view := suite.v
+
+	view.self.note.epoch = math.MaxUint64
+	prevNote := view.self.note
+
+	assert.False(suite.T(), view.ShouldRebuttal(math.MaxUint64, 1), "Rebutted with a wrapped epoch.")
+	assert.Equal(suite.T(), prevNote, view.self.note, "Note replaced on overflow.")
+}
+
+func (suite *ViewTestSuite) TestRefreshNote() {
+	view := suite.v
+
+	prevNote := view.self.note
+
+	require.NoError(suite.T(), view.RefreshNote(), "Failed to refresh note.")
+
+	assert.Equal(suite.T(), prevNote.epoch+1, view.LocalEpoch(), "Epoch not incremented.")
+	assert.Equal(suite.T(), prevNote.mask, view.self.note.mask, "Mask changed.")
+	assert.NotNil(suite.T(), view.self.note.signature, "Note not signed.")
+
+	view.self.note.epoch = math.MaxUint64
+
+	assert.EqualError(suite.T(), view.RefreshNote(), errEpochOverflow.Error(), "Epoch wrapped around.")
+	assert.Equal(suite.T(), uint64(math.MaxUint64), view.LocalEpoch(), "Note replaced on overflow.")
+}
+
 func (suite *ViewTestSuite) TestShouldBeNeighbour() {
 	view := suite.v
 
@@ -633,6 +947,24 @@ func (suite *ViewTestSuite) TestIsAlive() {
 	assert.True(suite.T(), view.IsAlive(p.Id), "Id of live peer should return true.")
 }
 
+func (suite *ViewTestSuite) TestIsAliveAddr() {
+	view := suite.v
+
+	p := &Peer{
+		Id:   "testId",
+		Addr: "testAddr",
+	}
+
+	view.viewMap[p.Id] = p
+
+	assert.False(suite.T(), view.IsAliveAddr(p.Addr), "Address of peer outside the live view should return false.")
+
+	view.liveMap[p.Id] = p
+
+	assert.True(suite.T(), view.IsAliveAddr(p.Addr), "Address of live peer should return true.")
+	assert.False(suite.T(), view.IsAliveAddr(p.Id), "Id should not match as an address.")
+}
+
 func (suite *ViewTestSuite) TestIncrementGossipRing() {
 	var i uint32
 	view := suite.v
@@ -892,3 +1224,17 @@ type signerStub struct {
 func (s *signerStub) Sign(data []byte) ([]byte, []byte, error) {
 	return nil, nil, nil
 }
+
+// Clock that only moves when stepped.
+type stepClock struct {
+	Clock
+	now time.Time
+}
+
+func (sc *stepClock) Now() time.Time {
+	return sc.now
+}
+
+func (sc *stepClock) step() {
+	sc.now = sc.now.Add(time.Second)
+}
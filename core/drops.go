@@ -0,0 +1,92 @@
+package core
+
+import (
+	log "github.com/inconshreveable/log15"
+)
+
+// Number of drops waiting for the drop handler, further drops are counted but not reported.
+const dropQueueSize = 256
+
+// Kind of message or datagram the node dropped, see SetDropHandler.
+type DropKind uint8
+
+const (
+	// Inbound gossip rejected before it was merged, because the sender
+	// exceeded its gossip rate or is not our ring neighbour.
+	DroppedGossip DropKind = iota
+
+	// Stream message that could not be received from, or sent to, the other end of a stream.
+	DroppedStreamFrame
+
+	// Failed read of the udp socket serving pings, or failed write of a pong, never attributed to a peer.
+	UdpReadError
+	UdpWriteError
+)
+
+func (k DropKind) String() string {
+	switch k {
+	case DroppedGossip:
+		return "gossip"
+	case DroppedStreamFrame:
+		return "stream frame"
+	case UdpReadError:
+		return "udp read"
+	case UdpWriteError:
+		return "udp write"
+	default:
+		return "unknown"
+	}
+}
+
+type dropReport struct {
+	kind   DropKind
+	peerId []byte
+}
+
+// Counts the drop and queues it for the drop handler, peerId is nil if the peer is unknown.
+// Drops are only counted while the queue is full, so a slow handler can not hold up the node.
+func (n *Node) recordDrop(kind DropKind, peerId []byte) {
+	n.stats.recordDrop(kind)
+
+	if n.getDropHandler() == nil {
+		return
+	}
+
+	select {
+	case n.drops <- dropReport{kind: kind, peerId: peerId}:
+	default:
+	}
+}
+
+// Hands queued drops to the drop handler in the order they occurred.
+func (n *Node) dropLoop() {
+	defer n.wg.Done()
+
+	for {
+		select {
+		case <-n.exitChan:
+			log.Info("Stopping drop reports")
+			return
+		case d := <-n.drops:
+			if handler := n.getDropHandler(); handler != nil {
+				handler(d.kind, d.peerId)
+			}
+		}
+	}
+}
+
+// Pings arrive from ephemeral ports, so udp errors can not be attributed to a peer.
+func (n *Node) udpError(write bool) {
+	if write {
+		n.recordDrop(UdpWriteError, nil)
+	} else {
+		n.recordDrop(UdpReadError, nil)
+	}
+}
+
+// The peer id is resolved once when the stream is opened, not per frame.
+func (n *Node) streamFrameDropped(peerId []byte) func() {
+	return func() {
+		n.recordDrop(DroppedStreamFrame, peerId)
+	}
+}
@@ -0,0 +1,181 @@
+package core
+
+import (
+	"sync"
+
+	log "github.com/inconshreveable/log15"
+	"github.com/joonnna/ifrit/core/discovery"
+	"golang.org/x/net/context"
+)
+
+// Number of membership events buffered for each channel returned by MembershipEvents.
+const eventChanSize = 256
+
+// Unbounded fifo of membership events, the view pushes events while holding its locks,
+// so pushing can never block.
+type eventQueue struct {
+	events []discovery.Event
+	mutex  sync.Mutex
+
+	signal chan struct{}
+}
+
+func newEventQueue() *eventQueue {
+	return &eventQueue{
+		signal: make(chan struct{}, 1),
+	}
+}
+
+func (q *eventQueue) push(e discovery.Event) {
+	q.mutex.Lock()
+	q.events = append(q.events, e)
+	q.mutex.Unlock()
+
+	select {
+	case q.signal <- struct{}{}:
+	default:
+	}
+}
+
+func (q *eventQueue) drain() []discovery.Event {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	ret := q.events
+	q.events = nil
+
+	return ret
+}
+
+// Delivers membership events to the membership handler in the order they occurred.
+func (n *Node) eventLoop() {
+	defer n.wg.Done()
+	defer n.closeEventSubs()
+
+	for {
+		select {
+		case <-n.exitChan:
+			log.Info("Stopping membership events")
+			return
+		case <-n.events.signal:
+			for _, e := range n.events.drain() {
+				if e.Kind == discovery.Left {
+					n.stats.forgetPeer(e.Id)
+				}
+
+				if handler := n.getMembershipHandler(); handler != nil {
+					handler(e)
+				}
+
+				n.publishEvent(e)
+			}
+
+			n.updateIsolation(n.clock.Now())
+			n.signalMembershipChange()
+		}
+	}
+}
+
+// Returns a channel receiving all membership events from now on, in the order they occurred.
+// Each channel buffers eventChanSize events, once full the oldest buffered event is dropped
+// to make room, so a slow consumer misses events instead of delaying them for others.
+// The channel is closed when the node stops.
+func (n *Node) MembershipEvents() <-chan discovery.Event {
+	n.eventSubsMutex.Lock()
+	defer n.eventSubsMutex.Unlock()
+
+	ch := make(chan discovery.Event, eventChanSize)
+
+	if n.eventSubsClosed {
+		close(ch)
+	} else {
+		n.eventSubs = append(n.eventSubs, ch)
+	}
+
+	return ch
+}
+
+func (n *Node) publishEvent(e discovery.Event) {
+	n.eventSubsMutex.Lock()
+	defer n.eventSubsMutex.Unlock()
+
+	for _, ch := range n.eventSubs {
+		for sent := false; !sent; {
+			select {
+			case ch <- e:
+				sent = true
+			default:
+				// Full, drop the oldest event unless the consumer just made room.
+				select {
+				case <-ch:
+				default:
+				}
+			}
+		}
+	}
+}
+
+func (n *Node) closeEventSubs() {
+	n.eventSubsMutex.Lock()
+	defer n.eventSubsMutex.Unlock()
+
+	for _, ch := range n.eventSubs {
+		close(ch)
+	}
+
+	n.eventSubs = nil
+	n.eventSubsClosed = true
+}
+
+// Returns a channel that is closed on the next membership change,
+// callers must check their condition after obtaining it to not miss a change.
+func (n *Node) membershipChanged() <-chan struct{} {
+	n.viewChangeMutex.Lock()
+	defer n.viewChangeMutex.Unlock()
+
+	return n.viewChange
+}
+
+func (n *Node) signalMembershipChange() {
+	n.viewChangeMutex.Lock()
+	defer n.viewChangeMutex.Unlock()
+
+	close(n.viewChange)
+	n.viewChange = make(chan struct{})
+}
+
+// Blocks until the live view holds at least the given number of peers.
+// Returns the context error if it does not before the context is done.
+func (n *Node) WaitForMembers(ctx context.Context, count int) error {
+	for {
+		changed := n.membershipChanged()
+
+		if len(n.view.Live()) >= count {
+			return nil
+		}
+
+		select {
+		case <-changed:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// Blocks until the peer with the given id is in the full view, returns its address.
+// Returns the context error if it does not show up before the context is done.
+func (n *Node) WaitForPeer(ctx context.Context, id []byte) (string, error) {
+	for {
+		changed := n.membershipChanged()
+
+		if addr, err := n.IdToAddr(id); err == nil {
+			return addr, nil
+		}
+
+		select {
+		case <-changed:
+		case <-ctx.Done():
+			return "", ctx.Err()
+		}
+	}
+}
@@ -6,70 +6,254 @@ import (
 	"time"
 
 	"github.com/golang/protobuf/proto"
+	log "github.com/inconshreveable/log15"
 	"github.com/joonnna/ifrit/core/discovery"
 	pb "github.com/joonnna/ifrit/protobuf"
+	"golang.org/x/net/context"
 )
 
 var (
 	errDead                 = errors.New("Peer is dead")
 	errInvalidPongSignature = errors.New("Invalid signature on pong message")
+	errTcpPingHost          = errors.New("Host answering tcp ping is not the pinged peer")
 )
 
+// Deadline of the tls handshake of a tcp ping.
+const tcpPingTimeout = time.Second * 5
+
+// Pings are signed by the sender and pongs by the receiver, so that hosts
+// outside the network can neither probe us nor spoof the liveness of a dead peer.
 type failureDetector struct {
 	ps             pingService
 	cs             cryptoService
+	id             []byte
 	maxFailedPings uint32
+
+	// Our note, attached to every ping, and the handler of the note in valid pongs.
+	// Pings carry no note if unset.
+	localNote   func() *pb.Note
+	noteHandler func(*discovery.Peer, *pb.Note)
+
+	// Told about the estimated clock offset of peers that stamp their pongs.
+	skewHandler func(*discovery.Peer, time.Duration)
+
+	// Checks peers over tcp once their udp pings have failed too often, nil accuses them right away.
+	tcpPing func(*discovery.Peer) error
 }
 
 type pingService interface {
 	Pause(time.Duration)
 	Ping(string, *pb.Ping) (*pb.Pong, error)
+	SetPingVerifier(func(*pb.Ping) bool)
+	SetPongNote(func() *pb.Note)
+	SetErrorHandler(func(bool))
 	Start()
 	Stop()
 }
 
-func newFd(ps pingService, cs cryptoService, maxPing uint32) *failureDetector {
+func newFd(ps pingService, cs cryptoService, id []byte, maxPing uint32) *failureDetector {
 	return &failureDetector{
 		ps:             ps,
 		cs:             cs,
+		id:             id,
 		maxFailedPings: maxPing,
 	}
 }
 
+// Must be called before probing.
+func (fd *failureDetector) setNoteExchange(localNote func() *pb.Note, handler func(*discovery.Peer, *pb.Note)) {
+	fd.localNote = localNote
+	fd.noteHandler = handler
+}
+
+// Must be called before probing.
+func (fd *failureDetector) setSkewHandler(handler func(*discovery.Peer, time.Duration)) {
+	fd.skewHandler = handler
+}
+
+// Must be called before probing.
+func (fd *failureDetector) setTcpFallback(ping func(*discovery.Peer) error) {
+	fd.tcpPing = ping
+}
+
 func (fd *failureDetector) stopServing(d time.Duration) {
 	fd.ps.Pause(d)
 }
 
+// A pong with a missing or invalid signature counts as a failed ping.
 func (fd *failureDetector) probe(dest *discovery.Peer) error {
 	msg := &pb.Ping{
 		Nonce: genNonce(),
+		Id:    fd.id,
+	}
+
+	if err := fd.sign(msg); err != nil {
+		return err
+	}
+
+	// Notes carry their own signature, so the note is left out of the ping signature.
+	if fd.localNote != nil {
+		msg.Note = fd.localNote()
 	}
 
+	// The pong signs the ping exactly as it was sent, along with its time.
+	sent, err := proto.Marshal(msg)
+	if err != nil {
+		return err
+	}
+
+	start := time.Now()
+
 	pong, err := fd.ps.Ping(dest.PingAddr, msg)
+	if err == nil && !fd.validPong(sent, pong, dest) {
+		err = errInvalidPongSignature
+	}
+
 	if err != nil {
 		dest.IncrementPing()
-		if dest.NumPing() >= fd.maxFailedPings {
+		if dest.NumPing() < fd.maxFailedPings {
+			return err
+		}
+
+		// Udp might be filtered on the way to an otherwise healthy peer.
+		if fd.tcpPing == nil {
+			return errDead
+		}
+
+		if err := fd.tcpPing(dest); err != nil {
+			log.Debug(err.Error(), "addr", dest.Addr)
 			return errDead
 		}
 
+		dest.ResetPing()
+
+		return nil
+	}
+
+	dest.ResetPing()
+
+	// Assumes the pong was stamped halfway through the round trip, so the estimate
+	// is off by at most half the round trip time, retransmits included.
+	if stamp := pong.GetTime(); stamp != 0 && fd.skewHandler != nil {
+		rtt := time.Since(start)
+		fd.skewHandler(dest, time.Unix(0, stamp).Sub(start.Add(rtt/2)))
+	}
+
+	if note := pong.GetNote(); note != nil && fd.noteHandler != nil {
+		fd.noteHandler(dest, note)
+	}
+
+	return nil
+}
+
+// The pong signature covers the ping as it was sent together with the time of the pong.
+func (fd *failureDetector) validPong(sent []byte, pong *pb.Pong, dest *discovery.Peer) bool {
+	sign := pong.GetSignature()
+	if sign == nil {
+		return false
+	}
+
+	data, err := pb.PongSignedData(sent, pong.GetTime())
+	if err != nil {
+		return false
+	}
+
+	return fd.cs.Verify(data, sign.GetR(), sign.GetS(), dest.PublicKey())
+}
+
+func (fd *failureDetector) sign(p *pb.Ping) error {
+	data, err := proto.Marshal(p)
+	if err != nil {
 		return err
 	}
 
-	pong.Signature = nil
-	bytes, err := proto.Marshal(pong)
+	r, s, err := fd.cs.Sign(data)
 	if err != nil {
 		return err
 	}
 
-	if sign := pong.GetSignature(); sign == nil {
-		return errInvalidPongSignature
-	} else {
-		if valid := fd.cs.Verify(bytes, sign.GetR(), sign.GetS(), dest.PublicKey()); !valid {
-			return errInvalidPongSignature
-		}
+	p.Signature = &pb.Signature{
+		R: r,
+		S: s,
 	}
 
-	dest.ResetPing()
+	return nil
+}
+
+// Pings the peer over tcp by a tls handshake with its rpc address, the handshake proves
+// that the host holds the key of the peer. Used once its udp pings have failed too often.
+func (n *Node) pingOverTcp(p *discovery.Peer) error {
+	ctx, cancel := context.WithTimeout(context.Background(), tcpPingTimeout)
+	defer cancel()
+
+	cert, err := n.comm.PeerCertificate(ctx, p.Addr)
+	if err != nil {
+		return err
+	}
+
+	if cert == nil || !p.SameHost(cert) {
+		return errTcpPingHost
+	}
+
+	n.stats.recordTcpPing()
+
+	log.Debug("Udp pings failed, peer answered over tcp", "addr", p.Addr)
+
+	return nil
+}
+
+// Answers pings from peers in our full view, merging the note they carry.
+func (n *Node) handlePing(p *pb.Ping) bool {
+	if !n.validPing(p) {
+		return false
+	}
+
+	if note := p.GetNote(); note != nil {
+		n.mergePeerNote(n.view.Peer(string(p.GetId())), note)
+	}
+
+	return true
+}
+
+// Only pings signed by a peer in our full view are answered.
+func (n *Node) validPing(p *pb.Ping) bool {
+	sign := p.GetSignature()
+	if sign == nil {
+		return false
+	}
+
+	peer := n.view.Peer(string(p.GetId()))
+	if peer == nil {
+		return false
+	}
+
+	data, err := proto.Marshal(&pb.Ping{
+		Nonce: p.GetNonce(),
+		Id:    p.GetId(),
+	})
+	if err != nil {
+		return false
+	}
+
+	return n.cs.Verify(data, sign.GetR(), sign.GetS(), peer.PublicKey())
+}
+
+// Merges a note received in a ping or pong, only the note of the peer itself is accepted.
+func (n *Node) mergePeerNote(p *discovery.Peer, note *pb.Note) {
+	if p == nil || p.Id != string(note.GetId()) {
+		return
+	}
+
+	if err := n.evalNote(note); err != nil {
+		log.Debug(err.Error())
+	}
+}
+
+// Our note as attached to pings and pongs, nil before the view has one.
+func (n *Node) localPbNote() *pb.Note {
+	if note := n.self.Note(); note != nil {
+		return note.ToPbMsg()
+	}
 
 	return nil
 }
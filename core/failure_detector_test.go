@@ -1 +1,323 @@
 package core
+
+import (
+	"crypto/ecdsa"
+	"crypto/x509"
+	"errors"
+	"math"
+	"testing"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+	log "github.com/inconshreveable/log15"
+	"github.com/joonnna/ifrit/core/discovery"
+	pb "github.com/joonnna/ifrit/protobuf"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/stretchr/testify/suite"
+)
+
+type FailureDetectorTestSuite struct {
+	suite.Suite
+	n *Node
+}
+
+func TestFailureDetectorTestSuite(t *testing.T) {
+	r := log.Root()
+
+	r.SetHandler(log.DiscardHandler())
+
+	suite.Run(t, new(FailureDetectorTestSuite))
+}
+
+func (suite *FailureDetectorTestSuite) SetupTest() {
+	priv, err := genKeys()
+	require.NoError(suite.T(), err, "Failed to generate keys")
+
+	n, err := NewNode(&commStub{}, &pingStub{}, &cmStub{cert: genCert(priv, 10)}, &cryptoStub{priv: priv}, testConfig())
+	require.NoError(suite.T(), err, "Failed to create node.")
+
+	suite.n = n
+}
+
+func (suite *FailureDetectorTestSuite) TestProbe() {
+	p, priv, err := addPeer(suite.n)
+	require.NoError(suite.T(), err, "Could not add peer.")
+
+	ps := &signingPingStub{priv: priv}
+	fd := newFd(ps, suite.n.cs, []byte(suite.n.self.Id), 2)
+
+	require.NoError(suite.T(), fd.probe(p), "Probe failed with a correctly signed pong.")
+	require.Equal(suite.T(), suite.n.self.Id, string(ps.last.GetId()), "Sent ping does not carry our id.")
+	require.NotNil(suite.T(), ps.last.GetSignature(), "Sent ping is not signed.")
+
+	other, err := genKeys()
+	require.NoError(suite.T(), err, "Failed to generate keys")
+
+	ps.priv = other
+
+	assert.EqualError(suite.T(), fd.probe(p), errInvalidPongSignature.Error(), "Accepted pong signed by another key.")
+	assert.EqualError(suite.T(), fd.probe(p), errDead.Error(), "Invalid pongs should count as failed pings.")
+
+	ps.priv = priv
+
+	require.NoError(suite.T(), fd.probe(p), "Probe failed with a correctly signed pong.")
+	assert.Zero(suite.T(), p.NumPing(), "Valid pong did not reset failed pings.")
+
+	ps.unsigned = true
+
+	assert.EqualError(suite.T(), fd.probe(p), errInvalidPongSignature.Error(), "Accepted unsigned pong.")
+}
+
+func (suite *FailureDetectorTestSuite) TestNoteExchange() {
+	n := suite.n
+
+	p, priv, err := addPeer(n)
+	require.NoError(suite.T(), err, "Could not add peer.")
+
+	ps := &signingPingStub{priv: priv, note: discovery.NewNote(p.Id, 2, math.MaxUint32, priv)}
+	fd := newFd(ps, n.cs, []byte(n.self.Id), 2)
+	fd.setNoteExchange(n.localPbNote, n.mergePeerNote)
+
+	require.NoError(suite.T(), fd.probe(p), "Probe failed with a correctly signed pong.")
+	assert.Equal(suite.T(), n.self.Note().Epoch(), ps.last.GetNote().GetEpoch(), "Sent ping does not carry our note.")
+	assert.Equal(suite.T(), uint64(2), p.Note().Epoch(), "Newer note in pong not merged.")
+
+	// Only the note of the pinged peer is accepted from its pong.
+	other, otherPriv, err := addPeer(n)
+	require.NoError(suite.T(), err, "Could not add peer.")
+
+	ps.note = discovery.NewNote(other.Id, 3, math.MaxUint32, otherPriv)
+
+	require.NoError(suite.T(), fd.probe(p), "Probe failed with a correctly signed pong.")
+	assert.Equal(suite.T(), uint64(1), other.Note().Epoch(), "Merged note of another peer from pong.")
+
+	ping := &pb.Ping{
+		Nonce: genNonce(),
+		Id:    []byte(p.Id),
+	}
+
+	require.NoError(suite.T(), newFd(&pingStub{}, &cryptoStub{priv: priv}, []byte(p.Id), 3).sign(ping), "Failed to sign ping.")
+
+	ping.Note = discovery.NewNote(p.Id, 4, math.MaxUint32, priv)
+
+	require.True(suite.T(), n.handlePing(ping), "Rejected ping carrying a note.")
+	assert.Equal(suite.T(), uint64(4), p.Note().Epoch(), "Newer note in ping not merged.")
+
+	ping.Note = discovery.NewNote(p.Id, 5, math.MaxUint32, otherPriv)
+
+	require.True(suite.T(), n.handlePing(ping), "Rejected ping carrying a note.")
+	assert.Equal(suite.T(), uint64(4), p.Note().Epoch(), "Merged note with invalid signature from ping.")
+}
+
+func (suite *FailureDetectorTestSuite) TestClockSkew() {
+	n := suite.n
+
+	p, priv, err := addPeer(n)
+	require.NoError(suite.T(), err, "Could not add peer.")
+
+	ps := &signingPingStub{priv: priv}
+	fd := newFd(ps, n.cs, []byte(n.self.Id), 2)
+	fd.setSkewHandler(n.recordClockSkew)
+
+	require.NoError(suite.T(), fd.probe(p), "Probe failed with a correctly signed pong.")
+
+	_, ok := n.EstimatedClockSkew([]byte(p.Id))
+	assert.False(suite.T(), ok, "Estimated skew from a pong without timestamp.")
+
+	ps.stamped = true
+	ps.skew = time.Hour
+
+	require.NoError(suite.T(), fd.probe(p), "Probe failed with a correctly signed pong.")
+
+	skew, ok := n.EstimatedClockSkew([]byte(p.Id))
+	require.True(suite.T(), ok, "No skew estimated from a stamped pong.")
+	assert.InDelta(suite.T(), float64(time.Hour), float64(skew), float64(time.Second), "Wrong skew estimate.")
+	assert.Equal(suite.T(), uint64(1), n.Stats().ClockSkews, "Skew beyond the threshold not counted.")
+
+	ps.skew = 0
+
+	require.NoError(suite.T(), fd.probe(p), "Probe failed with a correctly signed pong.")
+
+	skew, _ = n.EstimatedClockSkew([]byte(p.Id))
+	assert.True(suite.T(), skew > 0 && skew < time.Hour, "Estimate not weighted towards the newest sample.")
+	assert.Equal(suite.T(), uint64(1), n.Stats().ClockSkews, "Skew within the threshold counted.")
+
+	ps.skew = -time.Hour * 2
+
+	require.NoError(suite.T(), fd.probe(p), "Probe failed with a correctly signed pong.")
+	assert.Equal(suite.T(), uint64(2), n.Stats().ClockSkews, "Negative skew beyond the threshold not counted.")
+
+	n.stats.forgetPeer(p.Id)
+
+	_, ok = n.EstimatedClockSkew([]byte(p.Id))
+	assert.False(suite.T(), ok, "Skew estimate kept after the peer left.")
+}
+
+func (suite *FailureDetectorTestSuite) TestTamperedPongTime() {
+	n := suite.n
+
+	p, priv, err := addPeer(n)
+	require.NoError(suite.T(), err, "Could not add peer.")
+
+	ps := &signingPingStub{priv: priv, stamped: true, tampered: time.Hour}
+	fd := newFd(ps, n.cs, []byte(n.self.Id), 2)
+	fd.setSkewHandler(n.recordClockSkew)
+
+	require.Equal(suite.T(), errInvalidPongSignature, fd.probe(p), "Accepted a pong with a rewritten time.")
+
+	_, ok := n.EstimatedClockSkew([]byte(p.Id))
+	assert.False(suite.T(), ok, "Estimated skew from a tampered pong.")
+}
+
+func (suite *FailureDetectorTestSuite) TestTcpFallback() {
+	priv, err := genKeys()
+	require.NoError(suite.T(), err, "Failed to generate keys")
+
+	conf := testConfig()
+	conf.TcpPingFallback = true
+
+	cs := &certCommStub{}
+
+	n, err := NewNode(cs, &lossyPingStub{}, &cmStub{cert: genCert(priv, 10)}, &cryptoStub{priv: priv}, conf)
+	require.NoError(suite.T(), err, "Failed to create node.")
+
+	p, _, err := addPeer(n)
+	require.NoError(suite.T(), err, "Could not add peer.")
+
+	cs.cert, err = x509.ParseCertificate(p.Certificate())
+	require.NoError(suite.T(), err, "Failed to parse certificate.")
+
+	// Every udp ping is lost, but the peer answers over tcp.
+	for i := uint32(0); i < conf.PingLimit*3; i++ {
+		n.protocol().Monitor(n)
+	}
+
+	assert.False(suite.T(), p.IsAccused(), "Accused peer reachable over tcp.")
+	assert.False(suite.T(), n.view.HasTimer(p.Id), "Started removal timer for peer reachable over tcp.")
+	assert.True(suite.T(), n.view.IsAlive(p.Id), "Peer reachable over tcp not alive.")
+	assert.NotZero(suite.T(), n.Stats().TcpPings, "Tcp pings not counted.")
+
+	// A host with another key answering on the address of the peer does not count.
+	other, _, err := addPeer(n)
+	require.NoError(suite.T(), err, "Could not add peer.")
+	n.view.RemoveLive(other.Id)
+
+	cs.cert, err = x509.ParseCertificate(other.Certificate())
+	require.NoError(suite.T(), err, "Failed to parse certificate.")
+
+	for i := uint32(0); i < conf.PingLimit; i++ {
+		n.protocol().Monitor(n)
+	}
+
+	assert.True(suite.T(), n.view.HasTimer(p.Id), "Peer not accused although neither udp nor tcp pings reached it.")
+
+	// Without the fallback lost udp pings lead to an accusation.
+	n2, err := NewNode(cs, &lossyPingStub{}, &cmStub{cert: genCert(priv, 10)}, &cryptoStub{priv: priv}, testConfig())
+	require.NoError(suite.T(), err, "Failed to create node.")
+
+	p2, _, err := addPeer(n2)
+	require.NoError(suite.T(), err, "Could not add peer.")
+
+	for i := uint32(0); i < conf.PingLimit; i++ {
+		n2.protocol().Monitor(n2)
+	}
+
+	assert.True(suite.T(), n2.view.HasTimer(p2.Id), "Peer not accused without the tcp fallback.")
+	assert.Zero(suite.T(), n2.Stats().TcpPings, "Tcp pings counted without the fallback.")
+}
+
+func (suite *FailureDetectorTestSuite) TestValidPing() {
+	p, priv, err := addPeer(suite.n)
+	require.NoError(suite.T(), err, "Could not add peer.")
+
+	fd := newFd(&pingStub{}, &cryptoStub{priv: priv}, []byte(p.Id), 3)
+
+	ping := &pb.Ping{
+		Nonce: genNonce(),
+		Id:    []byte(p.Id),
+	}
+
+	assert.False(suite.T(), suite.n.validPing(ping), "Accepted unsigned ping.")
+
+	require.NoError(suite.T(), fd.sign(ping), "Failed to sign ping.")
+	assert.True(suite.T(), suite.n.validPing(ping), "Rejected ping from known peer.")
+
+	tampered := proto.Clone(ping).(*pb.Ping)
+	tampered.Nonce = genNonce()
+	assert.False(suite.T(), suite.n.validPing(tampered), "Accepted ping with tampered nonce.")
+
+	unknown, err := genKeys()
+	require.NoError(suite.T(), err, "Failed to generate keys")
+
+	spoofed := &pb.Ping{
+		Nonce: genNonce(),
+		Id:    []byte("unknown"),
+	}
+
+	require.NoError(suite.T(), newFd(&pingStub{}, &cryptoStub{priv: unknown}, spoofed.Id, 3).sign(spoofed), "Failed to sign ping.")
+	assert.False(suite.T(), suite.n.validPing(spoofed), "Accepted ping from peer outside the view.")
+}
+
+var errPingLost = errors.New("Ping lost")
+
+// Loses all pings, like a network filtering udp.
+type lossyPingStub struct {
+	pingStub
+}
+
+func (ps *lossyPingStub) Ping(addr string, m *pb.Ping) (*pb.Pong, error) {
+	return nil, errPingLost
+}
+
+// Answers pings with pongs signed by the given key, like the pinged peer would.
+type signingPingStub struct {
+	pingStub
+
+	priv     *ecdsa.PrivateKey
+	unsigned bool
+	last     *pb.Ping
+
+	// Attached to pongs if set.
+	note *pb.Note
+
+	// Pongs are stamped with our time plus the skew if set.
+	stamped bool
+	skew    time.Duration
+
+	// Added to the time after signing, as an on-path host rewriting the pong would.
+	tampered time.Duration
+}
+
+func (ps *signingPingStub) Ping(addr string, m *pb.Ping) (*pb.Pong, error) {
+	ps.last = m
+
+	if ps.unsigned {
+		return &pb.Pong{}, nil
+	}
+
+	ping, err := proto.Marshal(m)
+	if err != nil {
+		return nil, err
+	}
+
+	var stamp int64
+	if ps.stamped {
+		stamp = time.Now().Add(ps.skew).UnixNano()
+	}
+
+	data, err := pb.PongSignedData(ping, stamp)
+	if err != nil {
+		return nil, err
+	}
+
+	r, s, err := (&cryptoStub{priv: ps.priv}).Sign(data)
+	if err != nil {
+		return nil, err
+	}
+
+	pong := &pb.Pong{Signature: &pb.Signature{R: r, S: s}, Note: ps.note, Time: stamp}
+	pong.Time += int64(ps.tampered)
+
+	return pong, nil
+}
@@ -1,10 +1,12 @@
 package core
 
 import (
+	"bytes"
 	"crypto/sha256"
 	"crypto/x509"
 	"errors"
 	"io"
+	"sync"
 
 	"github.com/golang/protobuf/proto"
 	log "github.com/inconshreveable/log15"
@@ -38,6 +40,11 @@ var (
 	errSelfCert  = errors.New("Certificate was my own.")
 	errNoCert    = errors.New("No certificate present in tls context.")
 	errInvalidId = errors.New("Id in certificate is of invalid size.")
+	errKeyPin    = errors.New("Certificate public key does not match the key pinned for its id.")
+
+	errIdConflict = errors.New("Certificate claims the id of a known peer with another key or addresses.")
+
+	errRateLimited = errors.New("Gossip rate exceeded, try again later.")
 )
 
 func (n *Node) Spread(ctx context.Context, args *pb.State) (*pb.StateResponse, error) {
@@ -47,14 +54,29 @@ func (n *Node) Spread(ctx context.Context, args *pb.State) (*pb.StateResponse, e
 		return nil, err
 	}
 
+	_, end := n.startSpan(ctx, spanSpread, cert.SubjectKeyId, "", args)
+	defer end()
+
 	reply := &pb.StateResponse{}
 
 	remoteId := string(cert.SubjectKeyId[:])
+
+	if !n.gossipLimiter.allowAt(remoteId, n.clock.Now()) {
+		n.recordDrop(DroppedGossip, cert.SubjectKeyId)
+		return nil, errRateLimited
+	}
+
 	peer := n.view.Peer(remoteId)
 	if peer != nil {
 		observed = true
 	}
 
+	// Observers are kept out of the rings, so they are never our neighbours,
+	// the note tells us before we have seen the peer.
+	if args.GetOwnNote().GetObserver() || (observed && peer.IsObserver()) {
+		return n.replyObserver(cert, args, observed)
+	}
+
 	if neighbours := n.view.ShouldBeNeighbour(remoteId); neighbours {
 		if err := n.evalCertificate(cert); err != nil {
 			log.Error(err.Error())
@@ -75,7 +97,17 @@ func (n *Node) Spread(ctx context.Context, args *pb.State) (*pb.StateResponse, e
 			n.mergeViews(hosts, reply)
 		}
 
-		if handler := n.getGossipHandler(); handler != nil && extGossip != nil {
+		n.mergeGossipEntries(args.GetEntries())
+
+		if handler := n.getBatchGossipHandler(); handler != nil {
+			for _, e := range args.GetBatch() {
+				handler(e.GetId(), e.GetContent())
+			}
+		}
+
+		// Neighbours keep gossiping the same content each round until it changes,
+		// hand it to the application only the first time it is seen.
+		if handler := n.getGossipHandler(); handler != nil && extGossip != nil && n.seenGossip.add(gossipKey(remoteId, extGossip)) {
 			reply.ExternalGossip, err = handler(extGossip)
 			if err != nil {
 				log.Error(err.Error())
@@ -87,6 +119,7 @@ func (n *Node) Spread(ctx context.Context, args *pb.State) (*pb.StateResponse, e
 			if err != nil {
 				log.Debug(err.Error())
 			}
+			n.recordDrop(DroppedGossip, cert.SubjectKeyId)
 			return nil, errNotMyNeighbour
 		}
 
@@ -126,16 +159,79 @@ func (n *Node) Spread(ctx context.Context, args *pb.State) (*pb.StateResponse, e
 	return reply, nil
 }
 
+// Accepts the certificate and note of the observer and replies like a pull, nothing else it sends is merged.
+// Nobody gossips with observers, so they learn our certificate from the reply.
+func (n *Node) replyObserver(cert *x509.Certificate, args *pb.State, observed bool) (*pb.StateResponse, error) {
+	if !observed {
+		if err := n.evalCertificate(cert); err != nil {
+			log.Error(err.Error())
+			return nil, err
+		}
+	}
+
+	err := n.evalNote(args.GetOwnNote())
+	if err != nil && err != errOldNote {
+		log.Debug(err.Error())
+	}
+
+	reply := &pb.StateResponse{
+		Certificates: []*pb.Certificate{{Raw: n.cm.Certificate().Raw}},
+	}
+
+	hosts := args.GetExistingHosts()
+	if hosts == nil {
+		hosts = make(map[string]uint64)
+	}
+
+	n.mergeViews(hosts, reply)
+
+	return reply, nil
+}
+
+// Anti-entropy, replies with everything the requester is missing or has an older version of.
+// Unlike Spread, any authenticated peer can pull, and the requester's state is not merged.
+func (n *Node) Pull(ctx context.Context, args *pb.State) (*pb.StateResponse, error) {
+	cert, err := n.validateCtx(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	if !n.gossipLimiter.allowAt(string(cert.SubjectKeyId), n.clock.Now()) {
+		return nil, errRateLimited
+	}
+
+	reply := &pb.StateResponse{}
+
+	hosts := args.GetExistingHosts()
+	if hosts == nil {
+		hosts = make(map[string]uint64)
+	}
+
+	n.mergeViews(hosts, reply)
+
+	// Observers only spread membership.
+	if !n.observer {
+		reply.Entries = n.getVersionedGossip(n.newGossipBudget(reply))
+	}
+
+	return reply, nil
+}
+
 func (n *Node) Messenger(ctx context.Context, args *pb.Msg) (*pb.MsgResponse, error) {
 	var replyContent []byte
 
-	_, err := n.validateCtx(ctx)
+	cert, err := n.validateCtx(ctx)
 	if err != nil {
 		return nil, err
 	}
 
+	n.stats.recordMsgReceived()
+
+	_, end := n.startSpan(n.extractTrace(ctx), spanMessenger, cert.SubjectKeyId, "", args)
+	defer end()
+
 	if handler := n.getMsgHandler(); handler != nil {
-		replyContent, err = handler(args.GetContent())
+		replyContent, err = handler(cert.SubjectKeyId, args.GetContent())
 		if err != nil {
 			return nil, err
 		}
@@ -144,15 +240,29 @@ func (n *Node) Messenger(ctx context.Context, args *pb.Msg) (*pb.MsgResponse, er
 	return &pb.MsgResponse{Content: replyContent}, nil
 }
 
+// The stream handler is asked to accept the stream, given the sender id,
+// before any messages are read. A rejected stream is closed with the handler's error.
 func (n *Node) Stream(srv pb.Gossip_StreamServer) error {
-	// Channels used for bi-directional communication
-	input := make(chan []byte)
-	reply := make(chan []byte)
-	defer close(input)
+	cert, err := n.validateCtx(srv.Context())
+	if err != nil {
+		return err
+	}
+
+	if accept := n.getStreamHandler(); accept != nil {		
+		handler, err := accept(cert.SubjectKeyId)
+		if err != nil || handler == nil {
+			return err
+		}
+
+		// Channels used for bi-directional communication
+		input := make(chan []byte)
+		reply := make(chan []byte)
+		defer close(input)
+
+		sender := &streamSender{srv: srv}
 
-	if handler := n.getStreamHandler(); handler != nil {		
 		go n.runStreamHandler(handler, input, reply)
-		go n.replyStream(reply, srv)
+		go n.replyStream(reply, sender, cert.SubjectKeyId)
 		ctx := srv.Context()
 
 		for {
@@ -169,24 +279,34 @@ func (n *Node) Stream(srv pb.Gossip_StreamServer) error {
 
 			if err != nil {
 				log.Error(err.Error())
+				n.recordDrop(DroppedStreamFrame, cert.SubjectKeyId)
 				continue
 			}
 
 			input <-req.GetContent()
+
+			// Sequenced messages are acknowledged once the handler has consumed them,
+			// letting the sender move its window forward.
+			if seq := req.GetSeq(); seq != 0 {
+				if err := sender.send(&pb.MsgResponse{Ack: seq}); err != nil {
+					log.Error(err.Error())
+				}
+			}
 		}
 	}
 
 	return nil
 }
 
-func (n *Node) replyStream(reply chan []byte, srv pb.Gossip_StreamServer) {
+func (n *Node) replyStream(reply chan []byte, sender *streamSender, senderId []byte) {
 	for resp := range reply {
 		responseMsg := &pb.MsgResponse{
 			Content: resp,
 		}
 
-		if err := srv.Send(responseMsg); err != nil {
+		if err := sender.send(responseMsg); err != nil {
 			log.Error(err.Error())
+			n.recordDrop(DroppedStreamFrame, senderId)
 		}
 	}
 }
@@ -195,6 +315,59 @@ func (n *Node) runStreamHandler(handler streamMsg, input, reply chan []byte) {
 	handler(input, reply)
 }
 
+// Keeps the entries newer than our own version of them, they are relayed
+// to our neighbours from the next gossip round on.
+// Entries must be signed by their origin, our own entries are only changed locally.
+func (n *Node) mergeGossipEntries(entries []*pb.Data) {
+	for _, e := range entries {
+		if e.GetId() == nil || e.GetOrigin() == nil || string(e.GetOrigin()) == n.self.Id {
+			continue
+		}
+
+		if err := n.evalGossipEntry(e); err != nil {
+			log.Debug(err.Error(), "id", string(e.GetId()))
+			continue
+		}
+
+		if n.addVersionedGossip(e) {
+			log.Debug("Accepted gossip entry", "id", string(e.GetId()), "version", e.GetVersion())
+		}
+	}
+}
+
+// Checks the signature of the entry against the certificate of its origin.
+func (n *Node) evalGossipEntry(e *pb.Data) error {
+	sign := e.GetSignature()
+	if sign == nil {
+		return errInvalidSignature
+	}
+
+	if n.view.Peer(string(e.GetOrigin())) == nil {
+		return errNoPeer
+	}
+
+	b, err := gossipEntryBytes(e)
+	if err != nil {
+		return err
+	}
+
+	if valid := n.Verify(sign.GetR(), sign.GetS(), b, string(e.GetOrigin())); !valid {
+		return errInvalidSignature
+	}
+
+	return nil
+}
+
+// The signed part of a versioned entry, everything but the signature itself.
+func gossipEntryBytes(e *pb.Data) ([]byte, error) {
+	return proto.Marshal(&pb.Data{
+		Id:      e.GetId(),
+		Content: e.GetContent(),
+		Version: e.GetVersion(),
+		Origin:  e.GetOrigin(),
+	})
+}
+
 func (n *Node) mergeViews(given map[string]uint64, reply *pb.StateResponse) {
 	for _, p := range n.view.Full() {
 		if _, ok := given[p.Id]; !ok {
@@ -321,6 +494,11 @@ func (n *Node) evalAccusation(a *pb.Accusation, accuserPeer, p *discovery.Peer)
 
 		if rebut := n.view.ShouldRebuttal(epoch, ringNum); rebut {
 			n.protocol().Rebuttal(n)
+
+			if handler := n.getAccusationHandler(); handler != nil {
+				go handler([]byte(accuserPeer.Id), ringNum)
+			}
+
 			return nil
 		} else {
 			return errInvalidSelfAccusation
@@ -359,6 +537,8 @@ func (n *Node) evalAccusation(a *pb.Accusation, accuserPeer, p *discovery.Peer)
 		if exists := n.view.HasTimer(p.Id); !exists && live {
 			n.view.StartTimer(p, p.Note(), accuserPeer)
 		}
+
+		n.checkAccusationSkew(p, accuserPeer)
 	} else {
 		return errInvalidEpoch
 	}
@@ -399,6 +579,22 @@ func (n *Node) evalNote(newNote *pb.Note) error {
 		return err
 	}
 
+	// Peer announced its departure, remove it right away instead of waiting for accusations to time out.
+	if newNote.GetLeaving() {
+		if valid := n.cs.Verify(bytes, r, s, p.PublicKey()); !valid {
+			return errInvalidSignature
+		}
+
+		p.AddLeavingNote(mask, epoch, r, s)
+
+		n.view.RemoveLive(p.Id)
+		n.view.DeleteTimeout(p.Id)
+
+		log.Debug("Peer left", "epoch", epoch, "addr", p.Addr)
+
+		return nil
+	}
+
 	accusations := p.AllAccusations()
 	// Not accused, only need to check if newnote is more recent
 	if numAccs := len(accusations); numAccs == 0 {
@@ -408,11 +604,9 @@ func (n *Node) evalNote(newNote *pb.Note) error {
 				return errInvalidSignature
 			}
 
-			p.AddNote(mask, epoch, r, s)
+			addNote(p, newNote, r, s)
 
-			if alive := n.view.IsAlive(p.Id); !alive {
-				n.view.AddLive(p)
-			}
+			n.updateLiveness(p)
 		}
 	} else {
 		if valid := n.cs.Verify(bytes, r, s, p.PublicKey()); !valid {
@@ -427,7 +621,7 @@ func (n *Node) evalNote(newNote *pb.Note) error {
 		}
 
 		if note == nil || note.IsMoreRecent(epoch) {
-			p.AddNote(mask, epoch, r, s)
+			addNote(p, newNote, r, s)
 		}
 
 		// All accusations has to be invalidated before we add peer back to full view.
@@ -438,17 +632,43 @@ func (n *Node) evalNote(newNote *pb.Note) error {
 				n.view.DeleteTimeout(p.Id)
 			}
 
-			if alive := n.view.IsAlive(p.Id); !alive {
-				n.view.AddLive(p)
-			}
+			n.updateLiveness(p)
 
 			log.Debug("Rebuttal received", "epoch", epoch, "addr", p.Addr)
 		}
 	}
 
+	// Only the peer itself can sign a new note.
+	n.view.MarkSeen(p.Id)
+
 	return nil
 }
 
+// Stores the verified note, keeping whether it announces an observer.
+func addNote(p *discovery.Peer, note *pb.Note, r, s []byte) {
+	if note.GetObserver() {
+		p.AddObserverNote(note.GetMask(), note.GetEpoch(), r, s)
+	} else {
+		p.AddNote(note.GetMask(), note.GetEpoch(), r, s)
+	}
+}
+
+// Adds the peer to the live view, unless it is an observer, which is kept out of the live view and rings.
+func (n *Node) updateLiveness(p *discovery.Peer) {
+	alive := n.view.IsAlive(p.Id)
+
+	if p.IsObserver() {
+		if alive {
+			n.view.RemoveLive(p.Id)
+		}
+		return
+	}
+
+	if !alive {
+		n.view.AddLive(p)
+	}
+}
+
 func (n *Node) evalCertificate(cert *x509.Certificate) error {
 	if cert == nil {
 		return errNilCert
@@ -460,29 +680,98 @@ func (n *Node) evalCertificate(cert *x509.Certificate) error {
 		return errSelfCert
 	}
 
-	if len(id) != sha256.Size {
+	if err := n.checkCertificate(cert); err != nil {
+		return err
+	}
+
+	if p := n.view.Peer(id); p == nil {
+		n.view.AddFull(id, cert)
+	} else if !bytes.Equal(p.Certificate(), cert.Raw) {
+		// Keep the known peer rather than letting two hosts take turns on its id.
+		if !p.SameHost(cert) {
+			n.stats.recordIdConflict()
+			log.Error("Rejected certificate claiming the id of a known peer", "addr", p.Addr, "claimedBy", cert.Subject.Locality)
+			return errIdConflict
+		}
+
+		if err := p.UpdateCertificate(cert); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Checks that the certificate could belong to a peer, regardless of whether it is known.
+func (n *Node) checkCertificate(cert *x509.Certificate) error {
+	if cert == nil {
+		return errNilCert
+	}
+
+	if len(cert.SubjectKeyId) != sha256.Size {
 		return errInvalidId
 	}
 
+	if err := n.checkIssuer(cert); err != nil {
+		return err
+	}
+
+	return n.checkKeyPin(cert)
+}
+
+// Certificates must be signed by our own CA or one of the trusted CAs,
+// without any CA they must be self-signed.
+func (n *Node) checkIssuer(cert *x509.Certificate) error {
+	var cas []*x509.Certificate
+
 	if caCert := n.cm.CaCertificate(); caCert != nil {
-		err := cert.CheckSignatureFrom(caCert)
-		if err != nil {
-			return err
+		cas = append(cas, caCert)
+	}
+
+	for _, c := range n.trustedCAs {
+		if c != nil {
+			cas = append(cas, c)
 		}
-	} else {
-		err := cert.CheckSignatureFrom(cert)
-		if err != nil {
-			return err
+	}
+
+	if len(cas) == 0 {
+		return cert.CheckSignatureFrom(cert)
+	}
+
+	var err error
+
+	for _, c := range cas {
+		if err = cert.CheckSignatureFrom(c); err == nil {
+			return nil
 		}
 	}
 
-	if exists := n.view.Exists(id); !exists {
-		n.view.AddFull(id, cert)
+	return err
+}
+
+// Peers with a pinned key must present a certificate for that key, whichever CA signed it.
+// Rotated certificates keep the key and pass.
+func (n *Node) checkKeyPin(cert *x509.Certificate) error {
+	pin, exists := n.keyPins[string(cert.SubjectKeyId)]
+	if !exists {
+		return nil
+	}
+
+	if !bytes.Equal(pin, KeyPin(cert)) {
+		log.Error("Rejected certificate for pinned id", "subject", cert.Subject.Locality, "issuer", cert.Issuer.CommonName)
+		return errKeyPin
 	}
 
 	return nil
 }
 
+// Returns the pin of the certificate's public key, the sha256 digest of its subject public key info.
+func KeyPin(cert *x509.Certificate) []byte {
+	digest := sha256.Sum256(cert.RawSubjectPublicKeyInfo)
+
+	return digest[:]
+}
+
 func (n *Node) validateCtx(ctx context.Context) (*x509.Certificate, error) {
 	var tlsInfo credentials.TLSInfo
 	var ok bool
@@ -500,7 +789,12 @@ func (n *Node) validateCtx(ctx context.Context) (*x509.Certificate, error) {
 		return nil, errNoCert
 	}
 
-	return tlsInfo.State.PeerCertificates[0], nil
+	cert := tlsInfo.State.PeerCertificates[0]
+
+	// Every authenticated rpc counts as hearing from the peer.
+	n.view.MarkSeen(string(cert.SubjectKeyId))
+
+	return cert, nil
 }
 
 func hashContent(data []byte) []byte {
@@ -508,3 +802,17 @@ func hashContent(data []byte) []byte {
 	h.Write(data)
 	return h.Sum(nil)
 }
+
+// Serializes sends on a server stream, replies and acknowledgements
+// are sent from different goroutines.
+type streamSender struct {
+	mutex sync.Mutex
+	srv   pb.Gossip_StreamServer
+}
+
+func (s *streamSender) send(msg *pb.MsgResponse) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	return s.srv.Send(msg)
+}
@@ -9,6 +9,7 @@ import (
 	"crypto/x509/pkix"
 	"encoding/binary"
 	"errors"
+	"io"
 	"math"
 	"math/big"
 	"net"
@@ -23,6 +24,7 @@ import (
 	"github.com/stretchr/testify/require"
 	"github.com/stretchr/testify/suite"
 	"golang.org/x/net/context"
+	"google.golang.org/grpc"
 	"google.golang.org/grpc/credentials"
 	grpcPeer "google.golang.org/grpc/peer"
 )
@@ -52,7 +54,7 @@ func (suite *HandlerTestSuite) SetupTest() {
 
 	ownCert := genCert(priv, 10)
 
-	n, err := NewNode(&commStub{}, &pingStub{}, &cmStub{cert: ownCert}, &cryptoStub{priv: priv})
+	n, err := NewNode(&commStub{}, &pingStub{}, &cmStub{cert: ownCert}, &cryptoStub{priv: priv}, testConfig())
 	require.NoError(suite.T(), err, "Failed to create node.")
 
 	suite.n = n
@@ -276,8 +278,517 @@ func (suite *HandlerTestSuite) TestSpread() {
 	}
 }
 
+func (suite *HandlerTestSuite) TestSpreadGossipDeduplication() {
+	node := suite.n
+	node.seenGossip = newSeenCache(2)
+
+	succ, prev := node.view.MyRingNeighbours(1)
+
+	var calls int
+	node.SetGossipHandler(func(data []byte) ([]byte, error) {
+		calls++
+		return data, nil
+	})
+
+	first := []byte("first")
+
+	reply, err := node.Spread(peerContext(succ), &proto.State{ExternalGossip: first})
+	require.NoError(suite.T(), err, "Spread failed with valid context.")
+	require.Equal(suite.T(), first, reply.GetExternalGossip(), "Invalid gossip response.")
+
+	reply, err = node.Spread(peerContext(succ), &proto.State{ExternalGossip: first})
+	require.NoError(suite.T(), err, "Spread failed with valid context.")
+	require.Nil(suite.T(), reply.GetExternalGossip(), "Repeated gossip got a response.")
+	require.Equal(suite.T(), 1, calls, "Handler invoked for repeated gossip.")
+
+	_, err = node.Spread(peerContext(prev), &proto.State{ExternalGossip: first})
+	require.NoError(suite.T(), err, "Spread failed with valid context.")
+	require.Equal(suite.T(), 2, calls, "Same content from another peer is a distinct entry.")
+
+	_, err = node.Spread(peerContext(succ), &proto.State{ExternalGossip: []byte("second")})
+	require.NoError(suite.T(), err, "Spread failed with valid context.")
+	require.Equal(suite.T(), 3, calls, "New content from the same peer is a distinct entry.")
+	require.Equal(suite.T(), 2, node.seenGossip.len(), "Cache grew beyond its size.")
+
+	_, err = node.Spread(peerContext(succ), &proto.State{ExternalGossip: first})
+	require.NoError(suite.T(), err, "Spread failed with valid context.")
+	require.Equal(suite.T(), 4, calls, "Evicted entry should be delivered again.")
+}
+
+func (suite *HandlerTestSuite) TestSpreadVersionedGossip() {
+	node := suite.n
+
+	succ, _ := node.view.MyRingNeighbours(1)
+	priv := suite.privMap[succ.Id]
+
+	id := "key"
+
+	entry := func(e *proto.Data) *proto.State {
+		return &proto.State{
+			Entries: []*proto.Data{e},
+		}
+	}
+
+	_, err := node.Spread(peerContext(succ), entry(signedEntry(priv, succ.Id, id, "v1", 1)))
+	require.NoError(suite.T(), err, "Spread failed with valid context.")
+
+	_, err = node.Spread(peerContext(succ), entry(signedEntry(priv, succ.Id, id, "v2", 2)))
+	require.NoError(suite.T(), err, "Spread failed with valid context.")
+
+	content, version := node.GossipContentVersioned([]byte(id))
+	require.Equal(suite.T(), []byte("v2"), content, "Higher version did not replace the content.")
+	require.Equal(suite.T(), uint64(2), version, "Invalid version.")
+
+	entries := node.collectGossipContent().GetEntries()
+	require.Len(suite.T(), entries, 1, "Accepted entry not relayed.")
+	require.Equal(suite.T(), []byte("v2"), entries[0].GetContent(), "Relayed stale content.")
+	require.NotNil(suite.T(), entries[0].GetSignature(), "Relayed without the origin signature.")
+
+	_, err = node.Spread(peerContext(succ), entry(signedEntry(priv, succ.Id, id, "old", 1)))
+	require.NoError(suite.T(), err, "Spread failed with valid context.")
+
+	_, err = node.Spread(peerContext(succ), entry(signedEntry(priv, succ.Id, id, "same", 2)))
+	require.NoError(suite.T(), err, "Spread failed with valid context.")
+
+	content, version = node.GossipContentVersioned([]byte(id))
+	require.Equal(suite.T(), []byte("v2"), content, "Lower or equal version replaced the content.")
+	require.Equal(suite.T(), uint64(2), version, "Invalid version.")
+
+	require.False(suite.T(), node.SetGossipContentVersioned([]byte(id), []byte("mine"), 10), "Accepted local version of an id published by another node.")
+
+	content, _ = node.GossipContentVersioned([]byte("unknown"))
+	require.Nil(suite.T(), content, "Content returned for unknown id.")
+}
+
+func (suite *HandlerTestSuite) TestSpreadVersionedGossipOrigin() {
+	node := suite.n
+
+	succ, prev := node.view.MyRingNeighbours(1)
+	id := "key"
+
+	_, err := node.Spread(peerContext(succ), &proto.State{
+		Entries: []*proto.Data{signedEntry(suite.privMap[succ.Id], succ.Id, id, "v1", 1)},
+	})
+	require.NoError(suite.T(), err, "Spread failed with valid context.")
+
+	unsigned := signedEntry(suite.privMap[succ.Id], succ.Id, id, "unsigned", 5)
+	unsigned.Signature = nil
+
+	forged := signedEntry(suite.privMap[prev.Id], succ.Id, id, "forged", 6)
+
+	tampered := signedEntry(suite.privMap[succ.Id], succ.Id, id, "v7", 7)
+	tampered.Content = []byte("tampered")
+
+	// Validly signed, but only the origin of the id may publish new versions.
+	taken := signedEntry(suite.privMap[prev.Id], prev.Id, id, "taken", 8)
+
+	own := signedEntry(suite.privMap[succ.Id], node.self.Id, "ownKey", "v1", 1)
+
+	_, err = node.Spread(peerContext(prev), &proto.State{
+		Entries: []*proto.Data{unsigned, forged, tampered, taken, own},
+	})
+	require.NoError(suite.T(), err, "Spread failed with valid context.")
+
+	content, version := node.GossipContentVersioned([]byte(id))
+	require.Equal(suite.T(), []byte("v1"), content, "Entry replaced by another node.")
+	require.Equal(suite.T(), uint64(1), version, "Invalid version.")
+
+	content, _ = node.GossipContentVersioned([]byte("ownKey"))
+	require.Nil(suite.T(), content, "Accepted an entry on our behalf.")
+}
+
+func (suite *HandlerTestSuite) TestPullVersionedGossip() {
+	node := suite.n
+
+	var p *discovery.Peer
+	for _, peer := range node.view.Full() {
+		p = peer
+		break
+	}
+
+	require.True(suite.T(), node.SetGossipContentVersioned([]byte("key"), []byte("v1"), 1), "Rejected first version.")
+
+	reply, err := node.Pull(peerContext(p), &proto.State{ExistingHosts: node.view.State().GetExistingHosts()})
+	require.NoError(suite.T(), err, "Pull failed with valid context.")
+	require.Len(suite.T(), reply.GetEntries(), 1, "Versioned entries left out of the pull reply.")
+	require.Equal(suite.T(), []byte("v1"), reply.GetEntries()[0].GetContent(), "Invalid entry content.")
+	require.Equal(suite.T(), []byte(node.self.Id), reply.GetEntries()[0].GetOrigin(), "Entry does not carry its origin.")
+}
+
+func (suite *HandlerTestSuite) TestSpreadRateLimit() {
+	node := suite.n
+	node.gossipLimiter = newRateLimiter(1, 3)
+
+	flooder, wellBehaved := node.view.MyRingNeighbours(1)
+
+	for i := 0; i < 3; i++ {
+		_, err := node.Spread(peerContext(flooder), &proto.State{})
+		require.NoError(suite.T(), err, "Gossip within burst rejected.")
+	}
+
+	_, err := node.Spread(peerContext(flooder), &proto.State{})
+	require.EqualError(suite.T(), err, errRateLimited.Error(), "Gossip above rate accepted.")
+
+	_, err = node.Pull(peerContext(flooder), &proto.State{})
+	require.EqualError(suite.T(), err, errRateLimited.Error(), "Pull above rate accepted.")
+
+	_, err = node.Spread(peerContext(wellBehaved), &proto.State{})
+	require.NoError(suite.T(), err, "Well-behaved peer throttled.")
+
+	now := time.Now()
+	require.False(suite.T(), node.gossipLimiter.allowAt(flooder.Id, now), "Bucket refilled early.")
+	require.True(suite.T(), node.gossipLimiter.allowAt(flooder.Id, now.Add(time.Second*2)), "Bucket not refilled.")
+
+	node.gossipLimiter = newRateLimiter(0, 0)
+	for i := 0; i < 10; i++ {
+		_, err := node.Spread(peerContext(flooder), &proto.State{})
+		require.NoError(suite.T(), err, "Gossip rejected without a limit.")
+	}
+}
+
+func (suite *HandlerTestSuite) TestDropHandler() {
+	node := suite.n
+	node.gossipLimiter = newRateLimiter(1, 1)
+
+	type drop struct {
+		kind DropKind
+		id   []byte
+	}
+
+	drops := make(chan drop, 10)
+	node.SetDropHandler(func(kind DropKind, id []byte) {
+		drops <- drop{kind: kind, id: id}
+	})
+
+	node.wg.Add(1)
+	go node.dropLoop()
+	defer close(node.exitChan)
+
+	flooder, _ := node.view.MyRingNeighbours(1)
+
+	_, err := node.Spread(peerContext(flooder), &proto.State{})
+	require.NoError(suite.T(), err, "Gossip within burst rejected.")
+	require.Zero(suite.T(), node.Stats().DroppedGossip, "Accepted gossip counted as dropped.")
+
+	_, err = node.Spread(peerContext(flooder), &proto.State{})
+	require.EqualError(suite.T(), err, errRateLimited.Error(), "Gossip above rate accepted.")
+	require.Equal(suite.T(), uint64(1), node.Stats().DroppedGossip, "Rate limited gossip not counted.")
+
+	select {
+	case d := <-drops:
+		require.Equal(suite.T(), DroppedGossip, d.kind, "Wrong drop kind.")
+		require.Equal(suite.T(), []byte(flooder.Id), d.id, "Drop not attributed to the sender.")
+	case <-time.After(time.Second):
+		suite.T().Fatal("Drop handler not invoked.")
+	}
+
+	errorHandler := node.fd.ps.(*pingStub).errorHandler
+	require.NotNil(suite.T(), errorHandler, "Udp error handler not set.")
+
+	errorHandler(false)
+	errorHandler(true)
+	errorHandler(true)
+
+	s := node.Stats()
+	require.Equal(suite.T(), uint64(1), s.UdpReadErrors, "Udp read error not counted.")
+	require.Equal(suite.T(), uint64(2), s.UdpWriteErrors, "Udp write errors not counted.")
+
+	var kinds []DropKind
+	for i := 0; i < 3; i++ {
+		select {
+		case d := <-drops:
+			require.Nil(suite.T(), d.id, "Udp error attributed to a peer.")
+			kinds = append(kinds, d.kind)
+		case <-time.After(time.Second):
+			suite.T().Fatal("Drop handler not invoked.")
+		}
+	}
+	require.Equal(suite.T(), []DropKind{UdpReadError, UdpWriteError, UdpWriteError}, kinds, "Drops not reported in order.")
+
+	node.streamFrameDropped([]byte(flooder.Id))()
+	require.Equal(suite.T(), uint64(1), node.Stats().DroppedStreamFrames, "Stream frame drop not counted.")
+
+	select {
+	case d := <-drops:
+		require.Equal(suite.T(), DroppedStreamFrame, d.kind, "Wrong drop kind.")
+		require.Equal(suite.T(), []byte(flooder.Id), d.id, "Stream drop not attributed to the stream's peer.")
+	case <-time.After(time.Second):
+		suite.T().Fatal("Drop handler not invoked.")
+	}
+}
+
+func (suite *HandlerTestSuite) TestDropQueueFull() {
+	node := suite.n
+
+	node.SetDropHandler(func(kind DropKind, id []byte) {})
+
+	// Nothing drains the queue until the loop starts.
+	for i := 0; i < dropQueueSize+10; i++ {
+		node.udpError(false)
+	}
+
+	require.Equal(suite.T(), uint64(dropQueueSize+10), node.Stats().UdpReadErrors, "Drops beyond the queue not counted.")
+	require.Len(suite.T(), node.drops, dropQueueSize, "Queue grew beyond its size.")
+}
+
+func (suite *HandlerTestSuite) TestObserver() {
+	node := suite.n
+
+	priv, err := genKeys()
+	require.NoError(suite.T(), err, "Failed to generate keys.")
+
+	c := genCert(priv, node.view.NumRings())
+	id := string(c.SubjectKeyId)
+
+	require.NoError(suite.T(), node.view.AddFull(id, c), "Failed to add peer.")
+	observer := node.view.Peer(id)
+
+	err = node.evalNote(discovery.NewObserverNote(id, 1, math.MaxUint32, priv))
+	require.NoError(suite.T(), err, "Rejected observer note.")
+	require.True(suite.T(), observer.IsObserver(), "Observer note not stored.")
+	require.False(suite.T(), node.view.IsAlive(id), "Observer added to the live view.")
+
+	// A live peer restarting as an observer leaves the rings.
+	succ, _ := node.view.MyRingNeighbours(1)
+	err = node.evalNote(discovery.NewObserverNote(succ.Id, 2, math.MaxUint32, suite.privMap[succ.Id]))
+	require.NoError(suite.T(), err, "Rejected observer note.")
+	require.False(suite.T(), node.view.IsAlive(succ.Id), "Observer kept in the live view.")
+
+	monitors, monitoring := node.view.MonitorAssignment()
+	for _, p := range append(monitors, monitoring...) {
+		require.NotEqual(suite.T(), id, p.Id, "Observer chosen as monitor.")
+		require.NotEqual(suite.T(), succ.Id, p.Id, "Observer chosen as monitor.")
+	}
+
+	for _, p := range node.view.Live() {
+		require.NotEqual(suite.T(), id, p.Id, "Observer in the live view.")
+	}
+
+	// Observers are answered like pulls.
+	reply, err := node.Spread(peerContext(observer), &proto.State{
+		ExistingHosts:  map[string]uint64{},
+		OwnNote:        discovery.NewObserverNote(id, 2, math.MaxUint32, priv),
+		ExternalGossip: []byte("ignored"),
+	})
+	require.NoError(suite.T(), err, "Gossip from observer rejected.")
+	require.Len(suite.T(), reply.GetCertificates(), len(node.view.Full())+1, "Observer not given the full view and our certificate.")
+	require.Nil(suite.T(), reply.GetExternalGossip(), "Application gossip from observer handled.")
+	require.Equal(suite.T(), uint64(2), observer.Note().Epoch(), "Observer note not updated.")
+	require.False(suite.T(), node.view.IsAlive(id), "Observer added to the live view.")
+}
+
+func (suite *HandlerTestSuite) TestSpreadGossipBatch() {
+	node := suite.n
+
+	succ, _ := node.view.MyRingNeighbours(1)
+
+	for _, i := range []string{"0", "1", "2"} {
+		node.EnqueueGossip([]byte("id-"+i), []byte("data-"+i))
+	}
+
+	msg := node.collectPushContent()
+	require.Len(suite.T(), msg.GetBatch(), 3, "Enqueued payloads not batched.")
+	require.Empty(suite.T(), node.collectPushContent().GetBatch(), "Batch not drained.")
+
+	var received []string
+	node.SetBatchGossipHandler(func(id, data []byte) {
+		received = append(received, string(id)+":"+string(data))
+	})
+
+	_, err := node.Spread(peerContext(succ), msg)
+	require.NoError(suite.T(), err, "Spread failed with valid context.")
+	require.Equal(suite.T(), []string{"id-0:data-0", "id-1:data-1", "id-2:data-2"}, received, "Batch not delivered in order.")
+
+	received = nil
+
+	node.Spread(peerContext(nonNeighbouringPeers(node, 1)[0]), msg)
+	require.Empty(suite.T(), received, "Batch from non-neighbour delivered.")
+}
+
 func (suite *HandlerTestSuite) TestMessenger() {
+	node := suite.n
+
+	sender := node.view.Full()[0]
+	content := []byte("content")
+
+	var receivedId []byte
+	node.SetMsgHandlerWithSender(func(senderId, data []byte) ([]byte, error) {
+		receivedId = senderId
+		return data, nil
+	})
+
+	reply, err := node.Messenger(peerContext(sender), &proto.Msg{Content: content})
+	require.NoError(suite.T(), err, "Messenger failed with valid context.")
+	require.Equal(suite.T(), content, reply.GetContent(), "Invalid reply content.")
+	require.Equal(suite.T(), sender.Id, string(receivedId), "Handler did not receive the sender id.")
+
+	node.SetMsgHandler(func(data []byte) ([]byte, error) {
+		return nil, nil
+	})
+
+	reply, err = node.Messenger(peerContext(sender), &proto.Msg{Content: content})
+	require.NoError(suite.T(), err, "Messenger failed with valid context.")
+	require.Nil(suite.T(), reply.GetContent(), "Sender handler not replaced by message handler.")
+
+	_, err = node.Messenger(noCertPeerContext(sender), &proto.Msg{Content: content})
+	require.EqualError(suite.T(), err, errNoCert.Error(), "Should fail without peer certificate.")
+}
+
+func (suite *HandlerTestSuite) TestStreamAck() {
+	node := suite.n
+
+	consumed := make(chan []byte, 10)
+	node.SetStreamHandler(func(input, reply chan []byte) {
+		for content := range input {
+			consumed <- content
+		}
+		close(reply)
+	})
+
+	stream := newStreamStub(peerContext(node.view.Full()[0]))
+
+	done := make(chan error)
+	go func() {
+		done <- node.Stream(stream)
+	}()
+
+	stream.msgs <- &proto.Msg{Content: []byte("first"), Seq: 1}
+
+	ack := suite.nextResponse(stream)
+	require.Equal(suite.T(), uint64(1), ack.GetAck(), "Wrong acknowledgement.")
+	require.Nil(suite.T(), ack.GetContent(), "Acknowledgement should not carry content.")
+	require.Len(suite.T(), consumed, 1, "Acknowledged before the handler consumed the message.")
+
+	stream.msgs <- &proto.Msg{Content: []byte("unsequenced")}
+	stream.msgs <- &proto.Msg{Content: []byte("second"), Seq: 2}
+
+	ack = suite.nextResponse(stream)
+	require.Equal(suite.T(), uint64(2), ack.GetAck(), "Unsequenced message should not be acknowledged.")
+	require.Len(suite.T(), consumed, 3, "Handler did not consume all messages.")
+
+	close(stream.msgs)
+	require.NoError(suite.T(), <-done, "Stream failed.")
+}
 
+func (suite *HandlerTestSuite) TestStreamAccept() {
+	node := suite.n
+
+	sender := node.view.Full()[0]
+	errRejected := errors.New("rejected")
+
+	var receivedId []byte
+	handled := make(chan struct{})
+
+	node.SetStreamHandlerWithSender(func(senderId []byte) (func(chan []byte, chan []byte), error) {
+		receivedId = senderId
+		return nil, errRejected
+	})
+
+	err := node.Stream(newStreamStub(peerContext(sender)))
+	require.EqualError(suite.T(), err, errRejected.Error(), "Rejected stream did not fail.")
+	require.Equal(suite.T(), sender.Id, string(receivedId), "Handler did not receive the sender id.")
+
+	node.SetStreamHandlerWithSender(func(senderId []byte) (func(chan []byte, chan []byte), error) {
+		return func(input, reply chan []byte) {
+			close(handled)
+			close(reply)
+		}, nil
+	})
+
+	stream := newStreamStub(peerContext(sender))
+	close(stream.msgs)
+	require.NoError(suite.T(), node.Stream(stream), "Accepted stream failed.")
+
+	select {
+	case <-handled:
+	case <-time.After(time.Second):
+		suite.T().Fatal("Accepted stream was not handled.")
+	}
+
+	err = node.Stream(newStreamStub(noCertPeerContext(sender)))
+	require.EqualError(suite.T(), err, errNoCert.Error(), "Should fail without peer certificate.")
+}
+
+func (suite *HandlerTestSuite) nextResponse(stream *streamStub) *proto.MsgResponse {
+	select {
+	case resp := <-stream.sent:
+		return resp
+	case <-time.After(time.Second):
+		suite.T().Fatal("Timed out waiting for stream response.")
+	}
+
+	return nil
+}
+
+func (suite *HandlerTestSuite) TestPull() {
+	node := suite.n
+
+	full := node.view.Full()
+	requester := full[0]
+
+	reply, err := node.Pull(peerContext(requester), &proto.State{})
+	require.NoError(suite.T(), err, "Pull failed with valid context.")
+	require.Equal(suite.T(), len(full), len(reply.GetCertificates()), "Empty digest should get all certificates.")
+
+	digest := make(map[string]uint64)
+	for _, p := range full {
+		digest[p.Id] = p.Note().ToPbMsg().GetEpoch()
+	}
+	digest[node.self.Id] = node.self.Note().ToPbMsg().GetEpoch()
+
+	reply, err = node.Pull(peerContext(requester), &proto.State{ExistingHosts: digest})
+	require.NoError(suite.T(), err, "Pull failed with valid context.")
+	require.Empty(suite.T(), reply.GetCertificates(), "Up to date digest should get no certificates.")
+	require.Empty(suite.T(), reply.GetNotes(), "Up to date digest should get no notes.")
+
+	_, err = node.Pull(noCertPeerContext(requester), &proto.State{})
+	require.EqualError(suite.T(), err, errNoCert.Error(), "Should fail without peer certificate.")
+}
+
+func (suite *HandlerTestSuite) TestViewSnapshot() {
+	node := suite.n
+
+	full := node.view.Full()
+
+	dead := full[0]
+	node.view.RemoveLive(dead.Id)
+
+	accused := full[1]
+	accused.AddTestAccusation(discovery.NewAccusation(1, accused.Id, node.self.Id, 1, suite.priv))
+
+	noNote := full[2]
+	noNote.ClearNote()
+
+	snapshot := node.ViewSnapshot()
+	require.Equal(suite.T(), len(full), len(snapshot), "Snapshot should contain the full view.")
+
+	for _, info := range snapshot {
+		p := node.view.Peer(info.Id)
+		require.NotNil(suite.T(), p, "Snapshot contains unknown peer.")
+		require.Equal(suite.T(), p.Addr, info.Addr, "Invalid address.")
+		require.Equal(suite.T(), info.Id != dead.Id, info.Live, "Invalid liveness.")
+		require.Equal(suite.T(), info.Id == accused.Id, info.Accused, "Invalid accusation state.")
+
+		if info.Id == noNote.Id {
+			require.Zero(suite.T(), info.Epoch, "Peer without note should have zero epoch.")
+		} else {
+			require.Equal(suite.T(), uint64(1), info.Epoch, "Invalid note epoch.")
+		}
+	}
+}
+
+func (suite *HandlerTestSuite) TestIdToCertificate() {
+	node := suite.n
+
+	p := node.view.Full()[0]
+
+	cert, err := node.IdToCertificate([]byte(p.Id))
+	require.NoError(suite.T(), err, "Failed to find certificate of known peer.")
+	require.Equal(suite.T(), p.Certificate(), cert.Raw, "Returned wrong certificate.")
+	require.Equal(suite.T(), p.PublicKey(), cert.PublicKey, "Certificate public key does not match peer.")
+
+	_, err = node.IdToCertificate([]byte("non-existing-id"))
+	require.EqualError(suite.T(), err, errPeerNotFound.Error(), "Should return error on unknown id.")
 }
 
 func (suite *HandlerTestSuite) TestMergeViews() {
@@ -528,6 +1039,77 @@ func (suite *HandlerTestSuite) TestEvalAccusation() {
 
 }
 
+func (suite *HandlerTestSuite) TestAccusationHandler() {
+	var ringNum uint32 = 1
+
+	node := suite.n
+	selfId := node.self.Id
+
+	succ, prev := node.view.MyRingNeighbours(ringNum)
+
+	type accused struct {
+		accuserId []byte
+		ringNum   uint32
+	}
+
+	ch := make(chan accused, 2)
+	node.SetAccusationHandler(func(accuserId []byte, ringNum uint32) {
+		ch <- accused{accuserId: accuserId, ringNum: ringNum}
+	})
+
+	acc := discovery.NewAccusation(1, selfId, succ.Id, ringNum, suite.privMap[succ.Id])
+	require.Equal(suite.T(), errInvalidAccuser, node.evalAccusation(acc, succ, node.self), "Accepted invalid accuser.")
+
+	acc = discovery.NewAccusation(1, selfId, prev.Id, ringNum, suite.privMap[prev.Id])
+	require.NoError(suite.T(), node.evalAccusation(acc, prev, node.self), "Valid accusation failed.")
+
+	select {
+	case a := <-ch:
+		require.Equal(suite.T(), prev.Id, string(a.accuserId), "Handler given wrong accuser.")
+		require.Equal(suite.T(), ringNum, a.ringNum, "Handler given wrong ring.")
+	case <-time.After(time.Second):
+		suite.T().Fatal("Handler not invoked on accusation.")
+	}
+
+	acc = discovery.NewAccusation(1, selfId, prev.Id, ringNum, suite.privMap[prev.Id])
+	require.Equal(suite.T(), errInvalidSelfAccusation, node.evalAccusation(acc, prev, node.self), "Accepted rebutted accusation.")
+
+	select {
+	case <-ch:
+		suite.T().Fatal("Handler invoked for invalid accusations.")
+	case <-time.After(time.Millisecond * 50):
+	}
+}
+
+func (suite *HandlerTestSuite) TestEvalLeavingNote() {
+	node := suite.n
+
+	mask := uint32(math.MaxUint32)
+
+	live := node.view.Live()
+	peer := live[0]
+	other := live[1]
+
+	invalid := discovery.NewLeavingNote(peer.Id, 2, mask, suite.privMap[other.Id])
+	require.Equal(suite.T(), errInvalidSignature, node.evalNote(invalid), "Accepted leaving note with invalid signature.")
+	require.True(suite.T(), node.view.IsAlive(peer.Id), "Peer removed on invalid leaving note.")
+
+	acc := discovery.NewAccusation(1, peer.Id, other.Id, 1, suite.privMap[other.Id])
+	peer.AddTestAccusation(acc)
+	require.NoError(suite.T(), node.view.StartTimer(peer, peer.Note(), other), "Failed to start timer.")
+
+	leaving := discovery.NewLeavingNote(peer.Id, 2, mask, suite.privMap[peer.Id])
+	require.NoError(suite.T(), node.evalNote(leaving), "Valid leaving note failed.")
+	require.False(suite.T(), node.view.IsAlive(peer.Id), "Leaving peer still live.")
+	require.False(suite.T(), node.view.HasTimer(peer.Id), "Leaving peer still has a timeout.")
+	require.True(suite.T(), peer.Note().IsLeaving(), "Leaving note not stored.")
+	require.Equal(suite.T(), uint64(2), peer.Note().Epoch(), "Leaving note not stored.")
+
+	stale := discovery.NewNote(peer.Id, 2, mask, suite.privMap[peer.Id])
+	require.Equal(suite.T(), errOldNote, node.evalNote(stale), "Accepted stale note after leaving.")
+	require.False(suite.T(), node.view.IsAlive(peer.Id), "Stale note re-added leaving peer.")
+}
+
 func (suite *HandlerTestSuite) TestEvalNote() {
 	node := suite.n
 
@@ -745,6 +1327,159 @@ func (suite *HandlerTestSuite) TestEvalCertificate() {
 	}
 }
 
+func (suite *HandlerTestSuite) TestEvalCertificateTrustedCAs() {
+	caPriv, err := genKeys()
+	require.NoError(suite.T(), err, "Failed to generate keys")
+	caCert := genCert(caPriv, 10)
+
+	otherCaPriv, err := genKeys()
+	require.NoError(suite.T(), err, "Failed to generate keys")
+	otherCaCert := genCert(otherCaPriv, 10)
+
+	priv, err := genKeys()
+	require.NoError(suite.T(), err, "Failed to generate keys")
+
+	cm := &cmStub{cert: caSignedCert(priv, caCert, caPriv), ca: caCert}
+
+	untrusted, err := NewNode(&commStub{}, &pingStub{}, cm, &cryptoStub{priv: priv}, testConfig())
+	require.NoError(suite.T(), err, "Failed to create node.")
+
+	conf := testConfig()
+	conf.TrustedCAs = []*x509.Certificate{otherCaCert}
+
+	trusted, err := NewNode(&commStub{}, &pingStub{}, cm, &cryptoStub{priv: priv}, conf)
+	require.NoError(suite.T(), err, "Failed to create node.")
+
+	peerPriv, err := genKeys()
+	require.NoError(suite.T(), err, "Failed to generate keys")
+
+	ownCaCert := caSignedCert(peerPriv, caCert, caPriv)
+	otherCert := caSignedCert(peerPriv, otherCaCert, otherCaPriv)
+	selfCert := genCert(peerPriv, 10)
+
+	for _, n := range []*Node{untrusted, trusted} {
+		require.NoError(suite.T(), n.evalCertificate(ownCaCert), "Rejected certificate from own CA.")
+		require.Error(suite.T(), n.evalCertificate(selfCert), "Accepted self-signed certificate with a CA.")
+	}
+
+	require.Error(suite.T(), untrusted.evalCertificate(otherCert), "Accepted certificate from untrusted CA.")
+	require.False(suite.T(), untrusted.view.Exists(string(otherCert.SubjectKeyId)), "Certificate from untrusted CA added to view.")
+
+	require.NoError(suite.T(), trusted.evalCertificate(otherCert), "Rejected certificate from trusted CA.")
+	require.True(suite.T(), trusted.view.Exists(string(otherCert.SubjectKeyId)), "Certificate from trusted CA not added to view.")
+}
+
+func (suite *HandlerTestSuite) TestEvalCertificateKeyPins() {
+	caPriv, err := genKeys()
+	require.NoError(suite.T(), err, "Failed to generate keys")
+	caCert := genCert(caPriv, 10)
+
+	priv, err := genKeys()
+	require.NoError(suite.T(), err, "Failed to generate keys")
+
+	peerPriv, err := genKeys()
+	require.NoError(suite.T(), err, "Failed to generate keys")
+
+	roguePriv, err := genKeys()
+	require.NoError(suite.T(), err, "Failed to generate keys")
+
+	id := genId()
+
+	pinned := caSignedCertWithId(peerPriv, id, caCert, caPriv)
+	rotated := caSignedCertWithId(peerPriv, id, caCert, caPriv)
+	rogue := caSignedCertWithId(roguePriv, id, caCert, caPriv)
+	unpinned := caSignedCert(roguePriv, caCert, caPriv)
+
+	conf := testConfig()
+	conf.KeyPins = map[string][]byte{string(id): KeyPin(pinned)}
+
+	n, err := NewNode(&commStub{}, &pingStub{}, &cmStub{cert: caSignedCert(priv, caCert, caPriv), ca: caCert}, &cryptoStub{priv: priv}, conf)
+	require.NoError(suite.T(), err, "Failed to create node.")
+
+	require.EqualError(suite.T(), n.evalCertificate(rogue), errKeyPin.Error(), "Accepted certificate with a mismatched pin.")
+	require.False(suite.T(), n.view.Exists(string(id)), "Certificate with a mismatched pin added to view.")
+
+	require.NoError(suite.T(), n.evalCertificate(pinned), "Rejected certificate matching its pin.")
+	require.NoError(suite.T(), n.evalCertificate(rotated), "Rejected rotated certificate with the pinned key.")
+
+	require.EqualError(suite.T(), n.evalCertificate(rogue), errKeyPin.Error(), "Accepted certificate with a mismatched pin.")
+	require.NotEqual(suite.T(), rogue.Raw, n.view.Peer(string(id)).Certificate(), "Pinned peer certificate replaced.")
+
+	require.NoError(suite.T(), n.evalCertificate(unpinned), "Rejected certificate without a pin.")
+}
+
+func (suite *HandlerTestSuite) TestSendToHost() {
+	caPriv, err := genKeys()
+	require.NoError(suite.T(), err, "Failed to generate keys")
+	caCert := genCert(caPriv, 10)
+
+	otherCaPriv, err := genKeys()
+	require.NoError(suite.T(), err, "Failed to generate keys")
+	otherCaCert := genCert(otherCaPriv, 10)
+
+	priv, err := genKeys()
+	require.NoError(suite.T(), err, "Failed to generate keys")
+
+	hostPriv, err := genKeys()
+	require.NoError(suite.T(), err, "Failed to generate keys")
+
+	comm := &certCommStub{}
+
+	n, err := NewNode(comm, &pingStub{}, &cmStub{cert: caSignedCert(priv, caCert, caPriv), ca: caCert}, &cryptoStub{priv: priv}, testConfig())
+	require.NoError(suite.T(), err, "Failed to create node.")
+
+	comm.cert = caSignedCert(hostPriv, caCert, caPriv)
+	reply, err := n.SendToHost(context.Background(), "127.0.0.1:8000", []byte("data"))
+	require.NoError(suite.T(), err, "Rejected certificate signed by our CA.")
+	require.Equal(suite.T(), []byte("data"), reply, "Wrong response.")
+	require.False(suite.T(), n.view.Exists(string(comm.cert.SubjectKeyId)), "Verified host added to view.")
+
+	comm.cert = caSignedCert(hostPriv, otherCaCert, otherCaPriv)
+	_, err = n.SendToHost(context.Background(), "127.0.0.1:8000", []byte("data"))
+	require.True(suite.T(), errors.Is(err, ErrPeerCert), "Accepted certificate signed by another CA.")
+
+	comm.cert = nil
+	_, err = n.SendToHost(context.Background(), "127.0.0.1:8000", []byte("data"))
+	require.True(suite.T(), errors.Is(err, ErrPeerCert), "Accepted missing certificate.")
+	require.Zero(suite.T(), n.InFlight(), "Slot not released.")
+}
+
+func (suite *HandlerTestSuite) TestEvalCertificateIdConflict() {
+	priv, err := genKeys()
+	require.NoError(suite.T(), err, "Failed to generate keys")
+
+	n, err := NewNode(&commStub{}, &pingStub{}, &cmStub{cert: genCert(priv, 10)}, &cryptoStub{priv: priv}, testConfig())
+	require.NoError(suite.T(), err, "Failed to create node.")
+
+	peerPriv, err := genKeys()
+	require.NoError(suite.T(), err, "Failed to generate keys")
+
+	otherPriv, err := genKeys()
+	require.NoError(suite.T(), err, "Failed to generate keys")
+
+	known := genCert(peerPriv, 10)
+	require.NoError(suite.T(), n.evalCertificate(known), "Failed to add certificate.")
+
+	otherKey := genCert(otherPriv, 10)
+	otherKey.SubjectKeyId = known.SubjectKeyId
+	otherKey = renewCert(otherPriv, otherKey)
+
+	otherAddrs := *known
+	otherAddrs.RawSubject = nil
+	otherAddrs.Subject.Locality = []string{"127.0.0.1:9000", "otherPingAddr", "otherHttpAddr"}
+	moved := renewCert(peerPriv, &otherAddrs)
+
+	for _, c := range []*x509.Certificate{otherKey, moved} {
+		require.EqualError(suite.T(), n.evalCertificate(c), errIdConflict.Error(), "Accepted certificate of another host with a known id.")
+	}
+
+	require.Equal(suite.T(), known.Raw, n.view.Peer(string(known.SubjectKeyId)).Certificate(), "Known peer overwritten.")
+	require.Equal(suite.T(), uint64(2), n.Stats().IdConflicts, "Conflicts not counted.")
+
+	require.NoError(suite.T(), n.evalCertificate(renewCert(peerPriv, known)), "Rejected renewed certificate.")
+	require.Equal(suite.T(), uint64(2), n.Stats().IdConflicts, "Renewal counted as a conflict.")
+}
+
 func (suite *HandlerTestSuite) TestValidateCtx() {
 	node := suite.n
 
@@ -849,6 +1584,42 @@ func invalidCertPeerContext(p *discovery.Peer) context.Context {
 	return grpcPeer.NewContext(context.Background(), authInfo)
 }
 
+// In-memory server side of a stream, feeds the given messages to the node
+// and records what it sends back.
+type streamStub struct {
+	grpc.ServerStream
+
+	ctx  context.Context
+	msgs chan *proto.Msg
+	sent chan *proto.MsgResponse
+}
+
+func newStreamStub(ctx context.Context) *streamStub {
+	return &streamStub{
+		ctx:  ctx,
+		msgs: make(chan *proto.Msg),
+		sent: make(chan *proto.MsgResponse, 10),
+	}
+}
+
+func (ss *streamStub) Context() context.Context {
+	return ss.ctx
+}
+
+func (ss *streamStub) Recv() (*proto.Msg, error) {
+	msg, ok := <-ss.msgs
+	if !ok {
+		return nil, io.EOF
+	}
+
+	return msg, nil
+}
+
+func (ss *streamStub) Send(resp *proto.MsgResponse) error {
+	ss.sent <- resp
+	return nil
+}
+
 func nonNeighbouringPeers(n *Node, amount int) []*discovery.Peer {
 	var fetched []*discovery.Peer
 
@@ -899,6 +1670,30 @@ func checkPeerPlacement(n *Node, peers []*discovery.Peer) []*discovery.Peer {
 	return invalid
 }
 
+// Versioned entry claiming the given origin, signed with the given key.
+func signedEntry(priv *ecdsa.PrivateKey, origin, id, content string, version uint64) *proto.Data {
+	e := &proto.Data{
+		Id:      []byte(id),
+		Content: []byte(content),
+		Version: version,
+		Origin:  []byte(origin),
+	}
+
+	b, err := gossipEntryBytes(e)
+	if err != nil {
+		panic(err)
+	}
+
+	r, s, err := (&cryptoStub{priv: priv}).Sign(b)
+	if err != nil {
+		panic(err)
+	}
+
+	e.Signature = &proto.Signature{R: r, S: s}
+
+	return e
+}
+
 func addPeer(node *Node) (*discovery.Peer, *ecdsa.PrivateKey, error) {
 	privKey, err := genKeys()
 	if err != nil {
@@ -936,6 +1731,79 @@ func genCert(priv *ecdsa.PrivateKey, rings uint32) *x509.Certificate {
 	return c
 }
 
+// Same as genCert, but the certificate carries the given zone label.
+func genZonedCert(priv *ecdsa.PrivateKey, zone string) *x509.Certificate {
+	pk := pkix.Name{
+		Locality:           []string{"127.0.0.1:8000", "pingAddr", "httpAddr"},
+		OrganizationalUnit: []string{zone},
+	}
+
+	c, err := selfSignedCert(priv, pk)
+	if err != nil {
+		panic(err)
+	}
+
+	return c
+}
+
+func caSignedCert(priv *ecdsa.PrivateKey, caCert *x509.Certificate, caPriv *ecdsa.PrivateKey) *x509.Certificate {
+	return caSignedCertWithId(priv, genId(), caCert, caPriv)
+}
+
+func caSignedCertWithId(priv *ecdsa.PrivateKey, id []byte, caCert *x509.Certificate, caPriv *ecdsa.PrivateKey) *x509.Certificate {
+	serial, err := genSerialNumber()
+	if err != nil {
+		panic(err)
+	}
+
+	template := &x509.Certificate{
+		SerialNumber: serial,
+		SubjectKeyId: id,
+		Subject: pkix.Name{
+			Locality: []string{"127.0.0.1:8000", "pingAddr", "httpAddr"},
+		},
+		NotBefore: time.Now().AddDate(-10, 0, 0),
+		NotAfter:  time.Now().AddDate(10, 0, 0),
+		KeyUsage:  x509.KeyUsageDigitalSignature,
+	}
+
+	signed, err := x509.CreateCertificate(rand.Reader, template, caCert, priv.Public(), caPriv)
+	if err != nil {
+		panic(err)
+	}
+
+	c, err := x509.ParseCertificate(signed)
+	if err != nil {
+		panic(err)
+	}
+
+	return c
+}
+
+// Returns a self-signed copy of the given certificate which expires later.
+func renewCert(priv *ecdsa.PrivateKey, c *x509.Certificate) *x509.Certificate {
+	serial, err := genSerialNumber()
+	if err != nil {
+		panic(err)
+	}
+
+	template := *c
+	template.SerialNumber = serial
+	template.NotAfter = c.NotAfter.Add(time.Hour)
+
+	signed, err := x509.CreateCertificate(rand.Reader, &template, &template, priv.Public(), priv)
+	if err != nil {
+		panic(err)
+	}
+
+	renewed, err := x509.ParseCertificate(signed)
+	if err != nil {
+		panic(err)
+	}
+
+	return renewed
+}
+
 func genInvalidSignatureCert(priv *ecdsa.PrivateKey, rings uint32) *x509.Certificate {
 	pk := pkix.Name{
 		Locality: []string{"127.0.0.1:8000", "pingAddr", "httpAddr"},
@@ -1009,7 +1877,7 @@ func selfSignedCert(priv *ecdsa.PrivateKey, pk pkix.Name) (*x509.Certificate, er
 		NotBefore:             time.Now().AddDate(-10, 0, 0),
 		NotAfter:              time.Now().AddDate(10, 0, 0),
 		ExtraExtensions:       []pkix.Extension{ext},
-		PublicKey:             priv.PublicKey,
+		PublicKey:             &priv.PublicKey,
 		IPAddresses:           []net.IP{ip},
 		IsCA:                  true,
 		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth,
@@ -0,0 +1,86 @@
+package core
+
+import (
+	"fmt"
+	"time"
+)
+
+const (
+	// Gossip intervals without a completed round before the node is unhealthy, when none are configured.
+	defaultHealthMissedRounds = 3
+
+	// How long the live view may stay empty while other peers are known, when no timeout is configured.
+	defaultHealthIsolationTimeout = time.Minute * 5
+)
+
+// Records when the live view last became empty, zero while it has members.
+func (n *Node) updateIsolation(now time.Time) {
+	n.healthMutex.Lock()
+	defer n.healthMutex.Unlock()
+
+	if len(n.view.Live()) > 0 {
+		n.isolatedSince = time.Time{}
+	} else if n.isolatedSince.IsZero() {
+		n.isolatedSince = now
+	}
+}
+
+func (n *Node) getIsolatedSince() time.Time {
+	n.healthMutex.Lock()
+	defer n.healthMutex.Unlock()
+
+	return n.isolatedSince
+}
+
+func (n *Node) getStartedAt() time.Time {
+	n.healthMutex.Lock()
+	defer n.healthMutex.Unlock()
+
+	return n.startedAt
+}
+
+// Returns false, with the reason, if the node is not running, has not completed a gossip round
+// within the configured number of gossip intervals, has a certificate or CA certificate outside its
+// validity period, or has had no live peers for longer than the isolation timeout while knowing of others.
+func (n *Node) Healthy() (bool, string) {
+	if !n.running() {
+		return false, "node is not running"
+	}
+
+	now := n.clock.Now()
+
+	rounds := n.healthMissedRounds
+	if rounds == 0 {
+		rounds = defaultHealthMissedRounds
+	}
+
+	// Rounds end within their deadline, so a round in progress is given its deadline as well.
+	last := n.stats.lastGossipRound()
+	if last.IsZero() {
+		last = n.getStartedAt()
+	}
+
+	if deadline := time.Duration(rounds)*n.getGossipTimeout() + n.roundTimeout(); now.Sub(last) > deadline {
+		return false, fmt.Sprintf("no gossip round completed within %d gossip intervals", rounds)
+	}
+
+	if cert := n.cm.Certificate(); now.After(cert.NotAfter) || now.Before(cert.NotBefore) {
+		return false, "certificate is outside its validity period"
+	}
+
+	if ca := n.cm.CaCertificate(); ca != nil && (now.After(ca.NotAfter) || now.Before(ca.NotBefore)) {
+		return false, "CA certificate is outside its validity period"
+	}
+
+	timeout := n.healthIsolationTimeout
+	if timeout <= 0 {
+		timeout = defaultHealthIsolationTimeout
+	}
+
+	// A node alone in the network has no peers to lose.
+	if since := n.getIsolatedSince(); !since.IsZero() && len(n.view.Full()) > 0 && now.Sub(since) > timeout {
+		return false, fmt.Sprintf("no live peers for %s", now.Sub(since).Round(time.Second))
+	}
+
+	return true, ""
+}
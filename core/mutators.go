@@ -1,21 +1,55 @@
 package core
 
 import (
+	"bytes"
 	"errors"
+	"io"
+	"io/ioutil"
+	"sort"
 	"time"
 
+	log "github.com/inconshreveable/log15"
+	"github.com/joonnna/ifrit/core/discovery"
 	"github.com/joonnna/ifrit/protobuf"
 )
 
 var (
 	errNotFound     = errors.New("No node info found")
 	errPeerNotFound = errors.New("No peer info found")
+	errPullOnly     = errors.New("Gossip batches are only sent by push gossip, the gossip mode is pull")
 )
 
+// Membership state is always included, application data only as far as the gossip size limit allows.
+// Versioned entries take turns. Batched payloads are left out, they are only meant for
+// ring neighbours, see collectPushContent.
 func (n *Node) collectGossipContent() *proto.State {
+	return n.gossipContent(false)
+}
+
+// Same as collectGossipContent, but also drains the payloads batched for the gossip round.
+// Batched payloads that do not fit are left for the next round.
+func (n *Node) collectPushContent() *proto.State {
+	return n.gossipContent(true)
+}
+
+func (n *Node) gossipContent(drainBatch bool) *proto.State {
 	msg := n.view.State()
 
-	msg.ExternalGossip = n.getExternalGossip()
+	// Observers only spread membership.
+	if n.observer {
+		return msg
+	}
+
+	b := n.newGossipBudget(msg)
+
+	if ext := n.getExternalGossip(); ext != nil && b.takeBytes(ext) {
+		msg.ExternalGossip = ext
+	}
+
+	if drainBatch {
+		msg.Batch = n.drainGossipBatch(b)
+	}
+	msg.Entries = n.getVersionedGossip(b)
 
 	return msg
 }
@@ -34,11 +68,11 @@ func (n *Node) protocol() protocol {
 	return n.p
 }
 
-func (n *Node) setGossipTimeout(timeout int) {
+func (n *Node) setGossipTimeout(timeout time.Duration) {
 	n.gossipTimeoutMutex.Lock()
 	defer n.gossipTimeoutMutex.Unlock()
 
-	n.gossipTimeout = (time.Duration(timeout) * time.Second)
+	n.gossipTimeout = timeout
 }
 
 func (n *Node) getGossipTimeout() time.Duration {
@@ -48,30 +82,356 @@ func (n *Node) getGossipTimeout() time.Duration {
 	return n.gossipTimeout
 }
 
+func (n *Node) setMonitorTimeout(timeout time.Duration) {
+	n.monitorTimeoutMutex.Lock()
+	defer n.monitorTimeoutMutex.Unlock()
+
+	n.monitorTimeout = timeout
+}
+
+func (n *Node) getMonitorTimeout() time.Duration {
+	n.monitorTimeoutMutex.RLock()
+	defer n.monitorTimeoutMutex.RUnlock()
+
+	return n.monitorTimeout
+}
+
+// Exposed to let ifrit client adjust the gossip interval of a running node,
+// the gossip loop picks up the new interval after its current wait.
+func (n *Node) SetGossipInterval(d time.Duration) error {
+	if d <= 0 {
+		return errInterval
+	}
+
+	n.setGossipTimeout(d)
+
+	return nil
+}
+
+// Same as SetGossipInterval but for the monitor loop.
+func (n *Node) SetMonitorInterval(d time.Duration) error {
+	if d <= 0 {
+		return errInterval
+	}
+
+	n.setMonitorTimeout(d)
+
+	return nil
+}
+
+// Same as SetGossipInterval but for how often expired accusations are checked.
+func (n *Node) SetViewUpdateInterval(d time.Duration) error {
+	if d <= 0 {
+		return errInterval
+	}
+
+	n.view.SetUpdateTimeout(d)
+
+	return nil
+}
+
 // Exposed to let ifrit client set directly
-func (n *Node) SetExternalGossipContent(data []byte) {
+// Returns false if the same content was already being gossiped.
+func (n *Node) SetExternalGossipContent(data []byte) bool {
+	return n.SetExternalGossipContentWithTTL(data, 0)
+}
+
+// Exposed to let ifrit client set content that stops being gossiped after the given ttl.
+// A ttl of zero or less never expires.
+// Returns false if the same content was already being gossiped, the ttl is updated regardless.
+func (n *Node) SetExternalGossipContentWithTTL(data []byte, ttl time.Duration) bool {
 	n.externalGossipMutex.Lock()
 	defer n.externalGossipMutex.Unlock()
 
+	changed := n.externalGossipExpired() || !bytes.Equal(n.externalGossip, data)
+
 	n.externalGossip = data
+
+	if ttl > 0 {
+		n.externalGossipExpiry = n.clock.Now().Add(ttl)
+	} else {
+		n.externalGossipExpiry = time.Time{}
+	}
+
+	return changed
+}
+
+// Exposed to let ifrit client stop gossiping application data.
+func (n *Node) ClearExternalGossipContent() {
+	n.SetExternalGossipContent(nil)
+}
+
+// Exposed to let ifrit client set the content from a stream of data.
+// Consumes the reader until EOF, returns an error if it yields no data.
+func (n *Node) ReadExternalGossipContent(data io.Reader) error {
+	content, err := ioutil.ReadAll(data)
+	if err != nil {
+		return err
+	}
+
+	if len(content) == 0 {
+		return errNoData
+	}
+
+	n.SetExternalGossipContent(content)
+
+	return nil
+}
+
+// Exposed to let ifrit client gossip content under the given id, the content replaces
+// what peers hold for the id only if the version is higher than theirs.
+// The entry is signed so peers can tell it came from us, only we can publish new versions of it.
+// Returns false if an equal or higher version is already known for the id, or if another node published the id.
+func (n *Node) SetGossipContentVersioned(id, data []byte, version uint64) bool {
+	e := &proto.Data{
+		Id:      id,
+		Content: data,
+		Version: version,
+		Origin:  []byte(n.self.Id),
+	}
+
+	b, err := gossipEntryBytes(e)
+	if err != nil {
+		log.Error(err.Error())
+		return false
+	}
+
+	r, s, err := n.cs.Sign(b)
+	if err != nil {
+		log.Error(err.Error())
+		return false
+	}
+
+	e.Signature = &proto.Signature{
+		R: r,
+		S: s,
+	}
+
+	return n.addVersionedGossip(e)
+}
+
+// Exposed to let ifrit client read the most recent version known for the given id,
+// the content is nil if none is known.
+func (n *Node) GossipContentVersioned(id []byte) ([]byte, uint64) {
+	n.versionedGossipMutex.RLock()
+	defer n.versionedGossipMutex.RUnlock()
+
+	e, exists := n.versionedGossip[string(id)]
+	if !exists {
+		return nil, 0
+	}
+
+	return e.GetContent(), e.GetVersion()
+}
+
+// Exposed to let ifrit client read all versioned entries it currently gossips,
+// as a copy mapping each id to its content.
+func (n *Node) GossipContent() map[string][]byte {
+	n.versionedGossipMutex.RLock()
+	defer n.versionedGossipMutex.RUnlock()
+
+	ret := make(map[string][]byte, len(n.versionedGossip))
+
+	for id, e := range n.versionedGossip {
+		ret[id] = append([]byte(nil), e.GetContent()...)
+	}
+
+	return ret
+}
+
+// Stores the entry if its version is higher than the one held for its id and it has the same origin.
+// New ids make room by evicting the least recently updated entry of another origin once the limit is reached,
+// they are dropped if all entries are our own.
+func (n *Node) addVersionedGossip(e *proto.Data) bool {
+	n.versionedGossipMutex.Lock()
+	defer n.versionedGossipMutex.Unlock()
+
+	id := string(e.GetId())
+
+	if old, exists := n.versionedGossip[id]; exists {
+		if !bytes.Equal(old.GetOrigin(), e.GetOrigin()) || !n.newerGossip(old, e) {
+			return false
+		}
+	} else if len(n.versionedGossip) >= n.maxVersionedGossip && !n.evictVersionedGossip() {
+		return false
+	}
+
+	n.versionedGossip[id] = e
+	n.versionedGossipUpdated[id] = n.clock.Now()
+
+	return true
+}
+
+// Removes the least recently updated entry published by another node, returns false if there is none.
+// Caller must hold the versioned gossip lock.
+func (n *Node) evictVersionedGossip() bool {
+	var oldest string
+	var oldestUpdate time.Time
+
+	for id, e := range n.versionedGossip {
+		if string(e.GetOrigin()) == n.self.Id {
+			continue
+		}
+
+		if updated := n.versionedGossipUpdated[id]; oldest == "" || updated.Before(oldestUpdate) {
+			oldest = id
+			oldestUpdate = updated
+		}
+	}
+
+	if oldest == "" {
+		return false
+	}
+
+	delete(n.versionedGossip, oldest)
+	delete(n.versionedGossipUpdated, oldest)
+
+	return true
+}
+
+// Returns true if the received entry should replace the current one for its id.
+func (n *Node) newerGossip(current, received *proto.Data) bool {
+	if n.gossipCmp != nil {
+		return n.gossipCmp(current.GetContent(), received.GetContent())
+	}
+
+	return received.GetVersion() > current.GetVersion()
+}
+
+// Returns the entries that fit in the budget, in order of their ids starting from the
+// first one left out last time, so every entry is gossiped within a few rounds.
+func (n *Node) getVersionedGossip(b *gossipBudget) []*proto.Data {
+	n.versionedGossipMutex.Lock()
+	defer n.versionedGossipMutex.Unlock()
+
+	ids := make([]string, 0, len(n.versionedGossip))
+	for id := range n.versionedGossip {
+		ids = append(ids, id)
+	}
+
+	sort.Strings(ids)
+
+	start := sort.SearchStrings(ids, n.nextVersionedGossip)
+
+	ret := make([]*proto.Data, 0, len(ids))
+
+	for i := range ids {
+		id := ids[(start+i)%len(ids)]
+		e := n.versionedGossip[id]
+
+		if b.takeMsg(e) {
+			ret = append(ret, e)
+		} else if !b.oversizedMsg(e) {
+			n.nextVersionedGossip = id
+			break
+		}
+	}
+
+	return ret
+}
+
+// Exposed to let ifrit client enqueue application payloads,
+// all payloads enqueued between two gossip rounds are sent together in the next one.
+// Returns an error without push gossip, as nothing would ever send the payloads.
+func (n *Node) EnqueueGossip(id, data []byte) error {
+	if !n.push {
+		return errPullOnly
+	}
+
+	if n.observer {
+		return nil
+	}
+
+	n.gossipBatchMutex.Lock()
+	defer n.gossipBatchMutex.Unlock()
+
+	n.gossipBatch = append(n.gossipBatch, &proto.Data{
+		Id:      id,
+		Content: data,
+	})
+
+	return nil
+}
+
+// Removes and returns the pending payloads that fit in the budget, in the order they were enqueued.
+// Payloads too large for any gossip message are dropped.
+func (n *Node) drainGossipBatch(b *gossipBudget) []*proto.Data {
+	n.gossipBatchMutex.Lock()
+	defer n.gossipBatchMutex.Unlock()
+
+	var ret []*proto.Data
+
+	for len(n.gossipBatch) > 0 {
+		e := n.gossipBatch[0]
+
+		if b.takeMsg(e) {
+			ret = append(ret, e)
+		} else if b.oversizedMsg(e) {
+			log.Error("Dropping gossip payload larger than the gossip size limit", "size", len(e.GetContent()))
+		} else {
+			break
+		}
+
+		n.gossipBatch = n.gossipBatch[1:]
+	}
+
+	if len(n.gossipBatch) == 0 {
+		n.gossipBatch = nil
+	}
+
+	return ret
 }
 
 func (n *Node) getExternalGossip() []byte {
 	n.externalGossipMutex.RLock()
 	defer n.externalGossipMutex.RUnlock()
 
+	if n.externalGossipExpired() {
+		return nil
+	}
+
 	return n.externalGossip
 }
 
+// Removes the external gossip if its ttl has passed.
+func (n *Node) expireExternalGossip() {
+	n.externalGossipMutex.Lock()
+	defer n.externalGossipMutex.Unlock()
+
+	if n.externalGossipExpired() {
+		n.externalGossip = nil
+		n.externalGossipExpiry = time.Time{}
+	}
+}
+
+// Caller must hold the external gossip mutex.
+func (n *Node) externalGossipExpired() bool {
+	return !n.externalGossipExpiry.IsZero() && n.clock.Now().After(n.externalGossipExpiry)
+}
+
 // Expose so that client can set new handler directly
 func (n *Node) SetMsgHandler(newHandler processMsg) {
+	var handler senderMsg
+
+	if newHandler != nil {
+		handler = func(sender, data []byte) ([]byte, error) {
+			return newHandler(data)
+		}
+	}
+
+	n.SetMsgHandlerWithSender(handler)
+}
+
+// Expose so that client can set new handler directly
+// Replaces any handler set through SetMsgHandler.
+func (n *Node) SetMsgHandlerWithSender(newHandler senderMsg) {
 	n.msgHandlerMutex.Lock()
 	defer n.msgHandlerMutex.Unlock()
 
 	n.msgHandler = newHandler
 }
 
-func (n *Node) getMsgHandler() processMsg {
+func (n *Node) getMsgHandler() senderMsg {
 	n.msgHandlerMutex.RLock()
 	defer n.msgHandlerMutex.RUnlock()
 
@@ -93,32 +453,166 @@ func (n *Node) getGossipHandler() processMsg {
 	return n.gossipHandler
 }
 
+// Expose so that client can set new handler directly
+func (n *Node) SetBatchGossipHandler(newHandler func([]byte, []byte)) {
+	n.batchHandlerMutex.Lock()
+	defer n.batchHandlerMutex.Unlock()
+
+	n.batchHandler = newHandler
+}
+
+func (n *Node) getBatchGossipHandler() func([]byte, []byte) {
+	n.batchHandlerMutex.RLock()
+	defer n.batchHandlerMutex.RUnlock()
+
+	return n.batchHandler
+}
+
 // Expose so that client can set new handler directly
 func (n *Node) SetResponseHandler(newHandler func([]byte)) {
+	var handler func([]byte, []byte, []byte)
+
+	if newHandler != nil {
+		handler = func(peerId, contentId, response []byte) {
+			newHandler(response)
+		}
+	}
+
+	n.SetResponseHandlerWithSender(handler)
+}
+
+// Expose so that client can set new handler directly
+// The handler is given the id of the responding peer, the sha256 digest of the gossip content
+// the response was made for, and the response. Replaces any handler set through SetResponseHandler.
+func (n *Node) SetResponseHandlerWithSender(newHandler func(peerId, contentId, response []byte)) {
 	n.responseHandlerMutex.Lock()
 	defer n.responseHandlerMutex.Unlock()
 
 	n.responseHandler = newHandler
 }
 
-func (n *Node) getResponseHandler() func([]byte) {
+func (n *Node) getResponseHandler() func([]byte, []byte, []byte) {
 	n.responseHandlerMutex.RLock()
 	defer n.responseHandlerMutex.RUnlock()
 
 	return n.responseHandler
 }
 
+// Expose so that client can set new tap directly
+func (n *Node) SetGossipTap(tap func(addr string, msg *proto.State)) {
+	n.gossipTapMutex.Lock()
+	defer n.gossipTapMutex.Unlock()
+
+	n.gossipTap = tap
+}
+
+func (n *Node) getGossipTap() func(string, *proto.State) {
+	n.gossipTapMutex.RLock()
+	defer n.gossipTapMutex.RUnlock()
+
+	return n.gossipTap
+}
+
 // Expose so that client can set new handler directly
 func (n *Node) SetStreamHandler(newHandler streamMsg) {
+	var handler acceptStream
+
+	if newHandler != nil {
+		handler = func(sender []byte) (func(chan []byte, chan []byte), error) {
+			return newHandler, nil
+		}
+	}
+
+	n.SetStreamHandlerWithSender(handler)
+}
+
+// Expose so that client can set new handler directly
+// Replaces any handler set through SetStreamHandler.
+func (n *Node) SetStreamHandlerWithSender(newHandler acceptStream) {
 	n.streamHandlerMutex.Lock()
 	defer n.streamHandlerMutex.Unlock()
 
 	n.streamHandler = newHandler
 }
 
-func (n *Node) getStreamHandler() streamMsg {
+func (n *Node) getStreamHandler() acceptStream {
 	n.streamHandlerMutex.RLock()
 	defer n.streamHandlerMutex.RUnlock()
 
 	return n.streamHandler
+}
+
+// Expose so that client can set new handler directly
+func (n *Node) SetMembershipHandler(newHandler func(discovery.Event)) {
+	n.membershipHandlerMutex.Lock()
+	defer n.membershipHandlerMutex.Unlock()
+
+	n.membershipHandler = newHandler
+}
+
+func (n *Node) getMembershipHandler() func(discovery.Event) {
+	n.membershipHandlerMutex.RLock()
+	defer n.membershipHandlerMutex.RUnlock()
+
+	return n.membershipHandler
+}
+
+// Expose so that client can set new handler directly
+func (n *Node) SetAccusationHandler(newHandler func([]byte, uint32)) {
+	n.accusationHandlerMutex.Lock()
+	defer n.accusationHandlerMutex.Unlock()
+
+	n.accusationHandler = newHandler
+}
+
+func (n *Node) getAccusationHandler() func([]byte, uint32) {
+	n.accusationHandlerMutex.RLock()
+	defer n.accusationHandlerMutex.RUnlock()
+
+	return n.accusationHandler
+}
+
+// Expose so that client can set new handler directly
+func (n *Node) SetDropHandler(newHandler func(DropKind, []byte)) {
+	n.dropHandlerMutex.Lock()
+	defer n.dropHandlerMutex.Unlock()
+
+	n.dropHandler = newHandler
+}
+
+func (n *Node) getDropHandler() func(DropKind, []byte) {
+	n.dropHandlerMutex.RLock()
+	defer n.dropHandlerMutex.RUnlock()
+
+	return n.dropHandler
+}
+
+// Expose so that client can set new handler directly
+func (n *Node) SetCaStatusHandler(newHandler func(bool)) {
+	n.caStatusHandlerMutex.Lock()
+	defer n.caStatusHandlerMutex.Unlock()
+
+	n.caStatusHandler = newHandler
+}
+
+func (n *Node) getCaStatusHandler() func(bool) {
+	n.caStatusHandlerMutex.RLock()
+	defer n.caStatusHandlerMutex.RUnlock()
+
+	return n.caStatusHandler
+}
+
+// Expose so that client can set new handler directly
+func (n *Node) SetCertExpiryHandler(newHandler func(time.Duration)) {
+	n.certExpiryHandlerMutex.Lock()
+	defer n.certExpiryHandlerMutex.Unlock()
+
+	n.certExpiryHandler = newHandler
+}
+
+func (n *Node) getCertExpiryHandler() func(time.Duration) {
+	n.certExpiryHandlerMutex.RLock()
+	defer n.certExpiryHandlerMutex.RUnlock()
+
+	return n.certExpiryHandler
 }
\ No newline at end of file
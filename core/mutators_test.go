@@ -1 +1,208 @@
 package core
+
+import (
+	"bytes"
+	"crypto/rand"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+	log "github.com/inconshreveable/log15"
+	pb "github.com/joonnna/ifrit/protobuf"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/stretchr/testify/suite"
+)
+
+type MutatorsTestSuite struct {
+	suite.Suite
+	n *Node
+}
+
+func TestMutatorsTestSuite(t *testing.T) {
+	r := log.Root()
+
+	r.SetHandler(log.DiscardHandler())
+
+	suite.Run(t, new(MutatorsTestSuite))
+}
+
+func (suite *MutatorsTestSuite) SetupTest() {
+	priv, err := genKeys()
+	require.NoError(suite.T(), err, "Failed to generate keys")
+
+	n, err := NewNode(&commStub{}, &pingStub{}, &cmStub{cert: genCert(priv, 10)}, &cryptoStub{priv: priv}, testConfig())
+	require.NoError(suite.T(), err, "Failed to create node.")
+
+	suite.n = n
+}
+
+func (suite *MutatorsTestSuite) TestReadExternalGossipContent() {
+	content := make([]byte, 4096)
+	_, err := rand.Read(content)
+	require.NoError(suite.T(), err, "Failed to generate content.")
+
+	err = suite.n.ReadExternalGossipContent(bytes.NewReader(content))
+	require.NoError(suite.T(), err, "Failed to read content from reader.")
+	assert.Equal(suite.T(), content, suite.n.getExternalGossip(), "Stored content does not match reader content.")
+
+	err = suite.n.ReadExternalGossipContent(bytes.NewReader(nil))
+	assert.EqualError(suite.T(), err, errNoData.Error(), "Should return error on empty reader.")
+	assert.Equal(suite.T(), content, suite.n.getExternalGossip(), "Empty reader should not replace existing content.")
+}
+
+func (suite *MutatorsTestSuite) TestClearExternalGossipContent() {
+	suite.n.SetExternalGossipContent([]byte("content"))
+
+	suite.n.ClearExternalGossipContent()
+	assert.Nil(suite.T(), suite.n.getExternalGossip(), "Content not cleared.")
+	assert.Nil(suite.T(), suite.n.collectGossipContent().GetExternalGossip(), "Cleared content still gossiped.")
+}
+
+func (suite *MutatorsTestSuite) TestExternalGossipTTL() {
+	content := []byte("content")
+
+	suite.n.SetExternalGossipContentWithTTL(content, time.Hour)
+	assert.Equal(suite.T(), content, suite.n.getExternalGossip(), "Content expired before its ttl.")
+
+	suite.n.SetExternalGossipContentWithTTL(content, time.Millisecond)
+	time.Sleep(time.Millisecond * 5)
+	assert.Nil(suite.T(), suite.n.getExternalGossip(), "Expired content still gossiped.")
+
+	suite.n.expireExternalGossip()
+	assert.Nil(suite.T(), suite.n.externalGossip, "Expired content not removed.")
+
+	suite.n.SetExternalGossipContentWithTTL(content, time.Millisecond)
+	suite.n.SetExternalGossipContent(content)
+	time.Sleep(time.Millisecond * 5)
+	assert.Equal(suite.T(), content, suite.n.getExternalGossip(), "Setting content without ttl should clear the previous ttl.")
+}
+
+func (suite *MutatorsTestSuite) TestSetExternalGossipContentChanged() {
+	content := []byte("content")
+
+	assert.True(suite.T(), suite.n.SetExternalGossipContent(content), "New content should be reported as changed.")
+	assert.False(suite.T(), suite.n.SetExternalGossipContent([]byte("content")), "Equal content should be reported as unchanged.")
+	assert.True(suite.T(), suite.n.SetExternalGossipContent([]byte("other")), "Different content should be reported as changed.")
+
+	suite.n.SetExternalGossipContentWithTTL(content, time.Millisecond)
+	time.Sleep(time.Millisecond * 5)
+	assert.True(suite.T(), suite.n.SetExternalGossipContent(content), "Replacing expired content should be reported as changed.")
+}
+
+func (suite *MutatorsTestSuite) TestGossipContent() {
+	n := suite.n
+
+	require.Empty(suite.T(), n.GossipContent(), "Content reported without any entries.")
+
+	n.SetGossipContentVersioned([]byte("first"), []byte("v1"), 1)
+	n.SetGossipContentVersioned([]byte("second"), []byte("v1"), 1)
+	n.SetGossipContentVersioned([]byte("second"), []byte("v2"), 2)
+
+	content := n.GossipContent()
+	require.Equal(suite.T(), map[string][]byte{"first": []byte("v1"), "second": []byte("v2")}, content, "Wrong entries reported.")
+
+	content["first"][0] = 'x'
+	content["third"] = []byte("v1")
+	delete(content, "second")
+
+	after := n.GossipContent()
+	assert.Equal(suite.T(), []byte("v1"), after["first"], "Entry content not copied.")
+	assert.Contains(suite.T(), after, "second", "Map not copied.")
+	assert.NotContains(suite.T(), after, "third", "Map not copied.")
+}
+
+func (suite *MutatorsTestSuite) TestGossipComparator() {
+	n := suite.n
+	id := []byte("id")
+
+	n.gossipCmp = func(current, received []byte) bool {
+		return len(received) > len(current)
+	}
+
+	require.True(suite.T(), n.SetGossipContentVersioned(id, []byte("aa"), 5), "Rejected first content.")
+	require.False(suite.T(), n.SetGossipContentVersioned(id, []byte("b"), 10), "Versions compared instead of content.")
+	require.True(suite.T(), n.SetGossipContentVersioned(id, []byte("ccc"), 1), "Newer content by the comparator not accepted.")
+
+	content, version := n.GossipContentVersioned(id)
+	assert.Equal(suite.T(), []byte("ccc"), content, "Newer content by the comparator not accepted.")
+	assert.Equal(suite.T(), uint64(1), version, "Version not carried along.")
+}
+
+func (suite *MutatorsTestSuite) TestVersionedGossipLimit() {
+	clock := newFakeClock()
+
+	n := suite.n
+	n.clock = clock
+	n.maxVersionedGossip = 3
+
+	p, priv, err := addPeer(n)
+	require.NoError(suite.T(), err, "Could not add peer.")
+
+	require.True(suite.T(), n.SetGossipContentVersioned([]byte("own"), []byte("v1"), 1), "Rejected own entry.")
+
+	for _, id := range []string{"first", "second", "third"} {
+		n.mergeGossipEntries([]*pb.Data{signedEntry(priv, p.Id, id, "v1", 1)})
+		clock.Advance(time.Second)
+	}
+
+	content := n.GossipContent()
+	require.Len(suite.T(), content, 3, "Limit not enforced.")
+	assert.Contains(suite.T(), content, "own", "Own entry evicted.")
+	assert.NotContains(suite.T(), content, "first", "Least recently updated entry not evicted.")
+
+	require.True(suite.T(), n.SetGossipContentVersioned([]byte("own2"), []byte("v1"), 1), "Own entry did not make room.")
+	assert.Contains(suite.T(), n.GossipContent(), "third", "Most recently updated entry evicted.")
+
+	require.True(suite.T(), n.SetGossipContentVersioned([]byte("own3"), []byte("v1"), 1), "Own entry did not make room.")
+	require.False(suite.T(), n.SetGossipContentVersioned([]byte("own4"), []byte("v1"), 1), "Own entries evicted.")
+
+	n.mergeGossipEntries([]*pb.Data{signedEntry(priv, p.Id, "fourth", "v1", 1)})
+
+	content = n.GossipContent()
+	assert.Len(suite.T(), content, 3, "Limit not enforced.")
+	assert.NotContains(suite.T(), content, "fourth", "Own entries evicted.")
+}
+
+func (suite *MutatorsTestSuite) TestGossipSizeLimit() {
+	n := suite.n
+
+	for i := 0; i < 50; i++ {
+		n.SetGossipContentVersioned([]byte(fmt.Sprintf("entry-%02d", i)), make([]byte, 100), 1)
+	}
+
+	var enqueued []string
+	for i := 0; i < 30; i++ {
+		id := fmt.Sprintf("batch-%02d", i)
+		enqueued = append(enqueued, id)
+		n.EnqueueGossip([]byte(id), make([]byte, 100))
+	}
+
+	n.SetExternalGossipContent(make([]byte, 100))
+
+	limit := proto.Size(n.view.State()) + 1000
+	n.maxGossipSize = limit
+
+	n.EnqueueGossip([]byte("oversized"), make([]byte, limit))
+
+	var batched []string
+	entries := make(map[string]bool)
+
+	for round := 0; round < 20; round++ {
+		msg := n.collectPushContent()
+		require.True(suite.T(), proto.Size(msg) <= limit, "Gossip message of %d bytes exceeds the limit.", proto.Size(msg))
+		require.NotNil(suite.T(), msg.GetExternalGossip(), "Gossip content left out.")
+
+		for _, e := range msg.GetBatch() {
+			batched = append(batched, string(e.GetId()))
+		}
+
+		for _, e := range msg.GetEntries() {
+			entries[string(e.GetId())] = true
+		}
+	}
+
+	assert.Equal(suite.T(), enqueued, batched, "Enqueued payloads not all sent, or out of order.")
+	assert.Len(suite.T(), entries, 50, "Versioned entries starved.")
+}
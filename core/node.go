@@ -4,14 +4,18 @@ import (
 	"crypto/ecdsa"
 	"crypto/x509"
 	"errors"
+	"fmt"
+	"math/rand"
+	"net/http"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	log "github.com/inconshreveable/log15"
 	"github.com/joonnna/ifrit/core/discovery"
 	pb "github.com/joonnna/ifrit/protobuf"
 	"github.com/joonnna/workerpool"
-	"github.com/spf13/viper"
+	"golang.org/x/net/context"
 )
 
 var (
@@ -19,10 +23,151 @@ var (
 	errNoData       = errors.New("Gossip data has zero length")
 	errNoCaAddr     = errors.New("No ca addr set in config with use_ca enabled")
 	errNoEntryAddrs = errors.New("No entry_addrs set in config with use_ca disabled")
+	errNoConfig     = errors.New("No node config provided")
+	errStarted      = errors.New("Node was already started")
+	errStopped      = errors.New("Node was already stopped")
+	errNotRunning   = errors.New("Node is not running")
+	errGossipMode   = errors.New("Invalid gossip mode, must be push, pull or push-pull")
+	errUnknownPeer  = errors.New("No peer with the given id in the full view")
+	errEvictSelf    = errors.New("Cannot evict myself")
+	errInterval     = errors.New("Interval must be positive")
+	errReplyDropped = errors.New("Reply channel is full, dropping reply")
+
+	// Returned by NewNode when the http server could not be bound, wraps the listen error.
+	ErrHttpListen = errors.New("Could not bind the http server")
+
+	// Returned by SendToHost when the host presents a certificate we would not accept from a peer, wraps the reason.
+	ErrPeerCert = errors.New("Peer certificate rejected")
+
+	// Returned by QueueMessage and QueueMessageWithRetry when sendQueueSize sends are already waiting.
+	ErrSendQueueFull = errors.New("Send queue is full")
 )
 
+// Versioned gossip entries kept at most, see addVersionedGossip.
+const maxVersionedGossip = 1024
+
+// Sends queued at most while waiting for a send worker, see QueueMessage.
+const sendQueueSize = 1024
+
+// Config contains the behavior settings of a node.
+type Config struct {
+	GossipInterval     time.Duration
+	MonitorInterval    time.Duration
+	ViewUpdateInterval time.Duration
+
+	// How long an accused peer has to rebut before being removed from the live view.
+	// Expired accusations are checked for each view update interval, which bounds how late the removal happens.
+	RemovalTimeout time.Duration
+
+	PingLimit             uint32
+	PingsPerInterval      uint32
+	MaxConcurrentMessages uint32
+
+	// Once PingLimit pings in a row to a peer have failed, checks it over tcp through a tls handshake with its
+	// rpc address before accusing it. Keeps peers from being accused where udp is filtered but tcp works.
+	TcpPingFallback bool
+
+	// Deadline of messages sent without one, covering the whole round trip. Zero means no deadline.
+	MessageTimeout time.Duration
+
+	// Deadline of each gossip round, covering the seeds, neighbours and pull partner contacted in one interval.
+	// Rpcs still running when it passes are cancelled, so a stalled peer can not hold up the following rounds.
+	// Zero defaults to the gossip interval.
+	GossipRoundTimeout time.Duration
+
+	// Number of neighbours gossiped with each gossip interval, chosen at random among all ring neighbours.
+	// Zero gossips with the successor and predecessor of one ring per interval, rotating through the rings.
+	GossipFanout uint32
+
+	// Chooses the neighbours to gossip with each interval if set, replacing the choice made by GossipFanout.
+	PartnerSelector PartnerSelector
+
+	// One of push, pull or push-pull, empty defaults to push.
+	// Push sends the local state to neighbours, pull asks a random live peer for anything newer than the local state.
+	GossipMode string
+
+	// Number of recently handled gossip entries remembered, an entry already seen
+	// is not passed to the gossip handler again. Zero disables deduplication.
+	GossipCacheSize uint32
+
+	// Gossip rpcs accepted per second from a single peer, zero disables the limit.
+	// Peers may exceed the rate in bursts of up to GossipRateBurst rpcs, zero defaults to the rate.
+	GossipRateLimit uint32
+	GossipRateBurst uint32
+
+	// Maximum encoded size of gossip messages, zero means no limit.
+	// Membership state is always sent, application data is spread over several rounds if it does not fit.
+	MaxGossipSize uint32
+
+	// Maximum number of peers in the full view, zero means no limit. Once exceeded, the peers outside
+	// the live view heard from least recently are evicted, live peers and ring neighbours are always kept.
+	MaxViewSize uint32
+
+	// Gossip intervals without a completed gossip round before the node reports itself unhealthy, zero means 3.
+	HealthMissedRounds uint32
+
+	// How long the node may have no live peers, while knowing of other peers, before it reports itself unhealthy.
+	// Zero means five minutes.
+	HealthIsolationTimeout time.Duration
+
+	// Rings we participate on, the lowest bit being the first ring, zero participates on all rings.
+	// Peers neither monitor us nor accept accusations against us on the other rings, and we monitor no one on them.
+	// At most half the rings minus one can be left out, NewNode fails otherwise.
+	RingMask uint32
+
+	// Only observe the network, for monitoring appliances. Other peers keep observers in their full view
+	// but out of their live view and rings, so observers are never chosen to monitor or gossip with.
+	// Observers see the full view and liveness of the others, but neither monitor anyone nor spread application gossip.
+	ObserverMode bool
+
+	// Addresses of existing hosts, contacted at startup when no CA is used.
+	EntryAddrs []string
+
+	// Addresses of hosts to gossip with after startup, whether a CA is used or not.
+	// Seeds that can not be reached are retried each gossip interval until SeedRetryTimeout has passed.
+	SeedNodes        []string
+	SeedRetryTimeout time.Duration
+
+	// How long before our certificate expires the cert expiry handler is invoked, zero disables the check.
+	CertExpiryThreshold time.Duration
+
+	// Clock offset of a pinged peer beyond which a warning is logged and the skew counted, zero defaults to a second.
+	ClockSkewThreshold time.Duration
+
+	// How often the CA is checked, reporting changes to the CA status handler and retrying certificate
+	// rotations that failed while it was down. Zero disables polling, as does running without a CA.
+	CaPollInterval time.Duration
+
+	// Certificates from other CAs than our own, peers signed by any of them are accepted.
+	TrustedCAs []*x509.Certificate
+
+	// Expected key pins of peer ids, see KeyPin. Certificates for a pinned id with another key are rejected.
+	KeyPins map[string][]byte
+
+	// Returns true if the received content of a versioned gossip entry is newer than the current one and replaces it.
+	// Versions are compared if nil, otherwise they are only carried along.
+	GossipComparator func(current, received []byte) bool
+
+	// Traces message and gossip rpcs if set, the trace context of messages is propagated to the receiver.
+	Tracer Tracer
+
+	// Drives the gossip, monitor and view update loops and all timeouts kept by the node, the real clock if nil.
+	// Socket deadlines, message timeouts and gossip round deadlines always use the real clock.
+	Clock discovery.Clock
+
+	// Visualizer specific
+	UseViz            bool
+	VizAddr           string
+	VizUpdateInterval time.Duration
+
+	// Address the http server binds to, a free port from 12300 on the hostname's address if empty.
+	HttpAddr string
+}
+
 type processMsg func([]byte) ([]byte, error)
+type senderMsg func([]byte, []byte) ([]byte, error)
 type streamMsg func(chan []byte, chan []byte)
+type acceptStream func([]byte) (func(chan []byte, chan []byte), error)
 
 type Node struct {
 	view *discovery.View
@@ -37,40 +182,158 @@ type Node struct {
 	exitFlag  bool
 	exitMutex sync.RWMutex
 
+	pauseFlag  bool
+	pauseMutex sync.RWMutex
+
+	startFlag  bool
+	startMutex sync.Mutex
+	ready      chan struct{}
+	readyOnce  sync.Once
+
 	viewUpdateTimeout time.Duration
 
 	gossipTimeout      time.Duration
 	gossipTimeoutMutex sync.RWMutex
 
-	pingsPerInterval int
-	monitorTimeout   time.Duration
-	nodeDeadTimeout  float64
+	gossipFanout    int
+	partnerSelector PartnerSelector
+	push, pull      bool
 
-	msgHandler      processMsg
+	pingsPerInterval    int
+	monitorTimeout      time.Duration
+	monitorTimeoutMutex sync.RWMutex
+
+	msgHandler      senderMsg
 	msgHandlerMutex sync.RWMutex
 
+	messageTimeout     time.Duration
+	gossipRoundTimeout time.Duration
+
 	gossipHandler      processMsg
 	gossipHandlerMutex sync.RWMutex
+	seenGossip         *seenCache
+	maxGossipSize      int
+	gossipLimiter      *rateLimiter
 
-	responseHandler      func([]byte)
+	responseHandler      func([]byte, []byte, []byte)
 	responseHandlerMutex sync.RWMutex
 
-	externalGossip      []byte
-	externalGossipMutex sync.RWMutex
+	// Sees every outgoing gossip message, see SetGossipTap.
+	gossipTap      func(string, *pb.State)
+	gossipTapMutex sync.RWMutex
+
+	externalGossip       []byte
+	externalGossipExpiry time.Time
+	externalGossipMutex  sync.RWMutex
+
+	// Versioned gossip entries by id, only the highest version of each is kept,
+	// along with when each was last updated.
+	versionedGossip        map[string]*pb.Data
+	versionedGossipUpdated map[string]time.Time
+	versionedGossipMutex   sync.RWMutex
+	maxVersionedGossip     int
+
+	// Decides which content of a versioned entry is newer, versions are compared if nil.
+	gossipCmp func(current, received []byte) bool
+
+	// Id of the first versioned entry left out of the last gossip message.
+	nextVersionedGossip string
 
-	streamHandler      streamMsg
+	// Application payloads waiting for the next gossip round.
+	gossipBatch      []*pb.Data
+	gossipBatchMutex sync.Mutex
+
+	batchHandler      func([]byte, []byte)
+	batchHandlerMutex sync.RWMutex
+
+	streamHandler      acceptStream
 	streamHandlerMutex sync.RWMutex
 
+	membershipHandler      func(discovery.Event)
+	membershipHandlerMutex sync.RWMutex
+
+	// Channels returned by MembershipEvents, closed once the event loop exits.
+	eventSubs       []chan discovery.Event
+	eventSubsClosed bool
+	eventSubsMutex  sync.Mutex
+
+	accusationHandler      func([]byte, uint32)
+	accusationHandlerMutex sync.RWMutex
+
+	dropHandler      func(DropKind, []byte)
+	dropHandlerMutex sync.RWMutex
+
+	certExpiryHandler      func(time.Duration)
+	certExpiryHandlerMutex sync.RWMutex
+	certExpiryThreshold    time.Duration
+	clockSkewThreshold     time.Duration
+	certExpiryNotified     bool
+
+	caStatusHandler      func(bool)
+	caStatusHandlerMutex sync.RWMutex
+	caPollInterval       time.Duration
+
+	// Set while the CA is unreachable, and after a rotation failed because of it.
+	caDown          bool
+	rotationPending bool
+	caMutex         sync.Mutex
+
+	events *eventQueue
+
+	// Drops waiting for the drop handler, see recordDrop.
+	drops chan dropReport
+
+	// Closed and replaced after each batch of membership events, see membershipChanged.
+	viewChange      chan struct{}
+	viewChangeMutex sync.Mutex
+
 	dispatcher *workerpool.Dispatcher
 
+	// Held by each outgoing message from before it is queued until its response arrives, bounds messages in flight.
+	msgSlots chan struct{}
+
+	// Sends waiting for one of the send workers, see QueueMessage.
+	sendQueue chan func()
+
+	// Closed once the node stops, aborting outgoing messages still waiting for a response.
+	msgAbort     chan struct{}
+	msgAbortOnce sync.Once
+
+	inflight    sync.WaitGroup
+	numInflight int64
+
+	stats *recorder
+
+	tracer Tracer
+
+	clock discovery.Clock
+
 	entryAddrs []string
 
+	// Seeds not reached yet, only accessed by the gossip loop.
+	seeds            []string
+	seedRetryTimeout time.Duration
+
+	trustedCAs []*x509.Certificate
+	keyPins    map[string][]byte
+
 	fd *failureDetector
 
 	comm commService
 	cs   cryptoService
 	cm   certManager
 
+	// Set by ObserverMode.
+	observer bool
+
+	// When the node was started and when its live view last became empty, see Healthy.
+	startedAt     time.Time
+	isolatedSince time.Time
+	healthMutex   sync.Mutex
+
+	healthMissedRounds     uint32
+	healthIsolationTimeout time.Duration
+
 	useViz bool
 	viz    *viz
 }
@@ -79,12 +342,17 @@ type commService interface {
 	Register(pb.GossipServer)
 	CloseConn(string)
 	Addr() string
-	Start()
+	Start() error
 	Stop()
-
-	Gossip(string, *pb.State) (*pb.StateResponse, error)
-	Send(string, *pb.Msg) (*pb.MsgResponse, error)
-	StreamMessenger(string, chan []byte, chan []byte) error
+	GracefulStop()
+	SetCertificate(*x509.Certificate)
+
+	Gossip(context.Context, string, *pb.State) (*pb.StateResponse, error)
+	Pull(context.Context, string, *pb.State) (*pb.StateResponse, error)
+	Send(context.Context, string, *pb.Msg) (*pb.MsgResponse, error)
+	StreamMessenger(string, chan []byte, chan []byte, uint32, string, func()) error
+	PeerCertificate(context.Context, string) (*x509.Certificate, error)
+	SendToHost(context.Context, string, *pb.Msg, func(*x509.Certificate) error) (*pb.MsgResponse, error)
 }
 
 type certManager interface {
@@ -96,6 +364,9 @@ type certManager interface {
 	Trusted() bool
 	SavePrivateKey(string) error
 	SaveCertificate(string) error
+	ExportIdentity() ([]byte, error)
+	RenewCertificate() (*x509.Certificate, error)
+	PingCa() error
 }
 
 type cryptoService interface {
@@ -103,24 +374,115 @@ type cryptoService interface {
 	Sign([]byte) ([]byte, []byte, error)
 }
 
+// Behaviour of a node each gossip and monitor interval.
+// Nodes always run correct, the other implementations in protocol.go model
+// byzantine peers for experiments and are never selected by a node on its own.
 type protocol interface {
 	Monitor(n *Node)
-	Gossip(n *Node)
+	Gossip(ctx context.Context, n *Node)
+	PullSync(ctx context.Context, n *Node)
 	Rebuttal(n *Node)
 }
 
 func (n *Node) gossipLoop() {
 	defer n.wg.Done()
 
+	seedDeadline := n.clock.Now().Add(n.seedRetryTimeout)
+
+	ctx, cancel := n.gossipRoundContext()
+	n.contactSeeds(ctx, seedDeadline)
+	cancel()
+
 	for {
 		select {
 		case <-n.exitChan:
 			log.Info("Exiting gossiping")
 			return
-		case <-time.After(n.getGossipTimeout()):
-			n.protocol().Gossip(n)
+		case <-n.clock.After(n.getGossipTimeout()):
+			n.expireExternalGossip()
+
+			if n.paused() {
+				continue
+			}
+
+			n.gossipRound(seedDeadline)
+		}
+	}
+}
+
+// Contacts the pending seeds and gossips according to the gossip mode, within the round deadline.
+func (n *Node) gossipRound(seedDeadline time.Time) {
+	ctx, cancel := n.gossipRoundContext()
+	defer cancel()
+
+	n.contactSeeds(ctx, seedDeadline)
+
+	if n.push {
+		n.protocol().Gossip(ctx, n)
+	}
+
+	if n.pull {
+		n.protocol().PullSync(ctx, n)
+	}
+
+	if ctx.Err() == context.DeadlineExceeded {
+		log.Warn("Gossip round exceeded its deadline, remaining rpcs were cancelled", "timeout", n.roundTimeout())
+	}
+
+	n.stats.recordRoundEnd(n.clock.Now())
+}
+
+func (n *Node) gossipRoundContext() (context.Context, context.CancelFunc) {
+	return context.WithTimeout(context.Background(), n.roundTimeout())
+}
+
+// Returns the gossip round deadline, which follows the gossip interval unless set.
+func (n *Node) roundTimeout() time.Duration {
+	if n.gossipRoundTimeout > 0 {
+		return n.gossipRoundTimeout
+	}
+
+	return n.getGossipTimeout()
+}
+
+// Gossips with the seeds not reached yet, the remaining seeds are
+// given up on once the deadline has passed.
+func (n *Node) contactSeeds(ctx context.Context, deadline time.Time) {
+	if len(n.seeds) == 0 {
+		return
+	}
+
+	msg := n.collectGossipContent()
+
+	var pending []string
+
+	for _, addr := range n.seeds {
+		if err := n.bootstrap(ctx, addr, msg); err != nil {
+			log.Error(err.Error(), "addr", addr)
+			pending = append(pending, addr)
 		}
 	}
+
+	if len(pending) > 0 && !n.clock.Now().Before(deadline) {
+		log.Error("Giving up on unreachable seed nodes", "seeds", pending)
+		pending = nil
+	}
+
+	n.seeds = pending
+}
+
+// Gossips with the given host and merges everything it replies with.
+func (n *Node) bootstrap(ctx context.Context, addr string, msg *pb.State) error {
+	reply, err := n.gossip(ctx, addr, msg)
+	if err != nil {
+		return err
+	}
+
+	n.mergeCertificates(reply.GetCertificates())
+	n.mergeNotes(reply.GetNotes())
+	n.mergeAccusations(reply.GetAccusations())
+
+	return nil
 }
 
 func (n *Node) monitorLoop() {
@@ -131,22 +493,83 @@ func (n *Node) monitorLoop() {
 		case <-n.exitChan:
 			log.Info("Stopping monitoring")
 			return
-		case <-time.After(n.monitorTimeout):
-			n.protocol().Monitor(n)
+		case <-n.clock.After(n.getMonitorTimeout()):
+			if n.paused() {
+				continue
+			}
+
+			// Observers are not in the rings of others, so their accusations would be rejected.
+			if !n.observer {
+				n.protocol().Monitor(n)
+			}
+			n.checkCertExpiry(n.clock.Now())
 		}
 	}
 }
 
-func NewNode(comm commService, ps pingService, cm certManager, cs cryptoService) (*Node, error) {
+// Invokes the cert expiry handler once each time the remaining validity
+// of our certificate drops below the threshold.
+func (n *Node) checkCertExpiry(now time.Time) {
+	if n.certExpiryThreshold <= 0 {
+		return
+	}
+
+	expiresIn := n.cm.Certificate().NotAfter.Sub(now)
+
+	if expiresIn > n.certExpiryThreshold {
+		n.certExpiryNotified = false
+		return
+	}
+
+	if n.certExpiryNotified {
+		return
+	}
+
+	if handler := n.getCertExpiryHandler(); handler != nil {
+		n.certExpiryNotified = true
+		go handler(expiresIn)
+	}
+}
+
+func NewNode(comm commService, ps pingService, cm certManager, cs cryptoService, conf *Config) (*Node, error) {
 	var perInterval int
 
+	if conf == nil {
+		return nil, errNoConfig
+	}
+
+	push, pull, err := gossipMode(conf.GossipMode)
+	if err != nil {
+		return nil, err
+	}
+
 	v, err := discovery.NewView(cm.NumRings(), cm.Certificate(), comm, cs)
 	if err != nil {
 		log.Error(err.Error())
 		return nil, err
 	}
 
-	num := viper.GetInt("pings_per_interval")
+	clock := conf.Clock
+	if clock == nil {
+		clock = discovery.RealClock()
+	}
+
+	v.SetRemovalTimeout(conf.RemovalTimeout)
+	v.SetUpdateTimeout(conf.ViewUpdateInterval)
+	v.SetClock(clock)
+	v.SetMaxFull(int(conf.MaxViewSize))
+
+	if err := v.SetRingMask(conf.RingMask); err != nil {
+		return nil, err
+	}
+
+	if conf.ObserverMode {
+		if err := v.SetObserver(); err != nil {
+			return nil, err
+		}
+	}
+
+	num := int(conf.PingsPerInterval)
 	if num == 0 {
 		perInterval = 1
 	} else if rings := int(v.NumRings()); num > rings {
@@ -156,36 +579,91 @@ func NewNode(comm commService, ps pingService, cm certManager, cs cryptoService)
 	}
 
 	n := &Node{
-		exitChan:       make(chan bool, 1),
-		wg:             &sync.WaitGroup{},
-		gossipTimeout:  time.Second * time.Duration(viper.GetInt32("gossip_interval")),
-		monitorTimeout: time.Second * time.Duration(viper.GetInt32("monitor_interval")),
-		dispatcher: workerpool.NewDispatcher(uint32(viper.
-			GetInt32("max_concurrent_messages"))),
-		entryAddrs:       viper.GetStringSlice("entry_addrs"),
-		p:                correct{},
-		pingsPerInterval: perInterval,
-
-		fd:   newFd(ps, cs, uint32(viper.GetInt32("ping_limit"))),
+		exitChan:          make(chan bool, 1),
+		ready:             make(chan struct{}),
+		wg:                &sync.WaitGroup{},
+		gossipTimeout:     conf.GossipInterval,
+		gossipFanout:      int(conf.GossipFanout),
+		partnerSelector:   conf.PartnerSelector,
+		push:              push,
+		pull:              pull,
+		monitorTimeout:    conf.MonitorInterval,
+		viewUpdateTimeout: conf.ViewUpdateInterval,
+		messageTimeout:    conf.MessageTimeout,
+		dispatcher:        workerpool.NewDispatcher(conf.MaxConcurrentMessages),
+		msgSlots:          make(chan struct{}, conf.MaxConcurrentMessages),
+		sendQueue:         make(chan func(), sendQueueSize),
+		msgAbort:          make(chan struct{}),
+		entryAddrs:        conf.EntryAddrs,
+		seeds:             append([]string(nil), conf.SeedNodes...),
+		seedRetryTimeout:  conf.SeedRetryTimeout,
+		trustedCAs:        conf.TrustedCAs,
+		p:                 correct{},
+		pingsPerInterval:  perInterval,
+
+		gossipRoundTimeout: conf.GossipRoundTimeout,
+
+		certExpiryThreshold: conf.CertExpiryThreshold,
+		clockSkewThreshold:  conf.ClockSkewThreshold,
+		caPollInterval:      conf.CaPollInterval,
+
+		observer: conf.ObserverMode,
+
+		healthMissedRounds:     conf.HealthMissedRounds,
+		healthIsolationTimeout: conf.HealthIsolationTimeout,
+
+		keyPins: make(map[string][]byte, len(conf.KeyPins)),
+
+		fd:   newFd(ps, cs, []byte(v.Self().Id), conf.PingLimit),
 		cm:   cm,
 		cs:   cs,
 		comm: comm,
 		self: v.Self(),
 		view: v,
 
+		seenGossip:             newSeenCache(conf.GossipCacheSize),
+		gossipLimiter:          newRateLimiter(conf.GossipRateLimit, conf.GossipRateBurst),
+		maxGossipSize:          int(conf.MaxGossipSize),
+		versionedGossip:        make(map[string]*pb.Data),
+		versionedGossipUpdated: make(map[string]time.Time),
+		maxVersionedGossip:     maxVersionedGossip,
+		gossipCmp:              conf.GossipComparator,
+
+		events: newEventQueue(),
+		drops:  make(chan dropReport, dropQueueSize),
+		stats:  &recorder{},
+		tracer: conf.Tracer,
+		clock:  clock,
+
+		viewChange: make(chan struct{}),
+
 		// Visualizer specific
-		useViz: viper.GetBool("use_viz"),
+		useViz: conf.UseViz,
 	}
 
 	if n.useViz {
-		interval := time.Second * time.Duration(viper.GetInt32("viz_update_interval"))
-		viz, err := newViz(n, viper.GetString("viz_addr"), interval, cm.Trusted())
+		viz, err := newViz(n, conf.HttpAddr, conf.VizAddr, conf.VizUpdateInterval, cm.Trusted())
 		if err != nil {
 			return nil, err
 		}
 		n.viz = viz
 	}
 
+	for id, pin := range conf.KeyPins {
+		n.keyPins[id] = append([]byte(nil), pin...)
+	}
+
+	v.SetEventHandler(n.events.push)
+	ps.SetPingVerifier(n.handlePing)
+	ps.SetPongNote(n.localPbNote)
+	ps.SetErrorHandler(n.udpError)
+	n.fd.setNoteExchange(n.localPbNote, n.mergePeerNote)
+	n.fd.setSkewHandler(n.recordClockSkew)
+
+	if conf.TcpPingFallback {
+		n.fd.setTcpFallback(n.pingOverTcp)
+	}
+
 	n.comm.Register(n)
 
 	if n.cm.CaCertificate() != nil {
@@ -209,150 +687,1018 @@ func NewNode(comm commService, ps pingService, cm certManager, cs cryptoService)
 	return n, nil
 }
 
-func (n *Node) SendMessage(dest string, ch chan []byte, data []byte) {
+// Exactly one value is delivered through the channel, the response, nil on failure,
+// or a close if the context is cancelled, so a buffer of one keeps the send from blocking.
+// Cancelling the given context aborts the message and closes the reply channel.
+// Requests that are never answered are aborted by the message timeout or once the node stops.
+// Blocks while MaxConcurrentMessages messages are in flight, the context also bounds the wait.
+func (n *Node) SendMessage(ctx context.Context, dest string, ch chan []byte, data []byte) {
 	msg := &pb.Msg{
 		Content: data,
 	}
 
-	n.dispatcher.Submit(func() {
-		n.sendMsg(dest, ch, msg)
-	})
-}
-
-func (n *Node) Sign(content []byte) ([]byte, []byte, error) {
-	r, s, err := n.cs.Sign(content)
-	if err != nil {
-		return nil, nil, err
+	// Waiting for a slot here keeps messages beyond the limit out of the dispatcher.
+	if !n.acquireMsgSlot(ctx) {
+		close(ch)
+		return
 	}
 
-	return r, s, nil
+	n.submit(func() {
+		defer n.releaseMsgSlot()
+
+		n.sendMsg(ctx, dest, ch, msg)
+	})
 }
 
-func (n *Node) Verify(r, s, content []byte, id string) bool {
-	p := n.view.Peer(id)
-	if p == nil {
-		return false
-	}
+// Same as SendMessage, but returns right away, the message is sent by one of the send workers.
+// Returns ErrSendQueueFull if sendQueueSize sends are already waiting for a worker, and an error if the node is stopped.
+// Messages queued before the node starts are sent once it runs.
+func (n *Node) QueueMessage(ctx context.Context, dest string, ch chan []byte, data []byte) error {
+	return n.queueSend(func() {
+		n.SendMessage(ctx, dest, ch, data)
+	})
+}
 
-	return n.cs.Verify(content, r, s, p.PublicKey())
+// Same as SendMessageWithRetry, but queued as for QueueMessage.
+func (n *Node) QueueMessageWithRetry(id []byte, ch chan []byte, data []byte, policy RetryPolicy) error {
+	return n.queueSend(func() {
+		n.SendMessageWithRetry(id, ch, data, policy)
+	})
 }
 
-func (n *Node) IdToAddr(id []byte) (string, error) {
-	p := n.view.Peer(string(id))
-	if p == nil {
-		return "", errors.New("Could not find peer with specified id")
-	}
+func (n *Node) queueSend(job func()) error {
+	// Holding the lock keeps Stop from draining the queue before the send is in it.
+	n.exitMutex.Lock()
+	defer n.exitMutex.Unlock()
 
-	return p.Addr, nil
-}
+	if n.exitFlag {
+		return errStopped
+	}
 
-func (n *Node) SendMessages(dest []string, ch chan []byte, data []byte) {
-	msg := &pb.Msg{
-		Content: data,
+	select {
+	case n.sendQueue <- job:
+		return nil
+	default:
+		return ErrSendQueueFull
 	}
+}
 
-	for _, addr := range dest {
-		a := addr
-		n.dispatcher.Submit(func() {
-			n.sendMsg(a, ch, msg)
-		})
+// Runs queued sends until the node stops, MaxConcurrentMessages workers run this loop.
+func (n *Node) sendLoop() {
+	for {
+		select {
+		case <-n.exitChan:
+			return
+		case job := <-n.sendQueue:
+			job()
+		}
 	}
 }
 
-func (n *Node) OpenStream(dest string, input, reply chan []byte) {
-	n.dispatcher.Submit(func() {
-		n.openStream(dest, input, reply)
-	})
+// Runs the sends still queued after the node stopped, each fails right away and delivers its failure.
+func (n *Node) drainSendQueue() {
+	for {
+		select {
+		case job := <-n.sendQueue:
+			job()
+		default:
+			return
+		}
+	}
 }
 
-func (n *Node) SendStream(ch chan<- []byte, data []byte) {
-	ch <- data
+// Returns the number of messages currently waiting for a response, at most MaxConcurrentMessages.
+func (n *Node) InFlight() int {
+	return len(n.msgSlots)
 }
 
-func (n *Node) openStream(dest string, input, reply chan []byte) {
-	if err := n.comm.StreamMessenger(dest, input, reply); err != nil {
-		log.Error(err.Error())
+// Waits until fewer than MaxConcurrentMessages messages are in flight and takes a slot,
+// returns false if the context is done or the node stops first.
+func (n *Node) acquireMsgSlot(ctx context.Context) bool {
+	// A free slot must not win over a stopped node, the dispatcher no longer takes work then.
+	select {
+	case <-n.exitChan:
+		return false
+	case <-n.msgAbort:
+		return false
+	default:
 	}
-}
 
-func (n *Node) sendMsg(dest string, ch chan []byte, msg *pb.Msg) {
-	reply, err := n.comm.Send(dest, msg)
-	if err != nil {
-		log.Error(err.Error())
-		ch <- nil
+	select {
+	case n.msgSlots <- struct{}{}:
+		return true
+	case <-ctx.Done():
+		return false
+	case <-n.exitChan:
+		return false
+	case <-n.msgAbort:
+		return false
 	}
-	ch <- reply.GetContent()
 }
 
-func (n *Node) isStopping() bool {
-	n.exitMutex.Lock()
-	defer n.exitMutex.Unlock()
+func (n *Node) releaseMsgSlot() {
+	<-n.msgSlots
+}
 
-	if n.exitFlag {
-		return true
-	}
+// Controls how messages are retried, see SendMessageWithRetry.
+type RetryPolicy struct {
+	// Total number of attempts, values below one gives a single attempt.
+	MaxAttempts int
 
-	n.exitFlag = true
-	close(n.exitChan)
+	// Wait before the second attempt, doubled for each following attempt.
+	BaseBackoff time.Duration
 
-	return false
+	// Upper bound of the wait between attempts, zero means unbounded.
+	MaxBackoff time.Duration
 }
 
-func (n *Node) Stop() {
-	if n.isStopping() {
-		return
+// Sends the message to the peer with the given id, retrying failed attempts with exponential backoff.
+// The id is resolved to an address before each attempt, in case it changed in the view.
+// The first successful response is sent through the channel, or nil if all attempts failed,
+// the channel needs a buffer of one as nothing else is sent.
+func (n *Node) SendMessageWithRetry(id []byte, ch chan []byte, data []byte, policy RetryPolicy) {
+	msg := &pb.Msg{
+		Content: data,
 	}
 
-	if n.useViz {
-		n.viz.stop()
-	}
+	backoff := policy.BaseBackoff
 
-	n.view.Stop()
-	n.fd.stop()
+	for attempt := 1; ; attempt++ {
+		addr, err := n.IdToAddr(id)
+		if err == nil {
+			reply, err := n.sendAttempt(addr, msg)
+			if err == nil {
+				deliverReply(ch, reply)
+				return
+			}
+			log.Debug(err.Error(), "addr", addr, "attempt", attempt)
+		}
 
-	n.dispatcher.Stop()
-	n.wg.Wait()
-}
+		if attempt >= policy.MaxAttempts {
+			deliverReply(ch, nil)
+			return
+		}
 
-func (n *Node) LiveMembers() []string {
-	live := n.view.Live()
+		t := n.clock.NewTimer(backoff)
 
-	ret := make([]string, 0, len(live))
+		select {
+		case <-n.exitChan:
+			t.Stop()
+			deliverReply(ch, nil)
+			return
+		case <-t.C():
+		}
 
-	for _, p := range n.view.Live() {
-		ret = append(ret, p.Addr)
+		backoff *= 2
+		if policy.MaxBackoff > 0 && backoff > policy.MaxBackoff {
+			backoff = policy.MaxBackoff
+		}
 	}
-
-	return ret
 }
 
-func (n *Node) HttpAddr() string {
-	return n.self.HttpAddr
-}
+// Sends the message through the dispatcher and waits for the result.
+func (n *Node) sendAttempt(dest string, msg *pb.Msg) ([]byte, error) {
+	var reply *pb.MsgResponse
+	var err error
 
-func (n *Node) Id() string {
-	return n.self.Id
-}
+	done := make(chan struct{})
 
-func (n *Node) Addr() string {
-	return n.comm.Addr()
+	if !n.acquireMsgSlot(context.Background()) {
+		return nil, errStopped
+	}
+
+	n.submit(func() {
+		defer close(done)
+		defer n.releaseMsgSlot()
+
+		ctx, cancel := n.messageContext(context.Background())
+		defer cancel()
+
+		n.stats.recordMsgSent()
+
+		reply, err = n.send(ctx, dest, msg)
+	})
+
+	<-done
+
+	if err != nil {
+		return nil, err
+	}
+
+	return replyContent(reply), nil
+}
+
+// Sends the message without a reply path, the response is discarded.
+// Returns once the message is queued for sending, or an error if the node is not running.
+// Like SendMessage, blocks while MaxConcurrentMessages messages are in flight.
+func (n *Node) Notify(dest string, data []byte) error {
+	if !n.running() {
+		return errNotRunning
+	}
+
+	msg := &pb.Msg{
+		Content: data,
+	}
+
+	ctx, cancel := n.messageContext(context.Background())
+
+	if !n.acquireMsgSlot(ctx) {
+		cancel()
+		return errNotRunning
+	}
+
+	// Holding the lock keeps Stop from stopping the dispatcher before the notification is submitted.
+	n.exitMutex.Lock()
+	defer n.exitMutex.Unlock()
+
+	if n.exitFlag {
+		n.releaseMsgSlot()
+		cancel()
+		return errNotRunning
+	}
+
+	n.submit(func() {
+		defer n.releaseMsgSlot()
+		defer cancel()
+
+		n.stats.recordMsgSent()
+
+		if _, err := n.send(ctx, dest, msg); err != nil {
+			log.Error(err.Error(), "addr", dest)
+		}
+	})
+
+	return nil
 }
 
-func (n *Node) Start() {
+func gossipMode(mode string) (bool, bool, error) {
+	switch mode {
+	case "", "push":
+		return true, false, nil
+	case "pull":
+		return false, true, nil
+	case "push-pull":
+		return true, true, nil
+	default:
+		return false, false, errGossipMode
+	}
+}
+
+// Returns a random peer from the live view, nil if it is empty.
+func (n *Node) pullPartner() *discovery.Peer {
+	live := n.view.Live()
+	if len(live) == 0 {
+		return nil
+	}
+
+	return live[rand.Intn(len(live))]
+}
+
+// Returns the neighbours to gossip with this interval.
+func (n *Node) gossipPartners() []*discovery.Peer {
+	if n.partnerSelector != nil {
+		return n.selectedPartners()
+	}
+
+	if n.gossipFanout == 0 {
+		return n.view.GossipPartners()
+	}
+
+	candidates := n.view.MyNeighbours()
+	if n.gossipFanout >= len(candidates) {
+		return candidates
+	}
+
+	rand.Shuffle(len(candidates), func(i, j int) {
+		candidates[i], candidates[j] = candidates[j], candidates[i]
+	})
+
+	return candidates[:n.gossipFanout]
+}
+
+func (n *Node) Sign(content []byte) ([]byte, []byte, error) {
+	r, s, err := n.cs.Sign(content)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return r, s, nil
+}
+
+func (n *Node) Verify(r, s, content []byte, id string) bool {
+	p := n.view.Peer(id)
+	if p == nil {
+		return false
+	}
+
+	return n.cs.Verify(content, r, s, p.PublicKey())
+}
+
+// Same as Verify, but the signature is checked against the certificate of the peer
+// that was valid at the given time. Renewed certificates replace older ones as peers gossip,
+// the last few replaced certificates of each peer are kept.
+func (n *Node) VerifyAt(r, s, content, id []byte, at time.Time) bool {
+	p := n.view.Peer(string(id))
+	if p == nil {
+		return false
+	}
+
+	cert := p.CertificateAt(at)
+	if cert == nil {
+		return false
+	}
+
+	pub, ok := cert.PublicKey.(*ecdsa.PublicKey)
+	if !ok {
+		return false
+	}
+
+	return n.cs.Verify(content, r, s, pub)
+}
+
+func (n *Node) IdToAddr(id []byte) (string, error) {
+	p := n.view.Peer(string(id))
+	if p == nil {
+		return "", errors.New("Could not find peer with specified id")
+	}
+
+	return p.Addr, nil
+}
+
+// Returns the id of the peer listening on the given address, nil if it is not in the full view.
+func (n *Node) addrToId(addr string) []byte {
+	for _, p := range n.view.Full() {
+		if p.Addr == addr {
+			return []byte(p.Id)
+		}
+	}
+
+	return nil
+}
+
+// Removes the peer with the given id from the live view immediately,
+// instead of waiting for its accusation to time out.
+// The peer is accused on every ring where we are its predecessor,
+// the accusations reach other nodes as they gossip with us so they evict it as well.
+func (n *Node) EvictPeer(id []byte) error {
+	var ringNum uint32
+
+	if string(id) == n.self.Id {
+		return errEvictSelf
+	}
+
+	p := n.view.Peer(string(id))
+	if p == nil {
+		return errUnknownPeer
+	}
+
+	// Accusations must be created before the peer leaves our rings,
+	// afterwards we can no longer tell if we are its predecessor.
+	if note := p.Note(); note != nil {
+		numRings := n.view.NumRings()
+
+		for ringNum = 1; ringNum <= numRings; ringNum++ {
+			if note.IsRingDisabled(ringNum, numRings) || !n.view.ValidAccuser(p, n.self, ringNum) {
+				continue
+			}
+
+			err := p.CreateAccusation(note, n.self, ringNum, n.cs)
+			if err == nil {
+				n.stats.recordAccusation()
+			} else if err != discovery.ErrAccAlreadyExists {
+				log.Error(err.Error())
+			}
+		}
+	}
+
+	n.view.RemoveLive(p.Id)
+	n.view.DeleteTimeout(p.Id)
+
+	return nil
+}
+
+// Returns a copy of the certificate of the peer with the given id.
+func (n *Node) IdToCertificate(id []byte) (*x509.Certificate, error) {
+	p := n.view.Peer(string(id))
+	if p == nil {
+		return nil, errPeerNotFound
+	}
+
+	return x509.ParseCertificate(p.Certificate())
+}
+
+// Requests a renewed certificate from the CA and starts using it for all new connections.
+// Peers keep accepting the old certificate until they have received the new one through gossip.
+func (n *Node) RotateCertificate() error {
+	cert, err := n.cm.RenewCertificate()
+	if err != nil {
+		n.caRequestFailed()
+		return err
+	}
+
+	if err := n.view.RotateCertificate(cert); err != nil {
+		return err
+	}
+
+	n.comm.SetCertificate(cert)
+
+	return nil
+}
+
+// Returns the epoch of our own note, peers replace their copy of the note once they receive a higher one.
+func (n *Node) LocalEpoch() uint64 {
+	return n.view.LocalEpoch()
+}
+
+// Signs our note again with the next epoch. Every gossip message carries our note,
+// so peers holding an older one accept the new one from the next gossip round on.
+// Returns an error if the note already is at the highest possible epoch.
+func (n *Node) RefreshNote() error {
+	return n.view.RefreshNote()
+}
+
+// Sends the message to all given destinations, one value per destination is delivered
+// through the channel as for SendMessage, so its buffer needs room for all of them.
+func (n *Node) SendMessages(dest []string, ch chan []byte, data []byte) {
+	msg := &pb.Msg{
+		Content: data,
+	}
+
+	for _, addr := range dest {
+		a := addr
+		n.submit(func() {
+			n.sendMsg(context.Background(), a, ch, msg)
+		})
+	}
+}
+
+// Opens a stream to the given destination, window bounds the number of
+// unacknowledged messages in flight, zero disables flow control.
+// Messages are compressed with the given algorithm, empty uses the compression of all other rpcs.
+func (n *Node) OpenStream(dest string, input, reply chan []byte, window uint32, compression string) {
+	n.submit(func() {
+		n.openStream(dest, n.addrToId(dest), input, reply, window, compression)
+	})
+}
+
+// Same as OpenStream, but the destination is the id of an observed peer.
+// Fails right away if the id is unknown, the stream is opened in the background.
+func (n *Node) OpenStreamToId(destId []byte, input, reply chan []byte, window uint32, compression string) error {
+	dest, err := n.IdToAddr(destId)
+	if err != nil {
+		return err
+	}
+
+	go n.submit(func() {
+		n.openStream(dest, destId, input, reply, window, compression)
+	})
+
+	return nil
+}
+
+// Submits outgoing work to the dispatcher, tracking it until it completes
+// so that shutdown can wait for it.
+func (n *Node) submit(job func()) {
+	n.inflight.Add(1)
+	atomic.AddInt64(&n.numInflight, 1)
+
+	n.dispatcher.Submit(func() {
+		defer n.inflight.Done()
+		defer atomic.AddInt64(&n.numInflight, -1)
+
+		job()
+	})
+}
+
+func (n *Node) SendStream(ch chan<- []byte, data []byte) {
+	ch <- data
+}
+
+func (n *Node) openStream(dest string, destId []byte, input, reply chan []byte, window uint32, compression string) {
+	if err := n.comm.StreamMessenger(dest, input, reply, window, compression, n.streamFrameDropped(destId)); err != nil {
+		log.Error(err.Error())
+	}
+}
+
+func (n *Node) sendMsg(ctx context.Context, dest string, ch chan []byte, msg *pb.Msg) {
+	// Context might have been cancelled while waiting in the dispatcher queue.
+	if ctx.Err() != nil {
+		close(ch)
+		return
+	}
+
+	n.stats.recordMsgSent()
+
+	sendCtx, cancel := n.messageContext(ctx)
+	defer cancel()
+
+	// Only the caller's context closes the channel, the default timeout is a regular failure.
+	reply, err := n.send(sendCtx, dest, msg)
+	if err != nil {
+		log.Error(err.Error())
+		if ctx.Err() != nil {
+			close(ch)
+		} else {
+			deliverReply(ch, nil)
+		}
+		return
+	}
+	deliverReply(ch, replyContent(reply))
+}
+
+// Sends the single reply of a message without blocking, a full channel means that
+// the caller did not leave room for it, dropping it keeps the worker from leaking.
+func deliverReply(ch chan []byte, reply []byte) {
+	select {
+	case ch <- reply:
+	default:
+		log.Error(errReplyDropped.Error(), "capacity", cap(ch))
+	}
+}
+
+// Content of a message response, never nil so that an empty response can be told apart from a failed one.
+func replyContent(reply *pb.MsgResponse) []byte {
+	if content := reply.GetContent(); content != nil {
+		return content
+	}
+
+	return []byte{}
+}
+
+// Shows the message to the gossip tap, if any, before gossiping it to the given address.
+func (n *Node) gossip(ctx context.Context, addr string, msg *pb.State) (*pb.StateResponse, error) {
+	if tap := n.getGossipTap(); tap != nil {
+		tap(addr, msg)
+	}
+
+	return n.comm.Gossip(ctx, addr, msg)
+}
+
+// Sends the message within a span, the trace context is passed along to the receiver.
+func (n *Node) send(ctx context.Context, dest string, msg *pb.Msg) (*pb.MsgResponse, error) {
+	ctx, end := n.startSpan(ctx, spanSendMessage, nil, dest, msg)
+	defer end()
+
+	return n.comm.Send(n.injectTrace(ctx), dest, msg)
+}
+
+// Sends the message to a host that does not have to be in the view and returns its response.
+// The certificate the host presents is checked as if received from a peer on the connection carrying the message,
+// the message is only sent if it is accepted. The host is neither added to the view nor kept connected.
+// Returns an error wrapping ErrPeerCert if the certificate is rejected.
+func (n *Node) SendToHost(ctx context.Context, addr string, data []byte) ([]byte, error) {
+	if !n.acquireMsgSlot(ctx) {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		return nil, errStopped
+	}
+	defer n.releaseMsgSlot()
+
+	ctx, cancel := n.messageContext(ctx)
+	defer cancel()
+
+	msg := &pb.Msg{
+		Content: data,
+	}
+
+	verify := func(cert *x509.Certificate) error {
+		if err := n.checkCertificate(cert); err != nil {
+			return fmt.Errorf("%w: %s", ErrPeerCert, err.Error())
+		}
+		return nil
+	}
+
+	n.stats.recordMsgSent()
+
+	ctx, end := n.startSpan(ctx, spanSendMessage, nil, addr, msg)
+	defer end()
+
+	reply, err := n.comm.SendToHost(n.injectTrace(ctx), addr, msg, verify)
+	if err != nil {
+		return nil, err
+	}
+
+	return replyContent(reply), nil
+}
+
+// Applies the default message timeout unless the given context already has a deadline.
+// The context is cancelled as well once the node stops, so that requests to peers that never
+// answer do not outlive it, the returned cancel function must be called to release it.
+func (n *Node) messageContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	cancelTimeout := func() {}
+
+	if _, ok := ctx.Deadline(); !ok && n.messageTimeout > 0 {
+		ctx, cancelTimeout = context.WithTimeout(ctx, n.messageTimeout)
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+
+	go func() {
+		select {
+		case <-n.msgAbort:
+		case <-ctx.Done():
+		}
+		cancel()
+	}()
+
+	return ctx, func() {
+		cancel()
+		cancelTimeout()
+	}
+}
+
+// Aborts all outgoing messages, see messageContext.
+func (n *Node) abortMessages() {
+	n.msgAbortOnce.Do(func() {
+		close(n.msgAbort)
+	})
+}
+
+// Returns the default timeout of messages, zero if there is none.
+func (n *Node) MessageTimeout() time.Duration {
+	return n.messageTimeout
+}
+
+func (n *Node) isStopping() bool {
+	n.exitMutex.Lock()
+	defer n.exitMutex.Unlock()
+
+	if n.exitFlag {
+		return true
+	}
+
+	n.exitFlag = true
+	close(n.exitChan)
+
+	return false
+}
+
+func (n *Node) Stop() {
+	if n.isStopping() {
+		return
+	}
+
+	if n.useViz {
+		n.viz.stop()
+	}
+
+	n.view.Stop()
+	n.fd.stop()
+
+	n.abortMessages()
+	n.dispatcher.Stop()
+	n.wg.Wait()
+
+	n.drainSendQueue()
+}
+
+// Stops initiating gossip, monitoring and removal of accused peers until Resume is called.
+// Incoming requests are still served, but staying paused for longer than
+// the removal timeout risks being evicted by other peers.
+func (n *Node) Pause() {
+	n.setPaused(true)
+	n.view.Pause()
+}
+
+// Resumes gossip, monitoring and removal of accused peers after Pause.
+func (n *Node) Resume() {
+	n.view.Resume()
+	n.setPaused(false)
+}
+
+func (n *Node) paused() bool {
+	n.pauseMutex.RLock()
+	defer n.pauseMutex.RUnlock()
+
+	return n.pauseFlag
+}
+
+func (n *Node) setPaused(paused bool) {
+	n.pauseMutex.Lock()
+	defer n.pauseMutex.Unlock()
+
+	n.pauseFlag = paused
+}
+
+// Announces our departure to all ring neighbours before stopping,
+// so that they remove us right away instead of waiting for accusations to time out.
+func (n *Node) Leave() error {
+	if !n.running() {
+		return errNotRunning
+	}
+
+	if err := n.view.Leave(); err != nil {
+		return err
+	}
+
+	n.announceLeave()
+	n.Stop()
+
+	return nil
+}
+
+// The announcement is a final gossip round, neighbours that do not answer within the round timeout are given up on.
+func (n *Node) announceLeave() {
+	var wg sync.WaitGroup
+
+	ctx, cancel := n.gossipRoundContext()
+	defer cancel()
+
+	msg := &pb.State{
+		OwnNote: n.self.Note().ToPbMsg(),
+	}
+
+	for _, p := range n.view.MyNeighbours() {
+		wg.Add(1)
+		go func(addr string) {
+			defer wg.Done()
+
+			if _, err := n.gossip(ctx, addr, msg); err != nil {
+				log.Error(err.Error(), "addr", addr)
+			}
+		}(p.Addr)
+	}
+
+	wg.Wait()
+}
+
+// Same as Stop, but waits for outgoing messages, streams and incoming rpcs to complete
+// before stopping the rpc server. If the context is done first, the rpc server is
+// forcefully stopped and an error describing the remaining work is returned.
+func (n *Node) StopWithContext(ctx context.Context) error {
+	if n.isStopping() {
+		return errStopped
+	}
+
+	if n.useViz {
+		n.viz.stop()
+	}
+
+	n.view.Stop()
+	n.fd.stop()
+
+	drained := make(chan struct{})
+
+	go func() {
+		n.wg.Wait()
+		n.inflight.Wait()
+		n.comm.GracefulStop()
+		close(drained)
+	}()
+
+	// The send workers exit with the node, sends still queued fail once the dispatcher is stopped.
+	select {
+	case <-drained:
+		n.dispatcher.Stop()
+		n.abortMessages()
+		n.drainSendQueue()
+		return nil
+	case <-ctx.Done():
+		remaining := atomic.LoadInt64(&n.numInflight)
+		n.abortMessages()
+		n.comm.Stop()
+		n.dispatcher.Stop()
+		n.drainSendQueue()
+		return fmt.Errorf("Shutdown aborted with %d outgoing messages or streams in flight: %s",
+			remaining, ctx.Err().Error())
+	}
+}
+
+// Diagnostic information about a peer in the full view.
+type PeerInfo struct {
+	Id   string
+	Addr string
+
+	// Zone label of the certificate of the peer, empty if it has none.
+	Zone string
+
+	// If the peer is in the live view.
+	Live bool
+
+	// Epoch of the most recent note, zero if no note has been received.
+	Epoch uint64
+
+	// If the peer has outstanding accusations.
+	Accused bool
+}
+
+// Returns the members of every ring along with this node's successor and predecessor on it.
+func (n *Node) RingTopology() []discovery.RingView {
+	return n.view.RingTopology()
+}
+
+// Returns information about all peers in the full view, live or not.
+func (n *Node) ViewSnapshot() []PeerInfo {
+	full := n.view.Full()
+
+	ret := make([]PeerInfo, 0, len(full))
+
+	for _, p := range full {
+		ret = append(ret, n.peerInfo(p))
+	}
+
+	return ret
+}
+
+// Returns the peers monitoring this node, its predecessor on each ring.
+func (n *Node) Monitors() []PeerInfo {
+	monitors, _ := n.view.MonitorAssignment()
+
+	return n.peerInfos(monitors)
+}
+
+// Returns the peers this node monitors, its successor on each ring.
+func (n *Node) Monitoring() []PeerInfo {
+	_, monitoring := n.view.MonitorAssignment()
+
+	return n.peerInfos(monitoring)
+}
+
+func (n *Node) peerInfos(peers []*discovery.Peer) []PeerInfo {
+	ret := make([]PeerInfo, 0, len(peers))
+
+	for _, p := range peers {
+		ret = append(ret, n.peerInfo(p))
+	}
+
+	return ret
+}
+
+func (n *Node) peerInfo(p *discovery.Peer) PeerInfo {
+	info := PeerInfo{
+		Id:      p.Id,
+		Addr:    p.Addr,
+		Zone:    p.Zone,
+		Live:    n.view.IsAlive(p.Id),
+		Accused: p.IsAccused(),
+	}
+
+	if note := p.Note(); note != nil {
+		info.Epoch = note.Epoch()
+	}
+
+	return info
+}
+
+func (n *Node) LiveMembers() []string {
+	live := n.view.Live()
+
+	ret := make([]string, 0, len(live))
+
+	for _, p := range live {
+		ret = append(ret, p.Addr)
+	}
+
+	return ret
+}
+
+// Same as LiveMembers, but only the peers whose certificate carries the given zone label.
+func (n *Node) LiveMembersInZone(zone string) []string {
+	var ret []string
+
+	for _, p := range n.view.Live() {
+		if p.Zone == zone {
+			ret = append(ret, p.Addr)
+		}
+	}
+
+	return ret
+}
+
+// Returns the zone label of our own certificate, empty if it has none.
+func (n *Node) Zone() string {
+	return n.self.Zone
+}
+
+// Returns true if the peer with the given id is in the live view.
+// Peers only in the full view, such as those removed after an accusation, are not live.
+func (n *Node) IsLive(id []byte) bool {
+	return n.view.IsAlive(string(id))
+}
+
+// Same as IsLive, but for the peer with the given rpc address.
+func (n *Node) IsLiveAddr(addr string) bool {
+	return n.view.IsAliveAddr(addr)
+}
+
+func (n *Node) HttpAddr() string {
+	return n.self.HttpAddr
+}
+
+func (n *Node) Id() string {
+	return n.self.Id
+}
+
+// Address of the rpc server as advertised in the node's certificate.
+func (n *Node) Addr() string {
+	return n.self.Addr
+}
+
+// Blocks until the node is stopped, or until the rpc or http server fails.
+// Returns the error of the failing server, nil if stopped.
+func (n *Node) Start() error {
+	if err := n.setStarted(); err != nil {
+		return err
+	}
+
+	return n.run()
+}
+
+// Starts the node in the background and returns immediately.
+// The first returned channel is closed once the node is participating in the network, or once it failed to start,
+// in which case the error already waits in the second one.
+// The second receives what Start would return once the node stops, the error of a failing server or nil.
+func (n *Node) StartAsync() (<-chan struct{}, <-chan error, error) {
+	if err := n.setStarted(); err != nil {
+		return nil, nil, err
+	}
+
+	errChan := make(chan error, 1)
+
+	go func() {
+		errChan <- n.run()
+
+		// Failures before the node participated leave ready open,
+		// the error is sent first so it can be read once ready is closed.
+		n.signalReady()
+	}()
+
+	return n.ready, errChan, nil
+}
+
+func (n *Node) signalReady() {
+	n.readyOnce.Do(func() {
+		close(n.ready)
+	})
+}
+
+func (n *Node) running() bool {
+	n.startMutex.Lock()
+	started := n.startFlag
+	n.startMutex.Unlock()
+
+	n.exitMutex.Lock()
+	defer n.exitMutex.Unlock()
+
+	return started && !n.exitFlag
+}
+
+func (n *Node) setStarted() error {
+	n.startMutex.Lock()
+	defer n.startMutex.Unlock()
+
+	if n.startFlag {
+		return errStarted
+	}
+
+	n.startFlag = true
+
+	return nil
+}
+
+func (n *Node) run() error {
 	log.Info("Started Node")
 
+	n.healthMutex.Lock()
+	n.startedAt = n.clock.Now()
+	n.healthMutex.Unlock()
+
+	n.updateIsolation(n.startedAt)
+
+	errChan := make(chan error, 2)
+
 	go n.fd.start()
-	go n.comm.Start()
+	go func() {
+		if err := n.comm.Start(); err != nil {
+			errChan <- err
+		}
+	}()
 	go n.view.Start()
 
-	n.wg.Add(2)
+	n.wg.Add(4)
 	go n.gossipLoop()
 	go n.monitorLoop()
+	go n.eventLoop()
+	go n.dropLoop()
+
+	if n.caPollInterval > 0 && n.cm.CaCertificate() != nil {
+		n.wg.Add(1)
+		go n.caPollLoop()
+	}
 
 	n.dispatcher.Start()
 
+	for i := 0; i < cap(n.msgSlots); i++ {
+		go n.sendLoop()
+	}
+
 	if n.useViz {
-		n.viz.start()
+		go func() {
+			if err := n.viz.start(); err != nil && err != http.ErrServerClosed {
+				errChan <- err
+			}
+		}()
 	}
 
 	msg := n.collectGossipContent()
@@ -361,21 +1707,35 @@ func (n *Node) Start() {
 	// TODO retry if we fail to contact them?
 	if n.cm.CaCertificate() == nil {
 		for _, addr := range n.entryAddrs {
-			reply, err := n.comm.Gossip(addr, msg)
-			if err != nil {
+			if err := n.bootstrap(context.Background(), addr, msg); err != nil {
 				log.Error(err.Error(), "addr", addr)
-				continue
 			}
-
-			n.mergeCertificates(reply.GetCertificates())
-			n.mergeNotes(reply.GetNotes())
-			n.mergeAccusations(reply.GetAccusations())
 		}
 	}
 
-	<-n.exitChan
+	// Servers failing right away are reported before readiness.
+	select {
+	case err := <-errChan:
+		log.Error(err.Error())
+		n.Stop()
+		return err
+	default:
+	}
+
+	n.signalReady()
+
+	select {
+	case err := <-errChan:
+		log.Error(err.Error())
+		n.Stop()
+		return err
+	case <-n.exitChan:
+	}
+
 	log.Info("Exiting node")
 	n.Stop()
+
+	return nil
 }
 
 func (n *Node) SavePrivateKey(path string) error {
@@ -385,3 +1745,17 @@ func (n *Node) SavePrivateKey(path string) error {
 func (n *Node) SaveCertificate(path string) error {
 	return n.cm.SaveCertificate(path)
 }
+
+func (n *Node) ExportIdentity() ([]byte, error) {
+	return n.cm.ExportIdentity()
+}
+
+// Returns a copy of the node's current certificate, DER encoded.
+func (n *Node) Certificate() []byte {
+	return append([]byte(nil), n.cm.Certificate().Raw...)
+}
+
+// Returns a copy of the node's public key, DER encoded as a PKIX subject public key info.
+func (n *Node) PublicKey() []byte {
+	return append([]byte(nil), n.cm.Certificate().RawSubjectPublicKeyInfo...)
+}
@@ -5,17 +5,25 @@ import (
 	"crypto/rand"
 	"crypto/tls"
 	"crypto/x509"
+	"encoding/json"
+	"errors"
 	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
 	"os"
+	"strconv"
+	"sync"
 	"testing"
 	"time"
 
 	log "github.com/inconshreveable/log15"
-	"github.com/spf13/viper"
 	"github.com/stretchr/testify/require"
 	"github.com/stretchr/testify/suite"
+	"go.uber.org/goleak"
 	"golang.org/x/net/context"
 
+	"github.com/joonnna/ifrit/core/discovery"
 	pb "github.com/joonnna/ifrit/protobuf"
 )
 
@@ -32,48 +40,1817 @@ func TestNodeTestSuite(t *testing.T) {
 
 	r.SetHandler(log.CallerFileHandler(log.StreamHandler(os.Stdout, log.TerminalFormat())))
 
-	viper.Set("use_ca", false)
-	viper.Set("use_viz", false)
+	suite.Run(t, new(NodeTestSuite))
+}
+
+func (suite *NodeTestSuite) SetupTest() {
+	suite.nodes = nil
+
+	for i := 0; i < 30; i++ {
+
+		priv, err := genKeys()
+		require.NoError(suite.T(), err, "Failed to generate keys")
+
+		ownCert := genCert(priv, 10)
+		n, err := NewNode(&commStub{}, &pingStub{}, &cmStub{cert: ownCert}, &cryptoStub{priv: priv}, testConfig())
+		require.NoError(suite.T(), err, "Failed to create node.")
+
+		suite.nodes = append(suite.nodes, n)
+	}
+}
+
+func (suite *NodeTestSuite) TestGossip() {
+	// Everyone gossips with everyone,
+	// then assert that everyone has the same view.
+
+	for _, n := range suite.nodes {
+		for _, n2 := range suite.nodes {
+			reply, err := n2.Spread(context.Background(), n.collectGossipContent())
+			if err != nil {
+				continue
+			}
+			n.mergeCertificates(reply.GetCertificates())
+			n.mergeNotes(reply.GetNotes())
+			n.mergeAccusations(reply.GetAccusations())
+		}
+	}
+
+	for _, n := range suite.nodes {
+		for _, n2 := range suite.nodes {
+			require.NoError(suite.T(), n.view.Compare(n2.view), "Views are not equal.")
+		}
+	}
+
+}
+
+func (suite *NodeTestSuite) TestSendMessageCancelled() {
+	n := suite.nodes[0]
+
+	ch := make(chan []byte, 1)
+	n.sendMsg(context.Background(), "addr", ch, &pb.Msg{})
+	reply, ok := <-ch
+	require.True(suite.T(), ok, "Reply channel closed without cancellation.")
+	require.NotNil(suite.T(), reply, "Empty response mistaken for a failed one.")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	ch = make(chan []byte, 1)
+	n.sendMsg(ctx, "addr", ch, &pb.Msg{})
+	_, ok = <-ch
+	require.False(suite.T(), ok, "Reply channel should be closed on cancelled context.")
+}
+
+func (suite *NodeTestSuite) TestMembershipEvents() {
+	n := suite.nodes[0]
+
+	ch := make(chan discovery.Event, 3)
+	n.SetMembershipHandler(func(e discovery.Event) {
+		ch <- e
+	})
+
+	n.wg.Add(1)
+	go n.eventLoop()
+	defer func() {
+		close(n.exitChan)
+		n.wg.Wait()
+	}()
+
+	p := suite.nodes[1].self
+
+	n.view.AddLive(p)
+	n.view.RemoveLive(p.Id)
+
+	for _, kind := range []discovery.EventKind{discovery.Joined, discovery.Left} {
+		select {
+		case e := <-ch:
+			require.Equal(suite.T(), kind, e.Kind, "Events delivered out of order.")
+			require.Equal(suite.T(), p.Id, e.Id, "Event has wrong peer id.")
+		case <-time.After(time.Second):
+			suite.T().Fatal("Membership event was not delivered.")
+		}
+	}
+}
+
+func (suite *NodeTestSuite) TestMembershipEventsChannel() {
+	n := suite.nodes[0]
+
+	ch := n.MembershipEvents()
+	slow := n.MembershipEvents()
+
+	// Queued before the event loop starts, so that all are delivered in one batch.
+	total := eventChanSize + 10
+	for i := 0; i < total; i++ {
+		n.events.push(discovery.Event{Id: strconv.Itoa(i), Kind: discovery.Joined})
+	}
+
+	changed := n.membershipChanged()
+
+	n.wg.Add(1)
+	go n.eventLoop()
+
+	select {
+	case <-changed:
+	case <-time.After(time.Second * 5):
+		suite.T().Fatal("Membership events were not delivered.")
+	}
+
+	for _, c := range []<-chan discovery.Event{ch, slow} {
+		require.Len(suite.T(), c, eventChanSize, "Channel not filled to its buffer size.")
+	}
+
+	for i := total - eventChanSize; i < total; i++ {
+		require.Equal(suite.T(), strconv.Itoa(i), (<-ch).Id, "Oldest events not dropped first.")
+	}
+
+	close(n.exitChan)
+	n.wg.Wait()
+
+	require.Len(suite.T(), slow, eventChanSize, "Buffered events lost on stop.")
+	for range slow {
+	}
+
+	_, ok := <-ch
+	require.False(suite.T(), ok, "Channel not closed on stop.")
+
+	_, ok = <-n.MembershipEvents()
+	require.False(suite.T(), ok, "Channel requested after stop not closed.")
+}
+
+func (suite *NodeTestSuite) TestWaitForPeer() {
+	n := suite.nodes[0]
+
+	n.wg.Add(1)
+	go n.eventLoop()
+	defer func() {
+		close(n.exitChan)
+		n.wg.Wait()
+	}()
+
+	cert := suite.nodes[1].cm.Certificate()
+	id := cert.SubjectKeyId
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*50)
+	defer cancel()
+
+	_, err := n.WaitForPeer(ctx, id)
+	require.Equal(suite.T(), context.DeadlineExceeded, err, "Returned before the peer joined.")
+
+	go func() {
+		time.Sleep(time.Millisecond * 50)
+		require.NoError(suite.T(), n.view.AddFull(string(id), cert), "Failed to add peer.")
+		n.view.AddLive(n.view.Peer(string(id)))
+	}()
+
+	ctx, cancel = context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+
+	addr, err := n.WaitForPeer(ctx, id)
+	require.NoError(suite.T(), err, "Peer never resolved.")
+	require.Equal(suite.T(), suite.nodes[1].self.Addr, addr, "Resolved wrong address.")
+
+	addr, err = n.WaitForPeer(context.Background(), id)
+	require.NoError(suite.T(), err, "Known peer not resolved at once.")
+	require.Equal(suite.T(), suite.nodes[1].self.Addr, addr, "Resolved wrong address.")
+}
+
+func (suite *NodeTestSuite) TestWaitForMembers() {
+	n := suite.nodes[0]
+
+	n.wg.Add(1)
+	go n.eventLoop()
+	defer func() {
+		close(n.exitChan)
+		n.wg.Wait()
+	}()
+
+	require.NoError(suite.T(), n.WaitForMembers(context.Background(), 0), "Waited for no members.")
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*50)
+	defer cancel()
+
+	require.Equal(suite.T(), context.DeadlineExceeded, n.WaitForMembers(ctx, 2), "Returned before members joined.")
+
+	errChan := make(chan error, 2)
+	go func() {
+		for i := 0; i < 2; i++ {
+			time.Sleep(time.Millisecond * 20)
+			_, _, err := addPeer(n)
+			errChan <- err
+		}
+	}()
+
+	ctx, cancel = context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+
+	require.NoError(suite.T(), n.WaitForMembers(ctx, 2), "Members never joined.")
+	require.Len(suite.T(), n.LiveMembers(), 2, "Returned before members joined.")
+
+	for i := 0; i < 2; i++ {
+		require.NoError(suite.T(), <-errChan, "Could not add peer.")
+	}
+}
+
+func (suite *NodeTestSuite) TestStartServerFailure() {
+	priv, err := genKeys()
+	require.NoError(suite.T(), err, "Failed to generate keys")
+
+	serveErr := errors.New("serve failed")
+
+	n, err := NewNode(&failingCommStub{err: serveErr}, &pingStub{}, &cmStub{cert: genCert(priv, 10)}, &cryptoStub{priv: priv}, testConfig())
+	require.NoError(suite.T(), err, "Failed to create node.")
+
+	errChan := make(chan error, 1)
+	go func() {
+		errChan <- n.Start()
+	}()
+
+	select {
+	case err := <-errChan:
+		require.Equal(suite.T(), serveErr, err, "Start did not return the server error.")
+	case <-time.After(time.Second * 5):
+		n.Stop()
+		suite.T().Fatal("Start did not return on server failure.")
+	}
+}
+
+func (suite *NodeTestSuite) TestStartAsync() {
+	n := suite.nodes[0]
+
+	ready, errChan, err := n.StartAsync()
+	require.NoError(suite.T(), err, "Failed to start node.")
+
+	select {
+	case <-ready:
+	case <-time.After(time.Second * 5):
+		n.Stop()
+		suite.T().Fatal("Node never signalled readiness.")
+	}
+
+	_, _, err = n.StartAsync()
+	require.EqualError(suite.T(), err, errStarted.Error(), "Should not start twice.")
+	require.EqualError(suite.T(), n.Start(), errStarted.Error(), "Should not start twice.")
+
+	n.Stop()
+
+	select {
+	case err := <-errChan:
+		require.NoError(suite.T(), err, "Stopped node reported an error.")
+	case <-time.After(time.Second * 5):
+		suite.T().Fatal("Stopped node did not report its result.")
+	}
+}
+
+func (suite *NodeTestSuite) TestStartAsyncFailure() {
+	priv, err := genKeys()
+	require.NoError(suite.T(), err, "Failed to generate keys")
+
+	serveErr := errors.New("serve failed")
+
+	n, err := NewNode(&failingCommStub{err: serveErr}, &pingStub{}, &cmStub{cert: genCert(priv, 10)}, &cryptoStub{priv: priv}, testConfig())
+	require.NoError(suite.T(), err, "Failed to create node.")
+
+	ready, errChan, err := n.StartAsync()
+	require.NoError(suite.T(), err, "Failed to start node.")
+
+	select {
+	case <-ready:
+	case <-time.After(time.Second * 5):
+		n.Stop()
+		suite.T().Fatal("Readiness not signalled on server failure.")
+	}
+
+	select {
+	case err := <-errChan:
+		require.Equal(suite.T(), serveErr, err, "StartAsync did not report the server error.")
+	case <-time.After(time.Second * 5):
+		n.Stop()
+		suite.T().Fatal("StartAsync did not report the server failure.")
+	}
+}
+
+func (suite *NodeTestSuite) TestStopWithContext() {
+	priv, err := genKeys()
+	require.NoError(suite.T(), err, "Failed to generate keys")
+
+	blocking := &blockingCommStub{release: make(chan struct{})}
+
+	n, err := NewNode(blocking, &pingStub{}, &cmStub{cert: genCert(priv, 10)}, &cryptoStub{priv: priv}, testConfig())
+	require.NoError(suite.T(), err, "Failed to create node.")
+
+	ready, _, err := n.StartAsync()
+	require.NoError(suite.T(), err, "Failed to start node.")
+	<-ready
+
+	ch := make(chan []byte, 1)
+	n.SendMessage(context.Background(), "addr", ch, []byte("content"))
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*50)
+	defer cancel()
+
+	require.Error(suite.T(), n.StopWithContext(ctx), "Should return error with messages in flight.")
+	require.EqualError(suite.T(), n.StopWithContext(context.Background()), errStopped.Error(), "Should not stop twice.")
+
+	close(blocking.release)
+
+	n2 := suite.nodes[0]
+
+	ready, _, err = n2.StartAsync()
+	require.NoError(suite.T(), err, "Failed to start node.")
+	<-ready
+
+	ch = make(chan []byte, 1)
+	n2.SendMessage(context.Background(), "addr", ch, []byte("content"))
+
+	require.NoError(suite.T(), n2.StopWithContext(context.Background()), "Should drain without error.")
+	require.Len(suite.T(), ch, 1, "Message not completed before stopping.")
+}
+
+func (suite *NodeTestSuite) TestCertExpiry() {
+	n := suite.nodes[0]
+	n.certExpiryThreshold = time.Hour
+
+	ch := make(chan time.Duration, 3)
+	n.SetCertExpiryHandler(func(expiresIn time.Duration) {
+		ch <- expiresIn
+	})
+
+	notAfter := n.cm.Certificate().NotAfter
+
+	n.checkCertExpiry(notAfter.Add(-time.Hour * 2))
+	select {
+	case <-ch:
+		suite.T().Fatal("Handler invoked before crossing the threshold.")
+	case <-time.After(time.Millisecond * 50):
+	}
+
+	n.checkCertExpiry(notAfter.Add(-time.Minute * 30))
+	select {
+	case expiresIn := <-ch:
+		require.Equal(suite.T(), time.Minute*30, expiresIn, "Handler given wrong remaining validity.")
+	case <-time.After(time.Second):
+		suite.T().Fatal("Handler not invoked after crossing the threshold.")
+	}
+
+	n.checkCertExpiry(notAfter.Add(-time.Minute * 20))
+	select {
+	case <-ch:
+		suite.T().Fatal("Handler invoked twice for the same crossing.")
+	case <-time.After(time.Millisecond * 50):
+	}
+
+	n.checkCertExpiry(notAfter.Add(-time.Hour * 2))
+	n.checkCertExpiry(notAfter.Add(-time.Minute * 10))
+	select {
+	case <-ch:
+	case <-time.After(time.Second):
+		suite.T().Fatal("Handler not invoked after crossing the threshold again.")
+	}
+}
+
+func (suite *NodeTestSuite) TestEvictPeer() {
+	var ringNum uint32 = 1
+
+	n := suite.nodes[0]
+
+	for i := 0; i < 10; i++ {
+		_, _, err := addPeer(n)
+		require.NoError(suite.T(), err, "Could not add peer.")
+	}
+
+	succ, _ := n.view.MyRingNeighbours(ringNum)
+	require.NotNil(suite.T(), succ, "No successor on ring.")
+
+	require.NoError(suite.T(), n.EvictPeer([]byte(succ.Id)), "Failed to evict peer.")
+	require.False(suite.T(), n.view.IsAlive(succ.Id), "Evicted peer still live.")
+	require.False(suite.T(), n.view.HasTimer(succ.Id), "Evicted peer still has a timeout.")
+
+	for _, rv := range n.RingTopology() {
+		require.NotContains(suite.T(), rv.Members, succ.Id, "Evicted peer still on ring %d.", rv.Num)
+	}
+
+	acc := succ.RingAccusation(ringNum)
+	require.NotNil(suite.T(), acc, "Evicted peer not accused.")
+	require.True(suite.T(), acc.IsAccuser(n.self.Id), "Evicted peer accused by someone else.")
+
+	reply := &pb.StateResponse{}
+	n.mergeViews(make(map[string]uint64), reply)
+
+	var spread bool
+	for _, a := range reply.GetAccusations() {
+		if string(a.GetAccused()) == succ.Id && string(a.GetAccuser()) == n.self.Id {
+			spread = true
+		}
+	}
+	require.True(suite.T(), spread, "Accusation not included in gossip replies.")
+
+	require.EqualError(suite.T(), n.EvictPeer([]byte("unknown")), errUnknownPeer.Error(), "Evicted unknown peer.")
+	require.EqualError(suite.T(), n.EvictPeer([]byte(n.self.Id)), errEvictSelf.Error(), "Evicted myself.")
+}
+
+func (suite *NodeTestSuite) TestLeaveStalledNeighbour() {
+	priv, err := genKeys()
+	require.NoError(suite.T(), err, "Failed to generate keys")
+
+	comm := &stallingCommStub{}
+
+	conf := testConfig()
+	conf.GossipInterval = time.Hour
+	conf.GossipRoundTimeout = time.Millisecond * 50
+
+	n, err := NewNode(comm, &pingStub{}, &cmStub{cert: genCert(priv, 10)}, &cryptoStub{priv: priv}, conf)
+	require.NoError(suite.T(), err, "Failed to create node.")
+
+	for i := 0; i < 10; i++ {
+		_, _, err := addPeer(n)
+		require.NoError(suite.T(), err, "Could not add peer.")
+	}
+
+	ready, _, err := n.StartAsync()
+	require.NoError(suite.T(), err, "Failed to start node.")
+	<-ready
+
+	left := make(chan error, 1)
+	go func() {
+		left <- n.Leave()
+	}()
+
+	select {
+	case err := <-left:
+		require.NoError(suite.T(), err, "Failed to leave.")
+	case <-time.After(time.Second * 5):
+		suite.T().Fatal("Leave blocked by a stalled neighbour.")
+	}
+
+	require.Equal(suite.T(), context.DeadlineExceeded, comm.stallErr(), "Stalled announcement not cancelled at the round deadline.")
+	require.False(suite.T(), n.running(), "Node still running after leaving.")
+}
+
+func (suite *NodeTestSuite) TestOpenStreamToId() {
+	priv, err := genKeys()
+	require.NoError(suite.T(), err, "Failed to generate keys")
+
+	recorder := &streamRecordingCommStub{streams: make(chan string, 1)}
+
+	n, err := NewNode(recorder, &pingStub{}, &cmStub{cert: genCert(priv, 10)}, &cryptoStub{priv: priv}, testConfig())
+	require.NoError(suite.T(), err, "Failed to create node.")
+
+	p, _, err := addPeer(n)
+	require.NoError(suite.T(), err, "Could not add peer.")
+
+	drops := make(chan []byte, 1)
+	n.SetDropHandler(func(kind DropKind, id []byte) {
+		drops <- id
+	})
+
+	ready, _, err := n.StartAsync()
+	require.NoError(suite.T(), err, "Failed to start node.")
+	defer n.Stop()
+	<-ready
+
+	err = n.OpenStreamToId([]byte("unknown"), make(chan []byte), make(chan []byte), 0, "")
+	require.Error(suite.T(), err, "Opened a stream to an unknown id.")
+
+	reply := make(chan []byte)
+	require.NoError(suite.T(), n.OpenStreamToId([]byte(p.Id), make(chan []byte), reply, 0, ""), "Failed to open stream.")
+
+	select {
+	case addr := <-recorder.streams:
+		require.Equal(suite.T(), p.Addr, addr, "Stream opened to the wrong address.")
+	case <-time.After(time.Second):
+		suite.T().Fatal("Stream not opened.")
+	}
+
+	select {
+	case id := <-drops:
+		require.Equal(suite.T(), []byte(p.Id), id, "Dropped message not attributed to the stream's peer.")
+	case <-time.After(time.Second):
+		suite.T().Fatal("Drop handler not invoked.")
+	}
+
+	_, ok := <-reply
+	require.False(suite.T(), ok, "Reply stream not closed.")
+	require.Empty(suite.T(), recorder.streams, "Stream opened for the unknown id.")
+}
+
+func (suite *NodeTestSuite) TestIntervals() {
+	priv, err := genKeys()
+	require.NoError(suite.T(), err, "Failed to generate keys")
+
+	clock := newFakeClock()
+
+	conf := testConfig()
+	conf.Clock = clock
+	conf.GossipInterval = time.Second * 2
+	conf.MonitorInterval = time.Second * 3
+	conf.ViewUpdateInterval = time.Second * 10
+	conf.RemovalTimeout = 0
+	conf.PingsPerInterval = 1
+
+	n, err := NewNode(&commStub{}, &pingStub{}, &cmStub{cert: genCert(priv, 10)}, &cryptoStub{priv: priv}, conf)
+	require.NoError(suite.T(), err, "Failed to create node.")
+
+	for i := 0; i < 10; i++ {
+		_, _, err := addPeer(n)
+		require.NoError(suite.T(), err, "Could not add peer.")
+	}
+
+	accused := n.view.Live()[0]
+
+	ready, _, err := n.StartAsync()
+	require.NoError(suite.T(), err, "Failed to start node.")
+	defer n.Stop()
+	<-ready
+
+	require.NoError(suite.T(), n.view.StartTimer(accused, accused.Note(), n.self), "Failed to start timer.")
+
+	advance := func(seconds int) {
+		for i := 0; i < seconds; i++ {
+			require.Eventually(suite.T(), func() bool { return clock.waiting() == 3 }, time.Second, time.Millisecond, "Loops not waiting on the clock.")
+			clock.Advance(time.Second)
+		}
+		require.Eventually(suite.T(), func() bool { return clock.waiting() == 3 }, time.Second, time.Millisecond, "Loops not waiting on the clock.")
+	}
+
+	pings := func() uint64 {
+		s := n.Stats()
+		return s.PingsSucceeded + s.PingsFailed
+	}
+
+	advance(9)
+	require.True(suite.T(), n.view.IsAlive(accused.Id), "Accusation expired before the view update interval.")
+	require.Equal(suite.T(), uint64(4), n.Stats().GossipRounds, "Gossip loop not driven by the gossip interval.")
+	require.Equal(suite.T(), uint64(3), pings(), "Monitor loop not driven by the monitor interval.")
+
+	advance(1)
+	require.False(suite.T(), n.view.IsAlive(accused.Id), "Accusation not expired after the view update interval.")
+	require.Equal(suite.T(), uint64(5), n.Stats().GossipRounds, "Gossip loop not driven by the gossip interval.")
+	require.Equal(suite.T(), uint64(3), pings(), "Monitor loop not driven by the monitor interval.")
+
+	advance(20)
+	require.Equal(suite.T(), uint64(15), n.Stats().GossipRounds, "Gossip loop not driven by the gossip interval.")
+	require.Equal(suite.T(), uint64(10), pings(), "Monitor loop not driven by the monitor interval.")
+}
+
+func (suite *NodeTestSuite) TestSetIntervals() {
+	priv, err := genKeys()
+	require.NoError(suite.T(), err, "Failed to generate keys")
+
+	conf := testConfig()
+	conf.GossipInterval = time.Millisecond * 100
+	conf.MonitorInterval = time.Millisecond * 100
+	conf.PingsPerInterval = 1
+
+	n, err := NewNode(&commStub{}, &pingStub{}, &cmStub{cert: genCert(priv, 10)}, &cryptoStub{priv: priv}, conf)
+	require.NoError(suite.T(), err, "Failed to create node.")
+
+	for i := 0; i < 10; i++ {
+		_, _, err := addPeer(n)
+		require.NoError(suite.T(), err, "Could not add peer.")
+	}
+
+	require.Error(suite.T(), n.SetGossipInterval(0), "Accepted zero gossip interval.")
+	require.Error(suite.T(), n.SetMonitorInterval(-time.Second), "Accepted negative monitor interval.")
+	require.Error(suite.T(), n.SetViewUpdateInterval(0), "Accepted zero view update interval.")
+
+	ready, _, err := n.StartAsync()
+	require.NoError(suite.T(), err, "Failed to start node.")
+	defer n.Stop()
+	<-ready
+
+	require.NoError(suite.T(), n.SetGossipInterval(time.Millisecond*10), "Failed to set gossip interval.")
+	require.NoError(suite.T(), n.SetMonitorInterval(time.Millisecond*10), "Failed to set monitor interval.")
+	require.NoError(suite.T(), n.SetViewUpdateInterval(time.Millisecond*10), "Failed to set view update interval.")
+
+	time.Sleep(time.Millisecond * 500)
+
+	stats := n.Stats()
+
+	// The loops run at most 5 times in 500ms at the initial interval.
+	require.True(suite.T(), stats.GossipRounds > 15, "Gossip loop did not pick up the new interval.")
+	require.True(suite.T(), stats.PingsSucceeded+stats.PingsFailed > 15, "Monitor loop did not pick up the new interval.")
+}
+
+func (suite *NodeTestSuite) TestClock() {
+	priv, err := genKeys()
+	require.NoError(suite.T(), err, "Failed to generate keys")
+
+	clock := newFakeClock()
+
+	conf := testConfig()
+	conf.Clock = clock
+	conf.GossipInterval = time.Second * 10
+	conf.MonitorInterval = time.Hour
+	conf.ViewUpdateInterval = time.Second
+	conf.RemovalTimeout = time.Second * 30
+
+	n, err := NewNode(&commStub{}, &pingStub{}, &cmStub{cert: genCert(priv, 10)}, &cryptoStub{priv: priv}, conf)
+	require.NoError(suite.T(), err, "Failed to create node.")
+
+	for i := 0; i < 10; i++ {
+		_, _, err := addPeer(n)
+		require.NoError(suite.T(), err, "Could not add peer.")
+	}
+
+	accused := n.view.Live()[0]
+
+	ready, _, err := n.StartAsync()
+	require.NoError(suite.T(), err, "Failed to start node.")
+	defer n.Stop()
+	<-ready
+
+	// Advances the clock a second at a time, letting the gossip, monitor
+	// and view update loops get back to waiting on the clock after each step.
+	advance := func(seconds int) {
+		for i := 0; i < seconds; i++ {
+			require.Eventually(suite.T(), func() bool { return clock.waiting() == 3 }, time.Second, time.Millisecond, "Loops not waiting on the clock.")
+			clock.Advance(time.Second)
+		}
+		require.Eventually(suite.T(), func() bool { return clock.waiting() == 3 }, time.Second, time.Millisecond, "Loops not waiting on the clock.")
+	}
+
+	advance(9)
+	require.Zero(suite.T(), n.Stats().GossipRounds, "Gossiped before the gossip interval passed.")
+
+	advance(1)
+	require.Equal(suite.T(), uint64(1), n.Stats().GossipRounds, "Did not gossip once the gossip interval passed.")
+
+	require.NoError(suite.T(), n.view.StartTimer(accused, accused.Note(), n.self), "Failed to start timer.")
+
+	advance(30)
+	require.True(suite.T(), n.view.IsAlive(accused.Id), "Accusation expired before the removal timeout.")
+
+	advance(1)
+	require.False(suite.T(), n.view.IsAlive(accused.Id), "Accusation not expired after the removal timeout.")
+	require.Equal(suite.T(), uint64(4), n.Stats().GossipRounds, "Gossip rounds not driven by the clock.")
+}
+
+func (suite *NodeTestSuite) TestMonitorAssignment() {
+	n := suite.nodes[0]
+
+	require.Empty(suite.T(), n.Monitors(), "Monitors without any peers.")
+	require.Empty(suite.T(), n.Monitoring(), "Monitoring without any peers.")
+
+	for i := 0; i < 10; i++ {
+		_, _, err := addPeer(n)
+		require.NoError(suite.T(), err, "Could not add peer.")
+	}
+
+	monitors := make(map[string]bool)
+	monitoring := make(map[string]bool)
+
+	for r := uint32(1); r <= n.view.NumRings(); r++ {
+		succ, prev := n.view.MyRingNeighbours(r)
+		monitoring[succ.Id] = true
+		monitors[prev.Id] = true
+	}
+
+	infoIds := func(infos []PeerInfo) map[string]bool {
+		ret := make(map[string]bool)
+		for _, info := range infos {
+			require.False(suite.T(), ret[info.Id], "Peer listed twice.")
+			require.True(suite.T(), info.Live, "Live neighbour not reported as live.")
+			ret[info.Id] = true
+		}
+		return ret
+	}
+
+	require.Equal(suite.T(), monitors, infoIds(n.Monitors()), "Monitors are not the ring predecessors.")
+	require.Equal(suite.T(), monitoring, infoIds(n.Monitoring()), "Monitored peers are not the ring successors.")
+}
+
+func (suite *NodeTestSuite) TestRotateCertificate() {
+	priv, err := genKeys()
+	require.NoError(suite.T(), err, "Failed to generate keys")
+
+	old := genCert(priv, 10)
+	renewed := renewCert(priv, old)
+
+	n, err := NewNode(&commStub{}, &pingStub{}, &cmStub{cert: old, renewed: renewed}, &cryptoStub{priv: priv}, testConfig())
+	require.NoError(suite.T(), err, "Failed to create node.")
+
+	other := suite.nodes[0]
+	require.NoError(suite.T(), other.evalCertificate(old), "Failed to add certificate.")
+
+	require.NoError(suite.T(), n.RotateCertificate(), "Failed to rotate certificate.")
+	require.Equal(suite.T(), renewed.Raw, n.self.Certificate(), "Renewed certificate not installed.")
+	require.Equal(suite.T(), uint64(2), n.self.Note().Epoch(), "Note epoch not incremented.")
+
+	// Neighbours receive the renewed certificate through the tls handshake of new connections.
+	_, err = other.Spread(peerContext(n.self), n.collectGossipContent())
+	require.NoError(suite.T(), err, "Gossip with renewed certificate failed.")
+
+	p := other.view.Peer(n.self.Id)
+	require.NotNil(suite.T(), p, "Peer removed from view.")
+	require.Equal(suite.T(), renewed.Raw, p.Certificate(), "Renewed certificate not propagated.")
+
+	require.NoError(suite.T(), other.evalCertificate(old), "Old certificate should still be accepted.")
+	require.Equal(suite.T(), renewed.Raw, p.Certificate(), "Old certificate replaced the renewed one.")
+
+	otherPriv, err := genKeys()
+	require.NoError(suite.T(), err, "Failed to generate keys")
+
+	forged := genCert(otherPriv, 10)
+	forged.SubjectKeyId = old.SubjectKeyId
+	forged = renewCert(otherPriv, forged)
+	require.Error(suite.T(), other.evalCertificate(forged), "Accepted certificate with a different key.")
+}
+
+func (suite *NodeTestSuite) TestRefreshNote() {
+	n := suite.nodes[0]
+	other := suite.nodes[1]
+
+	_, err := other.Spread(peerContext(n.self), n.collectGossipContent())
+	require.NoError(suite.T(), err, "Gossip failed.")
+
+	p := other.view.Peer(n.self.Id)
+	require.NotNil(suite.T(), p, "Peer not added to view.")
+	require.Equal(suite.T(), n.LocalEpoch(), p.Note().Epoch(), "Note not propagated.")
+
+	epoch := n.LocalEpoch()
+
+	require.NoError(suite.T(), n.RefreshNote(), "Failed to refresh note.")
+	require.Equal(suite.T(), epoch+1, n.LocalEpoch(), "Epoch not incremented.")
+
+	_, err = other.Spread(peerContext(n.self), n.collectGossipContent())
+	require.NoError(suite.T(), err, "Gossip failed.")
+
+	require.Equal(suite.T(), epoch+1, p.Note().Epoch(), "Refreshed note not propagated.")
+}
+
+func (suite *NodeTestSuite) TestMembersInZone() {
+	priv, err := genKeys()
+	require.NoError(suite.T(), err, "Failed to generate keys")
+
+	n, err := NewNode(&commStub{}, &pingStub{}, &cmStub{cert: genZonedCert(priv, "eu")}, &cryptoStub{priv: priv}, testConfig())
+	require.NoError(suite.T(), err, "Failed to create node.")
+	require.Equal(suite.T(), "eu", n.Zone(), "Zone of own certificate not parsed.")
+
+	zones := map[string]int{"eu": 3, "us": 2, "": 1}
+
+	for zone, amount := range zones {
+		for i := 0; i < amount; i++ {
+			peerPriv, err := genKeys()
+			require.NoError(suite.T(), err, "Failed to generate keys")
+
+			var cert *x509.Certificate
+			if zone == "" {
+				cert = genCert(peerPriv, n.view.NumRings())
+			} else {
+				cert = genZonedCert(peerPriv, zone)
+			}
+
+			require.NoError(suite.T(), n.evalCertificate(cert), "Failed to add certificate.")
+
+			p := n.view.Peer(string(cert.SubjectKeyId))
+			require.NotNil(suite.T(), p, "Peer not added to view.")
+			require.Equal(suite.T(), zone, p.Zone, "Zone not stored on peer.")
+
+			n.view.AddLive(p)
+		}
+	}
+
+	for zone, amount := range zones {
+		require.Len(suite.T(), n.LiveMembersInZone(zone), amount, "Wrong number of members in zone %q.", zone)
+	}
+
+	require.Empty(suite.T(), n.LiveMembersInZone("ap"), "Members in unknown zone.")
+
+	for _, info := range n.ViewSnapshot() {
+		_, ok := zones[info.Zone]
+		require.True(suite.T(), ok, "Zone missing from peer info.")
+	}
+}
+
+func (suite *NodeTestSuite) TestCertificate() {
+	priv, err := genKeys()
+	require.NoError(suite.T(), err, "Failed to generate keys")
+
+	old := genCert(priv, 10)
+	renewed := renewCert(priv, old)
+
+	n, err := NewNode(&commStub{}, &pingStub{}, &cmStub{cert: old, renewed: renewed}, &cryptoStub{priv: priv}, testConfig())
+	require.NoError(suite.T(), err, "Failed to create node.")
+
+	cert := n.Certificate()
+	require.Equal(suite.T(), old.Raw, cert, "Wrong certificate.")
+
+	cert[0] ^= 0xff
+	require.Equal(suite.T(), old.Raw, n.Certificate(), "Certificate not copied.")
+
+	key, err := x509.ParsePKIXPublicKey(n.PublicKey())
+	require.NoError(suite.T(), err, "Failed to parse public key.")
+	require.Equal(suite.T(), &priv.PublicKey, key, "Wrong public key.")
+
+	n.PublicKey()[0] ^= 0xff
+	require.Equal(suite.T(), old.RawSubjectPublicKeyInfo, n.PublicKey(), "Public key not copied.")
+
+	require.NoError(suite.T(), n.RotateCertificate(), "Failed to rotate certificate.")
+	require.Equal(suite.T(), renewed.Raw, n.Certificate(), "Renewed certificate not returned.")
+}
+
+func (suite *NodeTestSuite) TestObserverMode() {
+	priv, err := genKeys()
+	require.NoError(suite.T(), err, "Failed to generate keys")
+
+	conf := testConfig()
+	conf.ObserverMode = true
+
+	cert := genCert(priv, 10)
+
+	n, err := NewNode(&commStub{}, &pingStub{}, &cmStub{cert: cert, renewed: renewCert(priv, cert)}, &cryptoStub{priv: priv}, conf)
+	require.NoError(suite.T(), err, "Failed to create node.")
+
+	note := n.self.Note()
+	require.True(suite.T(), note.IsObserver(), "Local note does not announce an observer.")
+	require.Equal(suite.T(), uint64(1), note.Epoch(), "Observer note not the first note.")
+
+	n.SetExternalGossipContent([]byte("content"))
+	n.EnqueueGossip([]byte("id"), []byte("data"))
+	n.SetGossipContentVersioned([]byte("id"), []byte("data"), 1)
+
+	msg := n.collectPushContent()
+	require.Nil(suite.T(), msg.GetExternalGossip(), "Observer spread gossip content.")
+	require.Empty(suite.T(), msg.GetBatch(), "Observer spread batched gossip.")
+	require.Empty(suite.T(), msg.GetEntries(), "Observer spread versioned gossip.")
+	require.True(suite.T(), msg.GetOwnNote().GetObserver(), "Gossiped note does not announce an observer.")
+
+	// Peers receiving the note keep the observer out of their live view.
+	other := suite.nodes[0]
+	require.NoError(suite.T(), other.evalCertificate(cert), "Failed to add observer certificate.")
+	require.NoError(suite.T(), other.evalNote(msg.GetOwnNote()), "Rejected observer note.")
+	require.True(suite.T(), other.view.Exists(n.self.Id), "Observer not in the full view.")
+	require.False(suite.T(), other.view.IsAlive(n.self.Id), "Observer added to the live view.")
+
+	require.NoError(suite.T(), n.RotateCertificate(), "Failed to rotate certificate.")
+	require.True(suite.T(), n.self.Note().IsObserver(), "Renewed note does not announce an observer.")
+}
+
+func (suite *NodeTestSuite) TestRingMask() {
+	priv, err := genKeys()
+	require.NoError(suite.T(), err, "Failed to generate keys")
+
+	cm := &cmStub{cert: genCert(priv, 10)}
+
+	conf := testConfig()
+	conf.RingMask = ^uint32(0) >> (32 - cm.NumRings())
+	conf.RingMask &^= 1 << 1
+
+	n, err := NewNode(&commStub{}, &pingStub{}, cm, &cryptoStub{priv: priv}, conf)
+	require.NoError(suite.T(), err, "Failed to create node.")
+	require.Equal(suite.T(), conf.RingMask, n.self.Note().ToPbMsg().GetMask(), "Local note does not carry the ring mask.")
+
+	conf.RingMask = 1
+
+	_, err = NewNode(&commStub{}, &pingStub{}, cm, &cryptoStub{priv: priv}, conf)
+	require.Error(suite.T(), err, "Accepted a mask leaving out too many rings.")
+}
+
+func (suite *NodeTestSuite) TestHttpAddr() {
+	priv, err := genKeys()
+	require.NoError(suite.T(), err, "Failed to generate keys")
+
+	conf := testConfig()
+	conf.UseViz = true
+	conf.HttpAddr = "127.0.0.1:0"
+
+	n, err := NewNode(&commStub{}, &pingStub{}, &cmStub{cert: genCert(priv, 10)}, &cryptoStub{priv: priv}, conf)
+	require.NoError(suite.T(), err, "Failed to create node.")
+	defer n.viz.l.Close()
+
+	host, _, err := net.SplitHostPort(n.viz.httpAddr)
+	require.NoError(suite.T(), err, "Invalid http address.")
+	require.Equal(suite.T(), "127.0.0.1", host, "Http server not bound to the given address.")
+
+	conf.HttpAddr = n.viz.httpAddr
+
+	_, err = NewNode(&commStub{}, &pingStub{}, &cmStub{cert: genCert(priv, 10)}, &cryptoStub{priv: priv}, conf)
+	require.True(suite.T(), errors.Is(err, ErrHttpListen), "Created node with an address in use, got %v.", err)
+}
+
+func (suite *NodeTestSuite) TestVerifyAt() {
+	n := suite.nodes[0]
+
+	p, priv, err := addPeer(n)
+	require.NoError(suite.T(), err, "Could not add peer.")
+
+	content := []byte("content")
+
+	r, s, err := (&cryptoStub{priv: priv}).Sign(content)
+	require.NoError(suite.T(), err, "Failed to sign.")
+
+	id := []byte(p.Id)
+
+	require.True(suite.T(), n.VerifyAt(r, s, content, id, time.Now()), "Signature invalid within certificate validity.")
+	require.False(suite.T(), n.VerifyAt(r, s, []byte("other"), id, time.Now()), "Signature valid for other content.")
+	require.False(suite.T(), n.VerifyAt(r, s, content, id, time.Now().AddDate(20, 0, 0)), "Signature valid after certificate expiry.")
+	require.False(suite.T(), n.VerifyAt(r, s, content, []byte("unknown"), time.Now()), "Signature valid for unknown peer.")
+}
+
+func (suite *NodeTestSuite) TestNotify() {
+	priv, err := genKeys()
+	require.NoError(suite.T(), err, "Failed to generate keys")
+
+	recorder := &recordingCommStub{sent: make(chan *pb.Msg, 1)}
+
+	n, err := NewNode(recorder, &pingStub{}, &cmStub{cert: genCert(priv, 10)}, &cryptoStub{priv: priv}, testConfig())
+	require.NoError(suite.T(), err, "Failed to create node.")
+
+	require.EqualError(suite.T(), n.Notify("addr", []byte("content")), errNotRunning.Error(), "Should not notify before starting.")
+
+	ready, _, err := n.StartAsync()
+	require.NoError(suite.T(), err, "Failed to start node.")
+	<-ready
+
+	require.NoError(suite.T(), n.Notify("addr", []byte("content")), "Failed to notify.")
+
+	select {
+	case msg := <-recorder.sent:
+		require.Equal(suite.T(), []byte("content"), msg.GetContent(), "Sent wrong content.")
+	case <-time.After(time.Second):
+		suite.T().Fatal("Notification was never sent.")
+	}
+
+	n.Stop()
+
+	require.EqualError(suite.T(), n.Notify("addr", []byte("content")), errNotRunning.Error(), "Should not notify after stopping.")
+}
+
+func (suite *NodeTestSuite) TestNotifyConcurrencyLimit() {
+	priv, err := genKeys()
+	require.NoError(suite.T(), err, "Failed to generate keys")
+
+	conf := testConfig()
+	conf.MaxConcurrentMessages = 2
+
+	comm := &concurrencyCommStub{release: make(chan struct{})}
+
+	n, err := NewNode(comm, &pingStub{}, &cmStub{cert: genCert(priv, 10)}, &cryptoStub{priv: priv}, conf)
+	require.NoError(suite.T(), err, "Failed to create node.")
+
+	ready, _, err := n.StartAsync()
+	require.NoError(suite.T(), err, "Failed to start node.")
+	<-ready
+
+	for i := 0; i < 2; i++ {
+		require.NoError(suite.T(), n.Notify("addr", []byte("data")), "Failed to notify.")
+	}
+
+	require.Eventually(suite.T(), func() bool {
+		active, _ := comm.counts()
+		return active == 2
+	}, time.Second, time.Millisecond, "Notifications not sent.")
+	require.Equal(suite.T(), 2, n.InFlight(), "Notifications not counted as in flight.")
+
+	blocked := make(chan error, 1)
+	go func() {
+		blocked <- n.Notify("addr", []byte("data"))
+	}()
+
+	select {
+	case <-blocked:
+		suite.T().Fatal("Notification beyond the limit not held back.")
+	case <-time.After(time.Millisecond * 50):
+	}
+
+	n.Stop()
+
+	select {
+	case err := <-blocked:
+		require.EqualError(suite.T(), err, errNotRunning.Error(), "Held back notification not failed by stop.")
+	case <-time.After(time.Second):
+		suite.T().Fatal("Notification still waiting after stop.")
+	}
+
+	_, max := comm.counts()
+	require.Equal(suite.T(), 2, max, "Notifications exceeded the limit.")
+}
+
+func (suite *NodeTestSuite) TestLeave() {
+	priv, err := genKeys()
+	require.NoError(suite.T(), err, "Failed to generate keys")
+
+	recorder := &gossipRecordingCommStub{}
+
+	n, err := NewNode(recorder, &pingStub{}, &cmStub{cert: genCert(priv, 10)}, &cryptoStub{priv: priv}, testConfig())
+	require.NoError(suite.T(), err, "Failed to create node.")
+
+	require.EqualError(suite.T(), n.Leave(), errNotRunning.Error(), "Should not leave before starting.")
+
+	for i := 0; i < 10; i++ {
+		_, _, err := addPeer(n)
+		require.NoError(suite.T(), err, "Could not add peer.")
+	}
+
+	neighbours := n.view.MyNeighbours()
+	epoch := n.self.Note().Epoch()
+
+	ready, _, err := n.StartAsync()
+	require.NoError(suite.T(), err, "Failed to start node.")
+	<-ready
+
+	require.NoError(suite.T(), n.Leave(), "Failed to leave.")
+	require.False(suite.T(), n.running(), "Node still running after leaving.")
+
+	notes := recorder.leavingNotes()
+	require.Equal(suite.T(), len(neighbours), len(notes), "Departure not announced to every neighbour.")
+
+	for _, note := range notes {
+		require.Equal(suite.T(), n.self.Id, string(note.GetId()), "Announced note of another peer.")
+		require.Equal(suite.T(), epoch+1, note.GetEpoch(), "Leaving note has wrong epoch.")
+	}
+
+	require.EqualError(suite.T(), n.Leave(), errNotRunning.Error(), "Should not leave twice.")
+}
+
+func (suite *NodeTestSuite) TestHealthy() {
+	priv, err := genKeys()
+	require.NoError(suite.T(), err, "Failed to generate keys")
+
+	clock := newFakeClock()
+
+	conf := testConfig()
+	conf.Clock = clock
+	conf.GossipInterval = time.Second * 10
+	conf.MonitorInterval = time.Hour
+	conf.ViewUpdateInterval = time.Second
+	conf.HealthMissedRounds = 2
+	conf.HealthIsolationTimeout = time.Second * 30
+
+	cert := genCert(priv, 10)
+	cm := &cmStub{cert: cert}
+
+	n, err := NewNode(&commStub{}, &pingStub{}, cm, &cryptoStub{priv: priv}, conf)
+	require.NoError(suite.T(), err, "Failed to create node.")
+
+	healthy, _ := n.Healthy()
+	require.False(suite.T(), healthy, "Healthy before being started.")
+
+	p, _, err := addPeer(n)
+	require.NoError(suite.T(), err, "Could not add peer.")
+
+	ready, _, err := n.StartAsync()
+	require.NoError(suite.T(), err, "Failed to start node.")
+	defer n.Stop()
+	<-ready
+
+	advance := func(seconds int) {
+		for i := 0; i < seconds; i++ {
+			require.Eventually(suite.T(), func() bool { return clock.waiting() == 3 }, time.Second, time.Millisecond, "Loops not waiting on the clock.")
+			clock.Advance(time.Second)
+		}
+		require.Eventually(suite.T(), func() bool { return clock.waiting() == 3 }, time.Second, time.Millisecond, "Loops not waiting on the clock.")
+	}
+
+	probe := func() int {
+		rec := httptest.NewRecorder()
+		(&viz{n: n}).healthHandler(rec, httptest.NewRequest("GET", "/healthz", nil))
+		return rec.Code
+	}
+
+	healthy, reason := n.Healthy()
+	require.True(suite.T(), healthy, "Unhealthy right after starting: %s", reason)
+	require.Equal(suite.T(), http.StatusOK, probe(), "Health endpoint reports a healthy node as unhealthy.")
+
+	// Missed rounds, the round deadline defaults to the gossip interval.
+	n.Pause()
+	advance(30)
+
+	healthy, reason = n.Healthy()
+	require.True(suite.T(), healthy, "Unhealthy within the missed rounds: %s", reason)
+
+	advance(1)
+
+	healthy, reason = n.Healthy()
+	require.False(suite.T(), healthy, "Healthy without gossip rounds.")
+	require.Contains(suite.T(), reason, "gossip round", "Wrong reason.")
+	require.Equal(suite.T(), http.StatusServiceUnavailable, probe(), "Health endpoint reports an unhealthy node as healthy.")
+
+	n.Resume()
+	advance(10)
+
+	healthy, reason = n.Healthy()
+	require.True(suite.T(), healthy, "Unhealthy after gossip resumed: %s", reason)
+
+	expired := *cert
+	expired.NotAfter = clock.Now().Add(-time.Second)
+	cm.cert = &expired
+
+	healthy, reason = n.Healthy()
+	require.False(suite.T(), healthy, "Healthy with an expired certificate.")
+	require.Contains(suite.T(), reason, "certificate", "Wrong reason.")
+
+	cm.cert = cert
+
+	n.view.RemoveLive(p.Id)
+	require.Eventually(suite.T(), func() bool { return !n.getIsolatedSince().IsZero() }, time.Second, time.Millisecond, "Empty live view not noticed.")
+
+	advance(30)
+
+	healthy, reason = n.Healthy()
+	require.True(suite.T(), healthy, "Unhealthy within the isolation timeout: %s", reason)
+
+	advance(1)
+
+	healthy, reason = n.Healthy()
+	require.False(suite.T(), healthy, "Healthy without live peers past the isolation timeout.")
+	require.Contains(suite.T(), reason, "no live peers", "Wrong reason.")
+}
+
+func (suite *NodeTestSuite) TestCaStatus() {
+	priv, err := genKeys()
+	require.NoError(suite.T(), err, "Failed to generate keys")
+
+	clock := newFakeClock()
+
+	conf := testConfig()
+	conf.Clock = clock
+	conf.GossipInterval = time.Hour
+	conf.MonitorInterval = time.Hour
+	conf.ViewUpdateInterval = time.Hour
+	conf.CaPollInterval = time.Second * 10
+
+	cert := genCert(priv, 10)
+	renewed := renewCert(priv, cert)
+	cm := &cmStub{cert: cert, ca: cert, renewed: renewed}
+
+	n, err := NewNode(&commStub{}, &pingStub{}, cm, &cryptoStub{priv: priv}, conf)
+	require.NoError(suite.T(), err, "Failed to create node.")
+
+	statuses := make(chan bool, 2)
+	n.SetCaStatusHandler(func(up bool) {
+		statuses <- up
+	})
+
+	ready, _, err := n.StartAsync()
+	require.NoError(suite.T(), err, "Failed to start node.")
+	defer n.Stop()
+	<-ready
+
+	status := func() bool {
+		select {
+		case up := <-statuses:
+			return up
+		case <-time.After(time.Second):
+			suite.T().Fatal("CA status handler not invoked.")
+			return false
+		}
+	}
+
+	// The gossip, monitor, view update and CA poll loops.
+	poll := func() {
+		require.Eventually(suite.T(), func() bool { return clock.waiting() == 4 }, time.Second, time.Millisecond, "Loops not waiting on the clock.")
+		clock.Advance(conf.CaPollInterval)
+	}
+
+	require.True(suite.T(), n.CaReachable(), "CA unreachable before any request failed.")
+
+	cm.setCaDown(true)
+
+	require.Error(suite.T(), n.RotateCertificate(), "Rotated certificate while the CA is down.")
+	require.False(suite.T(), status(), "CA reported up after a failed rotation.")
+	require.False(suite.T(), n.CaReachable(), "CA reachable after a failed rotation.")
+
+	// No change, no invocation.
+	poll()
+
+	cm.setCaDown(false)
+	poll()
+
+	require.True(suite.T(), status(), "CA not reported up once it answered.")
+	require.Eventually(suite.T(), func() bool { return cm.Certificate() == renewed }, time.Second, time.Millisecond, "Failed rotation not retried.")
+	require.True(suite.T(), n.CaReachable(), "CA unreachable after it answered.")
+	require.Empty(suite.T(), statuses, "CA status handler invoked without a change.")
+}
+
+func (suite *NodeTestSuite) TestViewHandler() {
+	n := suite.nodes[0]
+
+	p, _, err := addPeer(n)
+	require.NoError(suite.T(), err, "Could not add peer.")
+
+	rec := httptest.NewRecorder()
+	(&viz{n: n}).viewHandler(rec, httptest.NewRequest("GET", "/view.json", nil))
+
+	require.Equal(suite.T(), http.StatusOK, rec.Code, "View dump failed.")
+	require.Equal(suite.T(), "application/json", rec.Header().Get("Content-Type"), "View dump is not json.")
+
+	dump := &viewDump{}
+	require.NoError(suite.T(), json.NewDecoder(rec.Body).Decode(dump), "Invalid json.")
+
+	require.Equal(suite.T(), n.self.Id, string(dump.Id), "Dump of another node.")
+	require.Len(suite.T(), dump.Peers, 1, "Dump should contain the full view.")
+	require.Equal(suite.T(), p.Id, string(dump.Peers[0].Id), "Wrong peer in dump.")
+	require.True(suite.T(), dump.Peers[0].Live, "Live peer not dumped as live.")
+	require.Equal(suite.T(), uint64(1), dump.Peers[0].Epoch, "Wrong note epoch.")
+	require.Len(suite.T(), dump.Rings, int(n.view.NumRings()), "Dump should contain every ring.")
+
+	for _, r := range dump.Rings {
+		require.Equal(suite.T(), p.Id, string(r.Successor), "Only peer should be the successor on ring %d.", r.Num)
+	}
+}
+
+func (suite *NodeTestSuite) TestPause() {
+	priv, err := genKeys()
+	require.NoError(suite.T(), err, "Failed to generate keys")
+
+	recorder := &gossipRecordingCommStub{}
+
+	conf := testConfig()
+	conf.GossipInterval = time.Millisecond
+
+	n, err := NewNode(recorder, &pingStub{}, &cmStub{cert: genCert(priv, 10)}, &cryptoStub{priv: priv}, conf)
+	require.NoError(suite.T(), err, "Failed to create node.")
+
+	for i := 0; i < 10; i++ {
+		_, _, err := addPeer(n)
+		require.NoError(suite.T(), err, "Could not add peer.")
+	}
+
+	n.Pause()
+
+	ready, _, err := n.StartAsync()
+	require.NoError(suite.T(), err, "Failed to start node.")
+	<-ready
+	defer n.Stop()
+
+	time.Sleep(time.Millisecond * 20)
+	require.Zero(suite.T(), recorder.numGossips(), "Gossiped while paused.")
+
+	n.Resume()
+
+	require.Eventually(suite.T(), func() bool {
+		return recorder.numGossips() > 0
+	}, time.Second, time.Millisecond, "No gossip after resuming.")
+}
+
+func (suite *NodeTestSuite) TestStalledGossipPartner() {
+	priv, err := genKeys()
+	require.NoError(suite.T(), err, "Failed to generate keys")
+
+	comm := &stallingCommStub{}
+
+	conf := testConfig()
+	conf.GossipInterval = time.Millisecond
+	conf.GossipRoundTimeout = time.Millisecond * 50
+
+	n, err := NewNode(comm, &pingStub{}, &cmStub{cert: genCert(priv, 10)}, &cryptoStub{priv: priv}, conf)
+	require.NoError(suite.T(), err, "Failed to create node.")
+
+	for i := 0; i < 10; i++ {
+		_, _, err := addPeer(n)
+		require.NoError(suite.T(), err, "Could not add peer.")
+	}
+
+	require.True(suite.T(), n.LastGossipRound().IsZero(), "Gossip round reported before starting.")
+
+	ready, _, err := n.StartAsync()
+	require.NoError(suite.T(), err, "Failed to start node.")
+	<-ready
+	defer n.Stop()
+
+	require.Eventually(suite.T(), func() bool {
+		return comm.numGossips() > 3
+	}, time.Second, time.Millisecond, "Gossip loop wedged by a stalled partner.")
+
+	require.Equal(suite.T(), context.DeadlineExceeded, comm.stallErr(), "Stalled rpc not cancelled at the round deadline.")
+
+	last := n.LastGossipRound()
+	require.False(suite.T(), last.IsZero(), "No gossip round reported.")
+	require.Equal(suite.T(), last, n.Stats().LastGossipRound, "Stats report another round.")
+
+	require.Eventually(suite.T(), func() bool {
+		return n.LastGossipRound().After(last)
+	}, time.Second, time.Millisecond, "Last gossip round not updated.")
+}
+
+func (suite *NodeTestSuite) TestContactSeeds() {
+	priv, err := genKeys()
+	require.NoError(suite.T(), err, "Failed to generate keys")
+
+	peerPriv, err := genKeys()
+	require.NoError(suite.T(), err, "Failed to generate keys")
+
+	peerCert := genCert(peerPriv, 10)
+
+	comm := &seedCommStub{
+		unreachable: map[string]bool{"down": true},
+		certs:       []*pb.Certificate{{Raw: peerCert.Raw}},
+	}
+
+	conf := testConfig()
+	conf.SeedNodes = []string{"up", "down"}
+
+	n, err := NewNode(comm, &pingStub{}, &cmStub{cert: genCert(priv, 10)}, &cryptoStub{priv: priv}, conf)
+	require.NoError(suite.T(), err, "Failed to create node.")
+
+	deadline := time.Now().Add(time.Hour)
+
+	n.contactSeeds(context.Background(), deadline)
+	require.True(suite.T(), n.view.Exists(string(peerCert.SubjectKeyId)), "Seed reply not merged into view.")
+	require.Equal(suite.T(), []string{"down"}, n.seeds, "Only the unreachable seed should be retried.")
+
+	comm.setReachable("down")
+	n.contactSeeds(context.Background(), deadline)
+	require.Empty(suite.T(), n.seeds, "Reached seed still retried.")
+	require.Equal(suite.T(), []string{"up", "down", "down"}, comm.contacted, "Seeds contacted wrongly.")
+
+	n.contactSeeds(context.Background(), deadline)
+	require.Len(suite.T(), comm.contacted, 3, "Seeds contacted after all were reached.")
+
+	comm.unreachable["gone"] = true
+	n.seeds = []string{"gone"}
+
+	n.contactSeeds(context.Background(), time.Now())
+	require.Empty(suite.T(), n.seeds, "Unreachable seed retried past the deadline.")
+	require.Equal(suite.T(), "gone", comm.contacted[3], "Seed not attempted before giving up.")
+}
+
+func (suite *NodeTestSuite) TestSeedsKeepGossipBatch() {
+	priv, err := genKeys()
+	require.NoError(suite.T(), err, "Failed to generate keys")
+
+	conf := testConfig()
+	conf.SeedNodes = []string{"seed"}
+
+	n, err := NewNode(&commStub{}, &pingStub{}, &cmStub{cert: genCert(priv, 10)}, &cryptoStub{priv: priv}, conf)
+	require.NoError(suite.T(), err, "Failed to create node.")
+
+	var sent []*pb.State
+	n.SetGossipTap(func(addr string, m *pb.State) {
+		sent = append(sent, m)
+	})
+
+	require.NoError(suite.T(), n.EnqueueGossip([]byte("id"), []byte("data")), "Failed to enqueue gossip.")
+
+	n.contactSeeds(context.Background(), time.Now().Add(time.Hour))
+	require.NoError(suite.T(), n.bootstrap(context.Background(), "entry", n.collectGossipContent()), "Failed to bootstrap.")
+
+	require.Len(suite.T(), sent, 2, "Seed and entry address not gossiped with.")
+	for _, msg := range sent {
+		require.Empty(suite.T(), msg.GetBatch(), "Batch sent to a seed or entry address.")
+	}
+
+	msg := n.collectPushContent()
+	require.Len(suite.T(), msg.GetBatch(), 1, "Batch drained by seed contact.")
+
+	conf = testConfig()
+	conf.GossipMode = "pull"
+
+	pull, err := NewNode(&commStub{}, &pingStub{}, &cmStub{cert: genCert(priv, 10)}, &cryptoStub{priv: priv}, conf)
+	require.NoError(suite.T(), err, "Failed to create node.")
+
+	require.EqualError(suite.T(), pull.EnqueueGossip([]byte("id"), []byte("data")), errPullOnly.Error(), "Enqueued gossip without push gossip.")
+	require.Empty(suite.T(), pull.gossipBatch, "Payload queued without push gossip.")
+}
+
+func (suite *NodeTestSuite) TestMessageTimeout() {
+	priv, err := genKeys()
+	require.NoError(suite.T(), err, "Failed to generate keys")
+
+	conf := testConfig()
+	conf.MessageTimeout = time.Millisecond * 50
+
+	n, err := NewNode(&slowCommStub{}, &pingStub{}, &cmStub{cert: genCert(priv, 10)}, &cryptoStub{priv: priv}, conf)
+	require.NoError(suite.T(), err, "Failed to create node.")
+
+	ch := make(chan []byte, 1)
+	start := time.Now()
+	n.sendMsg(context.Background(), "addr", ch, &pb.Msg{})
+
+	reply, ok := <-ch
+	require.True(suite.T(), ok, "Default timeout should not close the reply channel.")
+	require.Nil(suite.T(), reply, "Timed out message should give a nil reply.")
+	require.True(suite.T(), time.Since(start) < time.Second, "Default timeout not applied.")
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*200)
+	defer cancel()
+
+	ch = make(chan []byte, 1)
+	start = time.Now()
+	n.sendMsg(ctx, "addr", ch, &pb.Msg{})
+
+	_, ok = <-ch
+	require.False(suite.T(), ok, "Reply channel should be closed once the context deadline is exceeded.")
+	require.True(suite.T(), time.Since(start) >= time.Millisecond*200, "Context deadline did not replace the default timeout.")
+}
+
+func (suite *NodeTestSuite) TestAbandonedMessages() {
+	priv, err := genKeys()
+	require.NoError(suite.T(), err, "Failed to generate keys")
+
+	conf := testConfig()
+	conf.MessageTimeout = time.Millisecond * 50
+	conf.MaxConcurrentMessages = 20
+
+	n, err := NewNode(&slowCommStub{}, &pingStub{}, &cmStub{cert: genCert(priv, 10)}, &cryptoStub{priv: priv}, conf)
+	require.NoError(suite.T(), err, "Failed to create node.")
+
+	n.dispatcher.Start()
+	defer n.dispatcher.Stop()
+
+	// The dispatcher workers are expected to outlive the messages.
+	ignore := goleak.IgnoreCurrent()
+
+	for i := 0; i < 20; i++ {
+		go n.SendMessage(context.Background(), "addr", make(chan []byte, 1), []byte("data"))
+	}
+
+	goleak.VerifyNone(suite.T(), ignore)
+
+	ch := make(chan []byte, 1)
+	ch <- []byte("taken")
+	n.sendMsg(context.Background(), "addr", ch, &pb.Msg{})
+	require.Equal(suite.T(), []byte("taken"), <-ch, "Second reply delivered.")
+
+	conf.MessageTimeout = 0
+
+	n2, err := NewNode(&slowCommStub{}, &pingStub{}, &cmStub{cert: genCert(priv, 10)}, &cryptoStub{priv: priv}, conf)
+	require.NoError(suite.T(), err, "Failed to create node.")
+
+	n2.dispatcher.Start()
+	defer n2.dispatcher.Stop()
+
+	ignore = goleak.IgnoreCurrent()
+
+	chans := make([]chan []byte, 20)
+	for i := range chans {
+		chans[i] = make(chan []byte, 1)
+		go n2.SendMessage(context.Background(), "addr", chans[i], []byte("data"))
+	}
+
+	require.Eventually(suite.T(), func() bool { return n2.InFlight() == len(chans) }, time.Second, time.Millisecond, "Messages not sent.")
+
+	n2.abortMessages()
+
+	for _, ch := range chans {
+		require.Nil(suite.T(), <-ch, "Aborted message should give a nil reply.")
+	}
+
+	goleak.VerifyNone(suite.T(), ignore)
+}
+
+func (suite *NodeTestSuite) TestGossipTap() {
+	n := suite.nodes[0]
+
+	msg := n.collectGossipContent()
+
+	var tapped []string
+	n.SetGossipTap(func(addr string, m *pb.State) {
+		require.True(suite.T(), m == msg, "Tap not given the outgoing message.")
+		tapped = append(tapped, addr)
+	})
+
+	require.NoError(suite.T(), n.bootstrap(context.Background(), "seed", msg), "Failed to gossip.")
+	require.Equal(suite.T(), []string{"seed"}, tapped, "Tap not invoked before returning.")
+
+	n.SetGossipTap(nil)
+
+	require.NoError(suite.T(), n.bootstrap(context.Background(), "seed", msg), "Failed to gossip.")
+	require.Len(suite.T(), tapped, 1, "Removed tap invoked.")
+}
+
+func (suite *NodeTestSuite) TestMaxConcurrentMessages() {
+	priv, err := genKeys()
+	require.NoError(suite.T(), err, "Failed to generate keys")
+
+	conf := testConfig()
+	conf.MaxConcurrentMessages = 2
+
+	comm := &concurrencyCommStub{release: make(chan struct{})}
+
+	n, err := NewNode(comm, &pingStub{}, &cmStub{cert: genCert(priv, 10)}, &cryptoStub{priv: priv}, conf)
+	require.NoError(suite.T(), err, "Failed to create node.")
+
+	n.dispatcher.Start()
+	defer n.dispatcher.Stop()
+
+	chans := make([]chan []byte, 10)
+	for i := range chans {
+		chans[i] = make(chan []byte, 1)
+		go n.SendMessage(context.Background(), "addr", chans[i], []byte("data"))
+	}
+
+	require.Eventually(suite.T(), func() bool {
+		active, _ := comm.counts()
+		return active == 2
+	}, time.Second, time.Millisecond, "Messages not sent.")
+
+	time.Sleep(time.Millisecond * 50)
+
+	_, maxActive := comm.counts()
+	require.Equal(suite.T(), 2, maxActive, "More messages in flight than allowed.")
+	require.Equal(suite.T(), 2, n.InFlight(), "Wrong number of messages in flight.")
+
+	ctx, cancel := context.WithCancel(context.Background())
+
+	ch := make(chan []byte, 1)
+	queued := make(chan struct{})
+
+	go func() {
+		n.SendMessage(ctx, "addr", ch, []byte("data"))
+		close(queued)
+	}()
+
+	cancel()
+	<-queued
+
+	_, ok := <-ch
+	require.False(suite.T(), ok, "Queued message not aborted by its context.")
+
+	close(comm.release)
+
+	for _, ch := range chans {
+		require.Equal(suite.T(), []byte("data"), <-ch, "Queued message not sent.")
+	}
+
+	_, maxActive = comm.counts()
+	require.Equal(suite.T(), 2, maxActive, "More messages in flight than allowed.")
+	require.Eventually(suite.T(), func() bool { return n.InFlight() == 0 }, time.Second, time.Millisecond, "Slots not released.")
+}
+
+func (suite *NodeTestSuite) TestSendMessageWithRetry() {
+	priv, err := genKeys()
+	require.NoError(suite.T(), err, "Failed to generate keys")
+
+	comm := &flakyCommStub{}
+
+	n, err := NewNode(comm, &pingStub{}, &cmStub{cert: genCert(priv, 10)}, &cryptoStub{priv: priv}, testConfig())
+	require.NoError(suite.T(), err, "Failed to create node.")
+
+	ready, _, err := n.StartAsync()
+	require.NoError(suite.T(), err, "Failed to start node.")
+	defer n.Stop()
+	<-ready
+
+	peerPriv, err := genKeys()
+	require.NoError(suite.T(), err, "Failed to generate keys")
+
+	cert := genCert(peerPriv, 10)
+	id := cert.SubjectKeyId
+	require.NoError(suite.T(), n.view.AddFull(string(id), cert), "Failed to add peer.")
+
+	// The peer moves after the first failed attempt.
+	moved := renewCert(peerPriv, cert)
+	template := *moved
+	template.RawSubject = nil
+	template.Subject.Locality = []string{"movedAddr", "movedPing"}
+	moved = renewCert(peerPriv, &template)
+
+	oldAddr := cert.Subject.Locality[0]
+	newAddr := moved.Subject.Locality[0]
+	require.NotEqual(suite.T(), oldAddr, newAddr, "Peer address did not change.")
+
+	comm.fail = func(addr string, attempt int) bool {
+		if attempt == 1 {
+			n.view.RemoveTestFull(string(id))
+			n.view.AddFull(string(id), moved)
+		}
+		return attempt < 3
+	}
+
+	policy := RetryPolicy{MaxAttempts: 3, BaseBackoff: time.Millisecond * 10, MaxBackoff: time.Millisecond * 15}
+
+	ch := make(chan []byte, 1)
+	start := time.Now()
+	n.SendMessageWithRetry(id, ch, []byte("content"), policy)
+
+	require.Equal(suite.T(), []byte("content"), <-ch, "Did not get the successful response.")
+	require.True(suite.T(), time.Since(start) >= time.Millisecond*25, "Did not back off between attempts.")
+	require.Equal(suite.T(), []string{oldAddr, newAddr, newAddr}, comm.addrs(), "Id not resolved before each attempt.")
+
+	comm.fail = func(addr string, attempt int) bool {
+		return true
+	}
+	comm.reset()
+
+	ch = make(chan []byte, 1)
+	n.SendMessageWithRetry(id, ch, []byte("content"), policy)
+
+	require.Nil(suite.T(), <-ch, "Should give nil after exhausting attempts.")
+	require.Len(suite.T(), comm.addrs(), 3, "Wrong number of attempts.")
+}
+
+func (suite *NodeTestSuite) TestQueueMessage() {
+	priv, err := genKeys()
+	require.NoError(suite.T(), err, "Failed to generate keys")
+
+	conf := testConfig()
+	conf.MaxConcurrentMessages = 1
+
+	comm := &concurrencyCommStub{release: make(chan struct{})}
+
+	n, err := NewNode(comm, &pingStub{}, &cmStub{cert: genCert(priv, 10)}, &cryptoStub{priv: priv}, conf)
+	require.NoError(suite.T(), err, "Failed to create node.")
+
+	n.sendQueue = make(chan func(), 2)
+
+	chans := make([]chan []byte, 4)
+	for i := range chans {
+		chans[i] = make(chan []byte, 1)
+	}
+
+	ctx := context.Background()
+
+	require.NoError(suite.T(), n.QueueMessage(ctx, "addr", chans[0], []byte("data")), "Failed to queue before start.")
+
+	ready, _, err := n.StartAsync()
+	require.NoError(suite.T(), err, "Failed to start node.")
+	defer n.Stop()
+	<-ready
+
+	require.Eventually(suite.T(), func() bool {
+		active, _ := comm.counts()
+		return active == 1
+	}, time.Second, time.Millisecond, "Message queued before start not sent.")
+
+	// The only worker waits for a slot with the second message, the others fill the queue.
+	require.NoError(suite.T(), n.QueueMessage(ctx, "addr", chans[1], []byte("data")), "Failed to queue message.")
+	require.Eventually(suite.T(), func() bool { return len(n.sendQueue) == 0 }, time.Second, time.Millisecond, "Worker did not take the message.")
+
+	require.NoError(suite.T(), n.QueueMessage(ctx, "addr", chans[2], []byte("data")), "Failed to queue message.")
+	require.NoError(suite.T(), n.QueueMessageWithRetry([]byte("id"), chans[3], []byte("data"), RetryPolicy{}), "Failed to queue message.")
+
+	err = n.QueueMessage(ctx, "addr", make(chan []byte, 1), []byte("data"))
+	require.Equal(suite.T(), ErrSendQueueFull, err, "Full queue accepted a message.")
+
+	close(comm.release)
+
+	for _, ch := range chans[:3] {
+		require.Equal(suite.T(), []byte("data"), <-ch, "Queued message not sent.")
+	}
+	require.Nil(suite.T(), <-chans[3], "Retried message to an unknown id should fail.")
+
+	_, maxActive := comm.counts()
+	require.Equal(suite.T(), 1, maxActive, "More messages in flight than allowed.")
+}
+
+func (suite *NodeTestSuite) TestQueueMessageStop() {
+	priv, err := genKeys()
+	require.NoError(suite.T(), err, "Failed to generate keys")
+
+	conf := testConfig()
+	conf.MaxConcurrentMessages = 1
+
+	comm := &concurrencyCommStub{release: make(chan struct{})}
+
+	n, err := NewNode(comm, &pingStub{}, &cmStub{cert: genCert(priv, 10)}, &cryptoStub{priv: priv}, conf)
+	require.NoError(suite.T(), err, "Failed to create node.")
+
+	ready, _, err := n.StartAsync()
+	require.NoError(suite.T(), err, "Failed to start node.")
+	<-ready
+
+	chans := make([]chan []byte, 3)
+	for i := range chans {
+		chans[i] = make(chan []byte, 1)
+		require.NoError(suite.T(), n.QueueMessage(context.Background(), "addr", chans[i], []byte("data")), "Failed to queue message.")
+	}
+
+	require.Eventually(suite.T(), func() bool {
+		active, _ := comm.counts()
+		return active == 1
+	}, time.Second, time.Millisecond, "Message not sent.")
+
+	n.Stop()
+
+	// In flight, waiting for a slot and still queued.
+	for i, ch := range chans {
+		select {
+		case reply := <-ch:
+			require.Nil(suite.T(), reply, "Message %d got a response after stop.", i)
+		case <-time.After(time.Second):
+			suite.T().Fatalf("Message %d not failed by stop.", i)
+		}
+	}
+
+	err = n.QueueMessage(context.Background(), "addr", make(chan []byte, 1), []byte("data"))
+	require.Equal(suite.T(), errStopped, err, "Stopped node queued a message.")
+
+	_, err = n.sendAttempt("addr", &pb.Msg{Content: []byte("data")})
+	require.Equal(suite.T(), errStopped, err, "Attempt waited for a slot after stop.")
+}
+
+func (suite *NodeTestSuite) TestQueueMessageStopWithContext() {
+	priv, err := genKeys()
+	require.NoError(suite.T(), err, "Failed to generate keys")
+
+	conf := testConfig()
+	conf.MaxConcurrentMessages = 1
+
+	// Stopped before the queue is drained, the send workers never start.
+	idle, err := NewNode(&commStub{}, &pingStub{}, &cmStub{cert: genCert(priv, 10)}, &cryptoStub{priv: priv}, conf)
+	require.NoError(suite.T(), err, "Failed to create node.")
+
+	comm := &concurrencyCommStub{release: make(chan struct{})}
+
+	// Stopped while a message blocks, so the context runs out.
+	blocked, err := NewNode(comm, &pingStub{}, &cmStub{cert: genCert(priv, 10)}, &cryptoStub{priv: priv}, conf)
+	require.NoError(suite.T(), err, "Failed to create node.")
+
+	ready, _, err := blocked.StartAsync()
+	require.NoError(suite.T(), err, "Failed to start node.")
+	<-ready
+
+	for _, n := range []*Node{idle, blocked} {
+		chans := make([]chan []byte, 3)
+		for i := range chans {
+			chans[i] = make(chan []byte, 1)
+			require.NoError(suite.T(), n.QueueMessage(context.Background(), "addr", chans[i], []byte("data")), "Failed to queue message.")
+		}
 
-	suite.Run(t, new(NodeTestSuite))
+		if n == blocked {
+			require.Eventually(suite.T(), func() bool {
+				active, _ := comm.counts()
+				return active == 1
+			}, time.Second, time.Millisecond, "Message not sent.")
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*100)
+		n.StopWithContext(ctx)
+		cancel()
+
+		for i, ch := range chans {
+			select {
+			case reply := <-ch:
+				require.Nil(suite.T(), reply, "Message %d got a response after stop.", i)
+			case <-time.After(time.Second):
+				suite.T().Fatalf("Message %d not failed by stop.", i)
+			}
+		}
+	}
 }
 
-func (suite *NodeTestSuite) SetupTest() {
-	for i := 0; i < 30; i++ {
+// Compares allocations of SendMessage, as used by SendTo with the response ignored, against Notify.
+func BenchmarkSendMessage(b *testing.B) {
+	n := benchmarkNode(b)
+	defer n.Stop()
 
-		priv, err := genKeys()
-		require.NoError(suite.T(), err, "Failed to generate keys")
+	data := []byte("content")
 
-		ownCert := genCert(priv, 10)
-		n, err := NewNode(&commStub{}, &pingStub{}, &cmStub{cert: ownCert}, &cryptoStub{priv: priv})
-		require.NoError(suite.T(), err, "Failed to create node.")
+	b.ReportAllocs()
+	b.ResetTimer()
 
-		suite.nodes = append(suite.nodes, n)
+	for i := 0; i < b.N; i++ {
+		ch := make(chan []byte, 1)
+		go n.SendMessage(context.Background(), "addr", ch, data)
 	}
+
+	b.StopTimer()
+	n.inflight.Wait()
 }
 
-func (suite *NodeTestSuite) TestGossip() {
-	// Everyone gossips with everyone,
-	// then assert that everyone has the same view.
+func BenchmarkNotify(b *testing.B) {
+	n := benchmarkNode(b)
+	defer n.Stop()
 
-	for _, n := range suite.nodes {
-		for _, n2 := range suite.nodes {
-			reply, err := n2.Spread(context.Background(), n.collectGossipContent())
-			if err != nil {
-				continue
-			}
-			n.mergeCertificates(reply.GetCertificates())
-			n.mergeNotes(reply.GetNotes())
-			n.mergeAccusations(reply.GetAccusations())
+	data := []byte("content")
+
+	b.ReportAllocs()
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		if err := n.Notify("addr", data); err != nil {
+			b.Fatal(err)
 		}
 	}
 
-	for _, n := range suite.nodes {
-		for _, n2 := range suite.nodes {
-			require.NoError(suite.T(), n.view.Compare(n2.view), "Views are not equal.")
-		}
+	b.StopTimer()
+	n.inflight.Wait()
+}
+
+func benchmarkNode(b *testing.B) *Node {
+	log.Root().SetHandler(log.DiscardHandler())
+
+	priv, err := genKeys()
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	n, err := NewNode(&commStub{}, &pingStub{}, &cmStub{cert: genCert(priv, 10)}, &cryptoStub{priv: priv}, testConfig())
+	if err != nil {
+		b.Fatal(err)
 	}
 
+	ready, _, err := n.StartAsync()
+	if err != nil {
+		b.Fatal(err)
+	}
+	<-ready
+
+	return n
+}
+
+func testConfig() *Config {
+	return &Config{
+		GossipInterval:        time.Second * 10,
+		MonitorInterval:       time.Second * 10,
+		ViewUpdateInterval:    time.Second * 10,
+		RemovalTimeout:        time.Second * 60,
+		PingLimit:             3,
+		PingsPerInterval:      3,
+		MaxConcurrentMessages: 5,
+	}
 }
 
 type clientStub struct {
@@ -82,7 +1859,7 @@ type clientStub struct {
 func (cs *clientStub) Init(config *tls.Config) {
 }
 
-func (cs *clientStub) Gossip(addr string, args *pb.State) (*pb.StateResponse, error) {
+func (cs *clientStub) Gossip(ctx context.Context, addr string, args *pb.State) (*pb.StateResponse, error) {
 	return nil, nil
 }
 
@@ -124,21 +1901,307 @@ func (cs *commStub) Addr() string {
 	return "addr"
 }
 
-func (cs *commStub) Start() {
+func (cs *commStub) Start() error {
+	return nil
 }
 
 func (cs *commStub) Stop() {
 }
 
-func (cs *commStub) Gossip(addr string, m *pb.State) (*pb.StateResponse, error) {
+func (cs *commStub) GracefulStop() {
+}
+
+func (cs *commStub) SetCertificate(c *x509.Certificate) {
+}
+
+func (cs *commStub) Gossip(ctx context.Context, addr string, m *pb.State) (*pb.StateResponse, error) {
+	return &pb.StateResponse{}, nil
+}
+
+func (cs *commStub) Pull(ctx context.Context, addr string, m *pb.State) (*pb.StateResponse, error) {
 	return &pb.StateResponse{}, nil
 }
 
-func (cs *commStub) Send(addr string, m *pb.Msg) (*pb.MsgResponse, error) {
+func (cs *commStub) Send(ctx context.Context, addr string, m *pb.Msg) (*pb.MsgResponse, error) {
+	return &pb.MsgResponse{}, nil
+}
+
+func (cs *commStub) StreamMessenger(addr string, input, reply chan []byte, window uint32, compression string, dropped func()) error {
+	close(reply)
+	return nil
+}
+
+func (cs *commStub) PeerCertificate(ctx context.Context, addr string) (*x509.Certificate, error) {
+	return nil, nil
+}
+
+func (cs *commStub) SendToHost(ctx context.Context, addr string, m *pb.Msg, verify func(*x509.Certificate) error) (*pb.MsgResponse, error) {
+	return &pb.MsgResponse{}, nil
+}
+
+type certCommStub struct {
+	commStub
+	cert *x509.Certificate
+}
+
+func (cs *certCommStub) PeerCertificate(ctx context.Context, addr string) (*x509.Certificate, error) {
+	return cs.cert, nil
+}
+
+// Presents cert as the certificate of every host, echoes the content if it is accepted.
+func (cs *certCommStub) SendToHost(ctx context.Context, addr string, m *pb.Msg, verify func(*x509.Certificate) error) (*pb.MsgResponse, error) {
+	if err := verify(cs.cert); err != nil {
+		return nil, err
+	}
+
+	return &pb.MsgResponse{Content: m.GetContent()}, nil
+}
+
+type failingCommStub struct {
+	commStub
+	err error
+}
+
+func (cs *failingCommStub) Start() error {
+	return cs.err
+}
+
+// Blocks all messages until released.
+type blockingCommStub struct {
+	commStub
+	release chan struct{}
+}
+
+func (cs *blockingCommStub) Send(ctx context.Context, addr string, m *pb.Msg) (*pb.MsgResponse, error) {
+	<-cs.release
+	return &pb.MsgResponse{}, nil
+}
+
+// Records all sent messages.
+type recordingCommStub struct {
+	commStub
+	sent chan *pb.Msg
+}
+
+func (cs *recordingCommStub) Send(ctx context.Context, addr string, m *pb.Msg) (*pb.MsgResponse, error) {
+	cs.sent <- m
 	return &pb.MsgResponse{}, nil
 }
 
+// Records the notes of outgoing gossip instead of sending it.
+type gossipRecordingCommStub struct {
+	commStub
+
+	mutex sync.Mutex
+	notes []*pb.Note
+}
+
+func (cs *gossipRecordingCommStub) Gossip(ctx context.Context, addr string, m *pb.State) (*pb.StateResponse, error) {
+	cs.mutex.Lock()
+	defer cs.mutex.Unlock()
+
+	cs.notes = append(cs.notes, m.GetOwnNote())
+
+	return &pb.StateResponse{}, nil
+}
+
+// Records the address of each opened stream, reporting one dropped message per stream.
+type streamRecordingCommStub struct {
+	commStub
+
+	streams chan string
+}
+
+func (cs *streamRecordingCommStub) StreamMessenger(addr string, input, reply chan []byte, window uint32, compression string, dropped func()) error {
+	cs.streams <- addr
+	dropped()
+	close(reply)
+	return nil
+}
+
+// Never responds to the first gossip rpc, it only returns once the rpc is cancelled.
+type stallingCommStub struct {
+	commStub
+
+	mutex   sync.Mutex
+	calls   int
+	stalled error
+}
+
+func (cs *stallingCommStub) Gossip(ctx context.Context, addr string, m *pb.State) (*pb.StateResponse, error) {
+	cs.mutex.Lock()
+	cs.calls++
+	first := cs.calls == 1
+	cs.mutex.Unlock()
+
+	if !first {
+		return &pb.StateResponse{}, nil
+	}
+
+	<-ctx.Done()
+
+	cs.mutex.Lock()
+	cs.stalled = ctx.Err()
+	cs.mutex.Unlock()
+
+	return nil, ctx.Err()
+}
+
+func (cs *stallingCommStub) numGossips() int {
+	cs.mutex.Lock()
+	defer cs.mutex.Unlock()
+
+	return cs.calls
+}
+
+func (cs *stallingCommStub) stallErr() error {
+	cs.mutex.Lock()
+	defer cs.mutex.Unlock()
+
+	return cs.stalled
+}
+
+func (cs *gossipRecordingCommStub) numGossips() int {
+	cs.mutex.Lock()
+	defer cs.mutex.Unlock()
+
+	return len(cs.notes)
+}
+
+func (cs *gossipRecordingCommStub) leavingNotes() []*pb.Note {
+	cs.mutex.Lock()
+	defer cs.mutex.Unlock()
+
+	var ret []*pb.Note
+
+	for _, n := range cs.notes {
+		if n.GetLeaving() {
+			ret = append(ret, n)
+		}
+	}
+
+	return ret
+}
+
+// Fails gossip to unreachable addresses, all others reply with the given certificates.
+type seedCommStub struct {
+	commStub
+
+	mutex       sync.Mutex
+	unreachable map[string]bool
+	contacted   []string
+	certs       []*pb.Certificate
+}
+
+func (cs *seedCommStub) Gossip(ctx context.Context, addr string, m *pb.State) (*pb.StateResponse, error) {
+	cs.mutex.Lock()
+	defer cs.mutex.Unlock()
+
+	cs.contacted = append(cs.contacted, addr)
+
+	if cs.unreachable[addr] {
+		return nil, errors.New("unreachable")
+	}
+
+	return &pb.StateResponse{Certificates: cs.certs}, nil
+}
+
+func (cs *seedCommStub) setReachable(addr string) {
+	cs.mutex.Lock()
+	defer cs.mutex.Unlock()
+
+	delete(cs.unreachable, addr)
+}
+
+// Never answers, messages only complete when their context is done.
+type slowCommStub struct {
+	commStub
+}
+
+func (cs *slowCommStub) Send(ctx context.Context, addr string, m *pb.Msg) (*pb.MsgResponse, error) {
+	<-ctx.Done()
+	return nil, ctx.Err()
+}
+
+// Holds messages until released, echoes their content, and records how many were sent at once.
+type concurrencyCommStub struct {
+	commStub
+
+	release chan struct{}
+
+	mutex     sync.Mutex
+	active    int
+	maxActive int
+}
+
+func (cs *concurrencyCommStub) Send(ctx context.Context, addr string, m *pb.Msg) (*pb.MsgResponse, error) {
+	cs.mutex.Lock()
+	cs.active++
+	if cs.active > cs.maxActive {
+		cs.maxActive = cs.active
+	}
+	cs.mutex.Unlock()
+
+	defer func() {
+		cs.mutex.Lock()
+		cs.active--
+		cs.mutex.Unlock()
+	}()
+
+	select {
+	case <-cs.release:
+		return &pb.MsgResponse{Content: m.GetContent()}, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+func (cs *concurrencyCommStub) counts() (int, int) {
+	cs.mutex.Lock()
+	defer cs.mutex.Unlock()
+
+	return cs.active, cs.maxActive
+}
+
+// Fails messages as decided by fail, echoes the content of the rest.
+type flakyCommStub struct {
+	commStub
+
+	fail func(addr string, attempt int) bool
+
+	mutex sync.Mutex
+	sent  []string
+}
+
+func (cs *flakyCommStub) Send(ctx context.Context, addr string, m *pb.Msg) (*pb.MsgResponse, error) {
+	cs.mutex.Lock()
+	cs.sent = append(cs.sent, addr)
+	attempt := len(cs.sent)
+	cs.mutex.Unlock()
+
+	if cs.fail(addr, attempt) {
+		return nil, errors.New("unreachable")
+	}
+
+	return &pb.MsgResponse{Content: m.GetContent()}, nil
+}
+
+func (cs *flakyCommStub) addrs() []string {
+	cs.mutex.Lock()
+	defer cs.mutex.Unlock()
+
+	return append([]string(nil), cs.sent...)
+}
+
+func (cs *flakyCommStub) reset() {
+	cs.mutex.Lock()
+	defer cs.mutex.Unlock()
+
+	cs.sent = nil
+}
+
 type pingStub struct {
+	errorHandler func(bool)
 }
 
 func (ps *pingStub) Pause(t time.Duration) {
@@ -154,6 +2217,16 @@ func (ps *pingStub) Ping(addr string, m *pb.Ping) (*pb.Pong, error) {
 	return &pb.Pong{}, nil
 }
 
+func (ps *pingStub) SetPingVerifier(verifier func(*pb.Ping) bool) {
+}
+
+func (ps *pingStub) SetPongNote(note func() *pb.Note) {
+}
+
+func (ps *pingStub) SetErrorHandler(handler func(bool)) {
+	ps.errorHandler = handler
+}
+
 //TODO we need to decide upon stubs or not stubs etc, not just copy stuff, this is really ugly
 type cryptoStub struct {
 	priv *ecdsa.PrivateKey
@@ -187,17 +2260,44 @@ func (cs *cryptoStub) Sign(data []byte) ([]byte, []byte, error) {
 }
 
 type cmStub struct {
-	cert *x509.Certificate
+	cert    *x509.Certificate
+	ca      *x509.Certificate
+	renewed *x509.Certificate
+
+	// Fails PingCa and RenewCertificate while set.
+	caDown bool
+	mutex  sync.Mutex
 }
 
 func (cm *cmStub) Certificate() *x509.Certificate {
+	cm.mutex.Lock()
+	defer cm.mutex.Unlock()
+
 	return cm.cert
 }
 
-func (cm *cmStub) CaCertificate() *x509.Certificate {
+func (cm *cmStub) setCaDown(down bool) {
+	cm.mutex.Lock()
+	defer cm.mutex.Unlock()
+
+	cm.caDown = down
+}
+
+func (cm *cmStub) PingCa() error {
+	cm.mutex.Lock()
+	defer cm.mutex.Unlock()
+
+	if cm.caDown {
+		return errors.New("CA is down")
+	}
+
 	return nil
 }
 
+func (cm *cmStub) CaCertificate() *x509.Certificate {
+	return cm.ca
+}
+
 func (cm *cmStub) ContactList() []*x509.Certificate {
 	return nil
 }
@@ -209,3 +2309,152 @@ func (cm *cmStub) NumRings() uint32 {
 func (cm *cmStub) Trusted() bool {
 	return false
 }
+
+func (cm *cmStub) Priv() *ecdsa.PrivateKey {
+	return nil
+}
+
+func (cm *cmStub) SavePrivateKey(path string) error {
+	return nil
+}
+
+func (cm *cmStub) SaveCertificate(path string) error {
+	return nil
+}
+
+func (cm *cmStub) ExportIdentity() ([]byte, error) {
+	return nil, nil
+}
+
+func (cm *cmStub) RenewCertificate() (*x509.Certificate, error) {
+	cm.mutex.Lock()
+	defer cm.mutex.Unlock()
+
+	if cm.caDown {
+		return nil, errors.New("CA is down")
+	}
+
+	if cm.renewed == nil {
+		return nil, errors.New("No renewed certificate")
+	}
+
+	cm.cert = cm.renewed
+
+	return cm.renewed, nil
+}
+
+// Clock that only moves when advanced, timers fire once the clock reaches their deadline.
+type fakeClock struct {
+	now    time.Time
+	timers []*fakeTimer
+	mutex  sync.Mutex
+}
+
+type fakeTimer struct {
+	clock    *fakeClock
+	c        chan time.Time
+	deadline time.Time
+	active   bool
+	listed   bool
+}
+
+func newFakeClock() *fakeClock {
+	return &fakeClock{now: time.Now()}
+}
+
+func (fc *fakeClock) Now() time.Time {
+	fc.mutex.Lock()
+	defer fc.mutex.Unlock()
+
+	return fc.now
+}
+
+func (fc *fakeClock) After(d time.Duration) <-chan time.Time {
+	return fc.NewTimer(d).C()
+}
+
+func (fc *fakeClock) NewTimer(d time.Duration) discovery.Timer {
+	t := &fakeTimer{
+		clock: fc,
+		c:     make(chan time.Time, 1),
+	}
+
+	t.Reset(d)
+
+	return t
+}
+
+// Moves the clock forward and fires every timer whose deadline was reached.
+func (fc *fakeClock) Advance(d time.Duration) {
+	fc.mutex.Lock()
+	defer fc.mutex.Unlock()
+
+	fc.now = fc.now.Add(d)
+
+	var pending []*fakeTimer
+
+	for _, t := range fc.timers {
+		if t.active && t.deadline.After(fc.now) {
+			pending = append(pending, t)
+			continue
+		}
+
+		if t.active {
+			select {
+			case t.c <- fc.now:
+			default:
+			}
+		}
+
+		t.active = false
+		t.listed = false
+	}
+
+	fc.timers = pending
+}
+
+// Number of timers that have neither fired nor been stopped.
+func (fc *fakeClock) waiting() int {
+	fc.mutex.Lock()
+	defer fc.mutex.Unlock()
+
+	var count int
+
+	for _, t := range fc.timers {
+		if t.active {
+			count++
+		}
+	}
+
+	return count
+}
+
+func (ft *fakeTimer) C() <-chan time.Time {
+	return ft.c
+}
+
+func (ft *fakeTimer) Stop() bool {
+	ft.clock.mutex.Lock()
+	defer ft.clock.mutex.Unlock()
+
+	active := ft.active
+	ft.active = false
+
+	return active
+}
+
+func (ft *fakeTimer) Reset(d time.Duration) bool {
+	ft.clock.mutex.Lock()
+	defer ft.clock.mutex.Unlock()
+
+	active := ft.active
+	ft.active = true
+	ft.deadline = ft.clock.now.Add(d)
+
+	if !ft.listed {
+		ft.listed = true
+		ft.clock.timers = append(ft.clock.timers, ft)
+	}
+
+	return active
+}
@@ -0,0 +1,180 @@
+package core
+
+import (
+	"math/rand"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/joonnna/ifrit/core/discovery"
+)
+
+// Ring neighbour a PartnerSelector can choose to gossip with.
+type Neighbour struct {
+	Id   string
+	Addr string
+
+	// Rings the peer is our successor or predecessor on, in ascending order.
+	Rings []uint32
+
+	// Recent round trip time of gossip exchanges with the peer, zero if not measured yet.
+	RTT time.Duration
+}
+
+// Chooses the peers to gossip with each gossip interval, see Config.PartnerSelector.
+// Only ring neighbours accept gossip, so the selection is made among them.
+type PartnerSelector interface {
+	// Returns the ids of the neighbours to gossip with this round,
+	// ids that are not among the given neighbours are ignored.
+	SelectPartners(neighbours []Neighbour) []string
+}
+
+// Gossips with fanout neighbours chosen uniformly at random each round.
+func RandomPartners(fanout int) PartnerSelector {
+	return randomPartners{fanout: fanout}
+}
+
+// Gossips with the successor and predecessor of one ring each round, rotating through the rings.
+func RingPartners() PartnerSelector {
+	return &ringPartners{}
+}
+
+// Gossips with the fanout neighbours with the lowest round trip times,
+// neighbours not measured yet are preferred so that every neighbour gets measured.
+func LatencyPartners(fanout int) PartnerSelector {
+	return latencyPartners{fanout: fanout}
+}
+
+type randomPartners struct {
+	fanout int
+}
+
+func (rp randomPartners) SelectPartners(neighbours []Neighbour) []string {
+	ret := ids(neighbours)
+
+	rand.Shuffle(len(ret), func(i, j int) {
+		ret[i], ret[j] = ret[j], ret[i]
+	})
+
+	if rp.fanout < len(ret) {
+		ret = ret[:rp.fanout]
+	}
+
+	return ret
+}
+
+type ringPartners struct {
+	next  uint32
+	mutex sync.Mutex
+}
+
+func (rp *ringPartners) SelectPartners(neighbours []Neighbour) []string {
+	var numRings uint32
+
+	for _, nb := range neighbours {
+		for _, r := range nb.Rings {
+			if r > numRings {
+				numRings = r
+			}
+		}
+	}
+
+	if numRings == 0 {
+		return nil
+	}
+
+	rp.mutex.Lock()
+	ring := rp.next%numRings + 1
+	rp.next++
+	rp.mutex.Unlock()
+
+	var ret []string
+
+	for _, nb := range neighbours {
+		for _, r := range nb.Rings {
+			if r == ring {
+				ret = append(ret, nb.Id)
+				break
+			}
+		}
+	}
+
+	return ret
+}
+
+type latencyPartners struct {
+	fanout int
+}
+
+func (lp latencyPartners) SelectPartners(neighbours []Neighbour) []string {
+	sorted := append([]Neighbour(nil), neighbours...)
+
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return sorted[i].RTT < sorted[j].RTT
+	})
+
+	ret := ids(sorted)
+
+	if lp.fanout < len(ret) {
+		ret = ret[:lp.fanout]
+	}
+
+	return ret
+}
+
+func ids(neighbours []Neighbour) []string {
+	ret := make([]string, 0, len(neighbours))
+
+	for _, nb := range neighbours {
+		ret = append(ret, nb.Id)
+	}
+
+	return ret
+}
+
+// Returns the ring neighbours and the peers chosen among them by the partner selector.
+func (n *Node) selectedPartners() []*discovery.Peer {
+	var neighbours []Neighbour
+
+	peers := make(map[string]*discovery.Peer)
+	idx := make(map[string]int)
+
+	for r := uint32(1); r <= n.view.NumRings(); r++ {
+		succ, prev := n.view.MyRingNeighbours(r)
+
+		for _, p := range []*discovery.Peer{succ, prev} {
+			if p == nil {
+				continue
+			}
+
+			if i, exists := idx[p.Id]; exists {
+				if rings := neighbours[i].Rings; rings[len(rings)-1] != r {
+					neighbours[i].Rings = append(rings, r)
+				}
+				continue
+			}
+
+			rtt, _ := n.stats.peerLatency(p.Id)
+
+			idx[p.Id] = len(neighbours)
+			peers[p.Id] = p
+			neighbours = append(neighbours, Neighbour{
+				Id:    p.Id,
+				Addr:  p.Addr,
+				Rings: []uint32{r},
+				RTT:   rtt,
+			})
+		}
+	}
+
+	var ret []*discovery.Peer
+
+	for _, id := range n.partnerSelector.SelectPartners(neighbours) {
+		if p, exists := peers[id]; exists {
+			ret = append(ret, p)
+			delete(peers, id)
+		}
+	}
+
+	return ret
+}
@@ -1,17 +1,25 @@
 package core
 
 import (
+	"time"
+
+	"github.com/golang/protobuf/proto"
 	log "github.com/inconshreveable/log15"
 	"github.com/joonnna/ifrit/core/discovery"
 	pb "github.com/joonnna/ifrit/protobuf"
+	"golang.org/x/net/context"
 )
 
+// The protocol every node runs.
 type correct struct {
 }
 
+// Byzantine peer that accuses every peer it knows, for testing how the
+// network copes with false accusations. Not meant to be run by real nodes.
 type spamAccusations struct {
 }
 
+// Byzantine peer that floods the given address with gossip. Not meant to be run by real nodes.
 type experiment struct {
 	addr    string
 	maxConc int
@@ -27,7 +35,7 @@ func (c correct) Rebuttal(n *Node) {
 	}
 
 	for _, p := range neighbours {
-		_, err := n.comm.Gossip(p.Addr, msg)
+		_, err := n.gossip(context.Background(), p.Addr, msg)
 		if err != nil {
 			log.Error(err.Error(), "addr", p.Addr)
 			continue
@@ -35,18 +43,37 @@ func (c correct) Rebuttal(n *Node) {
 	}
 }
 
-func (c correct) Gossip(n *Node) {
-	msg := n.collectGossipContent()
+func (c correct) Gossip(ctx context.Context, n *Node) {
+	msg := n.collectPushContent()
 
-	neighbours := n.view.GossipPartners()
+	neighbours := n.gossipPartners()
+
+	defer n.stats.recordGossipRound()
+
+	var contentId []byte
 
 	for _, p := range neighbours {
-		reply, err := n.comm.Gossip(p.Addr, msg)
+		if ctx.Err() != nil {
+			break
+		}
+
+		// Round trips are measured in wall time, the clock only drives the protocol.
+		start := time.Now()
+
+		_, end := n.startSpan(ctx, spanGossip, []byte(p.Id), p.Addr, msg)
+		reply, err := n.gossip(ctx, p.Addr, msg)
+		end()
 		if err != nil {
 			log.Error(err.Error(), "addr", p.Addr)
 			continue
 		}
 
+		rtt := time.Since(start)
+
+		n.stats.recordGossipRTT(rtt)
+		n.stats.recordPeerRTT(p.Id, rtt)
+		n.stats.recordGossipBytes(proto.Size(msg), proto.Size(reply))
+
 		//log.Debug("Gossiped", "addr", p.Addr)
 
 		n.mergeCertificates(reply.GetCertificates())
@@ -55,12 +82,38 @@ func (c correct) Gossip(n *Node) {
 
 		if handler := n.getResponseHandler(); handler != nil {
 			if r := reply.GetExternalGossip(); r != nil {
-				handler(r)
+				if contentId == nil {
+					contentId = hashContent(msg.GetExternalGossip())
+				}
+				handler([]byte(p.Id), contentId, r)
 			}
 		}
 	}
 }
 
+// Sends the local digest to a random live peer, and merges everything it has that is newer.
+func (c correct) PullSync(ctx context.Context, n *Node) {
+	p := n.pullPartner()
+	if p == nil {
+		return
+	}
+
+	msg := &pb.State{
+		ExistingHosts: n.view.State().GetExistingHosts(),
+	}
+
+	reply, err := n.comm.Pull(ctx, p.Addr, msg)
+	if err != nil {
+		log.Error(err.Error(), "addr", p.Addr)
+		return
+	}
+
+	n.mergeCertificates(reply.GetCertificates())
+	n.mergeNotes(reply.GetNotes())
+	n.mergeAccusations(reply.GetAccusations())
+	n.mergeGossipEntries(reply.GetEntries())
+}
+
 func (c correct) Monitor(n *Node) {
 	for i := 1; i <= n.pingsPerInterval; i++ {
 		p, ringNum := n.view.MonitorTarget()
@@ -69,6 +122,8 @@ func (c correct) Monitor(n *Node) {
 		}
 
 		err := n.fd.probe(p)
+		n.stats.recordPing(err == nil)
+
 		if err == errDead {
 			log.Debug("Successor dead, accusing", "succ", p.Addr, "ringNum", ringNum)
 			peerNote := p.Note()
@@ -85,6 +140,10 @@ func (c correct) Monitor(n *Node) {
 			}
 
 			err := p.CreateAccusation(peerNote, n.self, ringNum, n.cs)
+			if err == nil {
+				n.stats.recordAccusation()
+			}
+
 			if err == discovery.ErrAccAlreadyExists || err == nil {
 				live := n.view.IsAlive(p.Id)
 				if exists := n.view.HasTimer(p.Id); !exists && live {
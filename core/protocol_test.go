@@ -1 +1,298 @@
 package core
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	log "github.com/inconshreveable/log15"
+	pb "github.com/joonnna/ifrit/protobuf"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/stretchr/testify/suite"
+	"golang.org/x/net/context"
+)
+
+type ProtocolTestSuite struct {
+	suite.Suite
+}
+
+func TestProtocolTestSuite(t *testing.T) {
+	r := log.Root()
+
+	r.SetHandler(log.DiscardHandler())
+
+	suite.Run(t, new(ProtocolTestSuite))
+}
+
+func (suite *ProtocolTestSuite) TestGossipFanout() {
+	tests := []struct {
+		fanout   uint32
+		expected int
+	}{
+		{fanout: 0, expected: 2},
+		{fanout: 1, expected: 1},
+		{fanout: 5, expected: 5},
+		{fanout: 10000, expected: -1},
+	}
+
+	for i, t := range tests {
+		priv, err := genKeys()
+		require.NoError(suite.T(), err, "Failed to generate keys")
+
+		conf := testConfig()
+		conf.GossipFanout = t.fanout
+
+		cs := &countingCommStub{}
+
+		n, err := NewNode(cs, &pingStub{}, &cmStub{cert: genCert(priv, 10)}, &cryptoStub{priv: priv}, conf)
+		require.NoError(suite.T(), err, "Failed to create node.")
+
+		for j := 0; j < 50; j++ {
+			_, _, err := addPeer(n)
+			require.NoError(suite.T(), err, "Could not add peer.")
+		}
+
+		expected := t.expected
+		if expected < 0 {
+			expected = len(n.view.MyNeighbours())
+		}
+
+		correct{}.Gossip(context.Background(), n)
+
+		assert.Equalf(suite.T(), expected, cs.numGossip(), "Invalid number of gossip calls for test %d.", i)
+	}
+}
+
+func (suite *ProtocolTestSuite) TestGossipMode() {
+	priv, err := genKeys()
+	require.NoError(suite.T(), err, "Failed to generate keys")
+
+	conf := testConfig()
+	conf.GossipMode = "invalid"
+
+	_, err = NewNode(&commStub{}, &pingStub{}, &cmStub{cert: genCert(priv, 10)}, &cryptoStub{priv: priv}, conf)
+	require.EqualError(suite.T(), err, errGossipMode.Error(), "Should fail with invalid gossip mode.")
+
+	for _, mode := range []string{"", "push", "pull", "push-pull"} {
+		conf.GossipMode = mode
+
+		n, err := NewNode(&commStub{}, &pingStub{}, &cmStub{cert: genCert(priv, 10)}, &cryptoStub{priv: priv}, conf)
+		require.NoErrorf(suite.T(), err, "Failed to create node with gossip mode %s.", mode)
+
+		assert.Equalf(suite.T(), mode != "pull", n.push, "Invalid push setting for gossip mode %s.", mode)
+		assert.Equalf(suite.T(), mode == "pull" || mode == "push-pull", n.pull, "Invalid pull setting for gossip mode %s.", mode)
+	}
+}
+
+func (suite *ProtocolTestSuite) TestPullSync() {
+	priv, err := genKeys()
+	require.NoError(suite.T(), err, "Failed to generate keys")
+
+	cs := &countingCommStub{}
+
+	n, err := NewNode(cs, &pingStub{}, &cmStub{cert: genCert(priv, 10)}, &cryptoStub{priv: priv}, testConfig())
+	require.NoError(suite.T(), err, "Failed to create node.")
+
+	correct{}.PullSync(context.Background(), n)
+	assert.Zero(suite.T(), cs.numPull(), "Should not pull with an empty live view.")
+
+	_, _, err = addPeer(n)
+	require.NoError(suite.T(), err, "Could not add peer.")
+
+	correct{}.PullSync(context.Background(), n)
+	assert.Equal(suite.T(), 1, cs.numPull(), "Should pull from one peer per round.")
+	assert.Zero(suite.T(), cs.numGossip(), "Pull should not push gossip.")
+}
+
+func (suite *ProtocolTestSuite) TestGossipLatency() {
+	priv, err := genKeys()
+	require.NoError(suite.T(), err, "Failed to generate keys")
+
+	cs := &countingCommStub{}
+
+	n, err := NewNode(cs, &pingStub{}, &cmStub{cert: genCert(priv, 10)}, &cryptoStub{priv: priv}, testConfig())
+	require.NoError(suite.T(), err, "Failed to create node.")
+
+	for i := 0; i < 10; i++ {
+		_, _, err := addPeer(n)
+		require.NoError(suite.T(), err, "Could not add peer.")
+	}
+
+	correct{}.Gossip(context.Background(), n)
+
+	latencies := n.AllPeerLatencies()
+	assert.Len(suite.T(), latencies, cs.numGossip(), "Latency not recorded for each gossip partner.")
+
+	for id := range latencies {
+		require.NotNil(suite.T(), n.view.Peer(id), "Latency recorded for unknown peer.")
+
+		_, ok := n.PeerLatency([]byte(id))
+		assert.True(suite.T(), ok, "Latency of gossip partner not returned.")
+	}
+}
+
+func (suite *ProtocolTestSuite) TestPartnerSelector() {
+	priv, err := genKeys()
+	require.NoError(suite.T(), err, "Failed to generate keys")
+
+	selector := &recordingSelector{}
+
+	conf := testConfig()
+	conf.PartnerSelector = selector
+
+	cs := &countingCommStub{}
+
+	n, err := NewNode(cs, &pingStub{}, &cmStub{cert: genCert(priv, 10)}, &cryptoStub{priv: priv}, conf)
+	require.NoError(suite.T(), err, "Failed to create node.")
+
+	for i := 0; i < 50; i++ {
+		_, _, err := addPeer(n)
+		require.NoError(suite.T(), err, "Could not add peer.")
+	}
+
+	correct{}.Gossip(context.Background(), n)
+
+	assert.Equal(suite.T(), 1, cs.numGossip(), "Did not gossip with the selected partner only.")
+
+	var expected []string
+	for _, p := range n.view.MyNeighbours() {
+		expected = append(expected, p.Id)
+	}
+
+	rings := make(map[string][]uint32)
+	for _, nb := range selector.neighbours {
+		rings[nb.Id] = nb.Rings
+	}
+
+	assert.ElementsMatch(suite.T(), expected, ids(selector.neighbours), "Selector not given all ring neighbours.")
+
+	for r := uint32(1); r <= n.view.NumRings(); r++ {
+		succ, prev := n.view.MyRingNeighbours(r)
+		assert.Contains(suite.T(), rings[succ.Id], r, "Ring missing for successor.")
+		assert.Contains(suite.T(), rings[prev.Id], r, "Ring missing for predecessor.")
+	}
+
+	ring := RingPartners()
+	for r := uint32(1); r <= n.view.NumRings()+1; r++ {
+		num := (r-1)%n.view.NumRings() + 1
+		succ, prev := n.view.MyRingNeighbours(num)
+		assert.ElementsMatch(suite.T(), []string{succ.Id, prev.Id}, ring.SelectPartners(selector.neighbours), "Ring partners not rotating through rings.")
+	}
+
+	assert.Len(suite.T(), RandomPartners(3).SelectPartners(selector.neighbours), 3, "Invalid number of random partners.")
+
+	measured := []Neighbour{
+		{Id: "slow", RTT: time.Second},
+		{Id: "fast", RTT: time.Millisecond},
+		{Id: "unmeasured"},
+	}
+	assert.Equal(suite.T(), []string{"unmeasured", "fast"}, LatencyPartners(2).SelectPartners(measured), "Latency partners not preferring unmeasured and fast peers.")
+}
+
+func (suite *ProtocolTestSuite) TestResponseHandler() {
+	priv, err := genKeys()
+	require.NoError(suite.T(), err, "Failed to generate keys")
+
+	conf := testConfig()
+	conf.GossipFanout = 10000
+
+	n, err := NewNode(&respondingCommStub{}, &pingStub{}, &cmStub{cert: genCert(priv, 10)}, &cryptoStub{priv: priv}, conf)
+	require.NoError(suite.T(), err, "Failed to create node.")
+
+	for i := 0; i < 10; i++ {
+		_, _, err := addPeer(n)
+		require.NoError(suite.T(), err, "Could not add peer.")
+	}
+
+	content := []byte("content")
+	n.SetExternalGossipContent(content)
+
+	var peers []string
+
+	n.SetResponseHandlerWithSender(func(peerId, contentId, response []byte) {
+		peers = append(peers, string(peerId))
+		assert.Equal(suite.T(), hashContent(content), contentId, "Invalid content id.")
+		assert.Equal(suite.T(), []byte("response"), response, "Invalid response.")
+	})
+
+	correct{}.Gossip(context.Background(), n)
+
+	var expected []string
+	for _, p := range n.view.MyNeighbours() {
+		expected = append(expected, p.Id)
+	}
+
+	assert.ElementsMatch(suite.T(), expected, peers, "Responses not attributed to the responding peers.")
+
+	responses := 0
+
+	n.SetResponseHandler(func(response []byte) {
+		responses++
+	})
+
+	correct{}.Gossip(context.Background(), n)
+
+	assert.Equal(suite.T(), len(expected), responses, "Handler without sender not invoked.")
+}
+
+// Picks the first neighbour and an unknown id.
+type recordingSelector struct {
+	neighbours []Neighbour
+}
+
+func (rs *recordingSelector) SelectPartners(neighbours []Neighbour) []string {
+	rs.neighbours = neighbours
+
+	return []string{neighbours[0].Id, "unknown"}
+}
+
+// Counts gossip and pull calls.
+type countingCommStub struct {
+	commStub
+
+	mutex  sync.Mutex
+	gossip int
+	pull   int
+}
+
+func (cs *countingCommStub) Pull(ctx context.Context, addr string, m *pb.State) (*pb.StateResponse, error) {
+	cs.mutex.Lock()
+	defer cs.mutex.Unlock()
+
+	cs.pull++
+
+	return &pb.StateResponse{}, nil
+}
+
+func (cs *countingCommStub) numPull() int {
+	cs.mutex.Lock()
+	defer cs.mutex.Unlock()
+
+	return cs.pull
+}
+
+func (cs *countingCommStub) Gossip(ctx context.Context, addr string, m *pb.State) (*pb.StateResponse, error) {
+	cs.mutex.Lock()
+	defer cs.mutex.Unlock()
+
+	cs.gossip++
+
+	return &pb.StateResponse{}, nil
+}
+
+func (cs *countingCommStub) numGossip() int {
+	cs.mutex.Lock()
+	defer cs.mutex.Unlock()
+
+	return cs.gossip
+}
+
+// Answers gossip as a gossip handler would.
+type respondingCommStub struct {
+	commStub
+}
+
+func (cs *respondingCommStub) Gossip(ctx context.Context, addr string, m *pb.State) (*pb.StateResponse, error) {
+	return &pb.StateResponse{ExternalGossip: []byte("response")}, nil
+}
@@ -0,0 +1,90 @@
+package core
+
+import (
+	"sync"
+	"time"
+)
+
+// Number of buckets kept before full ones are pruned, a full bucket is
+// equivalent to having none.
+const maxIdleBuckets = 1024
+
+// Token bucket per peer id, each peer may make rate requests per second
+// on average with bursts of up to burst requests.
+type rateLimiter struct {
+	rate  float64
+	burst float64
+
+	buckets map[string]*bucket
+	mutex   sync.Mutex
+}
+
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// Rate zero disables the limiter, every request is then allowed.
+// Burst defaults to the rate if zero.
+func newRateLimiter(rate, burst uint32) *rateLimiter {
+	if burst == 0 {
+		burst = rate
+	}
+
+	return &rateLimiter{
+		rate:    float64(rate),
+		burst:   float64(burst),
+		buckets: make(map[string]*bucket),
+	}
+}
+
+// Takes a token from the bucket of the given id at the given time, returns false if it was empty.
+func (rl *rateLimiter) allowAt(id string, now time.Time) bool {
+	if rl.rate == 0 {
+		return true
+	}
+
+	rl.mutex.Lock()
+	defer rl.mutex.Unlock()
+
+	b, exists := rl.buckets[id]
+	if !exists {
+		if len(rl.buckets) >= maxIdleBuckets {
+			rl.prune(now)
+		}
+
+		b = &bucket{tokens: rl.burst, last: now}
+		rl.buckets[id] = b
+	}
+
+	rl.refill(b, now)
+
+	if b.tokens < 1 {
+		return false
+	}
+
+	b.tokens--
+
+	return true
+}
+
+// Caller must hold the mutex.
+func (rl *rateLimiter) refill(b *bucket, now time.Time) {
+	if elapsed := now.Sub(b.last).Seconds(); elapsed > 0 {
+		b.tokens += elapsed * rl.rate
+		if b.tokens > rl.burst {
+			b.tokens = rl.burst
+		}
+		b.last = now
+	}
+}
+
+// Caller must hold the mutex.
+func (rl *rateLimiter) prune(now time.Time) {
+	for id, b := range rl.buckets {
+		rl.refill(b, now)
+		if b.tokens >= rl.burst {
+			delete(rl.buckets, id)
+		}
+	}
+}
@@ -0,0 +1,67 @@
+package core
+
+import (
+	"container/list"
+	"crypto/sha256"
+	"sync"
+)
+
+// Bounded set of recently handled gossip, the least recently seen entry is
+// evicted once the set is full.
+type seenCache struct {
+	size int
+
+	entries map[string]*list.Element
+	order   *list.List
+
+	mutex sync.Mutex
+}
+
+// Size zero disables the cache, every entry is then reported as unseen.
+func newSeenCache(size uint32) *seenCache {
+	return &seenCache{
+		size:    int(size),
+		entries: make(map[string]*list.Element),
+		order:   list.New(),
+	}
+}
+
+// Gossip is identified by the id of the peer gossiping it and the digest of
+// its content, so new content from the same peer is a new version.
+func gossipKey(id string, content []byte) string {
+	digest := sha256.Sum256(content)
+
+	return id + string(digest[:])
+}
+
+// Records the key as seen, returns false if it already was.
+func (sc *seenCache) add(key string) bool {
+	if sc.size == 0 {
+		return true
+	}
+
+	sc.mutex.Lock()
+	defer sc.mutex.Unlock()
+
+	if e, exists := sc.entries[key]; exists {
+		sc.order.MoveToFront(e)
+		return false
+	}
+
+	sc.entries[key] = sc.order.PushFront(key)
+
+	if sc.order.Len() > sc.size {
+		oldest := sc.order.Back()
+		sc.order.Remove(oldest)
+		delete(sc.entries, oldest.Value.(string))
+	}
+
+	return true
+}
+
+func (sc *seenCache) len() int {
+	sc.mutex.Lock()
+	defer sc.mutex.Unlock()
+
+	return sc.order.Len()
+}
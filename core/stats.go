@@ -0,0 +1,386 @@
+package core
+
+import (
+	"sync"
+	"time"
+)
+
+// Weight of the newest sample in the recent gossip rtt.
+const rttWeight = 0.25
+
+// Upper bounds of the gossip rtt histogram buckets.
+var rttBuckets = [...]time.Duration{
+	time.Millisecond,
+	time.Millisecond * 5,
+	time.Millisecond * 10,
+	time.Millisecond * 25,
+	time.Millisecond * 50,
+	time.Millisecond * 100,
+	time.Millisecond * 250,
+	time.Millisecond * 500,
+	time.Second,
+	time.Millisecond * 2500,
+	time.Second * 5,
+	time.Second * 10,
+}
+
+// Snapshot of the node's runtime statistics.
+type Stats struct {
+	// Number of completed gossip intervals.
+	GossipRounds uint64
+
+	// When the last gossip round ended, zero before the first one. Rounds end within their deadline,
+	// so a time further back than the gossip interval and round timeout means the gossip loop is stuck.
+	LastGossipRound time.Time
+
+	// Average round trip time of all successful gossip exchanges.
+	AvgGossipRTT time.Duration
+
+	// Exponentially weighted round trip time, reflects the most recent gossip exchanges.
+	RecentGossipRTT time.Duration
+
+	// Distribution of the round trip times of all successful gossip exchanges.
+	GossipRTTHistogram Histogram
+
+	// Marshaled size of gossip sent to neighbours and of the responses.
+	GossipBytesSent     uint64
+	GossipBytesReceived uint64
+
+	// Peers currently in the live view, and peers in the full view that are not.
+	LivePeers int
+	DeadPeers int
+
+	// Accused peers waiting for their removal timeout, a sudden rise points at an accusation storm.
+	PendingTimeouts int
+
+	// Number of accusations this node has raised against its ring successors.
+	AccusationsRaised uint64
+
+	// Outcome of pings sent to ring successors.
+	PingsSucceeded uint64
+	PingsFailed    uint64
+
+	// Application messages sent through SendTo and received by the message handler.
+	MessagesSent     uint64
+	MessagesReceived uint64
+
+	// Number of certificates rejected for claiming the id of a known peer with another key or addresses.
+	IdConflicts uint64
+
+	// Inbound gossip rejected before it was merged, and stream messages lost in either direction.
+	DroppedGossip       uint64
+	DroppedStreamFrames uint64
+
+	// Failed reads and pong writes of the udp socket serving pings.
+	UdpReadErrors  uint64
+	UdpWriteErrors uint64
+
+	// Pongs whose timestamp put the clock of the responder further off than the clock skew threshold.
+	ClockSkews uint64
+
+	// Peers found alive over tcp after their udp pings failed, see Config.TcpPingFallback.
+	TcpPings uint64
+}
+
+// Cumulative histogram.
+type Histogram struct {
+	// Number of samples less than or equal to each upper bound.
+	Buckets map[time.Duration]uint64
+
+	Count uint64
+	Sum   time.Duration
+}
+
+type recorder struct {
+	mutex sync.Mutex
+
+	gossipRounds    uint64
+	lastRound       time.Time
+	gossipExchanges uint64
+	totalGossipRTT  time.Duration
+	recentGossipRTT time.Duration
+	rttBucketCounts [len(rttBuckets)]uint64
+
+	// Exponentially weighted gossip rtt of each peer we gossip with, keyed by id.
+	peerRTT map[string]time.Duration
+
+	// Exponentially weighted clock offset of each peer we ping, keyed by id.
+	peerSkew   map[string]time.Duration
+	clockSkews uint64
+
+	gossipBytesSent     uint64
+	gossipBytesReceived uint64
+
+	accusations  uint64
+	pingsOk      uint64
+	pingsFailed  uint64
+	msgsSent     uint64
+	msgsReceived uint64
+
+	idConflicts uint64
+	tcpPings    uint64
+
+	drops [UdpWriteError + 1]uint64
+}
+
+func (r *recorder) recordGossipRound() {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	r.gossipRounds++
+}
+
+func (r *recorder) recordRoundEnd(t time.Time) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	r.lastRound = t
+}
+
+func (r *recorder) lastGossipRound() time.Time {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	return r.lastRound
+}
+
+func (r *recorder) recordGossipRTT(rtt time.Duration) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	if r.gossipExchanges == 0 {
+		r.recentGossipRTT = rtt
+	} else {
+		r.recentGossipRTT = time.Duration(rttWeight*float64(rtt) + (1-rttWeight)*float64(r.recentGossipRTT))
+	}
+
+	r.gossipExchanges++
+	r.totalGossipRTT += rtt
+
+	for i, bound := range rttBuckets {
+		if rtt <= bound {
+			r.rttBucketCounts[i]++
+			break
+		}
+	}
+}
+
+func (r *recorder) recordPeerRTT(id string, rtt time.Duration) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	if r.peerRTT == nil {
+		r.peerRTT = make(map[string]time.Duration)
+	}
+
+	if prev, ok := r.peerRTT[id]; ok {
+		rtt = time.Duration(rttWeight*float64(rtt) + (1-rttWeight)*float64(prev))
+	}
+
+	r.peerRTT[id] = rtt
+}
+
+func (r *recorder) forgetPeer(id string) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	delete(r.peerRTT, id)
+	delete(r.peerSkew, id)
+}
+
+func (r *recorder) peerLatency(id string) (time.Duration, bool) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	rtt, ok := r.peerRTT[id]
+
+	return rtt, ok
+}
+
+// Returns the weighted clock offset of the peer including the new sample.
+func (r *recorder) recordClockSkew(id string, skew time.Duration, exceeded bool) time.Duration {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	if r.peerSkew == nil {
+		r.peerSkew = make(map[string]time.Duration)
+	}
+
+	if prev, ok := r.peerSkew[id]; ok {
+		skew = time.Duration(rttWeight*float64(skew) + (1-rttWeight)*float64(prev))
+	}
+
+	r.peerSkew[id] = skew
+
+	if exceeded {
+		r.clockSkews++
+	}
+
+	return skew
+}
+
+func (r *recorder) peerClockSkew(id string) (time.Duration, bool) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	skew, ok := r.peerSkew[id]
+
+	return skew, ok
+}
+
+func (r *recorder) peerLatencies() map[string]time.Duration {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	ret := make(map[string]time.Duration, len(r.peerRTT))
+
+	for id, rtt := range r.peerRTT {
+		ret[id] = rtt
+	}
+
+	return ret
+}
+
+func (r *recorder) recordGossipBytes(sent, received int) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	r.gossipBytesSent += uint64(sent)
+	r.gossipBytesReceived += uint64(received)
+}
+
+func (r *recorder) recordPing(success bool) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	if success {
+		r.pingsOk++
+	} else {
+		r.pingsFailed++
+	}
+}
+
+func (r *recorder) recordTcpPing() {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	r.tcpPings++
+}
+
+func (r *recorder) recordAccusation() {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	r.accusations++
+}
+
+func (r *recorder) recordMsgSent() {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	r.msgsSent++
+}
+
+func (r *recorder) recordMsgReceived() {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	r.msgsReceived++
+}
+
+func (r *recorder) recordIdConflict() {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	r.idConflicts++
+}
+
+func (r *recorder) recordDrop(kind DropKind) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	if int(kind) < len(r.drops) {
+		r.drops[kind]++
+	}
+}
+
+func (r *recorder) snapshot() Stats {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	s := Stats{
+		GossipRounds:        r.gossipRounds,
+		LastGossipRound:     r.lastRound,
+		RecentGossipRTT:     r.recentGossipRTT,
+		GossipBytesSent:     r.gossipBytesSent,
+		GossipBytesReceived: r.gossipBytesReceived,
+		AccusationsRaised:   r.accusations,
+		PingsSucceeded:      r.pingsOk,
+		PingsFailed:         r.pingsFailed,
+		MessagesSent:        r.msgsSent,
+		MessagesReceived:    r.msgsReceived,
+		IdConflicts:         r.idConflicts,
+		DroppedGossip:       r.drops[DroppedGossip],
+		DroppedStreamFrames: r.drops[DroppedStreamFrame],
+		UdpReadErrors:       r.drops[UdpReadError],
+		UdpWriteErrors:      r.drops[UdpWriteError],
+		ClockSkews:          r.clockSkews,
+		TcpPings:            r.tcpPings,
+	}
+
+	if r.gossipExchanges > 0 {
+		s.AvgGossipRTT = r.totalGossipRTT / time.Duration(r.gossipExchanges)
+	}
+
+	s.GossipRTTHistogram = Histogram{
+		Buckets: make(map[time.Duration]uint64, len(rttBuckets)),
+		Count:   r.gossipExchanges,
+		Sum:     r.totalGossipRTT,
+	}
+
+	var cumulative uint64
+	for i, bound := range rttBuckets {
+		cumulative += r.rttBucketCounts[i]
+		s.GossipRTTHistogram.Buckets[bound] = cumulative
+	}
+
+	return s
+}
+
+// Returns the recent gossip round trip time of the given peer,
+// false if we have not gossiped with it since it joined the live view.
+func (n *Node) PeerLatency(id []byte) (time.Duration, bool) {
+	return n.stats.peerLatency(string(id))
+}
+
+// Returns the recent gossip round trip time of every live peer we have gossiped with, keyed by id.
+func (n *Node) AllPeerLatencies() map[string]time.Duration {
+	return n.stats.peerLatencies()
+}
+
+// Returns a snapshot of the node's runtime statistics.
+func (n *Node) Stats() Stats {
+	s := n.stats.snapshot()
+
+	live := len(n.view.Live())
+	full := len(n.view.Full())
+
+	s.LivePeers = live
+	if full > live {
+		s.DeadPeers = full - live
+	}
+
+	s.PendingTimeouts = n.PendingTimeouts()
+
+	return s
+}
+
+// Returns when the last gossip round ended, zero before the first one.
+func (n *Node) LastGossipRound() time.Time {
+	return n.stats.lastGossipRound()
+}
+
+// Returns the number of accused peers waiting for their removal timeout to expire.
+func (n *Node) PendingTimeouts() int {
+	return n.view.NumTimeouts()
+}
@@ -0,0 +1,109 @@
+package core
+
+import (
+	"testing"
+	"time"
+
+	log "github.com/inconshreveable/log15"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/suite"
+)
+
+type StatsTestSuite struct {
+	suite.Suite
+	r *recorder
+}
+
+func TestStatsTestSuite(t *testing.T) {
+	r := log.Root()
+
+	r.SetHandler(log.DiscardHandler())
+
+	suite.Run(t, new(StatsTestSuite))
+}
+
+func (suite *StatsTestSuite) SetupTest() {
+	suite.r = &recorder{}
+}
+
+func (suite *StatsTestSuite) TestEmpty() {
+	s := suite.r.snapshot()
+
+	assert.Zero(suite.T(), s.AvgGossipRTT, "Average rtt should be zero without samples.")
+	assert.Zero(suite.T(), s.RecentGossipRTT, "Recent rtt should be zero without samples.")
+}
+
+func (suite *StatsTestSuite) TestGossipRTT() {
+	suite.r.recordGossipRTT(time.Millisecond * 10)
+
+	s := suite.r.snapshot()
+	assert.Equal(suite.T(), time.Millisecond*10, s.AvgGossipRTT, "Invalid average with one sample.")
+	assert.Equal(suite.T(), time.Millisecond*10, s.RecentGossipRTT, "First sample should be the recent rtt.")
+
+	suite.r.recordGossipRTT(time.Millisecond * 30)
+
+	s = suite.r.snapshot()
+	assert.Equal(suite.T(), time.Millisecond*20, s.AvgGossipRTT, "Invalid average with two samples.")
+	assert.Equal(suite.T(), time.Millisecond*15, s.RecentGossipRTT, "Invalid weighted rtt.")
+
+	h := s.GossipRTTHistogram
+	assert.Equal(suite.T(), uint64(2), h.Count, "Invalid histogram count.")
+	assert.Equal(suite.T(), time.Millisecond*40, h.Sum, "Invalid histogram sum.")
+	assert.Equal(suite.T(), uint64(0), h.Buckets[time.Millisecond*5], "Invalid bucket below both samples.")
+	assert.Equal(suite.T(), uint64(1), h.Buckets[time.Millisecond*10], "Invalid bucket including the first sample.")
+	assert.Equal(suite.T(), uint64(2), h.Buckets[time.Second*10], "Invalid last bucket.")
+}
+
+func (suite *StatsTestSuite) TestPeerRTT() {
+	_, ok := suite.r.peerLatency("peer")
+	assert.False(suite.T(), ok, "Latency reported for unknown peer.")
+
+	suite.r.recordPeerRTT("peer", time.Millisecond*10)
+	suite.r.recordPeerRTT("other", time.Millisecond*50)
+
+	rtt, ok := suite.r.peerLatency("peer")
+	assert.True(suite.T(), ok, "No latency for recorded peer.")
+	assert.Equal(suite.T(), time.Millisecond*10, rtt, "First sample should be the peer latency.")
+
+	suite.r.recordPeerRTT("peer", time.Millisecond*30)
+
+	rtt, _ = suite.r.peerLatency("peer")
+	assert.Equal(suite.T(), time.Millisecond*15, rtt, "Invalid weighted peer latency.")
+
+	all := suite.r.peerLatencies()
+	assert.Equal(suite.T(), map[string]time.Duration{"peer": time.Millisecond * 15, "other": time.Millisecond * 50}, all, "Invalid latencies.")
+
+	all["peer"] = 0
+	rtt, _ = suite.r.peerLatency("peer")
+	assert.Equal(suite.T(), time.Millisecond*15, rtt, "Returned latencies not a copy.")
+
+	suite.r.forgetPeer("peer")
+	_, ok = suite.r.peerLatency("peer")
+	assert.False(suite.T(), ok, "Latency kept for forgotten peer.")
+}
+
+func (suite *StatsTestSuite) TestCounters() {
+	suite.r.recordGossipRound()
+	suite.r.recordAccusation()
+	suite.r.recordAccusation()
+	suite.r.recordMsgSent()
+	suite.r.recordMsgReceived()
+	suite.r.recordMsgReceived()
+	suite.r.recordMsgReceived()
+	suite.r.recordPing(true)
+	suite.r.recordPing(false)
+	suite.r.recordPing(false)
+	suite.r.recordGossipBytes(10, 20)
+	suite.r.recordIdConflict()
+
+	s := suite.r.snapshot()
+	assert.Equal(suite.T(), uint64(1), s.GossipRounds, "Invalid gossip rounds.")
+	assert.Equal(suite.T(), uint64(2), s.AccusationsRaised, "Invalid accusations.")
+	assert.Equal(suite.T(), uint64(1), s.MessagesSent, "Invalid messages sent.")
+	assert.Equal(suite.T(), uint64(3), s.MessagesReceived, "Invalid messages received.")
+	assert.Equal(suite.T(), uint64(1), s.PingsSucceeded, "Invalid successful pings.")
+	assert.Equal(suite.T(), uint64(2), s.PingsFailed, "Invalid failed pings.")
+	assert.Equal(suite.T(), uint64(10), s.GossipBytesSent, "Invalid gossip bytes sent.")
+	assert.Equal(suite.T(), uint64(20), s.GossipBytesReceived, "Invalid gossip bytes received.")
+	assert.Equal(suite.T(), uint64(1), s.IdConflicts, "Invalid id conflicts.")
+}
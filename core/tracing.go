@@ -0,0 +1,99 @@
+package core
+
+import (
+	"encoding/base64"
+
+	"github.com/golang/protobuf/proto"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc/metadata"
+)
+
+// Span names and attributes.
+const (
+	spanSendMessage = "ifrit.SendMessage"
+	spanMessenger   = "ifrit.Messenger"
+	spanGossip      = "ifrit.Gossip"
+	spanSpread      = "ifrit.Spread"
+
+	tracePeerIdAttr   = "ifrit.peer.id"
+	tracePeerAddrAttr = "ifrit.peer.addr"
+	traceSizeAttr     = "ifrit.payload.size"
+)
+
+// Traces message and gossip rpcs, see Config.Tracer.
+// Mirrors the OpenTelemetry tracer and text map propagator so that either can be wrapped directly.
+type Tracer interface {
+	// Starts a span with the given attributes as a child of the span in ctx, if any.
+	// Returns a context carrying the new span and a function ending it.
+	Start(ctx context.Context, name string, attrs map[string]interface{}) (context.Context, func())
+
+	// Writes the trace context carried by ctx to the carrier.
+	Inject(ctx context.Context, carrier map[string]string)
+
+	// Returns ctx with the trace context read from the carrier.
+	Extract(ctx context.Context, carrier map[string]string) context.Context
+}
+
+// Starts a span for an rpc with the peer identified by id, address or both,
+// the payload size is that of the given message. The returned function ends the span,
+// both are no-ops and nothing is computed when no tracer is set.
+func (n *Node) startSpan(ctx context.Context, name string, peerId []byte, addr string, msg proto.Message) (context.Context, func()) {
+	if n.tracer == nil {
+		return ctx, func() {}
+	}
+
+	attrs := map[string]interface{}{
+		traceSizeAttr: proto.Size(msg),
+	}
+
+	// Ids are raw digests, spans carry them base64 encoded like the view dump does.
+	if peerId != nil {
+		attrs[tracePeerIdAttr] = base64.StdEncoding.EncodeToString(peerId)
+	}
+
+	if addr != "" {
+		attrs[tracePeerAddrAttr] = addr
+	}
+
+	return n.tracer.Start(ctx, name, attrs)
+}
+
+// Adds the trace context of ctx to the outgoing grpc metadata.
+func (n *Node) injectTrace(ctx context.Context) context.Context {
+	if n.tracer == nil {
+		return ctx
+	}
+
+	carrier := make(map[string]string)
+	n.tracer.Inject(ctx, carrier)
+
+	var kv []string
+	for k, v := range carrier {
+		kv = append(kv, k, v)
+	}
+
+	if len(kv) == 0 {
+		return ctx
+	}
+
+	return metadata.AppendToOutgoingContext(ctx, kv...)
+}
+
+// Continues the trace of the sender from the incoming grpc metadata.
+func (n *Node) extractTrace(ctx context.Context) context.Context {
+	if n.tracer == nil {
+		return ctx
+	}
+
+	carrier := make(map[string]string)
+
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		for k, v := range md {
+			if len(v) > 0 {
+				carrier[k] = v[0]
+			}
+		}
+	}
+
+	return n.tracer.Extract(ctx, carrier)
+}
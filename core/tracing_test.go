@@ -0,0 +1,210 @@
+package core
+
+import (
+	"encoding/base64"
+	"fmt"
+	"sync"
+	"testing"
+
+	"github.com/golang/protobuf/proto"
+	log "github.com/inconshreveable/log15"
+	"github.com/joonnna/ifrit/core/discovery"
+	pb "github.com/joonnna/ifrit/protobuf"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/stretchr/testify/suite"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc/metadata"
+)
+
+type TracingTestSuite struct {
+	suite.Suite
+	n      *Node
+	tracer *recordingTracer
+}
+
+func TestTracingTestSuite(t *testing.T) {
+	r := log.Root()
+
+	r.SetHandler(log.DiscardHandler())
+
+	suite.Run(t, new(TracingTestSuite))
+}
+
+func (suite *TracingTestSuite) SetupTest() {
+	priv, err := genKeys()
+	require.NoError(suite.T(), err, "Failed to generate keys")
+
+	suite.tracer = &recordingTracer{}
+
+	conf := testConfig()
+	conf.Tracer = suite.tracer
+
+	n, err := NewNode(&commStub{}, &pingStub{}, &cmStub{cert: genCert(priv, 10)}, &cryptoStub{priv: priv}, conf)
+	require.NoError(suite.T(), err, "Failed to create node.")
+
+	suite.n = n
+}
+
+func (suite *TracingTestSuite) TestMessagePropagation() {
+	sender, _, err := addPeer(suite.n)
+	require.NoError(suite.T(), err, "Could not add peer.")
+
+	// Messages sent by the node are received by itself, as if sent by the peer.
+	suite.n.comm = &loopbackCommStub{n: suite.n, from: sender}
+
+	var handlerSpan string
+	suite.n.SetMsgHandler(func(data []byte) ([]byte, error) {
+		handlerSpan = suite.tracer.last().name
+		return data, nil
+	})
+
+	content := []byte("content")
+
+	ctx, end := suite.tracer.Start(context.Background(), "caller", nil)
+
+	ch := make(chan []byte, 1)
+	suite.n.sendMsg(ctx, "addr", ch, &pb.Msg{Content: content})
+	end()
+
+	require.Equal(suite.T(), content, <-ch, "Invalid response.")
+	require.Equal(suite.T(), spanMessenger, handlerSpan, "Handler not invoked within the receiver span.")
+
+	spans := suite.tracer.all()
+	require.Len(suite.T(), spans, 3, "Invalid number of spans.")
+
+	caller, send, recv := spans[0], spans[1], spans[2]
+
+	assert.Equal(suite.T(), spanSendMessage, send.name, "Invalid sender span.")
+	assert.Equal(suite.T(), caller.id, send.parent, "Sender span not a child of the caller.")
+	assert.Equal(suite.T(), "addr", send.attrs[tracePeerAddrAttr], "Sender span without peer address.")
+	assert.Equal(suite.T(), proto.Size(&pb.Msg{Content: content}), send.attrs[traceSizeAttr], "Sender span without payload size.")
+
+	assert.Equal(suite.T(), spanMessenger, recv.name, "Invalid receiver span.")
+	assert.Equal(suite.T(), send.id, recv.parent, "Trace context not propagated to the receiver.")
+	assert.Equal(suite.T(), base64.StdEncoding.EncodeToString([]byte(sender.Id)), recv.attrs[tracePeerIdAttr], "Receiver span without sender id.")
+
+	for _, s := range spans {
+		assert.True(suite.T(), s.ended, "Span %s not ended.", s.name)
+	}
+}
+
+func (suite *TracingTestSuite) TestGossipSpans() {
+	p, _, err := addPeer(suite.n)
+	require.NoError(suite.T(), err, "Could not add peer.")
+
+	_, err = suite.n.Spread(peerContext(p), &pb.State{})
+	require.NoError(suite.T(), err, "Spread failed with valid context.")
+
+	s := suite.tracer.last()
+	assert.Equal(suite.T(), spanSpread, s.name, "Invalid spread span.")
+	assert.Equal(suite.T(), base64.StdEncoding.EncodeToString([]byte(p.Id)), s.attrs[tracePeerIdAttr], "Spread span without sender id.")
+	assert.True(suite.T(), s.ended, "Spread span not ended.")
+
+	suite.n.gossipFanout = 1
+	suite.n.protocol().Gossip(context.Background(), suite.n)
+
+	s = suite.tracer.last()
+	assert.Equal(suite.T(), spanGossip, s.name, "Invalid gossip span.")
+	assert.Contains(suite.T(), s.attrs, tracePeerAddrAttr, "Gossip span without peer address.")
+	assert.True(suite.T(), s.ended, "Gossip span not ended.")
+}
+
+func (suite *TracingTestSuite) TestNoTracer() {
+	suite.n.tracer = nil
+
+	ctx := context.Background()
+
+	spanCtx, end := suite.n.startSpan(ctx, spanSpread, nil, "addr", &pb.State{})
+	end()
+
+	assert.Equal(suite.T(), ctx, spanCtx, "Context altered without a tracer.")
+	assert.Equal(suite.T(), ctx, suite.n.injectTrace(ctx), "Context altered without a tracer.")
+	assert.Empty(suite.T(), suite.tracer.all(), "Spans recorded without a tracer.")
+}
+
+type span struct {
+	name   string
+	id     string
+	parent string
+	attrs  map[string]interface{}
+	ended  bool
+}
+
+type spanKey struct{}
+
+// Records spans and propagates the id of the current span as trace context.
+type recordingTracer struct {
+	mutex sync.Mutex
+	spans []*span
+}
+
+func (rt *recordingTracer) Start(ctx context.Context, name string, attrs map[string]interface{}) (context.Context, func()) {
+	rt.mutex.Lock()
+	defer rt.mutex.Unlock()
+
+	s := &span{
+		name:  name,
+		id:    fmt.Sprintf("span-%d", len(rt.spans)),
+		attrs: attrs,
+	}
+
+	if parent, ok := ctx.Value(spanKey{}).(string); ok {
+		s.parent = parent
+	}
+
+	rt.spans = append(rt.spans, s)
+
+	return context.WithValue(ctx, spanKey{}, s.id), func() {
+		rt.mutex.Lock()
+		defer rt.mutex.Unlock()
+
+		s.ended = true
+	}
+}
+
+func (rt *recordingTracer) Inject(ctx context.Context, carrier map[string]string) {
+	if id, ok := ctx.Value(spanKey{}).(string); ok {
+		carrier["span-id"] = id
+	}
+}
+
+func (rt *recordingTracer) Extract(ctx context.Context, carrier map[string]string) context.Context {
+	if id, ok := carrier["span-id"]; ok {
+		return context.WithValue(ctx, spanKey{}, id)
+	}
+
+	return ctx
+}
+
+func (rt *recordingTracer) all() []span {
+	rt.mutex.Lock()
+	defer rt.mutex.Unlock()
+
+	ret := make([]span, 0, len(rt.spans))
+	for _, s := range rt.spans {
+		ret = append(ret, *s)
+	}
+
+	return ret
+}
+
+func (rt *recordingTracer) last() span {
+	spans := rt.all()
+
+	return spans[len(spans)-1]
+}
+
+// Delivers sent messages to the given node, with the outgoing metadata as incoming metadata.
+type loopbackCommStub struct {
+	commStub
+
+	n    *Node
+	from *discovery.Peer
+}
+
+func (ls *loopbackCommStub) Send(ctx context.Context, addr string, m *pb.Msg) (*pb.MsgResponse, error) {
+	md, _ := metadata.FromOutgoingContext(ctx)
+
+	return ls.n.Messenger(metadata.NewIncomingContext(peerContext(ls.from), md), m)
+}
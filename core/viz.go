@@ -43,6 +43,82 @@ type state struct {
 	Trusted  bool
 }
 
+// JSON dump of the view served at /view.json, ids are raw bytes and thus base64 encoded.
+type viewDump struct {
+	Id    []byte
+	Addr  string
+	Peers []peerDump
+	Rings []ringDump
+}
+
+type peerDump struct {
+	Id          []byte
+	Addr        string
+	Live        bool
+	Epoch       uint64
+	Accusations []accusationDump
+}
+
+type accusationDump struct {
+	Accuser []byte
+	RingNum uint32
+	Epoch   uint64
+}
+
+type ringDump struct {
+	Num         uint32
+	Members     [][]byte
+	Successor   []byte
+	Predecessor []byte
+}
+
+func newViewDump(n *Node) *viewDump {
+	snapshot := n.view.Snapshot()
+
+	d := &viewDump{
+		Id:    []byte(n.self.Id),
+		Addr:  n.self.Addr,
+		Peers: make([]peerDump, 0, len(snapshot.Peers)),
+		Rings: make([]ringDump, 0, len(snapshot.Rings)),
+	}
+
+	for _, p := range snapshot.Peers {
+		peer := peerDump{
+			Id:    []byte(p.Id),
+			Addr:  p.Addr,
+			Live:  p.Live,
+			Epoch: p.Epoch,
+		}
+
+		for _, a := range p.Accusations {
+			peer.Accusations = append(peer.Accusations, accusationDump{
+				Accuser: []byte(a.Accuser),
+				RingNum: a.RingNum,
+				Epoch:   a.Epoch,
+			})
+		}
+
+		d.Peers = append(d.Peers, peer)
+	}
+
+	for _, r := range snapshot.Rings {
+		ring := ringDump{
+			Num:         r.Num,
+			Members:     make([][]byte, 0, len(r.Members)),
+			Successor:   []byte(r.Successor),
+			Predecessor: []byte(r.Predecessor),
+		}
+
+		for _, id := range r.Members {
+			ring.Members = append(ring.Members, []byte(id))
+		}
+
+		d.Rings = append(d.Rings, ring)
+	}
+
+	return d
+}
+
 func (s state) equal(other *state) bool {
 	return (s.Next == other.Next && s.Prev == other.Prev)
 }
@@ -55,10 +131,10 @@ func (s *state) marshal() io.Reader {
 	return bytes.NewReader(buff.Bytes())
 }
 
-func newViz(n *Node, vizAddr string, updateInterval time.Duration, trusted bool) (*viz, error) {
-	l, err := netutil.ListenOnPort(httpPort)
+func newViz(n *Node, httpAddr, vizAddr string, updateInterval time.Duration, trusted bool) (*viz, error) {
+	l, err := listenHttp(httpAddr)
 	if err != nil {
-		return nil, err
+		return nil, fmt.Errorf("%w: %s", ErrHttpListen, err.Error())
 	}
 
 	v := &viz{
@@ -75,6 +151,8 @@ func newViz(n *Node, vizAddr string, updateInterval time.Duration, trusted bool)
 	r := mux.NewRouter()
 	r.HandleFunc("/shutdownNode", v.shutdownHandler)
 	r.HandleFunc("/byzantine", v.byzantineHandler)
+	r.HandleFunc("/view.json", v.viewHandler).Methods("GET")
+	r.HandleFunc("/healthz", v.healthHandler).Methods("GET")
 
 	handler := cors.Default().Handler(r)
 
@@ -86,6 +164,15 @@ func newViz(n *Node, vizAddr string, updateInterval time.Duration, trusted bool)
 	return v, nil
 }
 
+// Binds the given address, or the first free port from httpPort if empty.
+func listenHttp(addr string) (net.Listener, error) {
+	if addr == "" {
+		return netutil.ListenOnPort(httpPort)
+	}
+
+	return net.Listen("tcp", addr)
+}
+
 func (v *viz) start() error {
 	v.addToViz()
 	go v.updateState()
@@ -108,6 +195,29 @@ func (v *viz) byzantineHandler(w http.ResponseWriter, r *http.Request) {
 	go v.periodicallyPause(time.Second * 20)
 }
 
+// Read-only dump of the full view, liveness, accusations and ring placements.
+func (v *viz) viewHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	if err := json.NewEncoder(w).Encode(newViewDump(v.n)); err != nil {
+		log.Error(err.Error())
+	}
+}
+
+// Answers 200 if the node is healthy, 503 with the reason otherwise, for liveness and readiness probes.
+func (v *viz) healthHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain")
+
+	healthy, reason := v.n.Healthy()
+	if !healthy {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		fmt.Fprintln(w, reason)
+		return
+	}
+
+	fmt.Fprintln(w, "ok")
+}
+
 func (v *viz) shutdownHandler(w http.ResponseWriter, r *http.Request) {
 	io.Copy(ioutil.Discard, r.Body)
 	r.Body.Close()
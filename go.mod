@@ -4,14 +4,16 @@ go 1.14
 
 require (
 	github.com/golang/protobuf v1.4.2
+	github.com/golang/snappy v0.0.1
 	github.com/gorilla/mux v1.7.4
 	github.com/inconshreveable/log15 v0.0.0-20200109203555-b30bc20e4fd1
 	github.com/jinzhu/configor v1.2.0
 	github.com/joonnna/workerpool v0.0.0-20180531065140-2c82629f6727
+	github.com/prometheus/client_golang v1.7.1
 	github.com/rs/cors v1.7.0
-	github.com/sirupsen/logrus v1.2.0 // indirect
 	github.com/spf13/viper v1.7.0
 	github.com/stretchr/testify v1.6.0
+	go.uber.org/goleak v1.1.10
 	golang.org/x/net v0.0.0-20200528225125-3c3fba18258b
 	google.golang.org/grpc v1.29.1
 )
@@ -0,0 +1,66 @@
+package ifrit
+
+import (
+	"errors"
+	"reflect"
+	"sync"
+
+	log "github.com/inconshreveable/log15"
+)
+
+var errLoggerSet = errors.New("A different logger is already installed, all clients in the process share one logger")
+
+var (
+	installedLogger Logger
+	loggerMutex     sync.Mutex
+)
+
+// Logger receives all log output of ifrit.
+// The key-value pairs alternate between keys and values, as in log15.
+type Logger interface {
+	Debug(msg string, keyvals ...interface{})
+	Info(msg string, keyvals ...interface{})
+	Error(msg string, keyvals ...interface{})
+}
+
+// Routes ifrit's log output to the given logger.
+// Ifrit logs through the log15 root logger, so the logger is global: it receives the output of all clients
+// in the process. The first logger stays installed, setting a different one afterwards is an error.
+func setLogger(l Logger) error {
+	loggerMutex.Lock()
+	defer loggerMutex.Unlock()
+
+	if installedLogger != nil {
+		if !sameLogger(installedLogger, l) {
+			return errLoggerSet
+		}
+		return nil
+	}
+
+	log.Root().SetHandler(log.FuncHandler(func(r *log.Record) error {
+		switch r.Lvl {
+		case log.LvlCrit, log.LvlError:
+			l.Error(r.Msg, r.Ctx...)
+		case log.LvlWarn, log.LvlInfo:
+			l.Info(r.Msg, r.Ctx...)
+		default:
+			l.Debug(r.Msg, r.Ctx...)
+		}
+
+		return nil
+	}))
+
+	installedLogger = l
+
+	return nil
+}
+
+// Comparing loggers of a type that is not comparable would panic, such loggers are never the same.
+func sameLogger(a, b Logger) bool {
+	t := reflect.TypeOf(a)
+	if t != reflect.TypeOf(b) || !t.Comparable() {
+		return false
+	}
+
+	return a == b
+}
@@ -0,0 +1,120 @@
+package ifrit
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const metricsNamespace = "ifrit"
+
+// Exports the client statistics as prometheus metrics.
+// Values are read from the client statistics on each scrape.
+type metricsCollector struct {
+	c *Client
+
+	gossipRounds        *prometheus.Desc
+	gossipBytesSent     *prometheus.Desc
+	gossipBytesReceived *prometheus.Desc
+	gossipRTT           *prometheus.Desc
+	livePeers           *prometheus.Desc
+	deadPeers           *prometheus.Desc
+	accusations         *prometheus.Desc
+	pings               *prometheus.Desc
+	messagesSent        *prometheus.Desc
+	messagesReceived    *prometheus.Desc
+	idConflicts         *prometheus.Desc
+	pendingTimeouts     *prometheus.Desc
+	lastGossipRound     *prometheus.Desc
+	drops               *prometheus.Desc
+	clockSkews          *prometheus.Desc
+	tcpPings            *prometheus.Desc
+}
+
+// Returns a prometheus collector exporting gossip, membership, ping and messaging metrics of the client.
+// Register it with a prometheus registry to expose the metrics.
+func (c *Client) MetricsCollector() prometheus.Collector {
+	labels := prometheus.Labels{"addr": c.Addr()}
+
+	desc := func(name, help string, variableLabels ...string) *prometheus.Desc {
+		return prometheus.NewDesc(prometheus.BuildFQName(metricsNamespace, "", name), help, variableLabels, labels)
+	}
+
+	return &metricsCollector{
+		c: c,
+
+		gossipRounds:        desc("gossip_rounds_total", "Number of completed gossip intervals."),
+		gossipBytesSent:     desc("gossip_sent_bytes_total", "Bytes of gossip sent to neighbours."),
+		gossipBytesReceived: desc("gossip_received_bytes_total", "Bytes of gossip responses received from neighbours."),
+		gossipRTT:           desc("gossip_rtt_seconds", "Round trip time of successful gossip exchanges."),
+		livePeers:           desc("live_peers", "Number of peers in the live view."),
+		deadPeers:           desc("dead_peers", "Number of known peers not in the live view."),
+		accusations:         desc("accusations_total", "Number of accusations raised against ring successors."),
+		pings:               desc("pings_total", "Number of pings sent to ring successors.", "result"),
+		messagesSent:        desc("messages_sent_total", "Number of application messages sent."),
+		messagesReceived:    desc("messages_received_total", "Number of application messages received."),
+		idConflicts:         desc("id_conflicts_total", "Number of certificates rejected for claiming the id of a known peer."),
+		pendingTimeouts:     desc("pending_timeouts", "Number of accused peers waiting for their removal timeout."),
+		lastGossipRound:     desc("last_gossip_round_timestamp_seconds", "Unix time the last gossip round ended."),
+		drops:               desc("drops_total", "Number of dropped gossip messages, stream frames and failed udp reads and writes.", "kind"),
+		clockSkews:          desc("clock_skews_total", "Number of pongs from peers whose clock was further off than the clock skew threshold."),
+		tcpPings:            desc("tcp_pings_total", "Number of peers found alive over tcp after their udp pings failed."),
+	}
+}
+
+func (m *metricsCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- m.gossipRounds
+	ch <- m.gossipBytesSent
+	ch <- m.gossipBytesReceived
+	ch <- m.gossipRTT
+	ch <- m.livePeers
+	ch <- m.deadPeers
+	ch <- m.accusations
+	ch <- m.pings
+	ch <- m.messagesSent
+	ch <- m.messagesReceived
+	ch <- m.idConflicts
+	ch <- m.pendingTimeouts
+	ch <- m.lastGossipRound
+	ch <- m.drops
+	ch <- m.clockSkews
+	ch <- m.tcpPings
+}
+
+func (m *metricsCollector) Collect(ch chan<- prometheus.Metric) {
+	s := m.c.Stats()
+
+	counter := func(d *prometheus.Desc, v uint64, labelValues ...string) {
+		ch <- prometheus.MustNewConstMetric(d, prometheus.CounterValue, float64(v), labelValues...)
+	}
+
+	counter(m.gossipRounds, s.GossipRounds)
+	counter(m.gossipBytesSent, s.GossipBytesSent)
+	counter(m.gossipBytesReceived, s.GossipBytesReceived)
+	counter(m.accusations, s.AccusationsRaised)
+	counter(m.pings, s.PingsSucceeded, "success")
+	counter(m.pings, s.PingsFailed, "failure")
+	counter(m.messagesSent, s.MessagesSent)
+	counter(m.messagesReceived, s.MessagesReceived)
+	counter(m.idConflicts, s.IdConflicts)
+	counter(m.drops, s.DroppedGossip, DroppedGossip.String())
+	counter(m.drops, s.DroppedStreamFrames, DroppedStreamFrame.String())
+	counter(m.drops, s.UdpReadErrors, UdpReadError.String())
+	counter(m.drops, s.UdpWriteErrors, UdpWriteError.String())
+	counter(m.clockSkews, s.ClockSkews)
+	counter(m.tcpPings, s.TcpPings)
+
+	ch <- prometheus.MustNewConstMetric(m.livePeers, prometheus.GaugeValue, float64(s.LivePeers))
+	ch <- prometheus.MustNewConstMetric(m.deadPeers, prometheus.GaugeValue, float64(s.DeadPeers))
+	ch <- prometheus.MustNewConstMetric(m.pendingTimeouts, prometheus.GaugeValue, float64(s.PendingTimeouts))
+
+	if !s.LastGossipRound.IsZero() {
+		ch <- prometheus.MustNewConstMetric(m.lastGossipRound, prometheus.GaugeValue, float64(s.LastGossipRound.UnixNano())/1e9)
+	}
+
+	h := s.GossipRTTHistogram
+	buckets := make(map[float64]uint64, len(h.Buckets))
+	for bound, count := range h.Buckets {
+		buckets[bound.Seconds()] = count
+	}
+
+	ch <- prometheus.MustNewConstHistogram(m.gossipRTT, h.Count, h.Sum.Seconds(), buckets)
+}
@@ -1,6 +1,8 @@
 package netutil
 
 import (
+	"crypto/x509/pkix"
+	"encoding/asn1"
 	"errors"
 	"fmt"
 	"net"
@@ -160,28 +162,45 @@ func LocalIP() (string, error) {
  * - marius
  */
 func ListenUdp(hostname string, portnum int) (*net.UDPConn, string, error) {
-	h, _ := os.Hostname()
+	return ListenUdpNetwork("udp", hostname, portnum)
+}
 
-	addr, err := net.LookupHost(h)
+// Same as ListenUdp, but on the given network, one of udp, udp4 or udp6,
+// restricting the socket to that address family.
+// The returned address is the given hostname with the port the socket is bound to,
+// bracketed for IPv6 hosts.
+func ListenUdpNetwork(network, hostname string, portnum int) (*net.UDPConn, string, error) {
+	udpAddr, err := net.ResolveUDPAddr(network, fmt.Sprintf(":%d", portnum))
 	if err != nil {
 		return nil, "", err
 	}
 
-	if hostname != "" {
-		addr[0] = hostname
-	}
-
-	udpAddr, err := net.ResolveUDPAddr("udp", fmt.Sprintf(":%d", portnum))
+	conn, err := net.ListenUDP(network, udpAddr)
 	if err != nil {
 		return nil, "", err
 	}
 
-	conn, err := net.ListenUDP("udp", udpAddr)
-	if err != nil {
-		return nil, "", err
+	fullAddr := net.JoinHostPort(hostname, strconv.Itoa(conn.LocalAddr().(*net.UDPAddr).Port))
+
+	return conn, fullAddr, nil
+}
+
+// DER sorts the values of an attribute, which would reorder the rpc, ping and
+// http addresses kept as locality values. Each address is given its own
+// attribute instead, parsing the certificate yields them in the original order.
+// Used for all certificates carrying addresses, by clients and the CA alike.
+func OrderedLocality(name pkix.Name) pkix.Name {
+	extra := append([]pkix.AttributeTypeAndValue(nil), name.ExtraNames...)
+
+	for _, l := range name.Locality {
+		extra = append(extra, pkix.AttributeTypeAndValue{
+			Type:  asn1.ObjectIdentifier{2, 5, 4, 7},
+			Value: l,
+		})
 	}
 
-	fullAddr := fmt.Sprintf("%s:%d", hostname, portnum)
+	name.Locality = nil
+	name.ExtraNames = extra
 
-	return conn, fullAddr, nil
+	return name
 }
@@ -48,6 +48,10 @@ type State struct {
 	ExistingHosts  map[string]uint64 `protobuf:"bytes,1,rep,name=existingHosts" json:"existingHosts,omitempty" protobuf_key:"bytes,1,opt,name=key" protobuf_val:"varint,2,opt,name=value"`
 	OwnNote        *Note             `protobuf:"bytes,2,opt,name=ownNote" json:"ownNote,omitempty"`
 	ExternalGossip []byte            `protobuf:"bytes,3,opt,name=externalGossip,proto3" json:"externalGossip,omitempty"`
+	// Versioned application entries, relayed to neighbours once accepted.
+	Entries []*Data `protobuf:"bytes,4,rep,name=entries" json:"entries,omitempty"`
+	// Application payloads enqueued since the last round, only sent once.
+	Batch []*Data `protobuf:"bytes,5,rep,name=batch" json:"batch,omitempty"`
 }
 
 func (m *State) Reset()                    { *m = State{} }
@@ -76,9 +80,24 @@ func (m *State) GetExternalGossip() []byte {
 	return nil
 }
 
+func (m *State) GetEntries() []*Data {
+	if m != nil {
+		return m.Entries
+	}
+	return nil
+}
+
+func (m *State) GetBatch() []*Data {
+	if m != nil {
+		return m.Batch
+	}
+	return nil
+}
+
 // Application message
 type Msg struct {
 	Content []byte `protobuf:"bytes,1,opt,name=content,proto3" json:"content,omitempty"`
+	Seq     uint64 `protobuf:"varint,2,opt,name=seq" json:"seq,omitempty"`
 }
 
 func (m *Msg) Reset()                    { *m = Msg{} }
@@ -93,9 +112,17 @@ func (m *Msg) GetContent() []byte {
 	return nil
 }
 
+func (m *Msg) GetSeq() uint64 {
+	if m != nil {
+		return m.Seq
+	}
+	return 0
+}
+
 // Application response
 type MsgResponse struct {
 	Content []byte `protobuf:"bytes,1,opt,name=content,proto3" json:"content,omitempty"`
+	Ack     uint64 `protobuf:"varint,2,opt,name=ack" json:"ack,omitempty"`
 }
 
 func (m *MsgResponse) Reset()                    { *m = MsgResponse{} }
@@ -110,11 +137,19 @@ func (m *MsgResponse) GetContent() []byte {
 	return nil
 }
 
+func (m *MsgResponse) GetAck() uint64 {
+	if m != nil {
+		return m.Ack
+	}
+	return 0
+}
+
 type StateResponse struct {
 	Certificates   []*Certificate `protobuf:"bytes,1,rep,name=certificates" json:"certificates,omitempty"`
 	Notes          []*Note        `protobuf:"bytes,2,rep,name=notes" json:"notes,omitempty"`
 	Accusations    []*Accusation  `protobuf:"bytes,3,rep,name=accusations" json:"accusations,omitempty"`
 	ExternalGossip []byte         `protobuf:"bytes,4,opt,name=externalGossip,proto3" json:"externalGossip,omitempty"`
+	Entries        []*Data        `protobuf:"bytes,5,rep,name=entries" json:"entries,omitempty"`
 }
 
 func (m *StateResponse) Reset()                    { *m = StateResponse{} }
@@ -150,6 +185,13 @@ func (m *StateResponse) GetExternalGossip() []byte {
 	return nil
 }
 
+func (m *StateResponse) GetEntries() []*Data {
+	if m != nil {
+		return m.Entries
+	}
+	return nil
+}
+
 // Raw certificate
 type Certificate struct {
 	Raw []byte `protobuf:"bytes,1,opt,name=raw,proto3" json:"raw,omitempty"`
@@ -221,6 +263,8 @@ type Note struct {
 	Id        []byte     `protobuf:"bytes,2,opt,name=id,proto3" json:"id,omitempty"`
 	Mask      uint32     `protobuf:"varint,3,opt,name=mask" json:"mask,omitempty"`
 	Signature *Signature `protobuf:"bytes,4,opt,name=signature" json:"signature,omitempty"`
+	Leaving   bool       `protobuf:"varint,5,opt,name=leaving" json:"leaving,omitempty"`
+	Observer  bool       `protobuf:"varint,6,opt,name=observer" json:"observer,omitempty"`
 }
 
 func (m *Note) Reset()                    { *m = Note{} }
@@ -256,6 +300,20 @@ func (m *Note) GetSignature() *Signature {
 	return nil
 }
 
+func (m *Note) GetLeaving() bool {
+	if m != nil {
+		return m.Leaving
+	}
+	return false
+}
+
+func (m *Note) GetObserver() bool {
+	if m != nil {
+		return m.Observer
+	}
+	return false
+}
+
 // Raw elliptic signature
 type Signature struct {
 	R []byte `protobuf:"bytes,1,opt,name=r,proto3" json:"r,omitempty"`
@@ -282,8 +340,11 @@ func (m *Signature) GetS() []byte {
 }
 
 type Data struct {
-	Content []byte `protobuf:"bytes,1,opt,name=content,proto3" json:"content,omitempty"`
-	Id      []byte `protobuf:"bytes,2,opt,name=id,proto3" json:"id,omitempty"`
+	Content   []byte     `protobuf:"bytes,1,opt,name=content,proto3" json:"content,omitempty"`
+	Id        []byte     `protobuf:"bytes,2,opt,name=id,proto3" json:"id,omitempty"`
+	Version   uint64     `protobuf:"varint,3,opt,name=version" json:"version,omitempty"`
+	Origin    []byte     `protobuf:"bytes,4,opt,name=origin,proto3" json:"origin,omitempty"`
+	Signature *Signature `protobuf:"bytes,5,opt,name=signature" json:"signature,omitempty"`
 }
 
 func (m *Data) Reset()                    { *m = Data{} }
@@ -305,8 +366,33 @@ func (m *Data) GetId() []byte {
 	return nil
 }
 
+func (m *Data) GetVersion() uint64 {
+	if m != nil {
+		return m.Version
+	}
+	return 0
+}
+
+func (m *Data) GetOrigin() []byte {
+	if m != nil {
+		return m.Origin
+	}
+	return nil
+}
+
+func (m *Data) GetSignature() *Signature {
+	if m != nil {
+		return m.Signature
+	}
+	return nil
+}
+
 type Ping struct {
-	Nonce []byte `protobuf:"bytes,1,opt,name=nonce,proto3" json:"nonce,omitempty"`
+	Nonce     []byte     `protobuf:"bytes,1,opt,name=nonce,proto3" json:"nonce,omitempty"`
+	Id        []byte     `protobuf:"bytes,2,opt,name=id,proto3" json:"id,omitempty"`
+	Signature *Signature `protobuf:"bytes,3,opt,name=signature" json:"signature,omitempty"`
+	// Note of the sender, not covered by the ping signature as notes are signed on their own.
+	Note *Note `protobuf:"bytes,4,opt,name=note" json:"note,omitempty"`
 }
 
 func (m *Ping) Reset()                    { *m = Ping{} }
@@ -321,9 +407,35 @@ func (m *Ping) GetNonce() []byte {
 	return nil
 }
 
+func (m *Ping) GetId() []byte {
+	if m != nil {
+		return m.Id
+	}
+	return nil
+}
+
+func (m *Ping) GetSignature() *Signature {
+	if m != nil {
+		return m.Signature
+	}
+	return nil
+}
+
+func (m *Ping) GetNote() *Note {
+	if m != nil {
+		return m.Note
+	}
+	return nil
+}
+
 type Pong struct {
 	Nonce     []byte     `protobuf:"bytes,1,opt,name=nonce,proto3" json:"nonce,omitempty"`
 	Signature *Signature `protobuf:"bytes,2,opt,name=signature" json:"signature,omitempty"`
+	// Note of the responder, not covered by the pong signature.
+	Note *Note `protobuf:"bytes,3,opt,name=note" json:"note,omitempty"`
+	// Unix time in nanoseconds when the responder answered, used to estimate clock skew.
+	// Covered by the pong signature along with the ping, zero if the responder does not stamp its pongs.
+	Time int64 `protobuf:"varint,4,opt,name=time" json:"time,omitempty"`
 }
 
 func (m *Pong) Reset()                    { *m = Pong{} }
@@ -345,6 +457,20 @@ func (m *Pong) GetSignature() *Signature {
 	return nil
 }
 
+func (m *Pong) GetNote() *Note {
+	if m != nil {
+		return m.Note
+	}
+	return nil
+}
+
+func (m *Pong) GetTime() int64 {
+	if m != nil {
+		return m.Time
+	}
+	return 0
+}
+
 type Test struct {
 	Nums []int32 `protobuf:"varint,1,rep,packed,name=nums" json:"nums,omitempty"`
 }
@@ -388,6 +514,8 @@ const _ = grpc.SupportPackageIsVersion4
 
 type GossipClient interface {
 	Spread(ctx context.Context, in *State, opts ...grpc.CallOption) (*StateResponse, error)
+	// Anti-entropy, existingHosts of the given state is the requesters digest.
+	Pull(ctx context.Context, in *State, opts ...grpc.CallOption) (*StateResponse, error)
 	Messenger(ctx context.Context, in *Msg, opts ...grpc.CallOption) (*MsgResponse, error)
 	Stream(ctx context.Context, opts ...grpc.CallOption) (Gossip_StreamClient, error)
 }
@@ -409,6 +537,15 @@ func (c *gossipClient) Spread(ctx context.Context, in *State, opts ...grpc.CallO
 	return out, nil
 }
 
+func (c *gossipClient) Pull(ctx context.Context, in *State, opts ...grpc.CallOption) (*StateResponse, error) {
+	out := new(StateResponse)
+	err := grpc.Invoke(ctx, "/proto.gossip/Pull", in, out, c.cc, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
 func (c *gossipClient) Messenger(ctx context.Context, in *Msg, opts ...grpc.CallOption) (*MsgResponse, error) {
 	out := new(MsgResponse)
 	err := grpc.Invoke(ctx, "/proto.gossip/Messenger", in, out, c.cc, opts...)
@@ -453,6 +590,8 @@ func (x *gossipStreamClient) Recv() (*MsgResponse, error) {
 
 type GossipServer interface {
 	Spread(context.Context, *State) (*StateResponse, error)
+	// Anti-entropy, existingHosts of the given state is the requesters digest.
+	Pull(context.Context, *State) (*StateResponse, error)
 	Messenger(context.Context, *Msg) (*MsgResponse, error)
 	Stream(Gossip_StreamServer) error
 }
@@ -479,6 +618,24 @@ func _Gossip_Spread_Handler(srv interface{}, ctx context.Context, dec func(inter
 	return interceptor(ctx, in, info, handler)
 }
 
+func _Gossip_Pull_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(State)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(GossipServer).Pull(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/proto.gossip/Pull",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(GossipServer).Pull(ctx, req.(*State))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
 func _Gossip_Messenger_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
 	in := new(Msg)
 	if err := dec(in); err != nil {
@@ -531,6 +688,10 @@ var _Gossip_serviceDesc = grpc.ServiceDesc{
 			MethodName: "Spread",
 			Handler:    _Gossip_Spread_Handler,
 		},
+		{
+			MethodName: "Pull",
+			Handler:    _Gossip_Pull_Handler,
+		},
 		{
 			MethodName: "Messenger",
 			Handler:    _Gossip_Messenger_Handler,
@@ -550,39 +711,49 @@ var _Gossip_serviceDesc = grpc.ServiceDesc{
 func init() { proto1.RegisterFile("gossip.proto", fileDescriptor0) }
 
 var fileDescriptor0 = []byte{
-	// 544 bytes of a gzipped FileDescriptorProto
-	0x1f, 0x8b, 0x08, 0x00, 0x00, 0x00, 0x00, 0x00, 0x02, 0xff, 0x94, 0x54, 0xd1, 0x6e, 0xd3, 0x30,
-	0x14, 0xc5, 0x69, 0xb2, 0xa9, 0x37, 0xe9, 0x34, 0xac, 0x3d, 0x44, 0x15, 0x52, 0x4b, 0x24, 0x58,
-	0x5e, 0xa8, 0xa6, 0x4e, 0x42, 0x88, 0x27, 0x10, 0x54, 0xf0, 0x40, 0xa7, 0xc9, 0xe3, 0x07, 0x4c,
-	0x7a, 0x09, 0xd6, 0x56, 0x3b, 0xb2, 0x5d, 0xb6, 0x7d, 0x09, 0xaf, 0xfc, 0x0d, 0x9f, 0xc1, 0xaf,
-	0x20, 0x3b, 0x49, 0x9b, 0x8e, 0x8e, 0x89, 0xa7, 0xde, 0xe3, 0x7b, 0xae, 0xef, 0xb9, 0xa7, 0x37,
-	0x86, 0xa4, 0x54, 0xc6, 0x88, 0x6a, 0x52, 0x69, 0x65, 0x15, 0x8d, 0xfc, 0x4f, 0xf6, 0x9b, 0x40,
-	0x74, 0x61, 0xb9, 0x45, 0x3a, 0x83, 0x01, 0xde, 0x08, 0x63, 0x85, 0x2c, 0x3f, 0x2a, 0x63, 0x4d,
-	0x4a, 0xc6, 0xbd, 0x3c, 0x9e, 0x8e, 0x6a, 0xfe, 0xc4, 0x93, 0x26, 0xb3, 0x2e, 0x63, 0x26, 0xad,
-	0xbe, 0x65, 0xdb, 0x55, 0xf4, 0x19, 0xec, 0xab, 0x6b, 0x79, 0xa6, 0x2c, 0xa6, 0xc1, 0x98, 0xe4,
-	0xf1, 0x34, 0x6e, 0x2e, 0x70, 0x47, 0xac, 0xcd, 0xd1, 0xe7, 0x70, 0x80, 0x37, 0x16, 0xb5, 0xe4,
-	0x57, 0x1f, 0xbc, 0xac, 0xb4, 0x37, 0x26, 0x79, 0xc2, 0xee, 0x9c, 0x0e, 0xdf, 0x00, 0xfd, 0xbb,
-	0x27, 0x3d, 0x84, 0xde, 0x25, 0xde, 0xa6, 0x64, 0x4c, 0xf2, 0x3e, 0x73, 0x21, 0x3d, 0x82, 0xe8,
-	0x3b, 0xbf, 0x5a, 0xd5, 0x4d, 0x43, 0x56, 0x83, 0xd7, 0xc1, 0x2b, 0x92, 0x8d, 0xa0, 0x37, 0x37,
-	0x25, 0x4d, 0x61, 0xbf, 0x50, 0xd2, 0xa2, 0xb4, 0xbe, 0x2c, 0x61, 0x2d, 0xcc, 0x8e, 0x21, 0x9e,
-	0x9b, 0x92, 0xa1, 0xa9, 0x94, 0x34, 0xf8, 0x0f, 0xe2, 0x2f, 0x02, 0x03, 0x6f, 0xc3, 0x9a, 0xfb,
-	0x12, 0x92, 0x02, 0xb5, 0x15, 0x5f, 0x45, 0xc1, 0x2d, 0xb6, 0x96, 0xd1, 0x66, 0xe2, 0x77, 0x9b,
-	0x14, 0xdb, 0xe2, 0xd1, 0xa7, 0x10, 0x49, 0xe5, 0x0a, 0x02, 0x5f, 0xb0, 0x65, 0x51, 0x9d, 0xa1,
-	0xa7, 0x10, 0xf3, 0xa2, 0x58, 0x19, 0x6e, 0x85, 0x92, 0x26, 0xed, 0x79, 0xe2, 0xe3, 0x86, 0xf8,
-	0x76, 0x9d, 0x61, 0x5d, 0xd6, 0x0e, 0x57, 0xc3, 0x5d, 0xae, 0x66, 0x23, 0x88, 0x3b, 0xe2, 0x9c,
-	0x9d, 0x9a, 0x5f, 0x37, 0xe3, 0xba, 0x30, 0xfb, 0x49, 0x00, 0x36, 0x4d, 0x9c, 0xbb, 0x58, 0xa9,
-	0xe2, 0x9b, 0xa7, 0x84, 0xac, 0x06, 0xce, 0x29, 0xdf, 0x1c, 0xb5, 0x77, 0x3d, 0x61, 0x2d, 0xdc,
-	0x64, 0x16, 0xcd, 0xdf, 0xda, 0x42, 0x3a, 0x81, 0xbe, 0x11, 0xa5, 0xe4, 0x76, 0xa5, 0xd1, 0x8b,
-	0x8b, 0xa7, 0x87, 0xed, 0x86, 0xb5, 0xe7, 0x6c, 0x43, 0x71, 0x37, 0x69, 0x21, 0xcb, 0xb3, 0xd5,
-	0x32, 0x8d, 0xc6, 0x24, 0x1f, 0xb0, 0x16, 0x66, 0x15, 0x84, 0x7e, 0x93, 0x76, 0x6b, 0x3b, 0x80,
-	0x40, 0x2c, 0x1a, 0x59, 0x81, 0x58, 0x50, 0x0a, 0xe1, 0x92, 0x9b, 0x4b, 0x2f, 0x67, 0xc0, 0x7c,
-	0xfc, 0xbf, 0x5a, 0xb2, 0x63, 0xe8, 0xaf, 0xcf, 0x69, 0x02, 0x44, 0x37, 0x8e, 0x11, 0xed, 0x90,
-	0x69, 0xba, 0x11, 0x93, 0x9d, 0x40, 0xf8, 0x9e, 0x5b, 0x7e, 0xff, 0x2a, 0xdd, 0x95, 0x97, 0x3d,
-	0x81, 0xf0, 0x5c, 0xc8, 0xd2, 0x0d, 0x23, 0x95, 0x2c, 0xb0, 0xe1, 0xd7, 0x20, 0xfb, 0x04, 0xe1,
-	0xb9, 0xba, 0x2f, 0xbb, 0x3d, 0x46, 0xf0, 0xf0, 0x18, 0x43, 0x08, 0x3f, 0xa3, 0xb1, 0xce, 0x12,
-	0xb9, 0x5a, 0xd6, 0x4b, 0x1b, 0x31, 0x1f, 0x4f, 0x7f, 0x10, 0xd8, 0xab, 0x9f, 0x09, 0x3a, 0x81,
-	0xbd, 0x8b, 0x4a, 0x23, 0x5f, 0xd0, 0xa4, 0xfb, 0x04, 0x0c, 0x8f, 0xba, 0xa8, 0xfd, 0x12, 0xb2,
-	0x47, 0xf4, 0x05, 0xf4, 0xe7, 0x68, 0x0c, 0xca, 0x12, 0x35, 0x85, 0x86, 0x34, 0x37, 0xe5, 0x90,
-	0x6e, 0xe2, 0x0e, 0xdd, 0x5d, 0x6f, 0x35, 0xf2, 0xe5, 0xc3, 0xdc, 0x9c, 0x9c, 0x90, 0x2f, 0x7b,
-	0x3e, 0x71, 0xfa, 0x27, 0x00, 0x00, 0xff, 0xff, 0x4b, 0x51, 0xad, 0xc8, 0xc6, 0x04, 0x00, 0x00,
+	// 690 bytes of a gzipped FileDescriptorProto
+	0x1f, 0x8b, 0x08, 0x00, 0x00, 0x00, 0x00, 0x00, 0x02, 0xff, 0x9c, 0x54, 0xcd, 0x6e, 0x13, 0x3b,
+	0x14, 0xbe, 0xce, 0xcc, 0xa4, 0xcd, 0x49, 0x52, 0xf5, 0x5a, 0xd5, 0xd5, 0x28, 0x9b, 0xe6, 0x8e,
+	0x04, 0x64, 0x01, 0x11, 0xa4, 0x12, 0x02, 0x56, 0x20, 0xa8, 0x60, 0x93, 0xaa, 0x72, 0x79, 0x01,
+	0x77, 0x72, 0x98, 0x5a, 0x49, 0xec, 0x60, 0x3b, 0x69, 0xbb, 0x60, 0xc1, 0x2b, 0xf0, 0x04, 0xac,
+	0x90, 0x78, 0x10, 0x5e, 0x87, 0x67, 0x40, 0xf6, 0xcc, 0x24, 0x99, 0xfe, 0xa5, 0x62, 0x35, 0xe7,
+	0xf3, 0xf9, 0xce, 0xf1, 0x77, 0x7e, 0xc6, 0xd0, 0xca, 0x94, 0x31, 0x62, 0xd6, 0x9f, 0x69, 0x65,
+	0x15, 0x8d, 0xfc, 0x27, 0xf9, 0x59, 0x83, 0xe8, 0xc4, 0x72, 0x8b, 0xf4, 0x10, 0xda, 0x78, 0x21,
+	0x8c, 0x15, 0x32, 0xfb, 0xa0, 0x8c, 0x35, 0x31, 0xe9, 0x06, 0xbd, 0xe6, 0x60, 0x3f, 0xe7, 0xf7,
+	0x3d, 0xa9, 0x7f, 0xb8, 0xce, 0x38, 0x94, 0x56, 0x5f, 0xb2, 0x6a, 0x14, 0x7d, 0x00, 0x5b, 0xea,
+	0x5c, 0x1e, 0x29, 0x8b, 0x71, 0xad, 0x4b, 0x7a, 0xcd, 0x41, 0xb3, 0x48, 0xe0, 0x8e, 0x58, 0xe9,
+	0xa3, 0x0f, 0x61, 0x07, 0x2f, 0x2c, 0x6a, 0xc9, 0x27, 0xef, 0xbd, 0xac, 0x38, 0xe8, 0x92, 0x5e,
+	0x8b, 0x5d, 0x39, 0x75, 0xe9, 0x50, 0x5a, 0x2d, 0xd0, 0xc4, 0x61, 0x37, 0x58, 0x4b, 0xf7, 0x8e,
+	0x5b, 0xce, 0x4a, 0x1f, 0xfd, 0x1f, 0xa2, 0x53, 0x6e, 0xd3, 0xb3, 0x38, 0xba, 0x4e, 0xca, 0x3d,
+	0x9d, 0xd7, 0x40, 0xaf, 0xab, 0xa7, 0xbb, 0x10, 0x8c, 0xf1, 0x32, 0x26, 0x5d, 0xd2, 0x6b, 0x30,
+	0x67, 0xd2, 0x3d, 0x88, 0x16, 0x7c, 0x32, 0xcf, 0xe5, 0x87, 0x2c, 0x07, 0xaf, 0x6a, 0x2f, 0x48,
+	0xf2, 0x0c, 0x82, 0xa1, 0xc9, 0x68, 0x0c, 0x5b, 0xa9, 0x92, 0x16, 0xa5, 0xf5, 0x61, 0x2d, 0x56,
+	0x42, 0x97, 0xcc, 0xe0, 0xe7, 0x22, 0xd0, 0x99, 0xc9, 0x4b, 0x68, 0x0e, 0x4d, 0xc6, 0xd0, 0xcc,
+	0x94, 0x34, 0x78, 0x77, 0x28, 0x4f, 0xc7, 0x65, 0x28, 0x4f, 0xc7, 0xc9, 0x6f, 0x02, 0x6d, 0xdf,
+	0xf4, 0x65, 0xf4, 0x73, 0x68, 0xa5, 0xa8, 0xad, 0xf8, 0x24, 0x52, 0x6e, 0xb1, 0x1c, 0x10, 0x2d,
+	0x6a, 0x7d, 0xbb, 0x72, 0xb1, 0x0a, 0xcf, 0x35, 0x47, 0x2a, 0x17, 0x50, 0xab, 0x34, 0xc7, 0x0f,
+	0x24, 0xf7, 0xd0, 0x03, 0x68, 0xf2, 0x34, 0x9d, 0x1b, 0x6e, 0x85, 0x92, 0x26, 0x0e, 0x3c, 0xf1,
+	0xdf, 0x82, 0xf8, 0x66, 0xe9, 0x61, 0xeb, 0xac, 0x1b, 0x66, 0x18, 0x6e, 0x9a, 0x61, 0x74, 0xfb,
+	0x0c, 0x93, 0x7d, 0x68, 0xae, 0xd5, 0xe0, 0x3a, 0xa2, 0xf9, 0x79, 0xd1, 0x27, 0x67, 0x26, 0xdf,
+	0x09, 0xc0, 0x4a, 0x8b, 0x1b, 0x14, 0xce, 0x54, 0x7a, 0xe6, 0x29, 0x21, 0xcb, 0x81, 0x6b, 0xb1,
+	0xd7, 0x88, 0xda, 0x37, 0xb3, 0xc5, 0x4a, 0xb8, 0xf2, 0x8c, 0x8a, 0x5d, 0x2b, 0x21, 0xed, 0x43,
+	0xc3, 0x88, 0x4c, 0x72, 0x3b, 0xd7, 0xe8, 0x6b, 0x68, 0x0e, 0x76, 0xcb, 0xb5, 0x2f, 0xcf, 0xd9,
+	0x8a, 0xe2, 0x32, 0x69, 0x21, 0xb3, 0xa3, 0xf9, 0x34, 0x8e, 0xba, 0xa4, 0xd7, 0x66, 0x25, 0x4c,
+	0x7e, 0x10, 0x08, 0xfd, 0x7e, 0xdf, 0x2c, 0x6e, 0x07, 0x6a, 0x62, 0x54, 0xe8, 0xaa, 0x89, 0x11,
+	0xa5, 0x10, 0x4e, 0xb9, 0x19, 0x7b, 0x3d, 0x6d, 0xe6, 0xed, 0xbf, 0x11, 0x33, 0x41, 0xbe, 0x10,
+	0x32, 0xf3, 0x62, 0xb6, 0x59, 0x09, 0x69, 0x07, 0xb6, 0xd5, 0xa9, 0x41, 0xbd, 0x40, 0x1d, 0xd7,
+	0xbd, 0x6b, 0x89, 0x93, 0x47, 0xd0, 0x58, 0x66, 0xa3, 0x2d, 0x20, 0xba, 0x68, 0x34, 0xd1, 0x0e,
+	0x99, 0x42, 0x23, 0x31, 0xc9, 0x37, 0x02, 0xa1, 0x9b, 0xd3, 0x1d, 0xbb, 0x7b, 0xb5, 0xaa, 0x18,
+	0xb6, 0x16, 0xa8, 0x8d, 0x50, 0xd2, 0x17, 0x16, 0xb2, 0x12, 0xd2, 0xff, 0xa0, 0xae, 0xb4, 0xc8,
+	0x84, 0x2c, 0x36, 0xa5, 0x40, 0xd5, 0x9a, 0xa3, 0x8d, 0x35, 0x27, 0x5f, 0x20, 0x3c, 0x76, 0x15,
+	0xee, 0xb9, 0xcd, 0x96, 0x29, 0x16, 0x8a, 0x72, 0x70, 0x4d, 0x4f, 0x25, 0x7b, 0xb0, 0xb9, 0xa3,
+	0xfb, 0x10, 0xba, 0xbf, 0xa2, 0x68, 0x7e, 0xe5, 0x77, 0xf1, 0x8e, 0xe4, 0x2b, 0x81, 0xf0, 0x58,
+	0xdd, 0x7a, 0x7f, 0xe5, 0xbe, 0xda, 0xfd, 0xef, 0x0b, 0x6e, 0xb9, 0xcf, 0xad, 0x89, 0x15, 0xd3,
+	0x5c, 0x50, 0xc0, 0xbc, 0x9d, 0x74, 0x20, 0xfc, 0x88, 0xc6, 0x3a, 0x9f, 0x9c, 0x4f, 0xf3, 0xc7,
+	0x20, 0x62, 0xde, 0x1e, 0xfc, 0x22, 0x50, 0xcf, 0x1f, 0x7b, 0xda, 0x87, 0xfa, 0xc9, 0x4c, 0x23,
+	0x1f, 0xd1, 0xd6, 0xfa, 0x43, 0xde, 0xd9, 0x5b, 0x47, 0xe5, 0x0b, 0x93, 0xfc, 0x43, 0x1f, 0x43,
+	0x78, 0x3c, 0x9f, 0x4c, 0xee, 0xc9, 0x7e, 0x02, 0x8d, 0x21, 0x1a, 0x83, 0x32, 0x43, 0x4d, 0xa1,
+	0x20, 0x0d, 0x4d, 0xd6, 0xa1, 0x2b, 0x7b, 0x8d, 0xee, 0xc4, 0x58, 0x8d, 0x7c, 0xba, 0x99, 0xdb,
+	0x23, 0x4f, 0xc9, 0x69, 0xdd, 0x3b, 0x0e, 0xfe, 0x0c, 0x00, 0x5c, 0xd4, 0xc9, 0x5e, 0xba, 0x06,
+	0x00, 0x00,
 }
@@ -0,0 +1,20 @@
+package proto
+
+import (
+	proto1 "github.com/golang/protobuf/proto"
+)
+
+// Returns the data covered by the signature of a pong, the ping exactly as it was
+// received followed by the marshalled time the pong is stamped with.
+// The note is left out, it carries its own signature.
+func PongSignedData(ping []byte, time int64) ([]byte, error) {
+	stamp, err := proto1.Marshal(&Pong{Time: time})
+	if err != nil {
+		return nil, err
+	}
+
+	data := make([]byte, 0, len(ping)+len(stamp))
+	data = append(data, ping...)
+
+	return append(data, stamp...), nil
+}